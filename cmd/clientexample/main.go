@@ -23,7 +23,6 @@ func main() {
 		QueueName:     queueName,
 		RetryInterval: 10 * time.Second,
 		StopOnError:   false,
-		PreserveError: true,
 		WorkerCount:   50,
 		WorkerBuffer:  50,
 	}
@@ -62,17 +61,24 @@ func main() {
 	fmt.Printf("Queue created: %+v\n", queue)
 
 	// Create some example tasks
+	items := make([]interface{}, 0, 3000)
 	for i := 1; i <= 3000; i++ {
-		task, err := client.CreateTask(ctx, config.QueueName, map[string]interface{}{
+		items = append(items, map[string]interface{}{
 			"job_number": i,
 			"data":       fmt.Sprintf("Example data %d", i),
 			"client_id":  client.ClientID(),
 		})
-		if err != nil {
-			log.Fatalf("Error creating task: %v", err)
+	}
+	results, err := client.CreateTasks(ctx, config.QueueName, items)
+	if err != nil {
+		log.Fatalf("Error creating tasks: %v", err)
+	}
+	for _, result := range results {
+		if result.Error != "" {
+			log.Fatalf("Error creating task %d: %s", result.Index, result.Error)
 		}
-		fmt.Printf("Task created: %+v\n", task)
 	}
+	fmt.Printf("%d tasks created\n", len(results))
 
 	// List tasks
 	tasks, err := client.GetTasks(ctx, jobqueue.TaskFilter{
@@ -93,14 +99,14 @@ func main() {
 	}
 }
 
-func mainTaskHandler(ctx context.Context, task *jobqueue.Task) error {
+func mainTaskHandler(ctx context.Context, task *jobqueue.Task) (interface{}, error) {
 	// Decode task data
 	var taskData struct {
 		JobNumber int    `json:"job_number"`
 		Data      string `json:"data"`
 	}
 	if err := json.Unmarshal(task.Data, &taskData); err != nil {
-		return fmt.Errorf("error decoding task data: %w", err)
+		return nil, fmt.Errorf("error decoding task data: %w", err)
 	}
 
 	fmt.Printf("Processing task %s: Job %d - %s\n",
@@ -112,5 +118,5 @@ func mainTaskHandler(ctx context.Context, task *jobqueue.Task) error {
 
 	// Simulate processing
 	time.Sleep(time.Duration(i) * time.Second)
-	return nil
+	return map[string]interface{}{"job_number": taskData.JobNumber, "seconds": i}, nil
 }
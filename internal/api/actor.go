@@ -0,0 +1,36 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/fernandezvara/jobqueues/internal/storage"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// withActor records the changes made by a request as made by its actor, see requestActor
+func withActor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := storage.WithActor(r.Context(), requestActor(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestActor identifies who makes a request: the worker in X-Client-ID, the API key of a
+// bearer token, the user authenticated by a proxy in front of the dashboard with basic auth, or
+// nobody. API keys are only recorded by a prefix of their SHA-256 hash.
+func requestActor(r *http.Request) storage.Actor {
+	actor := storage.Actor{Type: storage.ActorAnonymous, RequestID: middleware.GetReqID(r.Context())}
+
+	if clientID := r.Header.Get("X-Client-ID"); clientID != "" {
+		actor.Type, actor.Name = storage.ActorClient, clientID
+	} else if key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && key != "" {
+		sum := sha256.Sum256([]byte(key))
+		actor.Type, actor.Name = storage.ActorAPIKey, hex.EncodeToString(sum[:])[:16]
+	} else if user, _, ok := r.BasicAuth(); ok && user != "" {
+		actor.Type, actor.Name = storage.ActorUser, user
+	}
+	return actor
+}
@@ -1,7 +1,12 @@
 package api
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
 	"net/http"
 	"strconv"
 	"time"
@@ -89,26 +94,314 @@ func (h *Handlers) CreateOrUpdateQueue(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, queue)
 }
 
+// DeleteQueue removes a queue. ?mode=restrict (the default) refuses while it has pending or
+// running tasks, ?mode=cascade soft-deletes them and ?mode=move&target= moves every task to target.
+func (h *Handlers) DeleteQueue(w http.ResponseWriter, r *http.Request) {
+	queueName := chi.URLParam(r, "name")
+	mode := r.URL.Query().Get("mode")
+	target := r.URL.Query().Get("target")
+
+	err := h.service.DeleteQueue(r.Context(), queueName, mode, target)
+	switch {
+	case errors.Is(err, queue.ErrInvalidDeleteMode):
+		respondError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, storage.ErrQueueNotFound):
+		respondError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, storage.ErrQueueNotEmpty), errors.Is(err, storage.ErrQueueInUse):
+		respondError(w, http.StatusConflict, err.Error())
+	case err != nil:
+		respondError(w, http.StatusInternalServerError, err.Error())
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// RenameQueue renames a queue to the name in the body, moving its tasks along
+func (h *Handlers) RenameQueue(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if request.Name == "" {
+		respondError(w, http.StatusBadRequest, "New queue name is required")
+		return
+	}
+
+	queue, err := h.service.RenameQueue(r.Context(), chi.URLParam(r, "name"), request.Name)
+	switch {
+	case errors.Is(err, storage.ErrQueueNotFound):
+		respondError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, storage.ErrQueueExists):
+		respondError(w, http.StatusConflict, err.Error())
+	case err != nil:
+		respondError(w, http.StatusInternalServerError, err.Error())
+	default:
+		respondJSON(w, http.StatusOK, queue)
+	}
+}
+
+// PauseQueue stops handing out the tasks of a queue, which keeps accepting new ones
+func (h *Handlers) PauseQueue(w http.ResponseWriter, r *http.Request) {
+	h.setQueuePaused(w, r, true)
+}
+
+// ResumeQueue hands out the tasks of a paused queue again
+func (h *Handlers) ResumeQueue(w http.ResponseWriter, r *http.Request) {
+	h.setQueuePaused(w, r, false)
+}
+
+func (h *Handlers) setQueuePaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	queue, err := h.service.SetQueuePaused(r.Context(), chi.URLParam(r, "name"), paused)
+	if errors.Is(err, storage.ErrQueueNotFound) {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, queue)
+}
+
+func (h *Handlers) RedriveDeadLetters(w http.ResponseWriter, r *http.Request) {
+	queueName := chi.URLParam(r, "name")
+
+	var request struct {
+		TaskIDs []string `json:"task_ids"`
+	}
+	// the body is optional, an empty one redrives every dead-lettered task
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	queue, err := h.service.GetQueue(r.Context(), queueName)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if queue == nil {
+		respondError(w, http.StatusNotFound, "queue not found")
+		return
+	}
+
+	redriven, err := h.service.RedriveDeadLetters(r.Context(), queueName, request.TaskIDs)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]int{"redriven": redriven})
+}
+
+// PurgeTasks deletes the finished tasks past the retention of their queue, restricted to a
+// queue with ?queue=. With ?dry_run=true they are only counted.
+func (h *Handlers) PurgeTasks(w http.ResponseWriter, r *http.Request) {
+	queueName := r.URL.Query().Get("queue")
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	if queueName != "" {
+		queue, err := h.service.GetQueue(r.Context(), queueName)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if queue == nil {
+			respondError(w, http.StatusNotFound, "queue not found")
+			return
+		}
+	}
+
+	count, err := h.service.PurgeTasks(r.Context(), queueName, dryRun)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if dryRun {
+		respondJSON(w, http.StatusOK, map[string]int{"purgeable": count})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]int{"purged": count})
+}
+
+// createTaskRequest is the payload of a new task, which can be delayed instead of scheduled with run_at
+type createTaskRequest struct {
+	storage.Task
+	Delay time.Duration `json:"delay"`
+}
+
+func (request createTaskRequest) task() (storage.Task, error) {
+	task := request.Task
+	if request.Delay < 0 {
+		return task, fmt.Errorf("Delay cannot be negative")
+	}
+	if request.Delay > 0 {
+		if task.RunAt != nil {
+			return task, fmt.Errorf("run_at and delay are mutually exclusive")
+		}
+		runAt := time.Now().Add(request.Delay)
+		task.RunAt = &runAt
+	}
+	return task, nil
+}
+
 func (h *Handlers) CreateTask(w http.ResponseWriter, r *http.Request) {
-	var task storage.Task
-	if err := json.NewDecoder(r.Body).Decode(&task); err != nil {
+	var request createTaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 		respondError(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
 
-	if err := h.service.CreateTask(r.Context(), &task); err != nil {
+	task, err := request.task()
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	created, err := h.service.CreateTask(r.Context(), &task)
+	if errors.Is(err, storage.ErrDependencyNotFound) {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// An existing task returned for an idempotency key is not a new resource
+	status := http.StatusCreated
+	if !created {
+		status = http.StatusOK
+	}
+
+	respondJSON(w, status, task)
+}
+
+// CreateTasks creates a batch of tasks sent either as a JSON array or as a stream of
+// newline delimited JSON objects, reporting the outcome of each task by its index
+func (h *Handlers) CreateTasks(w http.ResponseWriter, r *http.Request) {
+	requests, err := decodeTaskBatch(r.Body)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	type itemResult struct {
+		Index   int    `json:"index"`
+		ID      string `json:"id,omitempty"`
+		Created bool   `json:"created"`
+		Error   string `json:"error,omitempty"`
+	}
+	results := make([]itemResult, len(requests))
+
+	var tasks []storage.Task
+	var indexes []int
+	for i, request := range requests {
+		results[i].Index = i
+		task, err := request.task()
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		tasks = append(tasks, task)
+		indexes = append(indexes, i)
+	}
+
+	batch, err := h.service.CreateTasks(r.Context(), tasks)
+	if err != nil {
 		respondError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	for i, result := range batch {
+		item := &results[indexes[i]]
+		if result.Err != nil {
+			item.Error = result.Err.Error()
+			continue
+		}
+		item.ID = result.Task.ID
+		item.Created = result.Created
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{"results": results})
+}
+
+// decodeTaskBatch reads up to queue.MaxBatchSize task payloads from a JSON array or NDJSON body
+func decodeTaskBatch(body io.Reader) ([]createTaskRequest, error) {
+	reader := bufio.NewReader(body)
+	first, err := peekNonSpace(reader)
+	if err == io.EOF {
+		return nil, fmt.Errorf("Empty batch")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid request payload")
+	}
+
+	decoder := json.NewDecoder(reader)
+	isArray := first == '['
+	if isArray {
+		if _, err := decoder.Token(); err != nil {
+			return nil, fmt.Errorf("Invalid request payload")
+		}
+	}
+
+	var requests []createTaskRequest
+	for {
+		if isArray && !decoder.More() {
+			break
+		}
+		var request createTaskRequest
+		err := decoder.Decode(&request)
+		if err == io.EOF && !isArray {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid task at index %d", len(requests))
+		}
+		if len(requests) == queue.MaxBatchSize {
+			return nil, fmt.Errorf("A batch cannot have more than %d tasks", queue.MaxBatchSize)
+		}
+		requests = append(requests, request)
+	}
+
+	if len(requests) == 0 {
+		return nil, fmt.Errorf("Empty batch")
+	}
+	return requests, nil
+}
 
-	respondJSON(w, http.StatusCreated, task)
+// peekNonSpace returns the first non whitespace byte of the reader without consuming it
+func peekNonSpace(reader *bufio.Reader) (byte, error) {
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			return b, reader.UnreadByte()
+		}
+	}
 }
 
 func (h *Handlers) GetTasks(w http.ResponseWriter, r *http.Request) {
 	filter := storage.TaskFilter{
 		QueueName: r.URL.Query().Get("queue"),
 		Status:    r.URL.Query().Get("status"),
+		BatchID:   r.URL.Query().Get("batch_id"),
 		SortBy:    r.URL.Query().Get("sort_by"),
+		// archived tasks are only searched on request, they are usually not needed
+		IncludeArchived: r.URL.Query().Get("include_archived") == "true",
+	}
+
+	if priority := r.URL.Query().Get("priority"); priority != "" {
+		value, err := strconv.Atoi(priority)
+		if err == nil {
+			filter.Priority = &value
+		}
 	}
 
 	if from := r.URL.Query().Get("from"); from != "" {
@@ -163,14 +456,39 @@ func (h *Handlers) GetTasks(w http.ResponseWriter, r *http.Request) {
 func (h *Handlers) UpdateTask(w http.ResponseWriter, r *http.Request) {
 	taskID := chi.URLParam(r, "id")
 
-	var task storage.Task
+	existingTask, err := h.service.GetTask(r.Context(), taskID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if existingTask == nil {
+		respondError(w, http.StatusNotFound, "task not found")
+		return
+	}
+
+	// Fields missing from the payload keep their current values
+	task := *existingTask
 	if err := json.NewDecoder(r.Body).Decode(&task); err != nil {
 		respondError(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
 
+	if task.Error != nil && task.Error.Message == "" {
+		respondError(w, http.StatusBadRequest, "Error message is required")
+		return
+	}
+
 	task.ID = taskID
-	if err := h.service.UpdateTask(r.Context(), &task); err != nil {
+	err = h.service.UpdateTask(r.Context(), &task, r.Header.Get("X-Client-ID"))
+	if errors.Is(err, storage.ErrLeaseLost) {
+		respondError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if errors.Is(err, queue.ErrInvalidStatusTransition) {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
 		respondError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -178,6 +496,35 @@ func (h *Handlers) UpdateTask(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, task)
 }
 
+func (h *Handlers) Heartbeat(w http.ResponseWriter, r *http.Request) {
+	taskID := chi.URLParam(r, "id")
+	clientID := r.Header.Get("X-Client-ID")
+
+	// the body is optional, without extension the lease is extended by the queue timeout
+	var request struct {
+		ExtendBy time.Duration `json:"extend_by"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	leaseExpiresAt, err := h.service.Heartbeat(r.Context(), taskID, clientID, request.ExtendBy)
+	if errors.Is(err, storage.ErrLeaseLost) {
+		respondError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"id":               taskID,
+		"lease_expires_at": leaseExpiresAt,
+	})
+}
+
 func (h *Handlers) DeleteTask(w http.ResponseWriter, r *http.Request) {
 	taskID := chi.URLParam(r, "id")
 
@@ -189,13 +536,222 @@ func (h *Handlers) DeleteTask(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// GetTaskGraph returns the tasks and dependencies of the workflow a task belongs to
+func (h *Handlers) GetTaskGraph(w http.ResponseWriter, r *http.Request) {
+	graph, err := h.service.GetTaskGraph(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if graph == nil {
+		respondError(w, http.StatusNotFound, "task not found")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, graph)
+}
+
+// GetTaskAttempts returns the history of the claims of a task
+func (h *Handlers) GetTaskAttempts(w http.ResponseWriter, r *http.Request) {
+	attempts, err := h.service.GetTaskAttempts(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if attempts == nil {
+		respondError(w, http.StatusNotFound, "task not found")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, attempts)
+}
+
+// GetTaskEvents returns the changes of a task, newest first
+func (h *Handlers) GetTaskEvents(w http.ResponseWriter, r *http.Request) {
+	filter, err := eventFilter(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter.TaskID = chi.URLParam(r, "id")
+
+	events, err := h.service.GetTaskEvents(r.Context(), filter)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, events)
+}
+
+// GetEvents searches the task event log by task, queue, actor and time range, newest first
+func (h *Handlers) GetEvents(w http.ResponseWriter, r *http.Request) {
+	filter, err := eventFilter(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter.TaskID = r.URL.Query().Get("task_id")
+
+	events, err := h.service.GetTaskEvents(r.Context(), filter)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, events)
+}
+
+// eventFilter reads the event filter of a request, times are unix seconds as in GetTasks
+func eventFilter(r *http.Request) (storage.TaskEventFilter, error) {
+	query := r.URL.Query()
+	filter := storage.TaskEventFilter{
+		QueueName: query.Get("queue"),
+		ActorType: query.Get("actor_type"),
+		Actor:     query.Get("actor"),
+	}
+
+	if from := query.Get("from"); from != "" {
+		seconds, err := strconv.ParseInt(from, 10, 64)
+		if err != nil {
+			return filter, errors.New("invalid from")
+		}
+		filter.FromDate = time.Unix(seconds, 0)
+	}
+	if to := query.Get("to"); to != "" {
+		seconds, err := strconv.ParseInt(to, 10, 64)
+		if err != nil {
+			return filter, errors.New("invalid to")
+		}
+		filter.ToDate = time.Unix(seconds, 0)
+	}
+	if limit := query.Get("limit"); limit != "" {
+		var err error
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 0 {
+			return filter, errors.New("invalid limit")
+		}
+	}
+	if offset := query.Get("offset"); offset != "" {
+		var err error
+		if filter.Offset, err = strconv.Atoi(offset); err != nil || filter.Offset < 0 {
+			return filter, errors.New("invalid offset")
+		}
+	}
+	return filter, nil
+}
+
+// createBatchRequest is the payload of a new batch, callback is the optional task enqueued once
+// every task of the batch finished
+type createBatchRequest struct {
+	Tasks    []createTaskRequest `json:"tasks"`
+	Callback *struct {
+		QueueName string          `json:"queue_name"`
+		Data      json.RawMessage `json:"data"`
+	} `json:"callback"`
+}
+
+// CreateBatch creates a group of tasks tracked together, failing as a whole if any task is invalid
+func (h *Handlers) CreateBatch(w http.ResponseWriter, r *http.Request) {
+	var request createBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	// validation
+	if len(request.Tasks) == 0 {
+		respondError(w, http.StatusBadRequest, "Empty batch")
+		return
+	}
+	if len(request.Tasks) > queue.MaxBatchSize {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("A batch cannot have more than %d tasks", queue.MaxBatchSize))
+		return
+	}
+	tasks := make([]storage.Task, len(request.Tasks))
+	for i, taskRequest := range request.Tasks {
+		task, err := taskRequest.task()
+		if err == nil && task.IdempotencyKey != nil {
+			err = fmt.Errorf("tasks of a batch cannot have an idempotency key")
+		}
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid task at index %d: %s", i, err))
+			return
+		}
+		tasks[i] = task
+	}
+
+	var batch storage.Batch
+	if request.Callback != nil {
+		if request.Callback.QueueName == "" {
+			respondError(w, http.StatusBadRequest, "Callback queue name is required")
+			return
+		}
+		batch.CallbackQueue = &request.Callback.QueueName
+		batch.CallbackData = request.Callback.Data
+	}
+
+	created, err := h.service.CreateBatch(r.Context(), &batch, tasks)
+	if errors.Is(err, storage.ErrDependencyNotFound) {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, created)
+}
+
+// GetBatch returns a batch with the counts of its tasks by status
+func (h *Handlers) GetBatch(w http.ResponseWriter, r *http.Request) {
+	batch, err := h.service.GetBatch(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if batch == nil {
+		respondError(w, http.StatusNotFound, "batch not found")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, batch)
+}
+
 func (h *Handlers) GetNextTask(w http.ResponseWriter, r *http.Request) {
 	queueName := r.URL.Query().Get("queue")
 	clientID := r.Header.Get("X-Client-ID")
 
-	task, err := h.service.GetNextTask(r.Context(), queueName, clientID)
+	// With wait, the request blocks until tasks are available or wait elapses
+	var wait time.Duration
+	if waitStr := r.URL.Query().Get("wait"); waitStr != "" {
+		var err error
+		wait, err = time.ParseDuration(waitStr)
+		if err != nil || wait < 0 {
+			respondError(w, http.StatusBadRequest, "Invalid wait duration")
+			return
+		}
+	}
+
+	// With count, up to count tasks are claimed at once and returned as a (possibly empty) list
+	if countStr := r.URL.Query().Get("count"); countStr != "" {
+		count, err := strconv.Atoi(countStr)
+		if err != nil || count < 1 {
+			respondError(w, http.StatusBadRequest, "Invalid count")
+			return
+		}
+		tasks, err := h.service.GetNextTasks(r.Context(), queueName, clientID, count, wait)
+		if err != nil {
+			respondClaimError(w, err)
+			return
+		}
+		respondJSON(w, http.StatusOK, tasks)
+		return
+	}
+
+	task, err := h.service.GetNextTask(r.Context(), queueName, clientID, wait)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondClaimError(w, err)
 		return
 	}
 
@@ -207,6 +763,120 @@ func (h *Handlers) GetNextTask(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, task)
 }
 
+// respondClaimError tells clients of a paused or rate limited queue when to come back
+func respondClaimError(w http.ResponseWriter, err error) {
+	var limited *storage.RateLimitError
+	switch {
+	case errors.Is(err, queue.ErrQueuePaused):
+		w.Header().Set("Retry-After", retryAfterSeconds(queue.PausedRetryAfter))
+		respondError(w, http.StatusServiceUnavailable, err.Error())
+	case errors.As(err, &limited):
+		w.Header().Set("Retry-After", retryAfterSeconds(limited.RetryAfter))
+		respondError(w, http.StatusTooManyRequests, err.Error())
+	default:
+		respondError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// CreateSchedule creates a schedule enqueueing a task with its priority and data every time its
+// cron expression fires
+func (h *Handlers) CreateSchedule(w http.ResponseWriter, r *http.Request) {
+	var schedule storage.Schedule
+	if err := json.NewDecoder(r.Body).Decode(&schedule); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	// validation
+	if schedule.QueueName == "" || schedule.Cron == "" {
+		respondError(w, http.StatusBadRequest, "Queue name and cron expression are required")
+		return
+	}
+	if _, err := schedule.Next(time.Now()); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.service.CreateSchedule(r.Context(), &schedule); err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, schedule)
+}
+
+// GetSchedules lists the schedules, restricted to a queue with ?queue=
+func (h *Handlers) GetSchedules(w http.ResponseWriter, r *http.Request) {
+	schedules, err := h.service.GetSchedules(r.Context(), r.URL.Query().Get("queue"))
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if schedules == nil {
+		schedules = []storage.Schedule{}
+	}
+
+	respondJSON(w, http.StatusOK, schedules)
+}
+
+func (h *Handlers) GetSchedule(w http.ResponseWriter, r *http.Request) {
+	schedule, err := h.service.GetSchedule(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if schedule == nil {
+		respondError(w, http.StatusNotFound, storage.ErrScheduleNotFound.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, schedule)
+}
+
+// PauseSchedule stops a schedule from enqueueing tasks
+func (h *Handlers) PauseSchedule(w http.ResponseWriter, r *http.Request) {
+	h.setSchedulePaused(w, r, true)
+}
+
+// ResumeSchedule enqueues the tasks of a paused schedule again, starting at its next run
+func (h *Handlers) ResumeSchedule(w http.ResponseWriter, r *http.Request) {
+	h.setSchedulePaused(w, r, false)
+}
+
+func (h *Handlers) setSchedulePaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	schedule, err := h.service.SetSchedulePaused(r.Context(), chi.URLParam(r, "id"), paused)
+	if errors.Is(err, storage.ErrScheduleNotFound) {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, schedule)
+}
+
+func (h *Handlers) DeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	err := h.service.DeleteSchedule(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, storage.ErrScheduleNotFound) {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// retryAfterSeconds formats d for the Retry-After header, which counts whole seconds
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
 func respondJSON(w http.ResponseWriter, status int, payload interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
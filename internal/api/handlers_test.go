@@ -0,0 +1,511 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fernandezvara/jobqueues/internal/queue"
+	"github.com/fernandezvara/jobqueues/internal/storage"
+	"github.com/fernandezvara/jobqueues/pkg/jobqueue"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	store := storage.NewMemoryStore()
+	service := queue.NewService(store, queue.WithNotifier(store))
+	server := httptest.NewServer(NewServer(service))
+	t.Cleanup(func() {
+		server.Close()
+		service.Shutdown()
+	})
+	return server
+}
+
+// request sends a JSON request as clientID and decodes the response into result when given
+func request(t *testing.T, server *httptest.Server, method, path, clientID, body string, result interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+path, bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if clientID != "" {
+		req.Header.Set("X-Client-ID", clientID)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			t.Fatalf("%s %s: decoding response: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestTaskLifecycle(t *testing.T) {
+	server := newTestServer(t)
+	request(t, server, http.MethodPut, "/api/v1/queues/q", "", `{"task_timeout": 60000000000}`, nil)
+
+	if status := request(t, server, http.MethodGet, "/api/v1/tasks/next?queue=q", "worker", "", nil); status != http.StatusNotFound {
+		t.Errorf("next task of an empty queue status = %d, want 404", status)
+	}
+
+	var created storage.Task
+	status := request(t, server, http.MethodPost, "/api/v1/tasks", "", `{"queue_name": "q", "data": {"n": 1}}`, &created)
+	if status != http.StatusCreated {
+		t.Fatalf("create status = %d, want 201", status)
+	}
+
+	var claimed storage.Task
+	if status := request(t, server, http.MethodGet, "/api/v1/tasks/next?queue=q", "worker", "", &claimed); status != http.StatusOK {
+		t.Fatalf("next task status = %d, want 200", status)
+	}
+	if claimed.ID != created.ID || claimed.Status != storage.TaskStatusRunning {
+		t.Fatalf("claimed %+v", claimed)
+	}
+
+	path := "/api/v1/tasks/" + claimed.ID
+	if status := request(t, server, http.MethodPut, path, "intruder", `{"status": "completed"}`, nil); status != http.StatusConflict {
+		t.Errorf("completion by another client status = %d, want 409", status)
+	}
+
+	if status := request(t, server, http.MethodPut, path, "worker", `{"status": "failed", "error": {}}`, nil); status != http.StatusBadRequest {
+		t.Errorf("error without message status = %d, want 400", status)
+	}
+
+	var completed storage.Task
+	body := `{"status": "completed", "data": {"n": 2}, "result": {"sum": 3}}`
+	if status := request(t, server, http.MethodPut, path, "worker", body, &completed); status != http.StatusOK {
+		t.Fatalf("completion status = %d, want 200", status)
+	}
+	if completed.Status != storage.TaskStatusCompleted || string(completed.Data) != `{"n":1}` || string(completed.Result) != `{"sum":3}` {
+		t.Errorf("completed task = %+v, want its data kept and its result", completed)
+	}
+
+	var attempts []storage.TaskAttempt
+	if status := request(t, server, http.MethodGet, path+"/attempts", "", "", &attempts); status != http.StatusOK {
+		t.Fatalf("attempts status = %d, want 200", status)
+	}
+	if len(attempts) != 1 || attempts[0].ClientID != "worker" || attempts[0].Outcome != storage.AttemptCompleted || attempts[0].Duration == nil {
+		t.Errorf("attempts = %+v, want the completed claim of worker", attempts)
+	}
+	if status := request(t, server, http.MethodGet, "/api/v1/tasks/missing/attempts", "", "", nil); status != http.StatusNotFound {
+		t.Errorf("attempts of a missing task status = %d, want 404", status)
+	}
+
+	var events []storage.TaskEvent
+	if status := request(t, server, http.MethodGet, path+"/events", "", "", &events); status != http.StatusOK {
+		t.Fatalf("events status = %d, want 200", status)
+	}
+	want := []struct{ event, actorType, actor string }{
+		{storage.EventStatusChanged, storage.ActorClient, "worker"},
+		{storage.EventStatusChanged, storage.ActorClient, "worker"},
+		{storage.EventCreated, storage.ActorAnonymous, ""},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("events = %+v, want %d", events, len(want))
+	}
+	for i, w := range want {
+		if got := events[i]; got.Type != w.event || got.Actor.Type != w.actorType || got.Actor.Name != w.actor || got.Actor.RequestID == "" {
+			t.Errorf("event %d = %+v, want %+v with a request ID", i, got, w)
+		}
+	}
+	if status := request(t, server, http.MethodGet, "/api/v1/events?actor=worker&queue=q&limit=1", "", "", &events); status != http.StatusOK {
+		t.Fatalf("search events status = %d, want 200", status)
+	}
+	if len(events) != 1 || events[0].ToStatus != storage.TaskStatusCompleted {
+		t.Errorf("events of worker = %+v, want the completion", events)
+	}
+	for _, query := range []string{"offset=-1", "limit=abc", "limit=-5", "from=yesterday"} {
+		if status := request(t, server, http.MethodGet, "/api/v1/events?"+query, "", "", nil); status != http.StatusBadRequest {
+			t.Errorf("events with %s status = %d, want 400", query, status)
+		}
+	}
+	if status := request(t, server, http.MethodGet, path+"/events?offset=-1", "", "", nil); status != http.StatusBadRequest {
+		t.Errorf("task events with a negative offset status = %d, want 400", status)
+	}
+}
+
+func TestRequestActor(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    map[string]string
+		basicAuth bool
+		actorType string
+		actor     string
+	}{
+		{"anonymous", nil, false, storage.ActorAnonymous, ""},
+		{"client", map[string]string{"X-Client-ID": "worker", "Authorization": "Bearer secret"}, false, storage.ActorClient, "worker"},
+		{"api key", map[string]string{"Authorization": "Bearer secret"}, false, storage.ActorAPIKey, "2bb80d537b1da3e3"},
+		{"user", nil, true, storage.ActorUser, "admin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/tasks", nil)
+			for key, value := range tt.header {
+				r.Header.Set(key, value)
+			}
+			if tt.basicAuth {
+				r.SetBasicAuth("admin", "password")
+			}
+			if got := requestActor(r); got.Type != tt.actorType || got.Name != tt.actor {
+				t.Errorf("requestActor = %+v, want %s %q", got, tt.actorType, tt.actor)
+			}
+		})
+	}
+}
+
+func TestCreateTaskIdempotencyKey(t *testing.T) {
+	server := newTestServer(t)
+	request(t, server, http.MethodPut, "/api/v1/queues/q", "", `{"task_timeout": 60000000000}`, nil)
+
+	body := `{"queue_name": "q", "idempotency_key": "k"}`
+	var first, second storage.Task
+	if status := request(t, server, http.MethodPost, "/api/v1/tasks", "", body, &first); status != http.StatusCreated {
+		t.Errorf("first create status = %d, want 201", status)
+	}
+	if status := request(t, server, http.MethodPost, "/api/v1/tasks", "", body, &second); status != http.StatusOK {
+		t.Errorf("repeated create status = %d, want 200", status)
+	}
+	if first.ID != second.ID {
+		t.Errorf("repeated create returned %s, want %s", second.ID, first.ID)
+	}
+}
+
+func TestCreateTasksBatch(t *testing.T) {
+	server := newTestServer(t)
+	request(t, server, http.MethodPut, "/api/v1/queues/q", "", `{"task_timeout": 60000000000}`, nil)
+
+	type result struct {
+		Index   int    `json:"index"`
+		ID      string `json:"id"`
+		Created bool   `json:"created"`
+		Error   string `json:"error"`
+	}
+	bodies := map[string]string{
+		"array":  `[{"queue_name": "q"}, {"queue_name": "missing"}, {"queue_name": "q", "delay": -1}]`,
+		"ndjson": "{\"queue_name\": \"q\"}\n{\"queue_name\": \"missing\"}\n{\"queue_name\": \"q\", \"delay\": -1}\n",
+	}
+	for name, body := range bodies {
+		var response struct {
+			Results []result `json:"results"`
+		}
+		status := request(t, server, http.MethodPost, "/api/v1/tasks/batch", "", body, &response)
+		if status != http.StatusOK {
+			t.Fatalf("%s: status = %d, want 200", name, status)
+		}
+		if len(response.Results) != 3 {
+			t.Fatalf("%s: results = %+v", name, response.Results)
+		}
+		if r := response.Results[0]; r.ID == "" || !r.Created || r.Error != "" {
+			t.Errorf("%s: valid item = %+v", name, r)
+		}
+		if r := response.Results[1]; r.Index != 1 || !strings.Contains(r.Error, "does not exist") {
+			t.Errorf("%s: item in a missing queue = %+v", name, r)
+		}
+		if r := response.Results[2]; r.Index != 2 || r.Error == "" || r.ID != "" {
+			t.Errorf("%s: item with a negative delay = %+v", name, r)
+		}
+	}
+
+	var tasks []storage.Task
+	request(t, server, http.MethodGet, "/api/v1/tasks/next?queue=q&count=10", "worker", "", &tasks)
+	if len(tasks) != 2 {
+		t.Errorf("claimed %d tasks, want the 2 created", len(tasks))
+	}
+}
+
+func TestCreateTasksBatchDependencies(t *testing.T) {
+	server := newTestServer(t)
+	request(t, server, http.MethodPut, "/api/v1/queues/q", "", `{"task_timeout": 60000000000}`, nil)
+
+	var parent storage.Task
+	request(t, server, http.MethodPost, "/api/v1/tasks", "", `{"queue_name": "q"}`, &parent)
+
+	var response struct {
+		Results []struct {
+			ID      string `json:"id"`
+			Created bool   `json:"created"`
+			Error   string `json:"error"`
+		} `json:"results"`
+	}
+	body := `[{"queue_name": "q", "depends_on": ["` + parent.ID + `"]}, {"queue_name": "q", "depends_on": ["missing"]}, {"queue_name": "q"}]`
+	if status := request(t, server, http.MethodPost, "/api/v1/tasks/batch", "", body, &response); status != http.StatusOK {
+		t.Fatalf("batch with an unknown dependency status = %d, want 200", status)
+	}
+	if len(response.Results) != 3 {
+		t.Fatalf("results = %+v", response.Results)
+	}
+	for _, i := range []int{0, 2} {
+		if r := response.Results[i]; r.ID == "" || !r.Created || r.Error != "" {
+			t.Errorf("valid item %d = %+v", i, r)
+		}
+	}
+	if r := response.Results[1]; r.Created || !strings.Contains(r.Error, "dependency not found") {
+		t.Errorf("item with an unknown dependency = %+v", r)
+	}
+}
+
+func TestPurgeTasks(t *testing.T) {
+	server := newTestServer(t)
+	request(t, server, http.MethodPut, "/api/v1/queues/q", "", `{"task_timeout": 60000000000, "retention": {"completed": 3600000000000}}`, nil)
+
+	var dryRun map[string]int
+	if status := request(t, server, http.MethodPost, "/api/v1/admin/purge?queue=q&dry_run=true", "", "", &dryRun); status != http.StatusOK {
+		t.Fatalf("dry run status = %d, want 200", status)
+	}
+	if count, ok := dryRun["purgeable"]; !ok || count != 0 {
+		t.Errorf("dry run = %v, want 0 purgeable", dryRun)
+	}
+
+	var purged map[string]int
+	request(t, server, http.MethodPost, "/api/v1/admin/purge", "", "", &purged)
+	if count, ok := purged["purged"]; !ok || count != 0 {
+		t.Errorf("purge = %v, want 0 purged", purged)
+	}
+
+	if status := request(t, server, http.MethodPost, "/api/v1/admin/purge?queue=missing", "", "", nil); status != http.StatusNotFound {
+		t.Errorf("purge of a missing queue status = %d, want 404", status)
+	}
+}
+
+func TestDeleteAndRenameQueue(t *testing.T) {
+	server := newTestServer(t)
+	request(t, server, http.MethodPut, "/api/v1/queues/q", "", `{"task_timeout": 60000000000}`, nil)
+	request(t, server, http.MethodPut, "/api/v1/queues/other", "", `{"task_timeout": 60000000000}`, nil)
+	request(t, server, http.MethodPost, "/api/v1/tasks", "", `{"queue_name": "q"}`, nil)
+
+	if status := request(t, server, http.MethodDelete, "/api/v1/queues/q", "", "", nil); status != http.StatusConflict {
+		t.Errorf("delete of a queue with pending tasks status = %d, want 409", status)
+	}
+	if status := request(t, server, http.MethodDelete, "/api/v1/queues/q?mode=move", "", "", nil); status != http.StatusBadRequest {
+		t.Errorf("move without target status = %d, want 400", status)
+	}
+	if status := request(t, server, http.MethodDelete, "/api/v1/queues/q?mode=move&target=missing", "", "", nil); status != http.StatusBadRequest {
+		t.Errorf("move to a missing queue status = %d, want 400", status)
+	}
+	if status := request(t, server, http.MethodDelete, "/api/v1/queues/q?mode=drop", "", "", nil); status != http.StatusBadRequest {
+		t.Errorf("unknown mode status = %d, want 400", status)
+	}
+
+	var renamed storage.Queue
+	if status := request(t, server, http.MethodPost, "/api/v1/queues/q/rename", "", `{"name": "renamed"}`, &renamed); status != http.StatusOK {
+		t.Fatalf("rename status = %d, want 200", status)
+	}
+	if renamed.Name != "renamed" || renamed.TaskTimeout != time.Minute {
+		t.Errorf("renamed queue = %+v", renamed)
+	}
+	if status := request(t, server, http.MethodPost, "/api/v1/queues/renamed/rename", "", `{"name": "other"}`, nil); status != http.StatusConflict {
+		t.Errorf("rename to an existing queue status = %d, want 409", status)
+	}
+
+	if status := request(t, server, http.MethodDelete, "/api/v1/queues/renamed?mode=move&target=other", "", "", nil); status != http.StatusNoContent {
+		t.Fatalf("delete status = %d, want 204", status)
+	}
+	if status := request(t, server, http.MethodGet, "/api/v1/queues/renamed", "", "", nil); status != http.StatusNotFound {
+		t.Errorf("get of the deleted queue status = %d, want 404", status)
+	}
+	if status := request(t, server, http.MethodDelete, "/api/v1/queues/renamed", "", "", nil); status != http.StatusNotFound {
+		t.Errorf("delete of a missing queue status = %d, want 404", status)
+	}
+
+	var moved storage.Task
+	if status := request(t, server, http.MethodGet, "/api/v1/tasks/next?queue=other", "worker", "", &moved); status != http.StatusOK {
+		t.Errorf("next task of the target queue status = %d, want 200", status)
+	}
+}
+
+func TestPauseQueue(t *testing.T) {
+	server := newTestServer(t)
+	request(t, server, http.MethodPut, "/api/v1/queues/q", "", `{"task_timeout": 60000000000}`, nil)
+
+	var paused storage.Queue
+	if status := request(t, server, http.MethodPost, "/api/v1/queues/q/pause", "", "", &paused); status != http.StatusOK || !paused.Paused {
+		t.Fatalf("pause = %d %+v, want 200 and a paused queue", status, paused)
+	}
+	if status := request(t, server, http.MethodPost, "/api/v1/tasks", "", `{"queue_name": "q"}`, nil); status != http.StatusCreated {
+		t.Errorf("create on a paused queue status = %d, want 201", status)
+	}
+
+	client := jobqueue.NewClient(server.URL, jobqueue.WithClientID("worker"))
+	_, err := client.GetNextTasks(context.Background(), "q", 1)
+	var apiErr *jobqueue.APIError
+	if !jobqueue.IsQueuePaused(err) || !errors.As(err, &apiErr) || apiErr.RetryAfter != queue.PausedRetryAfter {
+		t.Errorf("claim on a paused queue = %v, want a paused error with Retry-After", err)
+	}
+
+	if status := request(t, server, http.MethodPost, "/api/v1/queues/q/resume", "", "", nil); status != http.StatusOK {
+		t.Fatalf("resume status = %d, want 200", status)
+	}
+	if tasks, err := client.GetNextTasks(context.Background(), "q", 1); err != nil || len(tasks) != 1 {
+		t.Errorf("claim after resuming = %d tasks, %v, want 1", len(tasks), err)
+	}
+
+	if status := request(t, server, http.MethodPost, "/api/v1/queues/missing/pause", "", "", nil); status != http.StatusNotFound {
+		t.Errorf("pause of a missing queue status = %d, want 404", status)
+	}
+}
+
+func TestRateLimitedQueue(t *testing.T) {
+	server := newTestServer(t)
+	client := jobqueue.NewClient(server.URL, jobqueue.WithClientID("worker"))
+	ctx := context.Background()
+
+	q, err := client.CreateOrUpdateQueue(ctx, "q", time.Minute, jobqueue.WithRateLimit(0.1, 0))
+	if err != nil {
+		t.Fatalf("CreateOrUpdateQueue: %v", err)
+	}
+	if q.Rate != 0.1 || q.Burst != 1 {
+		t.Fatalf("queue rate = %v burst = %d, want 0.1 and the default burst of 1", q.Rate, q.Burst)
+	}
+	for i := 0; i < 2; i++ {
+		request(t, server, http.MethodPost, "/api/v1/tasks", "", `{"queue_name": "q"}`, nil)
+	}
+
+	if tasks, err := client.GetNextTasks(ctx, "q", 2); err != nil || len(tasks) != 1 {
+		t.Fatalf("first claim = %d tasks, %v, want the burst of 1", len(tasks), err)
+	}
+	_, err = client.GetNextTasks(ctx, "q", 1)
+	var apiErr *jobqueue.APIError
+	if !jobqueue.IsRateLimited(err) || !errors.As(err, &apiErr) || apiErr.RetryAfter != 10*time.Second {
+		t.Errorf("claim over the rate = %v, want a rate limited error with Retry-After 10s", err)
+	}
+}
+
+func TestSchedules(t *testing.T) {
+	server := newTestServer(t)
+	client := jobqueue.NewClient(server.URL)
+	ctx := context.Background()
+	if _, err := client.CreateOrUpdateQueue(ctx, "reports", time.Minute); err != nil {
+		t.Fatalf("CreateOrUpdateQueue: %v", err)
+	}
+
+	schedule, err := client.CreateSchedule(ctx, "reports", "0 8 * * 1-5", map[string]string{"report": "daily"},
+		jobqueue.WithTimezone("Europe/Madrid"), jobqueue.WithSchedulePriority(5))
+	if err != nil {
+		t.Fatalf("CreateSchedule: %v", err)
+	}
+	if schedule.ID == "" || schedule.Priority != 5 || schedule.NextRunAt.Before(time.Now()) {
+		t.Errorf("CreateSchedule = %+v, want an ID, the priority and a future next run", schedule)
+	}
+	if status := request(t, server, http.MethodPost, "/api/v1/schedules", "", `{"queue_name": "reports", "cron": "every day"}`, nil); status != http.StatusBadRequest {
+		t.Errorf("invalid cron expression status = %d, want 400", status)
+	}
+
+	if schedules, err := client.GetSchedules(ctx, "reports"); err != nil || len(schedules) != 1 {
+		t.Errorf("GetSchedules = %d schedules, %v, want 1", len(schedules), err)
+	}
+	if schedules, err := client.GetSchedules(ctx, "other"); err != nil || len(schedules) != 0 {
+		t.Errorf("GetSchedules(other) = %d schedules, %v, want none", len(schedules), err)
+	}
+
+	if paused, err := client.PauseSchedule(ctx, schedule.ID); err != nil || !paused.Paused {
+		t.Errorf("PauseSchedule = %+v, %v, want it paused", paused, err)
+	}
+	if resumed, err := client.ResumeSchedule(ctx, schedule.ID); err != nil || resumed.Paused {
+		t.Errorf("ResumeSchedule = %+v, %v, want it resumed", resumed, err)
+	}
+
+	if err := client.DeleteSchedule(ctx, schedule.ID); err != nil {
+		t.Fatalf("DeleteSchedule: %v", err)
+	}
+	if status := request(t, server, http.MethodGet, "/api/v1/schedules/"+schedule.ID, "", "", nil); status != http.StatusNotFound {
+		t.Errorf("get of a deleted schedule status = %d, want 404", status)
+	}
+	if status := request(t, server, http.MethodPost, "/api/v1/schedules/"+schedule.ID+"/pause", "", "", nil); status != http.StatusNotFound {
+		t.Errorf("pause of a deleted schedule status = %d, want 404", status)
+	}
+}
+
+func TestTaskDependencies(t *testing.T) {
+	server := newTestServer(t)
+	request(t, server, http.MethodPut, "/api/v1/queues/etl", "", `{"task_timeout": 60000000000}`, nil)
+
+	var extract, load storage.Task
+	request(t, server, http.MethodPost, "/api/v1/tasks", "", `{"queue_name": "etl", "data": {"step": "extract"}}`, &extract)
+	status := request(t, server, http.MethodPost, "/api/v1/tasks", "",
+		`{"queue_name": "etl", "data": {"step": "load"}, "depends_on": ["`+extract.ID+`"]}`, &load)
+	if status != http.StatusCreated || load.Status != storage.TaskStatusBlocked {
+		t.Fatalf("create dependent task status = %d, task %+v, want 201 and blocked", status, load)
+	}
+	if status := request(t, server, http.MethodPost, "/api/v1/tasks", "", `{"queue_name": "etl", "depends_on": ["missing"]}`, nil); status != http.StatusBadRequest {
+		t.Errorf("unknown dependency status = %d, want 400", status)
+	}
+
+	var graph storage.TaskGraph
+	if status := request(t, server, http.MethodGet, "/api/v1/tasks/"+extract.ID+"/graph", "", "", &graph); status != http.StatusOK {
+		t.Fatalf("graph status = %d, want 200", status)
+	}
+	if len(graph.Tasks) != 2 || len(graph.Edges) != 1 || graph.Edges[0] != (storage.TaskDependency{TaskID: load.ID, DependsOn: extract.ID}) {
+		t.Errorf("graph = %+v, want extract and load", graph)
+	}
+	if status := request(t, server, http.MethodGet, "/api/v1/tasks/missing/graph", "", "", nil); status != http.StatusNotFound {
+		t.Errorf("graph of a missing task status = %d, want 404", status)
+	}
+
+	var claimed storage.Task
+	request(t, server, http.MethodGet, "/api/v1/tasks/next?queue=etl", "worker", "", &claimed)
+	if claimed.ID != extract.ID {
+		t.Fatalf("claimed %s, want the task without dependencies %s", claimed.ID, extract.ID)
+	}
+	request(t, server, http.MethodPut, "/api/v1/tasks/"+claimed.ID, "worker", `{"status": "completed"}`, nil)
+
+	if request(t, server, http.MethodGet, "/api/v1/tasks/next?queue=etl", "worker", "", &claimed); claimed.ID != load.ID {
+		t.Errorf("claimed %s after its dependency completed, want %s", claimed.ID, load.ID)
+	}
+}
+
+func TestBatches(t *testing.T) {
+	server := newTestServer(t)
+	request(t, server, http.MethodPut, "/api/v1/queues/resize", "", `{"task_timeout": 60000000000}`, nil)
+	request(t, server, http.MethodPut, "/api/v1/queues/notify", "", `{"task_timeout": 60000000000}`, nil)
+
+	var batch storage.Batch
+	status := request(t, server, http.MethodPost, "/api/v1/batches", "",
+		`{"tasks": [{"queue_name": "resize", "data": {"image": 1}}, {"queue_name": "resize", "data": {"image": 2}}],
+		"callback": {"queue_name": "notify", "data": {"album": 7}}}`, &batch)
+	if status != http.StatusCreated || batch.Total != 2 || batch.Counts[storage.TaskStatusPending] != 2 {
+		t.Fatalf("create batch status = %d, batch %+v, want 201 with 2 pending tasks", status, batch)
+	}
+	for _, body := range []string{`{"tasks": []}`, `{"tasks": [{"queue_name": "resize", "idempotency_key": "k"}]}`,
+		`{"tasks": [{"queue_name": "resize"}], "callback": {}}`} {
+		if status := request(t, server, http.MethodPost, "/api/v1/batches", "", body, nil); status != http.StatusBadRequest {
+			t.Errorf("create batch %s status = %d, want 400", body, status)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		var claimed storage.Task
+		request(t, server, http.MethodGet, "/api/v1/tasks/next?queue=resize", "worker", "", &claimed)
+		if claimed.BatchID == nil || *claimed.BatchID != batch.ID {
+			t.Fatalf("claimed task batch = %v, want %s", claimed.BatchID, batch.ID)
+		}
+		request(t, server, http.MethodPut, "/api/v1/tasks/"+claimed.ID, "worker", `{"status": "completed"}`, nil)
+	}
+
+	if status := request(t, server, http.MethodGet, "/api/v1/batches/"+batch.ID, "", "", &batch); status != http.StatusOK {
+		t.Fatalf("get batch status = %d, want 200", status)
+	}
+	if batch.FinishedAt == nil || batch.Counts[storage.TaskStatusCompleted] != 2 || batch.CallbackTaskID == nil {
+		t.Fatalf("finished batch = %+v", batch)
+	}
+	var callback storage.Task
+	if request(t, server, http.MethodGet, "/api/v1/tasks/next?queue=notify", "worker", "", &callback); callback.ID != *batch.CallbackTaskID {
+		t.Errorf("claimed %s from the callback queue, want %v", callback.ID, batch.CallbackTaskID)
+	}
+	if status := request(t, server, http.MethodGet, "/api/v1/batches/missing", "", "", nil); status != http.StatusNotFound {
+		t.Errorf("missing batch status = %d, want 404", status)
+	}
+}
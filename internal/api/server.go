@@ -35,19 +35,42 @@ func (s *Server) setupRoutes() {
 	handlers := NewHandlers(s.service)
 
 	s.router.Route("/api/v1", func(r chi.Router) {
+		r.Use(withActor)
 		r.Get("/queues", handlers.GetQueues)
 		r.Route("/queues/{name}", func(r chi.Router) {
 			r.Get("/", handlers.GetQueue)
 			r.Put("/", handlers.CreateOrUpdateQueue)
+			r.Delete("/", handlers.DeleteQueue)
+			r.Post("/rename", handlers.RenameQueue)
+			r.Post("/pause", handlers.PauseQueue)
+			r.Post("/resume", handlers.ResumeQueue)
+			r.Post("/dead-letter/redrive", handlers.RedriveDeadLetters)
 		})
 		// r.Put("/queue/{name}", handlers.CreateOrUpdateQueue)
 		r.Post("/tasks", handlers.CreateTask)
+		r.Post("/tasks/batch", handlers.CreateTasks)
 		r.Get("/tasks", handlers.GetTasks)
 		r.Get("/tasks/next", handlers.GetNextTask)
 		r.Route("/tasks/{id}", func(r chi.Router) {
 			r.Put("/", handlers.UpdateTask)
 			r.Delete("/", handlers.DeleteTask)
+			r.Post("/heartbeat", handlers.Heartbeat)
+			r.Get("/graph", handlers.GetTaskGraph)
+			r.Get("/attempts", handlers.GetTaskAttempts)
+			r.Get("/events", handlers.GetTaskEvents)
 		})
+		r.Get("/events", handlers.GetEvents)
+		r.Post("/batches", handlers.CreateBatch)
+		r.Get("/batches/{id}", handlers.GetBatch)
+		r.Post("/schedules", handlers.CreateSchedule)
+		r.Get("/schedules", handlers.GetSchedules)
+		r.Route("/schedules/{id}", func(r chi.Router) {
+			r.Get("/", handlers.GetSchedule)
+			r.Delete("/", handlers.DeleteSchedule)
+			r.Post("/pause", handlers.PauseSchedule)
+			r.Post("/resume", handlers.ResumeSchedule)
+		})
+		r.Post("/admin/purge", handlers.PurgeTasks)
 	})
 
 	s.router.Get("/health", handlers.HealthCheck) // Health check route
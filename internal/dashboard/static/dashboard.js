@@ -5,6 +5,8 @@ document.addEventListener('alpine:init', () => {
         statistics: {
             all: 0,
             pending: 0,
+            blocked: 0,
+            scheduled: 0,
             running: 0,
             completed: 0,
             failed: 0,
@@ -17,6 +19,11 @@ document.addEventListener('alpine:init', () => {
         pageSize: 10,
         showModal: false,
         selectedTaskData: null,
+        // task detail
+        showDetail: false,
+        selectedTask: null,
+        taskAttempts: [],
+        taskEvents: [],
         filters: {
             queue: '',
             status: '',
@@ -33,6 +40,15 @@ document.addEventListener('alpine:init', () => {
         updateTimer: null,
         // pagination
         pageSize: 10,
+        // schedules tab
+        activeTab: 'tasks',
+        schedules: [],
+        newSchedule: {
+            queue_name: '',
+            cron: '',
+            timezone: '',
+            data: '{}'
+        },
 
 
         async init() {
@@ -53,6 +69,10 @@ document.addEventListener('alpine:init', () => {
             return this.startIndex + this.tasks.length < this.totalTasks;
         },
 
+        get selectedQueue() {
+            return this.queues.find(queue => queue.name === this.filters.queue);
+        },
+
         loadUserPreferences() {
             // load refresh interval from local storage
             const savedInterval = localStorage.getItem('refreshInterval');
@@ -78,6 +98,7 @@ document.addEventListener('alpine:init', () => {
         getStatusClass(status) {
             const classes = {
                 pending: 'bg-yellow-100 text-yellow-800',
+                blocked: 'bg-orange-100 text-orange-800',
                 running: 'bg-blue-100 text-blue-800',
                 completed: 'bg-green-100 text-green-800',
                 failed: 'bg-red-100 text-red-800',
@@ -90,6 +111,25 @@ document.addEventListener('alpine:init', () => {
             return new Date(dateString).toLocaleString();
         },
 
+        // durations are returned in nanoseconds
+        formatAttemptDuration(nanoseconds) {
+            if (nanoseconds === null || nanoseconds === undefined) return '-';
+            const ms = nanoseconds / 1e6;
+            if (ms < 1000) return `${Math.round(ms)} ms`;
+            return `${(ms / 1000).toFixed(1)} s`;
+        },
+
+        getAttemptClass(outcome) {
+            const classes = {
+                running: 'bg-blue-100 text-blue-800',
+                completed: 'bg-green-100 text-green-800',
+                failed: 'bg-red-100 text-red-800',
+                timeout: 'bg-orange-100 text-orange-800',
+                cancelled: 'bg-gray-100 text-gray-800'
+            };
+            return classes[outcome] || 'bg-gray-100 text-gray-800';
+        },
+
         async loadQueues() {
             try {
                 const response = await fetch('/api/v1/queues');
@@ -192,6 +232,102 @@ document.addEventListener('alpine:init', () => {
             localStorage.setItem('queueFilter', this.filters.queue);
             localStorage.setItem('statusFilter', this.filters.status);
             await this.loadData(true);
+            if (this.activeTab === 'schedules') {
+                await this.loadSchedules();
+            }
+        },
+
+        async showTab(tab) {
+            this.activeTab = tab;
+            if (tab === 'schedules') {
+                await this.loadSchedules();
+            }
+        },
+
+        // method to load the schedules of the selected queue
+        async loadSchedules() {
+            try {
+                const queryParams = new URLSearchParams();
+                if (this.filters.queue) queryParams.set('queue', this.filters.queue);
+
+                const response = await fetch(`/api/v1/schedules?${queryParams}`);
+                if (!response.ok) throw new Error('Failed to load schedules');
+
+                this.schedules = await response.json();
+            } catch (error) {
+                this.showError('Error loading schedules');
+                console.error('Error loading schedules:', error);
+            }
+        },
+
+        async createSchedule() {
+            let data;
+            try {
+                data = JSON.parse(this.newSchedule.data || '{}');
+            } catch (error) {
+                this.showError('Schedule data must be valid JSON');
+                return;
+            }
+
+            try {
+                const response = await fetch('/api/v1/schedules', {
+                    method: 'POST',
+                    headers: {
+                        'Content-Type': 'application/json'
+                    },
+                    body: JSON.stringify({ ...this.newSchedule, data })
+                });
+
+                if (!response.ok) {
+                    const body = await response.json();
+                    throw new Error(body.error || 'Failed to create schedule');
+                }
+
+                this.showSuccess('Schedule created');
+                this.newSchedule = { queue_name: '', cron: '', timezone: '', data: '{}' };
+                await this.loadSchedules();
+            } catch (error) {
+                this.showError(`Error creating schedule: ${error.message}`);
+                console.error('Error creating schedule:', error);
+            }
+        },
+
+        async toggleSchedulePause(schedule) {
+            const action = schedule.paused ? 'resume' : 'pause';
+            try {
+                const response = await fetch(`/api/v1/schedules/${schedule.id}/${action}`, {
+                    method: 'POST'
+                });
+
+                if (!response.ok) throw new Error(`Failed to ${action} schedule`);
+
+                const updated = await response.json();
+                Object.assign(schedule, updated);
+                this.showSuccess(updated.paused ? 'Schedule paused' : 'Schedule resumed');
+            } catch (error) {
+                this.showError(`Error trying to ${action} schedule`);
+                console.error(`Error trying to ${action} schedule:`, error);
+            }
+        },
+
+        async deleteSchedule(schedule) {
+            if (!confirm(`Are you sure you want to delete schedule '${schedule.id}'?`)) {
+                return;
+            }
+
+            try {
+                const response = await fetch(`/api/v1/schedules/${schedule.id}`, {
+                    method: 'DELETE'
+                });
+
+                if (!response.ok) throw new Error('Failed to delete schedule');
+
+                this.showSuccess('Schedule deleted successfully');
+                await this.loadSchedules();
+            } catch (error) {
+                this.showError('Error deleting schedule');
+                console.error('Error deleting schedule:', error);
+            }
         },
 
         getSuccessRate() {
@@ -219,10 +355,11 @@ document.addEventListener('alpine:init', () => {
                 this.charts.statusDistribution = new Chart(ctx, {
                     type: 'pie',
                     data: {
-                        labels: ['Pending', 'Running', 'Completed', 'Failed', 'Deleted'],
+                        labels: ['Pending', 'Blocked', 'Running', 'Completed', 'Failed', 'Deleted'],
                         datasets: [{
                             data: [
                                 this.statistics.pending,
+                                this.statistics.blocked,
                                 this.statistics.running,
                                 this.statistics.completed,
                                 this.statistics.failed,
@@ -230,6 +367,7 @@ document.addEventListener('alpine:init', () => {
                             ],
                             backgroundColor: [
                                 '#FCD34D', // pending
+                                '#FB923C', // blocked
                                 '#60A5FA', // running
                                 '#34D399', // completed
                                 '#F87171', // failed
@@ -263,6 +401,24 @@ document.addEventListener('alpine:init', () => {
             }
         },
 
+        async toggleQueuePause(queue) {
+            const action = queue.paused ? 'resume' : 'pause';
+            try {
+                const response = await fetch(`/api/v1/queues/${queue.name}/${action}`, {
+                    method: 'POST'
+                });
+
+                if (!response.ok) throw new Error(`Failed to ${action} queue`);
+
+                const updated = await response.json();
+                queue.paused = updated.paused;
+                this.showSuccess(updated.paused ? 'Queue paused, workers stop picking up its tasks' : 'Queue resumed');
+            } catch (error) {
+                this.showError(`Error trying to ${action} queue`);
+                console.error(`Error trying to ${action} queue:`, error);
+            }
+        },
+
         async deleteTask(task) {
             if (!confirm(`Are you sure you want to delete task '${task.id}'?`)) {
                 return;
@@ -293,8 +449,7 @@ document.addEventListener('alpine:init', () => {
                         'Content-Type': 'application/json'
                     },
                     body: JSON.stringify({
-                        status: 'pending',
-                        data: task.data
+                        status: 'pending'
                     })
                 });
 
@@ -311,7 +466,35 @@ document.addEventListener('alpine:init', () => {
         },
 
         showTaskData(task) {
-            this.selectedTaskData = task.data;
+            this.selectedTaskData = {
+                data: task.data,
+                result: task.result,
+                error: task.error
+            };
+            this.showModal = true;
+        },
+
+        async showTaskDetail(task) {
+            this.selectedTask = task;
+            this.taskAttempts = [];
+            this.taskEvents = [];
+            this.showDetail = true;
+            try {
+                const [attempts, events] = await Promise.all([
+                    fetch(`/api/v1/tasks/${task.id}/attempts`),
+                    fetch(`/api/v1/tasks/${task.id}/events?limit=100`)
+                ]);
+                if (!attempts.ok || !events.ok) throw new Error('Failed to load task history');
+                this.taskAttempts = await attempts.json();
+                this.taskEvents = await events.json();
+            } catch (error) {
+                this.showError('Error loading task history');
+                console.error('Error loading task history:', error);
+            }
+        },
+
+        showScheduleData(schedule) {
+            this.selectedTaskData = schedule.data;
             this.showModal = true;
         },
 
@@ -396,7 +579,8 @@ document.addEventListener('alpine:init', () => {
             this.refreshTimer = setInterval(async () => {
                 await Promise.all([
                     this.loadTasks(),
-                    this.loadStatistics()
+                    this.loadStatistics(),
+                    this.activeTab === 'schedules' ? this.loadSchedules() : null
                 ]);
                 this.updateNextRefresh();
             }, this.refreshInterval * 1000);
@@ -0,0 +1,103 @@
+package queue
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/fernandezvara/jobqueues/internal/storage"
+)
+
+// purgeBatchSize bounds the number of tasks deleted or archived by a single statement
+const purgeBatchSize = 500
+
+// Janitor periodically moves old finished tasks to the archive and purges the ones that
+// outlived the retention of their queue
+type Janitor struct {
+	store    storage.Store
+	interval time.Duration
+	stopChan chan struct{}
+	doneChan chan struct{}
+}
+
+func NewJanitor(store storage.Store, interval time.Duration) *Janitor {
+	return &Janitor{
+		store:    store,
+		interval: interval,
+		stopChan: make(chan struct{}),
+		doneChan: make(chan struct{}),
+	}
+}
+
+func (j *Janitor) Start() {
+	go j.run()
+}
+
+func (j *Janitor) Stop() {
+	close(j.stopChan)
+	<-j.doneChan
+}
+
+func (j *Janitor) run() {
+	defer close(j.doneChan)
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-j.stopChan:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+			asOf := time.Now()
+			archived, err := inBatches(ctx, j.stopChan, func() (int, error) {
+				return j.store.ArchiveTasks(ctx, asOf, purgeBatchSize)
+			})
+			if err != nil {
+				log.Printf("Error archiving tasks: %v", err)
+			}
+			if archived > 0 {
+				log.Printf("Archived %d finished tasks", archived)
+			}
+
+			purged, err := purgeTasks(ctx, j.store, "", j.stopChan)
+			if err != nil {
+				log.Printf("Error purging tasks: %v", err)
+			}
+			if purged > 0 {
+				log.Printf("Purged %d tasks past their retention", purged)
+			}
+			cancel()
+		}
+	}
+}
+
+// purgeTasks deletes the tasks past their retention in batches
+func purgeTasks(ctx context.Context, store storage.Store, queueName string, stop <-chan struct{}) (int, error) {
+	asOf := time.Now()
+	return inBatches(ctx, stop, func() (int, error) {
+		return store.PurgeTasks(ctx, queueName, asOf, purgeBatchSize)
+	})
+}
+
+// inBatches runs batch, which handles up to purgeBatchSize tasks, until a batch comes short,
+// the context is done or stop is closed. It returns the total number of tasks handled.
+func inBatches(ctx context.Context, stop <-chan struct{}, batch func() (int, error)) (int, error) {
+	total := 0
+	for {
+		n, err := batch()
+		total += n
+		if err != nil || n < purgeBatchSize {
+			return total, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return total, ctx.Err()
+		case <-stop:
+			return total, nil
+		default:
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package queue
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/fernandezvara/jobqueues/internal/storage"
+)
+
+// scheduleBatchSize bounds the number of schedules run by a single transaction
+const scheduleBatchSize = 100
+
+// Scheduler periodically enqueues the tasks of the due schedules. Every replica runs one, the
+// store makes sure each run of a schedule is enqueued once.
+type Scheduler struct {
+	store    storage.Store
+	interval time.Duration
+	stopChan chan struct{}
+	doneChan chan struct{}
+}
+
+func NewScheduler(store storage.Store, interval time.Duration) *Scheduler {
+	return &Scheduler{
+		store:    store,
+		interval: interval,
+		stopChan: make(chan struct{}),
+		doneChan: make(chan struct{}),
+	}
+}
+
+func (s *Scheduler) Start() {
+	go s.run()
+}
+
+func (s *Scheduler) Stop() {
+	close(s.stopChan)
+	<-s.doneChan
+}
+
+func (s *Scheduler) run() {
+	defer close(s.doneChan)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			s.runDue(ctx, time.Now())
+			cancel()
+		}
+	}
+}
+
+// runDue runs the schedules due at asOf in batches
+func (s *Scheduler) runDue(ctx context.Context, asOf time.Time) {
+	for {
+		ran, err := s.store.RunDueSchedules(ctx, asOf, scheduleBatchSize)
+		if err != nil {
+			log.Printf("Error running schedules: %v", err)
+			return
+		}
+		if ran < scheduleBatchSize {
+			return
+		}
+
+		select {
+		case <-s.stopChan:
+			return
+		default:
+		}
+	}
+}
@@ -2,7 +2,10 @@ package queue
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/fernandezvara/jobqueues/internal/storage"
@@ -13,27 +16,97 @@ type Service interface {
 	GetQueue(ctx context.Context, name string) (*storage.Queue, error)
 	GetQueues(ctx context.Context) ([]storage.Queue, error)
 	CreateOrUpdateQueue(ctx context.Context, queue *storage.Queue) error
-	CreateTask(ctx context.Context, task *storage.Task) error
-	UpdateTask(ctx context.Context, task *storage.Task) error
+	DeleteQueue(ctx context.Context, name, mode, target string) error
+	RenameQueue(ctx context.Context, name, newName string) (*storage.Queue, error)
+	SetQueuePaused(ctx context.Context, name string, paused bool) (*storage.Queue, error)
+	CreateTask(ctx context.Context, task *storage.Task) (bool, error)
+	CreateTasks(ctx context.Context, tasks []storage.Task) ([]BatchResult, error)
+	UpdateTask(ctx context.Context, task *storage.Task, clientID string) error
 	GetTask(ctx context.Context, id string) (*storage.Task, error)
+	GetTaskGraph(ctx context.Context, id string) (*storage.TaskGraph, error)
+	GetTaskAttempts(ctx context.Context, id string) ([]storage.TaskAttempt, error)
+	GetTaskEvents(ctx context.Context, filter storage.TaskEventFilter) ([]storage.TaskEvent, error)
+	CreateBatch(ctx context.Context, batch *storage.Batch, tasks []storage.Task) (*storage.Batch, error)
+	GetBatch(ctx context.Context, id string) (*storage.Batch, error)
 	GetTasks(ctx context.Context, filter storage.TaskFilter) ([]storage.Task, error)
 	GetTaskStats(ctx context.Context, filter storage.TaskFilter) (map[string]int, error)
-	GetNextTask(ctx context.Context, queueName, clientID string) (*storage.Task, error)
+	GetNextTask(ctx context.Context, queueName, clientID string, wait time.Duration) (*storage.Task, error)
+	GetNextTasks(ctx context.Context, queueName, clientID string, count int, wait time.Duration) ([]storage.Task, error)
+	Heartbeat(ctx context.Context, id, clientID string, extension time.Duration) (time.Time, error)
 	DeleteTask(ctx context.Context, id string) error
+	RedriveDeadLetters(ctx context.Context, queueName string, taskIDs []string) (int, error)
+	PurgeTasks(ctx context.Context, queueName string, dryRun bool) (int, error)
+	CreateSchedule(ctx context.Context, schedule *storage.Schedule) error
+	GetSchedule(ctx context.Context, id string) (*storage.Schedule, error)
+	GetSchedules(ctx context.Context, queueName string) ([]storage.Schedule, error)
+	SetSchedulePaused(ctx context.Context, id string, paused bool) (*storage.Schedule, error)
+	DeleteSchedule(ctx context.Context, id string) error
 	Shutdown() error
 }
 
+const (
+	// MaxBatchSize limits the number of tasks created in a single batch
+	MaxBatchSize = 1000
+	// maxClaimCount limits the number of tasks handed out in a single claim
+	maxClaimCount = 100
+	// MaxWait limits how long a claim waits for tasks to become available
+	MaxWait = time.Minute
+	// waitPollInterval is how often a waiting claim checks for tasks without being notified,
+	// e.g. for scheduled tasks becoming due
+	waitPollInterval = 5 * time.Second
+	// PausedRetryAfter is how long clients are told to wait before claiming from a paused queue again
+	PausedRetryAfter = 10 * time.Second
+)
+
+// ErrQueuePaused is returned when claiming tasks of a paused queue
+var ErrQueuePaused = errors.New("queue is paused")
+
+// ErrInvalidDeleteMode is returned when deleting a queue with an unknown mode, or moving its
+// tasks to a missing target
+var ErrInvalidDeleteMode = errors.New("invalid delete mode")
+
+// ErrInvalidStatusTransition is returned when an update moves a task to a status it cannot
+// reach from its current one
+var ErrInvalidStatusTransition = errors.New("invalid status transition")
+
+// BatchResult is the outcome of one task of a batch
+type BatchResult struct {
+	Task    *storage.Task
+	Created bool // false when an existing task was returned for its idempotency key
+	Err     error
+}
+
 type service struct {
 	store         storage.Store
+	notifier      storage.Notifier
 	timeoutWorker *TimeoutWorker
+	janitor       *Janitor
+	scheduler     *Scheduler
 }
 
-func NewService(store storage.Store) Service {
+// Option configures the service
+type Option func(*service)
+
+// WithNotifier wakes up waiting claims as soon as tasks are available instead of polling
+func WithNotifier(notifier storage.Notifier) Option {
+	return func(s *service) {
+		s.notifier = notifier
+	}
+}
+
+func NewService(store storage.Store, opts ...Option) Service {
 	s := &service{
 		store:         store,
 		timeoutWorker: NewTimeoutWorker(store, 30*time.Second),
+		janitor:       NewJanitor(store, time.Minute),
+		scheduler:     NewScheduler(store, time.Second),
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
 	s.timeoutWorker.Start()
+	s.janitor.Start()
+	s.scheduler.Start()
 	return s
 }
 
@@ -55,31 +128,208 @@ func (s *service) CreateOrUpdateQueue(ctx context.Context, queue *storage.Queue)
 	if queue.TaskTimeout <= 0 {
 		return fmt.Errorf("task timeout must be positive")
 	}
+	if err := validateRetryPolicy(queue.RetryPolicy); err != nil {
+		return err
+	}
+	if queue.IdempotencyTTL < 0 {
+		return fmt.Errorf("idempotency TTL cannot be negative")
+	}
+	if queue.Retention.Completed < 0 || queue.Retention.Failed < 0 || queue.Retention.Deleted < 0 {
+		return fmt.Errorf("retention cannot be negative")
+	}
+	if queue.ArchiveAfter < 0 {
+		return fmt.Errorf("archive age cannot be negative")
+	}
+	if queue.MaxRunning < 0 {
+		return fmt.Errorf("max running cannot be negative")
+	}
+	if queue.Rate < 0 || queue.Burst < 0 {
+		return fmt.Errorf("rate and burst cannot be negative")
+	}
+	// without a burst a rate hands out one task at a time
+	if queue.Rate > 0 && queue.Burst == 0 {
+		queue.Burst = 1
+	}
+	if queue.DeadLetterQueue != "" {
+		if queue.DeadLetterQueue == queue.Name {
+			return fmt.Errorf("a queue cannot be its own dead-letter queue")
+		}
+		deadLetterQueue, err := s.store.GetQueue(ctx, queue.DeadLetterQueue)
+		if err != nil {
+			return fmt.Errorf("error checking dead-letter queue: %w", err)
+		}
+		if deadLetterQueue == nil {
+			return fmt.Errorf("dead-letter queue %s does not exist", queue.DeadLetterQueue)
+		}
+	}
 	return s.store.CreateOrUpdateQueue(ctx, queue)
 }
 
-func (s *service) CreateTask(ctx context.Context, task *storage.Task) error {
-	if task.QueueName == "" {
+// DeleteQueue removes a queue, mode tells what happens to its pending and running tasks, see
+// storage.Store.DeleteQueue. An empty mode refuses to delete a queue with active tasks.
+func (s *service) DeleteQueue(ctx context.Context, name, mode, target string) error {
+	if name == "" {
 		return fmt.Errorf("queue name is required")
 	}
+	if mode == "" {
+		mode = storage.DeleteQueueRestrict
+	}
+
+	switch mode {
+	case storage.DeleteQueueRestrict, storage.DeleteQueueCascade:
+	case storage.DeleteQueueMove:
+		if target == "" {
+			return fmt.Errorf("%w: target queue is required to move tasks", ErrInvalidDeleteMode)
+		}
+		if target == name {
+			return fmt.Errorf("%w: tasks cannot be moved to the deleted queue", ErrInvalidDeleteMode)
+		}
+		targetQueue, err := s.store.GetQueue(ctx, target)
+		if err != nil {
+			return fmt.Errorf("error checking target queue: %w", err)
+		}
+		if targetQueue == nil {
+			return fmt.Errorf("%w: target queue %s does not exist", ErrInvalidDeleteMode, target)
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrInvalidDeleteMode, mode)
+	}
+	return s.store.DeleteQueue(ctx, name, mode, target)
+}
+
+// RenameQueue renames a queue, moving its tasks and dead letters along
+func (s *service) RenameQueue(ctx context.Context, name, newName string) (*storage.Queue, error) {
+	if name == "" || newName == "" {
+		return nil, fmt.Errorf("queue name is required")
+	}
+	if err := s.store.RenameQueue(ctx, name, newName); err != nil {
+		return nil, err
+	}
+	return s.store.GetQueue(ctx, newName)
+}
+
+// SetQueuePaused pauses or resumes a queue. Paused queues keep accepting tasks, but claims fail
+// with ErrQueuePaused until the queue is resumed.
+func (s *service) SetQueuePaused(ctx context.Context, name string, paused bool) (*storage.Queue, error) {
+	if name == "" {
+		return nil, fmt.Errorf("queue name is required")
+	}
+	if err := s.store.SetQueuePaused(ctx, name, paused); err != nil {
+		return nil, err
+	}
+	return s.store.GetQueue(ctx, name)
+}
+
+// CreateTask enqueues a new task. When the task carries an idempotency key already used
+// by an active task of the queue, the existing task is returned in task and created is false.
+// A task with dependencies is blocked until all of them complete, see storage.ErrDependencyNotFound.
+func (s *service) CreateTask(ctx context.Context, task *storage.Task) (bool, error) {
+	if task.QueueName == "" {
+		return false, fmt.Errorf("queue name is required")
+	}
 
 	// Verify that the queue exists
 	queue, err := s.store.GetQueue(ctx, task.QueueName)
 	if err != nil {
-		return fmt.Errorf("error checking queue: %w", err)
+		return false, fmt.Errorf("error checking queue: %w", err)
 	}
 	if queue == nil {
-		return fmt.Errorf("queue %s does not exist", task.QueueName)
+		return false, fmt.Errorf("queue %s does not exist", task.QueueName)
 	}
 
+	prepareTask(task)
+	if task.IdempotencyKey != nil {
+		return s.store.CreateUniqueTask(ctx, task, queue.IdempotencyTTL)
+	}
+
+	return true, s.store.CreateTask(ctx, task)
+}
+
+// CreateTasks enqueues a batch of tasks in a single transaction. Tasks that cannot be created,
+// e.g. because their queue does not exist, get an error in their result and the others are
+// still created. The returned error is only set when the whole batch failed.
+func (s *service) CreateTasks(ctx context.Context, tasks []storage.Task) ([]BatchResult, error) {
+	if len(tasks) > MaxBatchSize {
+		return nil, fmt.Errorf("a batch cannot have more than %d tasks", MaxBatchSize)
+	}
+
+	results := make([]BatchResult, len(tasks))
+	queues := make(map[string]*storage.Queue)
+	idempotencyTTLs := make(map[string]time.Duration)
+	var valid []*storage.Task
+	var validIndexes []int
+
+	for i := range tasks {
+		task := &tasks[i]
+		results[i].Task = task
+		if task.QueueName == "" {
+			results[i].Err = fmt.Errorf("queue name is required")
+			continue
+		}
+
+		queue, checked := queues[task.QueueName]
+		if !checked {
+			var err error
+			queue, err = s.store.GetQueue(ctx, task.QueueName)
+			if err != nil {
+				return nil, fmt.Errorf("error checking queue: %w", err)
+			}
+			queues[task.QueueName] = queue
+		}
+		if queue == nil {
+			results[i].Err = fmt.Errorf("queue %s does not exist", task.QueueName)
+			continue
+		}
+		idempotencyTTLs[queue.Name] = queue.IdempotencyTTL
+
+		prepareTask(task)
+		valid = append(valid, task)
+		validIndexes = append(validIndexes, i)
+	}
+
+	if len(valid) == 0 {
+		return results, nil
+	}
+
+	created, errs, err := s.store.CreateTasks(ctx, valid, idempotencyTTLs)
+	if err != nil {
+		return nil, err
+	}
+	for i, index := range validIndexes {
+		results[index].Created = created[i]
+		results[index].Err = errs[i]
+	}
+	return results, nil
+}
+
+// prepareTask sets the server generated fields of a new task
+func prepareTask(task *storage.Task) {
 	// Generate unique ID
 	task.ID = xid.New().String()
 	task.Status = storage.TaskStatusPending
 
-	return s.store.CreateTask(ctx, task)
+	// The store settles the status of blocked tasks from their dependencies
+	if len(task.DependsOn) > 0 {
+		task.Status = storage.TaskStatusBlocked
+		slices.Sort(task.DependsOn)
+		task.DependsOn = slices.Compact(task.DependsOn)
+	}
+
+	// Scheduled times are stored without time zone, normalize them to UTC
+	if task.RunAt != nil {
+		runAt := task.RunAt.UTC()
+		task.RunAt = &runAt
+	}
+
+	if task.IdempotencyKey != nil && *task.IdempotencyKey == "" {
+		task.IdempotencyKey = nil
+	}
 }
 
-func (s *service) UpdateTask(ctx context.Context, task *storage.Task) error {
+// UpdateTask updates a task on behalf of clientID. Reporting a result (completed or failed)
+// requires clientID to hold the attempt of the running task given in task.Attempt, otherwise
+// storage.ErrLeaseLost is returned and the task is left untouched.
+func (s *service) UpdateTask(ctx context.Context, task *storage.Task, clientID string) error {
 	if task.ID == "" {
 		return fmt.Errorf("task ID is required")
 	}
@@ -93,14 +343,83 @@ func (s *service) UpdateTask(ctx context.Context, task *storage.Task) error {
 		return fmt.Errorf("task %s does not exist", task.ID)
 	}
 
-	// Validate status transitions
-	if !isValidStatusTransition(existingTask.Status, task.Status) {
-		return fmt.Errorf("invalid status transition from %s to %s", existingTask.Status, task.Status)
+	// Results can only be reported by the worker holding the current attempt of a running task
+	statusChanged := task.Status != existingTask.Status
+	reportsResult := isResultStatus(task.Status) && (statusChanged || task.Attempt != existingTask.Attempt)
+	if reportsResult && !holdsClaim(existingTask, clientID, task.Attempt) {
+		return storage.ErrLeaseLost
+	}
+
+	// Validate status transitions, updates keeping the status (e.g. a priority change) are always allowed
+	if statusChanged && !isValidStatusTransition(existingTask.Status, task.Status) {
+		return fmt.Errorf("%w from %s to %s", ErrInvalidStatusTransition, existingTask.Status, task.Status)
 	}
 
+	// Attempt bookkeeping and the claim are owned by the server and the input data never
+	// changes, workers report what they produced in the result and error of the task
+	task.Data = existingTask.Data
+	task.QueueName = existingTask.QueueName
+	task.Attempt = existingTask.Attempt
+	task.NextAttemptAt = existingTask.NextAttemptAt
+	task.OriginalQueue = existingTask.OriginalQueue
+	task.AssignedTo = existingTask.AssignedTo
+	task.StartedAt = existingTask.StartedAt
+	task.LeaseExpiresAt = existingTask.LeaseExpiresAt
+	task.CompletedAt = existingTask.CompletedAt
+	if !reportsResult {
+		// only the worker holding the claim reports a result or an error
+		task.Result = existingTask.Result
+		task.Error = existingTask.Error
+	}
+
+	if statusChanged {
+		if err := s.applyStatusChange(ctx, task); err != nil {
+			return err
+		}
+	}
+
+	// Guard the write so a claim lost in the meantime is not overwritten
+	if reportsResult {
+		return s.store.UpdateClaimedTask(ctx, task, clientID, existingTask.Attempt)
+	}
 	return s.store.UpdateTask(ctx, task)
 }
 
+// applyStatusChange updates the bookkeeping fields of a task entering a new status,
+// rescheduling or dead-lettering failed tasks according to their queue
+func (s *service) applyStatusChange(ctx context.Context, task *storage.Task) error {
+	now := time.Now()
+	switch task.Status {
+	case storage.TaskStatusPending:
+		// A manual requeue starts a fresh retry cycle
+		task.Attempt = 0
+		task.NextAttemptAt = nil
+		task.AssignedTo = nil
+		task.StartedAt = nil
+		task.CompletedAt = nil
+		task.LeaseExpiresAt = nil
+		task.Result = nil
+		task.Error = nil
+	case storage.TaskStatusFailed:
+		queue, err := s.store.GetQueue(ctx, task.QueueName)
+		if err != nil {
+			return fmt.Errorf("error checking queue: %w", err)
+		}
+		if queue != nil && queue.RetryPolicy.ScheduleRetry(task, now) {
+			return nil
+		}
+		task.CompletedAt = &now
+		if queue != nil {
+			queue.DeadLetter(task)
+		}
+	case storage.TaskStatusCompleted:
+		// the error of a failed attempt no longer applies
+		task.CompletedAt = &now
+		task.Error = nil
+	}
+	return nil
+}
+
 func (s *service) GetTask(ctx context.Context, id string) (*storage.Task, error) {
 	if id == "" {
 		return nil, fmt.Errorf("task ID is required")
@@ -108,6 +427,94 @@ func (s *service) GetTask(ctx context.Context, id string) (*storage.Task, error)
 	return s.store.GetTask(ctx, id)
 }
 
+// GetTaskGraph returns the workflow a task belongs to, or nil when the task does not exist
+func (s *service) GetTaskGraph(ctx context.Context, id string) (*storage.TaskGraph, error) {
+	if id == "" {
+		return nil, fmt.Errorf("task ID is required")
+	}
+	return s.store.GetTaskGraph(ctx, id)
+}
+
+// GetTaskAttempts returns every claim of a task, oldest first, or nil when the task does not exist
+func (s *service) GetTaskAttempts(ctx context.Context, id string) ([]storage.TaskAttempt, error) {
+	if id == "" {
+		return nil, fmt.Errorf("task ID is required")
+	}
+	return s.store.GetTaskAttempts(ctx, id)
+}
+
+// GetTaskEvents returns the changes of tasks matching the filter, newest first
+func (s *service) GetTaskEvents(ctx context.Context, filter storage.TaskEventFilter) ([]storage.TaskEvent, error) {
+	if filter.Offset < 0 {
+		return nil, fmt.Errorf("offset cannot be negative")
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = 10 // default value
+	}
+	if filter.Limit > 100 {
+		filter.Limit = 100 // maximum limit
+	}
+	return s.store.GetTaskEvents(ctx, filter)
+}
+
+// CreateBatch creates a group of tasks, possibly in several queues, in a single transaction.
+// Once none of them is pending, blocked or running the batch finishes and a task is enqueued
+// in its callback queue if any. Unlike CreateTasks the whole batch fails if a task is invalid.
+func (s *service) CreateBatch(ctx context.Context, batch *storage.Batch, tasks []storage.Task) (*storage.Batch, error) {
+	if len(tasks) == 0 {
+		return nil, fmt.Errorf("a batch needs at least one task")
+	}
+	if len(tasks) > MaxBatchSize {
+		return nil, fmt.Errorf("a batch cannot have more than %d tasks", MaxBatchSize)
+	}
+
+	queues := make(map[string]bool)
+	if batch.CallbackQueue != nil {
+		queues[*batch.CallbackQueue] = true
+	}
+	valid := make([]*storage.Task, len(tasks))
+	for i := range tasks {
+		task := &tasks[i]
+		if task.QueueName == "" {
+			return nil, fmt.Errorf("queue name is required")
+		}
+		if task.IdempotencyKey != nil && *task.IdempotencyKey != "" {
+			return nil, fmt.Errorf("tasks of a batch cannot have an idempotency key")
+		}
+		queues[task.QueueName] = true
+		prepareTask(task)
+		valid[i] = task
+	}
+	for name := range queues {
+		queue, err := s.store.GetQueue(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("error checking queue: %w", err)
+		}
+		if queue == nil {
+			return nil, fmt.Errorf("queue %s does not exist", name)
+		}
+	}
+
+	batch.ID = xid.New().String()
+	batch.CallbackTaskID = nil
+	batch.FinishedAt = nil
+	if batch.CallbackData == nil {
+		batch.CallbackData = json.RawMessage(`{}`)
+	}
+	if err := s.store.CreateBatch(ctx, batch, valid); err != nil {
+		return nil, err
+	}
+	return s.store.GetBatch(ctx, batch.ID)
+}
+
+// GetBatch returns a batch with the counts of its tasks, or nil when it does not exist
+func (s *service) GetBatch(ctx context.Context, id string) (*storage.Batch, error) {
+	if id == "" {
+		return nil, fmt.Errorf("batch ID is required")
+	}
+	return s.store.GetBatch(ctx, id)
+}
+
 func (s *service) GetTasks(ctx context.Context, filter storage.TaskFilter) ([]storage.Task, error) {
 	if filter.Limit <= 0 {
 		filter.Limit = 10 // default value
@@ -122,24 +529,150 @@ func (s *service) GetTaskStats(ctx context.Context, filter storage.TaskFilter) (
 	return s.store.GetTaskStats(ctx, filter)
 }
 
-func (s *service) GetNextTask(ctx context.Context, queueName, clientID string) (*storage.Task, error) {
+// GetNextTask claims the next due task of the queue for clientID, waiting up to wait for one
+// to become available. It returns nil when there are no tasks.
+func (s *service) GetNextTask(ctx context.Context, queueName, clientID string, wait time.Duration) (*storage.Task, error) {
+	tasks, err := s.GetNextTasks(ctx, queueName, clientID, 1, wait)
+	if err != nil || len(tasks) == 0 {
+		return nil, err
+	}
+	return &tasks[0], nil
+}
+
+// GetNextTasks claims up to count due tasks of the queue for clientID, at most maxClaimCount.
+// When there are none it waits up to wait (at most MaxWait) for tasks to become available.
+func (s *service) GetNextTasks(ctx context.Context, queueName, clientID string, count int, wait time.Duration) ([]storage.Task, error) {
+	if count <= 0 {
+		return nil, fmt.Errorf("count must be positive")
+	}
+	if count > maxClaimCount {
+		count = maxClaimCount
+	}
+	if wait < 0 {
+		return nil, fmt.Errorf("wait cannot be negative")
+	}
+	if wait > MaxWait {
+		wait = MaxWait
+	}
+	if err := s.checkClaim(ctx, queueName, clientID); err != nil {
+		return nil, err
+	}
+
+	if wait == 0 {
+		return s.store.ClaimTasks(ctx, queueName, clientID, count)
+	}
+	return s.waitForTasks(ctx, queueName, clientID, count, wait)
+}
+
+// waitForTasks claims tasks, retrying whenever the queue is notified and periodically as a
+// fallback, until tasks are claimed or wait elapses. It subscribes before the first claim so
+// no notification is missed in between. Rate limited claims wait for the next token when it
+// comes before wait elapses.
+func (s *service) waitForTasks(ctx context.Context, queueName, clientID string, count int, wait time.Duration) ([]storage.Task, error) {
+	var notified <-chan struct{}
+	if s.notifier != nil {
+		ch, unsubscribe := s.notifier.Subscribe(queueName)
+		defer unsubscribe()
+		notified = ch
+	}
+
+	deadlineAt := time.Now().Add(wait)
+	deadline := time.NewTimer(wait)
+	defer deadline.Stop()
+	poll := time.NewTicker(waitPollInterval)
+	defer poll.Stop()
+
+	for {
+		tasks, err := s.store.ClaimTasks(ctx, queueName, clientID, count)
+		var limited *storage.RateLimitError
+		if errors.As(err, &limited) && time.Now().Add(limited.RetryAfter).Before(deadlineAt) {
+			if err := sleep(ctx, limited.RetryAfter); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil || len(tasks) > 0 {
+			return tasks, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-deadline.C:
+			return tasks, nil
+		case <-notified:
+		case <-poll.C:
+		}
+	}
+}
+
+// sleep waits for d unless ctx is done first
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// checkClaim validates the parameters of a task claim
+func (s *service) checkClaim(ctx context.Context, queueName, clientID string) error {
 	if queueName == "" {
-		return nil, fmt.Errorf("queue name is required")
+		return fmt.Errorf("queue name is required")
 	}
 	if clientID == "" {
-		return nil, fmt.Errorf("client ID is required")
+		return fmt.Errorf("client ID is required")
 	}
 
 	// Verify that the queue exists
 	queue, err := s.store.GetQueue(ctx, queueName)
 	if err != nil {
-		return nil, fmt.Errorf("error checking queue: %w", err)
+		return fmt.Errorf("error checking queue: %w", err)
 	}
 	if queue == nil {
-		return nil, fmt.Errorf("queue %s does not exist", queueName)
+		return fmt.Errorf("queue %s does not exist", queueName)
+	}
+	if queue.Paused {
+		return ErrQueuePaused
+	}
+	return nil
+}
+
+// Heartbeat extends the lease of a running task owned by clientID. When no extension is
+// given the lease is extended by the queue task timeout.
+func (s *service) Heartbeat(ctx context.Context, id, clientID string, extension time.Duration) (time.Time, error) {
+	if id == "" {
+		return time.Time{}, fmt.Errorf("task ID is required")
+	}
+	if clientID == "" {
+		return time.Time{}, fmt.Errorf("client ID is required")
+	}
+	if extension < 0 {
+		return time.Time{}, fmt.Errorf("lease extension cannot be negative")
+	}
+
+	if extension == 0 {
+		task, err := s.store.GetTask(ctx, id)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("error checking task: %w", err)
+		}
+		if task == nil {
+			return time.Time{}, storage.ErrLeaseLost
+		}
+		queue, err := s.store.GetQueue(ctx, task.QueueName)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("error checking queue: %w", err)
+		}
+		if queue == nil {
+			return time.Time{}, fmt.Errorf("queue %s does not exist", task.QueueName)
+		}
+		extension = queue.TaskTimeout
 	}
 
-	return s.store.GetNextPendingTask(ctx, queueName, clientID)
+	return s.store.ExtendLease(ctx, id, clientID, extension)
 }
 
 func (s *service) DeleteTask(ctx context.Context, id string) error {
@@ -149,15 +682,130 @@ func (s *service) DeleteTask(ctx context.Context, id string) error {
 	return s.store.DeleteTask(ctx, id)
 }
 
+func (s *service) RedriveDeadLetters(ctx context.Context, queueName string, taskIDs []string) (int, error) {
+	if queueName == "" {
+		return 0, fmt.Errorf("queue name is required")
+	}
+	return s.store.RedriveDeadLetters(ctx, queueName, taskIDs)
+}
+
+// PurgeTasks hard-deletes the finished tasks of a queue, or of every queue when queueName is
+// empty, that outlived the queue retention. A dry run only counts them.
+func (s *service) PurgeTasks(ctx context.Context, queueName string, dryRun bool) (int, error) {
+	if dryRun {
+		return s.store.CountPurgeableTasks(ctx, queueName, time.Now())
+	}
+	return purgeTasks(ctx, s.store, queueName, nil)
+}
+
+// CreateSchedule validates a schedule and stores it, due at the first run of its cron expression
+func (s *service) CreateSchedule(ctx context.Context, schedule *storage.Schedule) error {
+	if schedule.QueueName == "" {
+		return fmt.Errorf("queue name is required")
+	}
+	if schedule.Cron == "" {
+		return fmt.Errorf("cron expression is required")
+	}
+
+	queue, err := s.store.GetQueue(ctx, schedule.QueueName)
+	if err != nil {
+		return fmt.Errorf("error checking queue: %w", err)
+	}
+	if queue == nil {
+		return fmt.Errorf("queue %s does not exist", schedule.QueueName)
+	}
+
+	next, err := schedule.Next(time.Now())
+	if err != nil {
+		return err
+	}
+	schedule.ID = xid.New().String()
+	schedule.NextRunAt = next
+	schedule.LastRunAt = nil
+	if schedule.Data == nil {
+		schedule.Data = json.RawMessage(`{}`)
+	}
+	return s.store.CreateSchedule(ctx, schedule)
+}
+
+func (s *service) GetSchedule(ctx context.Context, id string) (*storage.Schedule, error) {
+	return s.store.GetSchedule(ctx, id)
+}
+
+func (s *service) GetSchedules(ctx context.Context, queueName string) ([]storage.Schedule, error) {
+	return s.store.GetSchedules(ctx, queueName)
+}
+
+// SetSchedulePaused pauses or resumes a schedule. Resumed schedules wait for their next run
+// instead of making up the ones missed while paused.
+func (s *service) SetSchedulePaused(ctx context.Context, id string, paused bool) (*storage.Schedule, error) {
+	schedule, err := s.store.GetSchedule(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if schedule == nil {
+		return nil, storage.ErrScheduleNotFound
+	}
+
+	nextRunAt := schedule.NextRunAt
+	if !paused {
+		if nextRunAt, err = schedule.Next(time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.store.SetSchedulePaused(ctx, id, paused, nextRunAt); err != nil {
+		return nil, err
+	}
+	return s.store.GetSchedule(ctx, id)
+}
+
+func (s *service) DeleteSchedule(ctx context.Context, id string) error {
+	return s.store.DeleteSchedule(ctx, id)
+}
+
 func (s *service) Shutdown() error {
 	s.timeoutWorker.Stop()
+	s.janitor.Stop()
+	s.scheduler.Stop()
+	return nil
+}
+
+// isResultStatus reports whether a status is a processing outcome sent by workers
+func isResultStatus(status string) bool {
+	return status == storage.TaskStatusCompleted || status == storage.TaskStatusFailed
+}
+
+// holdsClaim reports whether clientID is running the given attempt of the task
+func holdsClaim(task *storage.Task, clientID string, attempt int) bool {
+	return task.Status == storage.TaskStatusRunning &&
+		task.AssignedTo != nil && clientID != "" && *task.AssignedTo == clientID &&
+		task.Attempt == attempt
+}
+
+func validateRetryPolicy(policy storage.RetryPolicy) error {
+	if policy.MaxAttempts < 0 {
+		return fmt.Errorf("retry max attempts cannot be negative")
+	}
+	if policy.BaseDelay < 0 || policy.MaxDelay < 0 {
+		return fmt.Errorf("retry delays cannot be negative")
+	}
+	if policy.Multiplier != 0 && policy.Multiplier < 1 {
+		return fmt.Errorf("retry multiplier must be at least 1")
+	}
+	if policy.Jitter < 0 || policy.Jitter > 1 {
+		return fmt.Errorf("retry jitter must be between 0 and 1")
+	}
 	return nil
 }
 
 func isValidStatusTransition(from, to string) bool {
 	validTransitions := map[string][]string{
+		// only claiming starts a task, it sets the worker and the lease of the attempt
 		storage.TaskStatusPending: {
-			storage.TaskStatusRunning,
+			storage.TaskStatusDeleted,
+		},
+		// blocked tasks can only be cancelled, their dependencies settle them otherwise
+		storage.TaskStatusBlocked: {
 			storage.TaskStatusDeleted,
 		},
 		storage.TaskStatusRunning: {
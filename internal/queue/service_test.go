@@ -0,0 +1,243 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fernandezvara/jobqueues/internal/storage"
+)
+
+func newTestService(t *testing.T) (Service, *storage.MemoryStore) {
+	t.Helper()
+	store := storage.NewMemoryStore()
+	s := NewService(store, WithNotifier(store))
+	t.Cleanup(func() { s.Shutdown() })
+	return s, store
+}
+
+func createQueue(t *testing.T, s Service, queue storage.Queue) {
+	t.Helper()
+	if queue.TaskTimeout == 0 {
+		queue.TaskTimeout = time.Minute
+	}
+	if err := s.CreateOrUpdateQueue(context.Background(), &queue); err != nil {
+		t.Fatalf("CreateOrUpdateQueue: %v", err)
+	}
+}
+
+func claimTask(t *testing.T, s Service, queueName, clientID string) *storage.Task {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := s.CreateTask(ctx, &storage.Task{QueueName: queueName}); err != nil {
+		t.Fatalf("CreateTask: %v", err)
+	}
+	task, err := s.GetNextTask(ctx, queueName, clientID, 0)
+	if err != nil || task == nil {
+		t.Fatalf("GetNextTask = %v, %v", task, err)
+	}
+	return task
+}
+
+func TestUpdateTaskRequiresClaim(t *testing.T) {
+	s, _ := newTestService(t)
+	ctx := context.Background()
+	createQueue(t, s, storage.Queue{Name: "q"})
+	task := claimTask(t, s, "q", "worker")
+
+	completed := *task
+	completed.Status = storage.TaskStatusCompleted
+	if err := s.UpdateTask(ctx, &completed, "intruder"); !errors.Is(err, storage.ErrLeaseLost) {
+		t.Errorf("completion by another client = %v, want ErrLeaseLost", err)
+	}
+
+	stale := completed
+	stale.Attempt = task.Attempt - 1
+	if err := s.UpdateTask(ctx, &stale, "worker"); !errors.Is(err, storage.ErrLeaseLost) {
+		t.Errorf("completion of a previous attempt = %v, want ErrLeaseLost", err)
+	}
+
+	if err := s.UpdateTask(ctx, &completed, "worker"); err != nil {
+		t.Fatalf("completion by the owner: %v", err)
+	}
+	if got, _ := s.GetTask(ctx, task.ID); got.Status != storage.TaskStatusCompleted || got.CompletedAt == nil {
+		t.Errorf("completed task = %+v", got)
+	}
+
+	// updates keeping the status are not results, e.g. a priority change
+	prioritized := *task
+	prioritized.Status = storage.TaskStatusCompleted
+	prioritized.Priority = 5
+	if err := s.UpdateTask(ctx, &prioritized, ""); err != nil {
+		t.Errorf("priority change of a completed task: %v", err)
+	}
+}
+
+func TestUpdateTaskCannotStartTask(t *testing.T) {
+	s, _ := newTestService(t)
+	ctx := context.Background()
+	createQueue(t, s, storage.Queue{Name: "q"})
+	task := &storage.Task{QueueName: "q"}
+	if _, err := s.CreateTask(ctx, task); err != nil {
+		t.Fatalf("CreateTask: %v", err)
+	}
+
+	running := *task
+	running.Status = storage.TaskStatusRunning
+	if err := s.UpdateTask(ctx, &running, "worker"); !errors.Is(err, ErrInvalidStatusTransition) {
+		t.Errorf("update from pending to running = %v, want ErrInvalidStatusTransition", err)
+	}
+	if got, _ := s.GetTask(ctx, task.ID); got.Status != storage.TaskStatusPending {
+		t.Errorf("task status = %s, want pending", got.Status)
+	}
+}
+
+func TestUpdateTaskKeepsClaim(t *testing.T) {
+	s, _ := newTestService(t)
+	ctx := context.Background()
+	createQueue(t, s, storage.Queue{Name: "q"})
+	task := claimTask(t, s, "q", "worker")
+
+	// an update keeping the status cannot take the task over
+	intruder := "intruder"
+	takeover := *task
+	takeover.AssignedTo = &intruder
+	takeover.LeaseExpiresAt = nil
+	if err := s.UpdateTask(ctx, &takeover, "intruder"); err != nil {
+		t.Fatalf("same-status update: %v", err)
+	}
+	got, _ := s.GetTask(ctx, task.ID)
+	if got.AssignedTo == nil || *got.AssignedTo != "worker" || got.LeaseExpiresAt == nil {
+		t.Errorf("task after the same-status update = %+v, want it still held by worker", got)
+	}
+
+	completed := *got
+	completed.Status = storage.TaskStatusCompleted
+	if err := s.UpdateTask(ctx, &completed, "intruder"); !errors.Is(err, storage.ErrLeaseLost) {
+		t.Errorf("completion by the intruder = %v, want ErrLeaseLost", err)
+	}
+}
+
+func TestUpdateTaskKeepsResult(t *testing.T) {
+	s, _ := newTestService(t)
+	ctx := context.Background()
+	createQueue(t, s, storage.Queue{Name: "q"})
+	task := claimTask(t, s, "q", "worker")
+
+	task.Status = storage.TaskStatusCompleted
+	task.Result = json.RawMessage(`{"x":1}`)
+	if err := s.UpdateTask(ctx, task, "worker"); err != nil {
+		t.Fatalf("completion by the owner: %v", err)
+	}
+
+	for _, clientID := range []string{"", "worker"} {
+		overwrite := *task
+		overwrite.Result = json.RawMessage(`{"x":2}`)
+		overwrite.Error = &storage.TaskError{Message: "forged"}
+		if err := s.UpdateTask(ctx, &overwrite, clientID); err != nil {
+			t.Fatalf("update of a completed task: %v", err)
+		}
+		if got, _ := s.GetTask(ctx, task.ID); string(got.Result) != `{"x":1}` || got.Error != nil {
+			t.Errorf("task updated by %q = result %s, error %+v, want the reported result", clientID, got.Result, got.Error)
+		}
+	}
+}
+
+func TestFailedTaskIsRetriedThenDeadLettered(t *testing.T) {
+	s, _ := newTestService(t)
+	ctx := context.Background()
+	createQueue(t, s, storage.Queue{Name: "dlq"})
+	createQueue(t, s, storage.Queue{
+		Name:            "q",
+		RetryPolicy:     storage.RetryPolicy{MaxAttempts: 2},
+		DeadLetterQueue: "dlq",
+	})
+
+	task := claimTask(t, s, "q", "worker")
+	task.Status = storage.TaskStatusFailed
+	if err := s.UpdateTask(ctx, task, "worker"); err != nil {
+		t.Fatalf("UpdateTask: %v", err)
+	}
+	if task.Status != storage.TaskStatusPending || task.NextAttemptAt == nil {
+		t.Fatalf("first failure = %+v, want a pending retry", task)
+	}
+
+	retry, err := s.GetNextTask(ctx, "q", "worker", 0)
+	if err != nil || retry == nil || retry.ID != task.ID || retry.Attempt != 2 {
+		t.Fatalf("retry = %+v, %v", retry, err)
+	}
+	retry.Status = storage.TaskStatusFailed
+	if err := s.UpdateTask(ctx, retry, "worker"); err != nil {
+		t.Fatalf("UpdateTask: %v", err)
+	}
+
+	got, _ := s.GetTask(ctx, task.ID)
+	if got.Status != storage.TaskStatusFailed || got.QueueName != "dlq" {
+		t.Errorf("exhausted task = %+v, want failed in dlq", got)
+	}
+}
+
+func TestGetNextTaskWaitsForNewTasks(t *testing.T) {
+	s, _ := newTestService(t)
+	ctx := context.Background()
+	createQueue(t, s, storage.Queue{Name: "q"})
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		s.CreateTask(ctx, &storage.Task{QueueName: "q"})
+	}()
+
+	start := time.Now()
+	task, err := s.GetNextTask(ctx, "q", "worker", 10*time.Second)
+	if err != nil || task == nil {
+		t.Fatalf("GetNextTask = %v, %v", task, err)
+	}
+	if elapsed := time.Since(start); elapsed > waitPollInterval {
+		t.Errorf("waited %v, the notification did not wake up the claim", elapsed)
+	}
+}
+
+func TestGetNextTaskWaitTimesOut(t *testing.T) {
+	s, _ := newTestService(t)
+	createQueue(t, s, storage.Queue{Name: "q"})
+
+	task, err := s.GetNextTask(context.Background(), "q", "worker", 50*time.Millisecond)
+	if err != nil || task != nil {
+		t.Errorf("GetNextTask on an empty queue = %v, %v, want nil, nil", task, err)
+	}
+}
+
+func TestScheduleEnqueuesTasks(t *testing.T) {
+	s, _ := newTestService(t)
+	ctx := context.Background()
+	createQueue(t, s, storage.Queue{Name: "q"})
+
+	if err := s.CreateSchedule(ctx, &storage.Schedule{QueueName: "q", Cron: "61 * * * *"}); err == nil {
+		t.Errorf("CreateSchedule accepted an invalid cron expression")
+	}
+	if err := s.CreateSchedule(ctx, &storage.Schedule{QueueName: "q", Cron: "@hourly", Timezone: "Mars/Olympus"}); err == nil {
+		t.Errorf("CreateSchedule accepted an unknown time zone")
+	}
+	if err := s.CreateSchedule(ctx, &storage.Schedule{QueueName: "missing", Cron: "@hourly"}); err == nil {
+		t.Errorf("CreateSchedule accepted an unknown queue")
+	}
+
+	schedule := storage.Schedule{QueueName: "q", Cron: "@every 1s"}
+	if err := s.CreateSchedule(ctx, &schedule); err != nil {
+		t.Fatalf("CreateSchedule: %v", err)
+	}
+	task, err := s.GetNextTask(ctx, "q", "worker", 5*time.Second)
+	if err != nil || task == nil {
+		t.Fatalf("GetNextTask = %v, %v, want the task of the schedule", task, err)
+	}
+
+	paused, err := s.SetSchedulePaused(ctx, schedule.ID, true)
+	if err != nil || !paused.Paused {
+		t.Fatalf("SetSchedulePaused = %+v, %v, want it paused", paused, err)
+	}
+	if _, err := s.SetSchedulePaused(ctx, "missing", true); !errors.Is(err, storage.ErrScheduleNotFound) {
+		t.Errorf("SetSchedulePaused(missing) = %v, want ErrScheduleNotFound", err)
+	}
+}
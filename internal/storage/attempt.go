@@ -0,0 +1,46 @@
+package storage
+
+import "time"
+
+// attemptOutcome returns how the running attempt of a task ends when the task is written with
+// its current status, empty while it keeps running
+func attemptOutcome(task *Task) string {
+	switch {
+	case task.Status == TaskStatusRunning:
+		return ""
+	case task.Status == TaskStatusCompleted:
+		return AttemptCompleted
+	case task.Status == TaskStatusFailed, task.Status == TaskStatusPending && task.NextAttemptAt != nil:
+		// a failure rescheduled by the retry policy
+		return AttemptFailed
+	}
+	return AttemptCancelled
+}
+
+// attemptError is the error an attempt ending with outcome records
+func attemptError(task *Task, outcome string) *TaskError {
+	switch outcome {
+	case AttemptFailed:
+		return task.Error
+	case AttemptTimeout:
+		return timeoutError()
+	}
+	return nil
+}
+
+// setDuration fills the duration of a finished attempt
+func (a *TaskAttempt) setDuration() {
+	a.Duration = nil
+	if a.FinishedAt != nil {
+		d := a.FinishedAt.Sub(a.StartedAt)
+		a.Duration = &d
+	}
+}
+
+// finish closes a running attempt at now
+func (a *TaskAttempt) finish(outcome string, taskErr *TaskError, now time.Time) {
+	a.Outcome = outcome
+	a.Error = taskErr
+	a.FinishedAt = &now
+	a.setDuration()
+}
@@ -0,0 +1,28 @@
+package storage
+
+import (
+	"github.com/rs/xid"
+)
+
+// newBatchCounts returns the task counts of a batch with every status at zero
+func newBatchCounts() map[string]int {
+	return map[string]int{
+		TaskStatusPending:   0,
+		TaskStatusBlocked:   0,
+		TaskStatusRunning:   0,
+		TaskStatusCompleted: 0,
+		TaskStatusFailed:    0,
+		TaskStatusDeleted:   0,
+	}
+}
+
+// callbackTask is the task enqueued in the callback queue when the batch finishes, its data
+// is the callback data with the batch id added
+func (b Batch) callbackTask() Task {
+	return Task{
+		ID:        xid.New().String(),
+		QueueName: *b.CallbackQueue,
+		Status:    TaskStatusPending,
+		Data:      withField(b.CallbackData, "batch_id", b.ID),
+	}
+}
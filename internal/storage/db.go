@@ -4,6 +4,8 @@ import (
 	"database/sql"
 	"fmt"
 	"time"
+
+	_ "modernc.org/sqlite"
 )
 
 func NewDB(dataSourceName string) (*sql.DB, error) {
@@ -24,10 +26,30 @@ func NewDB(dataSourceName string) (*sql.DB, error) {
 	return db, nil
 }
 
-func InitSchema(db *sql.DB) error {
-	_, err := db.Exec(Schema)
+// NewSQLiteDB opens the SQLite database at path, creating it if needed. SQLite allows a single
+// writer, so the pool is limited to one connection and transactions take the write lock upfront.
+func NewSQLiteDB(path string) (*sql.DB, error) {
+	dsn := "file:" + path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+	db, err := sql.Open("sqlite", dsn)
 	if err != nil {
-		return fmt.Errorf("error initializing schema: %w", err)
+		return nil, fmt.Errorf("error opening database: %w", err)
+	}
+
+	if err = db.Ping(); err != nil {
+		return nil, fmt.Errorf("error connecting to the database: %w", err)
 	}
-	return nil
+
+	db.SetMaxOpenConns(1)
+
+	return db, nil
+}
+
+// InitSQLiteSchema applies the pending SQLite migrations
+func InitSQLiteSchema(db *sql.DB) error {
+	return migrateUp(db, DialectSQLite)
+}
+
+// InitSchema applies the pending Postgres migrations
+func InitSchema(db *sql.DB) error {
+	return migrateUp(db, DialectPostgres)
 }
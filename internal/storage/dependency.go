@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrDependencyNotFound is returned when a new task depends on a task that does not exist
+var ErrDependencyNotFound = errors.New("dependency not found")
+
+// dependencyOutcome returns the status a blocked task takes given the statuses of its parents,
+// and the parent that failed it if any. A failed parent fails the task, a deleted one deletes
+// it and it stays blocked until every parent completed.
+func dependencyOutcome(parents map[string]string) (string, string) {
+	ids := make([]string, 0, len(parents))
+	for id := range parents {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	status := TaskStatusPending
+	for _, id := range ids {
+		switch parents[id] {
+		case TaskStatusFailed:
+			return TaskStatusFailed, id
+		case TaskStatusDeleted:
+			status = TaskStatusDeleted
+		case TaskStatusCompleted:
+		default:
+			if status == TaskStatusPending {
+				status = TaskStatusBlocked
+			}
+		}
+	}
+	return status, ""
+}
+
+// resolveDependencies sets the status of a new or blocked task from the statuses of its parents,
+// naming the failed parent in the error of a failed task. It reports whether the task is no
+// longer blocked.
+func resolveDependencies(task *Task, parents map[string]string) bool {
+	status, cause := dependencyOutcome(parents)
+	task.Status = status
+	if cause != "" {
+		task.Error = &TaskError{Message: fmt.Sprintf("dependency %s failed", cause), Type: "dependency"}
+	}
+	return status != TaskStatusBlocked
+}
+
+// settlesDependents reports whether a task entering status may unblock, fail or delete the
+// tasks depending on it, or finish its batch
+func settlesDependents(status string) bool {
+	return status == TaskStatusCompleted || status == TaskStatusFailed || status == TaskStatusDeleted
+}
+
+// walkDependencies collects the tasks connected to id by dependencies in either direction and
+// the dependencies between them. edgesOf loads the dependencies touching any of the given tasks.
+func walkDependencies(id string, edgesOf func(ids []string) ([]TaskDependency, error)) ([]string, []TaskDependency, error) {
+	ids := []string{id}
+	seen := map[string]bool{id: true}
+	found := make(map[TaskDependency]bool)
+	var edges []TaskDependency
+
+	for frontier := ids; len(frontier) > 0; {
+		loaded, err := edgesOf(frontier)
+		if err != nil {
+			return nil, nil, err
+		}
+		frontier = nil
+		for _, edge := range loaded {
+			if found[edge] {
+				continue
+			}
+			found[edge] = true
+			edges = append(edges, edge)
+			for _, next := range []string{edge.TaskID, edge.DependsOn} {
+				if !seen[next] {
+					seen[next] = true
+					ids = append(ids, next)
+					frontier = append(frontier, next)
+				}
+			}
+		}
+	}
+	return ids, edges, nil
+}
+
+// newTaskGraph sorts the tasks and edges of a workflow by creation, filling the DependsOn of
+// the tasks from the edges
+func newTaskGraph(tasks []Task, edges []TaskDependency) *TaskGraph {
+	sort.Slice(tasks, func(i, j int) bool {
+		if !tasks[i].CreatedAt.Equal(tasks[j].CreatedAt) {
+			return tasks[i].CreatedAt.Before(tasks[j].CreatedAt)
+		}
+		return tasks[i].ID < tasks[j].ID
+	})
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].TaskID != edges[j].TaskID {
+			return edges[i].TaskID < edges[j].TaskID
+		}
+		return edges[i].DependsOn < edges[j].DependsOn
+	})
+
+	byID := make(map[string]*Task, len(tasks))
+	for i := range tasks {
+		tasks[i].DependsOn = nil
+		byID[tasks[i].ID] = &tasks[i]
+	}
+	for _, edge := range edges {
+		if task, ok := byID[edge.TaskID]; ok {
+			task.DependsOn = append(task.DependsOn, edge.DependsOn)
+		}
+	}
+	if edges == nil {
+		edges = []TaskDependency{}
+	}
+	return &TaskGraph{Tasks: tasks, Edges: edges}
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+)
+
+type actorKey struct{}
+
+// WithActor returns a context whose task changes are recorded as made by actor
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// actorFrom returns the actor of ctx, the system when there is none
+func actorFrom(ctx context.Context) Actor {
+	if actor, ok := ctx.Value(actorKey{}).(Actor); ok {
+		return actor
+	}
+	return Actor{Type: ActorSystem}
+}
+
+// newTaskEvent returns the event of task changing from previous, nil for a new task. Updates
+// keeping the status record every other column they changed, and nothing when there is none.
+func newTaskEvent(ctx context.Context, task *Task, previous *Task) *TaskEvent {
+	event := &TaskEvent{
+		TaskID:    task.ID,
+		QueueName: task.QueueName,
+		ToStatus:  task.Status,
+		Actor:     actorFrom(ctx),
+	}
+	details := make(map[string]interface{})
+	if previous == nil {
+		event.Type = EventCreated
+	} else {
+		from := previous.Status
+		event.FromStatus = &from
+		switch {
+		case task.Status == TaskStatusDeleted && from != TaskStatusDeleted:
+			event.Type = EventDeleted
+		case task.Status == TaskStatusPending && from != TaskStatusPending && from != TaskStatusBlocked:
+			event.Type = EventRequeued
+		case task.Status != from:
+			event.Type = EventStatusChanged
+		default:
+			event.Type = EventUpdated
+		}
+
+		if task.QueueName != previous.QueueName {
+			details["previous_queue"] = previous.QueueName
+		}
+		if task.Priority != previous.Priority {
+			details["priority"] = task.Priority
+			details["previous_priority"] = previous.Priority
+		}
+		if event.Type == EventUpdated {
+			changedColumns(details, task, previous)
+			if len(details) == 0 {
+				return nil
+			}
+		}
+	}
+	if task.Error != nil && (task.Status == TaskStatusFailed || event.Type == EventRequeued) {
+		details["error"] = task.Error
+	}
+
+	if len(details) > 0 {
+		event.Details, _ = json.Marshal(details)
+	}
+	return event
+}
+
+// changedColumns adds to details the new and previous values of the columns written by an
+// update, besides the status, queue and priority, that differ between task and previous
+func changedColumns(details map[string]interface{}, task, previous *Task) {
+	columns := []struct {
+		name            string
+		value, previous interface{}
+	}{
+		{"assigned_to", task.AssignedTo, previous.AssignedTo},
+		{"original_queue", task.OriginalQueue, previous.OriginalQueue},
+		{"next_attempt_at", task.NextAttemptAt, previous.NextAttemptAt},
+		{"lease_expires_at", task.LeaseExpiresAt, previous.LeaseExpiresAt},
+		{"result", nullJSONValue(task.Result), nullJSONValue(previous.Result)},
+		{"error", task.Error, previous.Error},
+	}
+	for _, column := range columns {
+		value, previousValue := jsonValue(column.value), jsonValue(column.previous)
+		if !reflect.DeepEqual(value, previousValue) {
+			details[column.name] = value
+			details["previous_"+column.name] = previousValue
+		}
+	}
+}
+
+// jsonValue returns v as decoded from its JSON encoding, so values are compared regardless of
+// how a store formats them, e.g. the spacing of JSON columns
+func jsonValue(v interface{}) interface{} {
+	if data, ok := v.([]byte); ok {
+		v = json.RawMessage(data)
+	}
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		return nil
+	}
+	return decoded
+}
+
+// claimEvents returns the events of pending tasks being claimed
+func claimEvents(ctx context.Context, tasks []Task) []*TaskEvent {
+	events := make([]*TaskEvent, len(tasks))
+	for i := range tasks {
+		previous := Task{Status: TaskStatusPending, QueueName: tasks[i].QueueName, Priority: tasks[i].Priority}
+		events[i] = newTaskEvent(ctx, &tasks[i], &previous)
+	}
+	return events
+}
+
+// deleteEvents returns the events of deleting the given tasks, as they were before
+func deleteEvents(ctx context.Context, previous []Task) []*TaskEvent {
+	events := make([]*TaskEvent, len(previous))
+	for i := range previous {
+		task := previous[i]
+		task.Status = TaskStatusDeleted
+		events[i] = newTaskEvent(ctx, &task, &previous[i])
+	}
+	return events
+}
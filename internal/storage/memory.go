@@ -0,0 +1,1415 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store that keeps queues and tasks in memory with the same semantics as the
+// Postgres store. It is meant for tests and embedded use, nothing survives a restart. It also
+// implements Notifier, waking up waiting claims when tasks are added to a queue.
+type MemoryStore struct {
+	broadcaster
+
+	mu        sync.Mutex
+	queues    map[string]*Queue
+	tasks     map[string]*memoryTask
+	archived  map[string]*memoryTask
+	buckets   map[string]*memoryBucket
+	schedules map[string]*Schedule
+	// dependencies holds the tasks each task depends on
+	dependencies map[string][]string
+	batches      map[string]*Batch
+	// attempts holds the claims of each task, oldest first
+	attempts map[string][]TaskAttempt
+	// events is the task event log, oldest first
+	events []TaskEvent
+	seq    int64
+}
+
+var (
+	_ Store    = (*MemoryStore)(nil)
+	_ Notifier = (*MemoryStore)(nil)
+)
+
+// memoryBucket is the rate_tokens and rate_updated_at of a queue
+type memoryBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// memoryTask is a stored task and its insertion sequence, which breaks ties on created_at
+type memoryTask struct {
+	Task
+	seq int64
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		queues:       make(map[string]*Queue),
+		tasks:        make(map[string]*memoryTask),
+		archived:     make(map[string]*memoryTask),
+		buckets:      make(map[string]*memoryBucket),
+		schedules:    make(map[string]*Schedule),
+		dependencies: make(map[string][]string),
+		batches:      make(map[string]*Batch),
+		attempts:     make(map[string][]TaskAttempt),
+	}
+}
+
+// Close releases nothing, it is only there to implement Notifier
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+func (s *MemoryStore) GetQueues(ctx context.Context) ([]Queue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var queues []Queue
+	for _, queue := range s.queues {
+		queues = append(queues, *queue)
+	}
+	sort.Slice(queues, func(i, j int) bool { return queues[i].Name < queues[j].Name })
+	return queues, nil
+}
+
+func (s *MemoryStore) CreateOrUpdateQueue(ctx context.Context, queue *Queue) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	queue.UpdatedAt = now
+	if existing, ok := s.queues[queue.Name]; ok {
+		queue.CreatedAt = existing.CreatedAt
+		queue.Paused = existing.Paused
+	} else {
+		queue.CreatedAt = now
+		queue.Paused = false
+	}
+
+	stored := *queue
+	s.queues[queue.Name] = &stored
+	return nil
+}
+
+func (s *MemoryStore) GetQueue(ctx context.Context, name string) (*Queue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queue, ok := s.queues[name]
+	if !ok {
+		return nil, nil
+	}
+	result := *queue
+	return &result, nil
+}
+
+func (s *MemoryStore) SetQueuePaused(ctx context.Context, name string, paused bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queue, ok := s.queues[name]
+	if !ok {
+		return ErrQueueNotFound
+	}
+	queue.Paused = paused
+	queue.UpdatedAt = time.Now()
+	if !paused {
+		s.wake(name)
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteQueue(ctx context.Context, name, mode, target string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.queues[name]; !ok {
+		return ErrQueueNotFound
+	}
+	for _, queue := range s.queues {
+		if queue.DeadLetterQueue == name {
+			return ErrQueueInUse
+		}
+	}
+
+	now := time.Now()
+	switch mode {
+	case DeleteQueueRestrict:
+		for _, task := range s.tasks {
+			if task.QueueName == name && isActive(task.Status) {
+				return ErrQueueNotEmpty
+			}
+		}
+	case DeleteQueueCascade:
+		var deleted []string
+		for _, task := range s.tasks {
+			if task.QueueName == name && isActive(task.Status) {
+				previous := task.Task
+				task.Status = TaskStatusDeleted
+				task.UpdatedAt = now
+				s.record(ctx, &task.Task, &previous)
+				deleted = append(deleted, task.ID)
+			}
+		}
+		s.finishAttempts(AttemptCancelled, nil, now, deleted...)
+		s.settleFinished(ctx, deleted...)
+	case DeleteQueueMove:
+		if _, ok := s.queues[target]; !ok {
+			return fmt.Errorf("queue %s does not exist", target)
+		}
+		for _, task := range s.tasks {
+			moved := task.Task
+			moved.QueueName = target
+			if task.QueueName == name && s.idempotencyKeyInUse(&moved) {
+				return fmt.Errorf("idempotency key %s is in use in queue %s", *task.IdempotencyKey, target)
+			}
+		}
+		s.moveReferences(name, target)
+		delete(s.queues, name)
+		delete(s.buckets, name)
+		s.wake(target)
+		return nil
+	default:
+		return fmt.Errorf("unknown delete mode %s", mode)
+	}
+
+	for id, task := range s.tasks {
+		if task.QueueName == name {
+			delete(s.tasks, id)
+			s.archived[id] = task
+		} else if task.OriginalQueue != nil && *task.OriginalQueue == name {
+			task.OriginalQueue = nil
+		}
+	}
+	for id, schedule := range s.schedules {
+		if schedule.QueueName == name {
+			delete(s.schedules, id)
+		}
+	}
+	delete(s.queues, name)
+	delete(s.buckets, name)
+	return nil
+}
+
+func (s *MemoryStore) RenameQueue(ctx context.Context, name, newName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queue, ok := s.queues[name]
+	if !ok {
+		return ErrQueueNotFound
+	}
+	if _, ok := s.queues[newName]; ok {
+		return ErrQueueExists
+	}
+
+	renamed := *queue
+	renamed.Name = newName
+	renamed.UpdatedAt = time.Now()
+	s.queues[newName] = &renamed
+	delete(s.queues, name)
+	if bucket, ok := s.buckets[name]; ok {
+		s.buckets[newName] = bucket
+		delete(s.buckets, name)
+	}
+
+	s.moveReferences(name, newName)
+	for _, other := range s.queues {
+		if other.DeadLetterQueue == name {
+			other.DeadLetterQueue = newName
+		}
+	}
+	s.wake(newName)
+	return nil
+}
+
+// moveReferences moves the tasks, archived or not, the dead letters and the schedules of queue
+// from to queue to
+func (s *MemoryStore) moveReferences(from, to string) {
+	for _, schedule := range s.schedules {
+		if schedule.QueueName == from {
+			schedule.QueueName = to
+		}
+	}
+	for _, batch := range s.batches {
+		if batch.CallbackQueue != nil && *batch.CallbackQueue == from {
+			batch.CallbackQueue = &to
+		}
+	}
+	for _, tasks := range []map[string]*memoryTask{s.tasks, s.archived} {
+		for _, task := range tasks {
+			if task.QueueName == from {
+				task.QueueName = to
+			}
+			if task.OriginalQueue != nil && *task.OriginalQueue == from {
+				task.OriginalQueue = &to
+			}
+		}
+	}
+}
+
+func (s *MemoryStore) CreateTask(ctx context.Context, task *Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.insert(ctx, task); err != nil {
+		return err
+	}
+	s.wake(task.QueueName)
+	return nil
+}
+
+func (s *MemoryStore) CreateUniqueTask(ctx context.Context, task *Task, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if task.IdempotencyKey != nil {
+		if existing := s.findByIdempotencyKey(task, ttl); existing != nil {
+			*task = cloneTask(&existing.Task)
+			return false, nil
+		}
+	}
+
+	if err := s.insert(ctx, task); err != nil {
+		return false, err
+	}
+	s.wake(task.QueueName)
+	return true, nil
+}
+
+func (s *MemoryStore) CreateTasks(ctx context.Context, tasks []*Task, idempotencyTTLs map[string]time.Duration) ([]bool, []error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	created := make([]bool, len(tasks))
+	errs := make([]error, len(tasks))
+	var inserted []string
+	logged := len(s.events)
+	for i, task := range tasks {
+		if task.IdempotencyKey != nil {
+			if existing := s.findByIdempotencyKey(task, idempotencyTTLs[task.QueueName]); existing != nil {
+				*task = cloneTask(&existing.Task)
+				continue
+			}
+		}
+
+		err := s.insert(ctx, task)
+		if errors.Is(err, ErrDependencyNotFound) {
+			errs[i] = err
+			continue
+		}
+		if err != nil {
+			// the batch is all or nothing, like the Postgres transaction
+			for _, id := range inserted {
+				delete(s.tasks, id)
+				delete(s.dependencies, id)
+			}
+			s.events = s.events[:logged]
+			return nil, nil, fmt.Errorf("error inserting task: %w", err)
+		}
+		inserted = append(inserted, task.ID)
+		created[i] = true
+	}
+
+	for _, task := range tasks {
+		s.wake(task.QueueName)
+	}
+	return created, errs, nil
+}
+
+// insert stores a new task, enforcing the constraints of the tasks table
+func (s *MemoryStore) insert(ctx context.Context, task *Task) error {
+	if _, ok := s.tasks[task.ID]; ok {
+		return fmt.Errorf("task %s already exists", task.ID)
+	}
+	if _, ok := s.queues[task.QueueName]; !ok {
+		return fmt.Errorf("queue %s does not exist", task.QueueName)
+	}
+	if len(task.DependsOn) > 0 {
+		parents := s.dependencyStatuses(task.DependsOn)
+		for _, id := range task.DependsOn {
+			if _, ok := parents[id]; !ok {
+				return fmt.Errorf("%w: %s", ErrDependencyNotFound, id)
+			}
+		}
+		resolveDependencies(task, parents)
+	}
+	if s.idempotencyKeyInUse(task) {
+		return fmt.Errorf("idempotency key %s is in use in queue %s", *task.IdempotencyKey, task.QueueName)
+	}
+
+	defaultData(task)
+	now := time.Now()
+	task.CreatedAt = now
+	task.UpdatedAt = now
+
+	s.seq++
+	stored := &memoryTask{Task: cloneTask(task), seq: s.seq}
+	if len(task.DependsOn) > 0 {
+		s.dependencies[task.ID] = stored.DependsOn
+		stored.DependsOn = nil
+	}
+	s.tasks[task.ID] = stored
+	s.record(ctx, task, nil)
+	return nil
+}
+
+// dependencyStatuses returns the statuses of the given tasks, archived or not, leaving out
+// the ones that do not exist
+func (s *MemoryStore) dependencyStatuses(ids []string) map[string]string {
+	statuses := make(map[string]string, len(ids))
+	for _, id := range ids {
+		if task, ok := s.tasks[id]; ok {
+			statuses[id] = task.Status
+		} else if task, ok := s.archived[id]; ok {
+			statuses[id] = task.Status
+		}
+	}
+	return statuses
+}
+
+// settleFinished finishes the batches of parentIDs and resolves the blocked tasks depending on
+// them, see resolveDependencies. Tasks failed or deleted by their parents settle in turn.
+func (s *MemoryStore) settleFinished(ctx context.Context, parentIDs ...string) {
+	now := time.Now()
+	for len(parentIDs) > 0 {
+		s.finishBatches(ctx, parentIDs)
+
+		settled := make(map[string]bool, len(parentIDs))
+		for _, id := range parentIDs {
+			settled[id] = true
+		}
+
+		parentIDs = nil
+		for id, dependsOn := range s.dependencies {
+			task, ok := s.tasks[id]
+			if !ok || task.Status != TaskStatusBlocked || !slices.ContainsFunc(dependsOn, func(parent string) bool {
+				return settled[parent]
+			}) {
+				continue
+			}
+			previous := task.Task
+			if !resolveDependencies(&task.Task, s.dependencyStatuses(dependsOn)) {
+				continue
+			}
+			task.UpdatedAt = now
+			s.record(ctx, &task.Task, &previous)
+			if task.Status == TaskStatusPending {
+				s.wake(task.QueueName)
+			} else {
+				parentIDs = append(parentIDs, id)
+			}
+		}
+	}
+}
+
+// idempotencyKeyInUse reports whether another active task of the queue holds the key of task
+func (s *MemoryStore) idempotencyKeyInUse(task *Task) bool {
+	if task.IdempotencyKey == nil || !isActive(task.Status) {
+		return false
+	}
+	for _, other := range s.tasks {
+		if other.ID != task.ID && other.QueueName == task.QueueName && other.IdempotencyKey != nil &&
+			*other.IdempotencyKey == *task.IdempotencyKey && isActive(other.Status) {
+			return true
+		}
+	}
+	return false
+}
+
+// findByIdempotencyKey returns the newest task holding the idempotency key of task,
+// see CreateUniqueTask
+func (s *MemoryStore) findByIdempotencyKey(task *Task, ttl time.Duration) *memoryTask {
+	since := time.Now().Add(-ttl)
+	var found *memoryTask
+	for _, other := range s.tasks {
+		if other.QueueName != task.QueueName || other.IdempotencyKey == nil ||
+			*other.IdempotencyKey != *task.IdempotencyKey || other.Status == TaskStatusDeleted {
+			continue
+		}
+		if !isActive(other.Status) && other.CreatedAt.Before(since) {
+			continue
+		}
+		if found == nil || other.CreatedAt.After(found.CreatedAt) {
+			found = other
+		}
+	}
+	return found
+}
+
+func (s *MemoryStore) UpdateTask(ctx context.Context, task *Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.tasks[task.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	return s.update(ctx, stored, task)
+}
+
+func (s *MemoryStore) UpdateClaimedTask(ctx context.Context, task *Task, clientID string, attempt int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.tasks[task.ID]
+	if !ok || stored.Status != TaskStatusRunning || stored.AssignedTo == nil ||
+		*stored.AssignedTo != clientID || stored.Attempt != attempt {
+		return ErrLeaseLost
+	}
+	return s.update(ctx, stored, task)
+}
+
+// update writes the mutable fields of task into the stored task
+func (s *MemoryStore) update(ctx context.Context, stored *memoryTask, task *Task) error {
+	if _, ok := s.queues[task.QueueName]; !ok {
+		return fmt.Errorf("queue %s does not exist", task.QueueName)
+	}
+	candidate := cloneTask(task)
+	candidate.IdempotencyKey = stored.IdempotencyKey
+	if s.idempotencyKeyInUse(&candidate) {
+		return fmt.Errorf("idempotency key %s is in use in queue %s", *candidate.IdempotencyKey, task.QueueName)
+	}
+
+	previous := stored.Task
+	updated := cloneTask(task)
+	stored.Status = updated.Status
+	stored.Result = updated.Result
+	stored.Error = updated.Error
+	stored.AssignedTo = updated.AssignedTo
+	stored.StartedAt = updated.StartedAt
+	stored.CompletedAt = updated.CompletedAt
+	stored.Attempt = updated.Attempt
+	stored.NextAttemptAt = updated.NextAttemptAt
+	stored.QueueName = updated.QueueName
+	stored.OriginalQueue = updated.OriginalQueue
+	stored.Priority = updated.Priority
+	stored.LeaseExpiresAt = updated.LeaseExpiresAt
+	stored.UpdatedAt = time.Now()
+
+	task.CreatedAt = stored.CreatedAt
+	task.UpdatedAt = stored.UpdatedAt
+	s.record(ctx, task, &previous)
+
+	if outcome := attemptOutcome(task); outcome != "" {
+		s.finishAttempts(outcome, attemptError(task, outcome), stored.UpdatedAt, task.ID)
+	}
+	if settlesDependents(task.Status) {
+		s.settleFinished(ctx, task.ID)
+	}
+
+	// requeued tasks may be waited for, as well as the slot a finished task frees in a limited queue
+	if task.Status == TaskStatusPending || (task.Status != TaskStatusRunning && s.queues[task.QueueName].MaxRunning > 0) {
+		s.wake(task.QueueName)
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetTask(ctx context.Context, id string) (*Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.tasks[id]
+	if !ok {
+		return nil, nil
+	}
+	task := cloneTask(&stored.Task)
+	task.DependsOn = append([]string(nil), s.dependencies[id]...)
+	return &task, nil
+}
+
+func (s *MemoryStore) GetTaskGraph(ctx context.Context, id string) (*TaskGraph, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.dependencyStatuses([]string{id})[id]; !ok {
+		return nil, nil
+	}
+
+	ids, edges, _ := walkDependencies(id, func(ids []string) ([]TaskDependency, error) {
+		var edges []TaskDependency
+		for taskID, dependsOn := range s.dependencies {
+			for _, parent := range dependsOn {
+				if slices.Contains(ids, taskID) || slices.Contains(ids, parent) {
+					edges = append(edges, TaskDependency{TaskID: taskID, DependsOn: parent})
+				}
+			}
+		}
+		return edges, nil
+	})
+
+	var tasks []Task
+	for _, id := range ids {
+		if task, ok := s.tasks[id]; ok {
+			tasks = append(tasks, cloneTask(&task.Task))
+		} else if task, ok := s.archived[id]; ok {
+			tasks = append(tasks, cloneTask(&task.Task))
+		}
+	}
+	return newTaskGraph(tasks, edges), nil
+}
+
+func (s *MemoryStore) GetTaskAttempts(ctx context.Context, id string) ([]TaskAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.dependencyStatuses([]string{id})[id]; !ok {
+		return nil, nil
+	}
+	attempts := []TaskAttempt{}
+	for i := range s.attempts[id] {
+		attempts = append(attempts, cloneAttempt(&s.attempts[id][i]))
+	}
+	return attempts, nil
+}
+
+func (s *MemoryStore) GetTaskEvents(ctx context.Context, filter TaskEventFilter) ([]TaskEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events := []TaskEvent{}
+	for i := len(s.events) - 1; i >= 0; i-- {
+		event := &s.events[i]
+		if !matchesEventFilter(event, filter) {
+			continue
+		}
+		if filter.Offset > 0 {
+			filter.Offset--
+			continue
+		}
+		if len(events) == filter.Limit {
+			break
+		}
+		events = append(events, cloneEvent(event))
+	}
+	return events, nil
+}
+
+// record appends the event of task changing from previous, see newTaskEvent
+func (s *MemoryStore) record(ctx context.Context, task *Task, previous *Task) {
+	event := newTaskEvent(ctx, task, previous)
+	if event == nil {
+		return
+	}
+	event.ID = int64(len(s.events)) + 1
+	event.CreatedAt = time.Now()
+	s.events = append(s.events, *event)
+}
+
+// finishAttempts closes the running attempt of the given tasks, if any, with outcome
+func (s *MemoryStore) finishAttempts(outcome string, taskErr *TaskError, now time.Time, ids ...string) {
+	for _, id := range ids {
+		attempts := s.attempts[id]
+		if len(attempts) > 0 && attempts[len(attempts)-1].FinishedAt == nil {
+			attempts[len(attempts)-1].finish(outcome, copyTaskError(taskErr), now)
+		}
+	}
+}
+
+func (s *MemoryStore) CreateBatch(ctx context.Context, batch *Batch, tasks []*Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.batches[batch.ID]; ok {
+		return fmt.Errorf("batch %s already exists", batch.ID)
+	}
+	var inserted []string
+	logged := len(s.events)
+	for _, task := range tasks {
+		task.BatchID = &batch.ID
+		if err := s.insert(ctx, task); err != nil {
+			// the batch is all or nothing, like the Postgres transaction
+			for _, id := range inserted {
+				delete(s.tasks, id)
+				delete(s.dependencies, id)
+			}
+			s.events = s.events[:logged]
+			return fmt.Errorf("error inserting task: %w", err)
+		}
+		inserted = append(inserted, task.ID)
+	}
+
+	now := time.Now()
+	batch.Total = len(tasks)
+	if batch.CallbackData == nil {
+		batch.CallbackData = json.RawMessage(`{}`)
+	}
+	batch.CreatedAt = now
+	batch.UpdatedAt = now
+	stored := cloneBatch(batch)
+	s.batches[batch.ID] = &stored
+
+	// tasks failed by their dependencies on creation may have finished the batch already
+	s.finishBatches(ctx, inserted)
+	for _, task := range tasks {
+		s.wake(task.QueueName)
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetBatch(ctx context.Context, id string) (*Batch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.batches[id]
+	if !ok {
+		return nil, nil
+	}
+	batch := cloneBatch(stored)
+	batch.Counts = newBatchCounts()
+	for _, tasks := range []map[string]*memoryTask{s.tasks, s.archived} {
+		for _, task := range tasks {
+			if task.BatchID != nil && *task.BatchID == id {
+				batch.Counts[task.Status]++
+			}
+		}
+	}
+	return &batch, nil
+}
+
+// finishBatches finishes the unfinished batches of taskIDs that have no pending, blocked or
+// running task left, enqueueing their callback task
+func (s *MemoryStore) finishBatches(ctx context.Context, taskIDs []string) {
+	for _, id := range taskIDs {
+		task, ok := s.tasks[id]
+		if !ok || task.BatchID == nil {
+			continue
+		}
+		batch, ok := s.batches[*task.BatchID]
+		if !ok || batch.FinishedAt != nil {
+			continue
+		}
+		if s.batchActive(batch.ID) {
+			continue
+		}
+
+		if batch.CallbackQueue != nil {
+			if _, ok := s.queues[*batch.CallbackQueue]; ok {
+				callback := batch.callbackTask()
+				if err := s.insert(ctx, &callback); err == nil {
+					s.wake(callback.QueueName)
+					batch.CallbackTaskID = &callback.ID
+				}
+			}
+		}
+		now := time.Now()
+		batch.FinishedAt = &now
+		batch.UpdatedAt = now
+	}
+}
+
+// batchActive reports whether a batch has a pending, blocked or running task
+func (s *MemoryStore) batchActive(id string) bool {
+	for _, task := range s.tasks {
+		if task.BatchID != nil && *task.BatchID == id && isActive(task.Status) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MemoryStore) GetTasks(ctx context.Context, filter TaskFilter) ([]Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	column, descending := filter.SortBy, false
+	if len(column) > 0 && column[0] == '-' {
+		column, descending = column[1:], true
+	}
+
+	var matching []*memoryTask
+	for _, task := range s.searched(filter) {
+		if matchesFilter(&task.Task, filter) {
+			matching = append(matching, task)
+		}
+	}
+
+	var sortErr error
+	sort.SliceStable(matching, func(i, j int) bool {
+		if column != "" {
+			c, err := compareColumn(&matching[i].Task, &matching[j].Task, column)
+			if err != nil {
+				sortErr = err
+				return false
+			}
+			if c != 0 {
+				return (c < 0) != descending
+			}
+		}
+		// newest first, as created_at DESC
+		return newer(matching[i], matching[j])
+	})
+	if sortErr != nil {
+		return nil, sortErr
+	}
+
+	if filter.Offset < 0 || filter.Limit < 0 {
+		return nil, fmt.Errorf("offset and limit cannot be negative")
+	}
+
+	var tasks []Task
+	for i := filter.Offset; i < len(matching) && len(tasks) < filter.Limit; i++ {
+		tasks = append(tasks, cloneTask(&matching[i].Task))
+	}
+	return tasks, nil
+}
+
+func (s *MemoryStore) GetTaskStats(ctx context.Context, filter TaskFilter) (map[string]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := map[string]int{
+		"all":       0,
+		"pending":   0,
+		"blocked":   0,
+		"scheduled": 0,
+		"running":   0,
+		"completed": 0,
+		"failed":    0,
+		"deleted":   0,
+	}
+
+	now := time.Now()
+	for _, task := range s.searched(filter) {
+		if !matchesFilter(&task.Task, filter) {
+			continue
+		}
+		stats["all"]++
+		if _, ok := stats[task.Status]; ok {
+			stats[task.Status]++
+		}
+		if task.Status == TaskStatusPending && !isDue(&task.Task, now) {
+			stats["scheduled"]++
+		}
+	}
+	return stats, nil
+}
+
+func (s *MemoryStore) GetNextPendingTask(ctx context.Context, queueName, clientID string) (*Task, error) {
+	tasks, err := s.ClaimTasks(ctx, queueName, clientID, 1)
+	if err != nil || len(tasks) == 0 {
+		return nil, err
+	}
+	return &tasks[0], nil
+}
+
+func (s *MemoryStore) ClaimTasks(ctx context.Context, queueName, clientID string, n int) ([]Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tasks := []Task{}
+	queue, ok := s.queues[queueName]
+	if n <= 0 || (ok && queue.Paused) {
+		return tasks, nil
+	}
+
+	now := time.Now()
+	var available []*memoryTask
+	running := 0
+	for _, task := range s.tasks {
+		if task.QueueName == queueName && task.Status == TaskStatusPending && task.AssignedTo == nil &&
+			isDue(&task.Task, now) {
+			available = append(available, task)
+		}
+		if task.QueueName == queueName && task.Status == TaskStatusRunning {
+			running++
+		}
+	}
+	if ok && queue.MaxRunning > 0 {
+		n = max(0, min(n, queue.MaxRunning-running))
+	}
+
+	var bucket tokenBucket
+	if ok && queue.Rate > 0 && n > 0 {
+		bucket = tokenBucket{rate: queue.Rate, burst: queue.Burst}
+		var elapsed *time.Duration
+		if stored, used := s.buckets[queueName]; used {
+			d := now.Sub(stored.updatedAt)
+			bucket.tokens, elapsed = stored.tokens, &d
+		}
+		bucket.refill(elapsed)
+		var err error
+		if n, err = bucket.take(n); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(available, func(i, j int) bool {
+		if available[i].Priority != available[j].Priority {
+			return available[i].Priority > available[j].Priority
+		}
+		return newer(available[j], available[i])
+	})
+	if len(available) > n {
+		available = available[:n]
+	}
+
+	var leaseExpiresAt *time.Time
+	if ok {
+		expiresAt := now.Add(queue.TaskTimeout)
+		leaseExpiresAt = &expiresAt
+	}
+
+	for _, task := range available {
+		previous := task.Task
+		assignedTo := clientID
+		startedAt := now
+		task.Status = TaskStatusRunning
+		task.AssignedTo = &assignedTo
+		task.StartedAt = &startedAt
+		task.UpdatedAt = now
+		task.Attempt++
+		task.NextAttemptAt = nil
+		task.LeaseExpiresAt = copyTime(leaseExpiresAt)
+		s.record(ctx, &task.Task, &previous)
+		tasks = append(tasks, cloneTask(&task.Task))
+		s.attempts[task.ID] = append(s.attempts[task.ID], TaskAttempt{
+			TaskID:    task.ID,
+			Attempt:   task.Attempt,
+			ClientID:  clientID,
+			Outcome:   AttemptRunning,
+			StartedAt: now,
+		})
+	}
+
+	if bucket.rate > 0 {
+		// only the tasks handed out spend tokens
+		s.buckets[queueName] = &memoryBucket{tokens: bucket.tokens + float64(n-len(tasks)), updatedAt: now}
+	}
+	return tasks, nil
+}
+
+func (s *MemoryStore) DeleteTask(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, ok := s.tasks[id]
+	if !ok {
+		return fmt.Errorf("task not found")
+	}
+	previous := task.Task
+	task.Status = TaskStatusDeleted
+	task.UpdatedAt = time.Now()
+	s.record(ctx, &task.Task, &previous)
+	s.finishAttempts(AttemptCancelled, nil, task.UpdatedAt, id)
+	s.settleFinished(ctx, id)
+	return nil
+}
+
+func (s *MemoryStore) ExtendLease(ctx context.Context, id, clientID string, extension time.Duration) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, ok := s.tasks[id]
+	if !ok || task.Status != TaskStatusRunning || task.AssignedTo == nil || *task.AssignedTo != clientID {
+		return time.Time{}, ErrLeaseLost
+	}
+	leaseExpiresAt := time.Now().Add(extension)
+	task.LeaseExpiresAt = &leaseExpiresAt
+	return leaseExpiresAt, nil
+}
+
+func (s *MemoryStore) MarkExpiredTasks(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var failed []string
+	for _, task := range s.tasks {
+		queue, ok := s.queues[task.QueueName]
+		if !ok || task.Status != TaskStatusRunning {
+			continue
+		}
+
+		expiresAt := task.LeaseExpiresAt
+		if expiresAt == nil {
+			// without a lease the timeout runs from the start, or the last update
+			startedAt := task.UpdatedAt
+			if task.StartedAt != nil {
+				startedAt = *task.StartedAt
+			}
+			deadline := startedAt.Add(queue.TaskTimeout)
+			expiresAt = &deadline
+		}
+		if !expiresAt.Before(now) {
+			continue
+		}
+
+		previous := task.Task
+		if !queue.RetryPolicy.ScheduleRetry(&task.Task, now) {
+			task.Status = TaskStatusFailed
+			queue.DeadLetter(&task.Task)
+		}
+		task.Error = timeoutError()
+		task.UpdatedAt = now
+		s.record(ctx, &task.Task, &previous)
+		s.finishAttempts(AttemptTimeout, timeoutError(), now, task.ID)
+
+		if task.Status == TaskStatusPending {
+			s.wake(task.QueueName)
+		} else {
+			failed = append(failed, task.ID)
+		}
+	}
+	s.settleFinished(ctx, failed...)
+	return nil
+}
+
+func (s *MemoryStore) RedriveDeadLetters(ctx context.Context, queueName string, taskIDs []string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	selected := make(map[string]bool, len(taskIDs))
+	for _, id := range taskIDs {
+		selected[id] = true
+	}
+
+	now := time.Now()
+	redriven := 0
+	for _, task := range s.tasks {
+		if task.QueueName != queueName || task.Status != TaskStatusFailed || task.OriginalQueue == nil {
+			continue
+		}
+		if len(taskIDs) > 0 && !selected[task.ID] {
+			continue
+		}
+
+		previous := task.Task
+		task.QueueName = *task.OriginalQueue
+		task.OriginalQueue = nil
+		task.Status = TaskStatusPending
+		task.AssignedTo = nil
+		task.StartedAt = nil
+		task.CompletedAt = nil
+		task.LeaseExpiresAt = nil
+		task.Attempt = 0
+		task.NextAttemptAt = nil
+		task.UpdatedAt = now
+		s.record(ctx, &task.Task, &previous)
+		redriven++
+
+		s.wake(task.QueueName)
+	}
+	return redriven, nil
+}
+
+func (s *MemoryStore) PurgeTasks(ctx context.Context, queueName string, asOf time.Time, limit int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purged := 0
+	for _, tasks := range []map[string]*memoryTask{s.tasks, s.archived} {
+		for id, task := range tasks {
+			if purged >= limit {
+				return purged, nil
+			}
+			if s.isPurgeable(task, queueName, asOf) {
+				delete(tasks, id)
+				delete(s.dependencies, id)
+				delete(s.attempts, id)
+				purged++
+			}
+		}
+	}
+	return purged, nil
+}
+
+func (s *MemoryStore) CountPurgeableTasks(ctx context.Context, queueName string, asOf time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, tasks := range []map[string]*memoryTask{s.tasks, s.archived} {
+		for _, task := range tasks {
+			if s.isPurgeable(task, queueName, asOf) {
+				count++
+			}
+		}
+	}
+	return count, nil
+}
+
+func (s *MemoryStore) ArchiveTasks(ctx context.Context, asOf time.Time, limit int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	archived := 0
+	for id, task := range s.tasks {
+		if archived >= limit {
+			break
+		}
+		queue, ok := s.queues[task.QueueName]
+		if !ok || queue.ArchiveAfter <= 0 || isActive(task.Status) ||
+			!task.UpdatedAt.Before(asOf.Add(-queue.ArchiveAfter)) {
+			continue
+		}
+		delete(s.tasks, id)
+		s.archived[id] = task
+		archived++
+	}
+	return archived, nil
+}
+
+func (s *MemoryStore) CreateSchedule(ctx context.Context, schedule *Schedule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.schedules[schedule.ID]; ok {
+		return fmt.Errorf("schedule %s already exists", schedule.ID)
+	}
+	if _, ok := s.queues[schedule.QueueName]; !ok {
+		return fmt.Errorf("queue %s does not exist", schedule.QueueName)
+	}
+
+	now := time.Now()
+	schedule.CreatedAt = now
+	schedule.UpdatedAt = now
+	stored := cloneSchedule(schedule)
+	s.schedules[schedule.ID] = &stored
+	return nil
+}
+
+func (s *MemoryStore) GetSchedule(ctx context.Context, id string) (*Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedule, ok := s.schedules[id]
+	if !ok {
+		return nil, nil
+	}
+	clone := cloneSchedule(schedule)
+	return &clone, nil
+}
+
+func (s *MemoryStore) GetSchedules(ctx context.Context, queueName string) ([]Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var schedules []Schedule
+	for _, schedule := range s.schedules {
+		if queueName == "" || schedule.QueueName == queueName {
+			schedules = append(schedules, cloneSchedule(schedule))
+		}
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		if !schedules[i].CreatedAt.Equal(schedules[j].CreatedAt) {
+			return schedules[i].CreatedAt.Before(schedules[j].CreatedAt)
+		}
+		return schedules[i].ID < schedules[j].ID
+	})
+	return schedules, nil
+}
+
+func (s *MemoryStore) SetSchedulePaused(ctx context.Context, id string, paused bool, nextRunAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedule, ok := s.schedules[id]
+	if !ok {
+		return ErrScheduleNotFound
+	}
+	schedule.Paused = paused
+	schedule.NextRunAt = nextRunAt
+	schedule.UpdatedAt = time.Now()
+	return nil
+}
+
+func (s *MemoryStore) DeleteSchedule(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.schedules[id]; !ok {
+		return ErrScheduleNotFound
+	}
+	delete(s.schedules, id)
+	return nil
+}
+
+func (s *MemoryStore) RunDueSchedules(ctx context.Context, asOf time.Time, limit int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []*Schedule
+	for _, schedule := range s.schedules {
+		if !schedule.Paused && !schedule.NextRunAt.After(asOf) {
+			due = append(due, schedule)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].NextRunAt.Before(due[j].NextRunAt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	now := time.Now()
+	for _, schedule := range due {
+		schedule.UpdatedAt = now
+		next, err := schedule.Next(asOf)
+		if err != nil {
+			schedule.Paused = true
+			continue
+		}
+
+		task := schedule.newTask()
+		if err := s.insert(ctx, &task); err != nil {
+			return 0, fmt.Errorf("error enqueuing task of schedule %s: %w", schedule.ID, err)
+		}
+		lastRunAt := schedule.NextRunAt
+		schedule.LastRunAt = &lastRunAt
+		schedule.NextRunAt = next
+		s.wake(schedule.QueueName)
+	}
+	return len(due), nil
+}
+
+// searched returns the tasks a filter searches, see taskSource
+func (s *MemoryStore) searched(filter TaskFilter) map[string]*memoryTask {
+	if !filter.IncludeArchived {
+		return s.tasks
+	}
+	tasks := make(map[string]*memoryTask, len(s.tasks)+len(s.archived))
+	for id, task := range s.tasks {
+		tasks[id] = task
+	}
+	for id, task := range s.archived {
+		tasks[id] = task
+	}
+	return tasks
+}
+
+// isPurgeable reports whether a task of queueName (any queue when empty) outlived the
+// retention of its queue at asOf
+func (s *MemoryStore) isPurgeable(task *memoryTask, queueName string, asOf time.Time) bool {
+	if queueName != "" && task.QueueName != queueName {
+		return false
+	}
+	queue, ok := s.queues[task.QueueName]
+	return ok && queue.Retention.isPurgeable(&task.Task, asOf)
+}
+
+// matchesFilter applies the conditions of a TaskFilter, see filterConditions
+func matchesFilter(task *Task, filter TaskFilter) bool {
+	if filter.QueueName != "" && task.QueueName != filter.QueueName {
+		return false
+	}
+	if filter.Status != "" && task.Status != filter.Status {
+		return false
+	}
+	if filter.Priority != nil && task.Priority != *filter.Priority {
+		return false
+	}
+	if filter.BatchID != "" && (task.BatchID == nil || *task.BatchID != filter.BatchID) {
+		return false
+	}
+	if !filter.FromDate.IsZero() && task.CreatedAt.Before(filter.FromDate) {
+		return false
+	}
+	if !filter.ToDate.IsZero() && task.CreatedAt.After(filter.ToDate) {
+		return false
+	}
+	return true
+}
+
+// matchesEventFilter reports whether an event matches the filter, see matchesFilter
+func matchesEventFilter(event *TaskEvent, filter TaskEventFilter) bool {
+	if filter.TaskID != "" && event.TaskID != filter.TaskID {
+		return false
+	}
+	if filter.QueueName != "" && event.QueueName != filter.QueueName {
+		return false
+	}
+	if filter.ActorType != "" && event.Actor.Type != filter.ActorType {
+		return false
+	}
+	if filter.Actor != "" && event.Actor.Name != filter.Actor {
+		return false
+	}
+	if !filter.FromDate.IsZero() && event.CreatedAt.Before(filter.FromDate) {
+		return false
+	}
+	if !filter.ToDate.IsZero() && event.CreatedAt.After(filter.ToDate) {
+		return false
+	}
+	return true
+}
+
+// compareColumn compares two tasks by a sortable column. Missing values sort after any
+// other value, as NULLs do in Postgres.
+func compareColumn(a, b *Task, column string) (int, error) {
+	switch column {
+	case "id":
+		return cmp.Compare(a.ID, b.ID), nil
+	case "queue_name":
+		return cmp.Compare(a.QueueName, b.QueueName), nil
+	case "status":
+		return cmp.Compare(a.Status, b.Status), nil
+	case "priority":
+		return cmp.Compare(a.Priority, b.Priority), nil
+	case "attempt":
+		return cmp.Compare(a.Attempt, b.Attempt), nil
+	case "assigned_to":
+		return compareOptional(a.AssignedTo, b.AssignedTo, cmp.Compare[string]), nil
+	case "original_queue":
+		return compareOptional(a.OriginalQueue, b.OriginalQueue, cmp.Compare[string]), nil
+	case "created_at":
+		return a.CreatedAt.Compare(b.CreatedAt), nil
+	case "updated_at":
+		return a.UpdatedAt.Compare(b.UpdatedAt), nil
+	case "started_at":
+		return compareOptional(a.StartedAt, b.StartedAt, time.Time.Compare), nil
+	case "completed_at":
+		return compareOptional(a.CompletedAt, b.CompletedAt, time.Time.Compare), nil
+	case "run_at":
+		return compareOptional(a.RunAt, b.RunAt, time.Time.Compare), nil
+	case "next_attempt_at":
+		return compareOptional(a.NextAttemptAt, b.NextAttemptAt, time.Time.Compare), nil
+	case "lease_expires_at":
+		return compareOptional(a.LeaseExpiresAt, b.LeaseExpiresAt, time.Time.Compare), nil
+	}
+	return 0, fmt.Errorf("cannot sort by %s", column)
+}
+
+func compareOptional[T any](a, b *T, compare func(T, T) int) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+	return compare(*a, *b)
+}
+
+// newer reports whether a was created after b, falling back to the insertion order
+func newer(a, b *memoryTask) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.After(b.CreatedAt)
+	}
+	return a.seq > b.seq
+}
+
+// isDue reports whether a pending task can be handed out at now
+func isDue(task *Task, now time.Time) bool {
+	return (task.RunAt == nil || !task.RunAt.After(now)) &&
+		(task.NextAttemptAt == nil || !task.NextAttemptAt.After(now))
+}
+
+func isActive(status string) bool {
+	return status == TaskStatusPending || status == TaskStatusRunning || status == TaskStatusBlocked
+}
+
+// withField sets a key of a JSON object, replacing data that is not an object
+func withField(data json.RawMessage, key string, value interface{}) json.RawMessage {
+	object := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &object); err != nil || object == nil {
+		object = make(map[string]json.RawMessage)
+	}
+	object[key], _ = json.Marshal(value)
+	result, _ := json.Marshal(object)
+	return result
+}
+
+// cloneBatch returns a copy of a stored batch, which has no counts
+func cloneBatch(batch *Batch) Batch {
+	clone := *batch
+	clone.CallbackQueue = copyString(batch.CallbackQueue)
+	clone.CallbackTaskID = copyString(batch.CallbackTaskID)
+	if batch.CallbackData != nil {
+		clone.CallbackData = append(json.RawMessage(nil), batch.CallbackData...)
+	}
+	clone.FinishedAt = copyTime(batch.FinishedAt)
+	return clone
+}
+
+// cloneTask returns a deep copy of task, so stored tasks are never shared with callers
+func cloneTask(task *Task) Task {
+	clone := *task
+	if task.Data != nil {
+		clone.Data = append(json.RawMessage(nil), task.Data...)
+	}
+	clone.AssignedTo = copyString(task.AssignedTo)
+	clone.OriginalQueue = copyString(task.OriginalQueue)
+	clone.IdempotencyKey = copyString(task.IdempotencyKey)
+	clone.BatchID = copyString(task.BatchID)
+	if task.Result != nil {
+		clone.Result = append(json.RawMessage(nil), task.Result...)
+	}
+	clone.Error = copyTaskError(task.Error)
+	if task.DependsOn != nil {
+		clone.DependsOn = append([]string(nil), task.DependsOn...)
+	}
+	clone.NextAttemptAt = copyTime(task.NextAttemptAt)
+	clone.RunAt = copyTime(task.RunAt)
+	clone.StartedAt = copyTime(task.StartedAt)
+	clone.CompletedAt = copyTime(task.CompletedAt)
+	clone.LeaseExpiresAt = copyTime(task.LeaseExpiresAt)
+	return clone
+}
+
+// cloneAttempt returns a deep copy of attempt, see cloneTask
+func cloneAttempt(attempt *TaskAttempt) TaskAttempt {
+	clone := *attempt
+	clone.Error = copyTaskError(attempt.Error)
+	clone.FinishedAt = copyTime(attempt.FinishedAt)
+	if attempt.Duration != nil {
+		d := *attempt.Duration
+		clone.Duration = &d
+	}
+	return clone
+}
+
+// cloneEvent returns a deep copy of event, see cloneTask
+func cloneEvent(event *TaskEvent) TaskEvent {
+	clone := *event
+	clone.FromStatus = copyString(event.FromStatus)
+	if event.Details != nil {
+		clone.Details = append(json.RawMessage(nil), event.Details...)
+	}
+	return clone
+}
+
+// cloneSchedule returns a deep copy of schedule, see cloneTask
+func cloneSchedule(schedule *Schedule) Schedule {
+	clone := *schedule
+	if schedule.Data != nil {
+		clone.Data = append(json.RawMessage(nil), schedule.Data...)
+	}
+	clone.LastRunAt = copyTime(schedule.LastRunAt)
+	return clone
+}
+
+func copyString(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	c := *s
+	return &c
+}
+
+func copyTaskError(e *TaskError) *TaskError {
+	if e == nil {
+		return nil
+	}
+	c := *e
+	if e.Details != nil {
+		c.Details = append(json.RawMessage(nil), e.Details...)
+	}
+	return &c
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	return &c
+}
@@ -0,0 +1,14 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/fernandezvara/jobqueues/internal/storage"
+	"github.com/fernandezvara/jobqueues/internal/storage/storetest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) storage.Store {
+		return storage.NewMemoryStore()
+	})
+}
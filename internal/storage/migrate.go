@@ -0,0 +1,320 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Database dialects with their own set of migrations
+const (
+	DialectPostgres = "postgres"
+	DialectSQLite   = "sqlite"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// Migration is a numbered schema change read from migrations/{dialect}/{version}_{name}.{up|down}.sql
+type Migration struct {
+	Version int
+	Name    string
+	up      string
+	down    string
+}
+
+// MigrationStatus tells whether a migration is applied. Applied migrations unknown to this
+// binary, written by a newer release, have an empty Name.
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// dialect holds the statements that differ between databases
+type dialect struct {
+	createTable string
+	isApplied   string
+	insert      string
+	delete      string
+	lock        string
+	unlock      string
+	timeArg     func(time.Time) interface{}
+	scanTime    func(*time.Time) interface{}
+}
+
+var dialects = map[string]dialect{
+	DialectPostgres: {
+		createTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+            version BIGINT PRIMARY KEY,
+            name VARCHAR(255) NOT NULL,
+            applied_at TIMESTAMP NOT NULL
+        )`,
+		isApplied: `SELECT COUNT(*) FROM schema_migrations WHERE version = $1`,
+		insert:    `INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+		delete:    `DELETE FROM schema_migrations WHERE version = $1`,
+		// replicas starting at the same time wait for each other instead of racing
+		lock:     `SELECT pg_advisory_lock(hashtext('jobqueue/schema_migrations'))`,
+		unlock:   `SELECT pg_advisory_unlock(hashtext('jobqueue/schema_migrations'))`,
+		timeArg:  func(t time.Time) interface{} { return t.UTC() },
+		scanTime: func(t *time.Time) interface{} { return t },
+	},
+	DialectSQLite: {
+		createTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+            version INTEGER PRIMARY KEY,
+            name TEXT NOT NULL,
+            applied_at INTEGER NOT NULL
+        )`,
+		isApplied: `SELECT COUNT(*) FROM schema_migrations WHERE version = ?`,
+		insert:    `INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+		delete:    `DELETE FROM schema_migrations WHERE version = ?`,
+		// transactions take the write lock, so each migration is checked again inside its own
+		timeArg:  func(t time.Time) interface{} { return t.UnixNano() },
+		scanTime: func(t *time.Time) interface{} { return unixTime{t} },
+	},
+}
+
+// Migrator applies and rolls back the embedded migrations of a dialect
+type Migrator struct {
+	db         *sql.DB
+	dialect    dialect
+	migrations []Migration
+}
+
+func NewMigrator(db *sql.DB, dialectName string) (*Migrator, error) {
+	d, ok := dialects[dialectName]
+	if !ok {
+		return nil, fmt.Errorf("unknown database dialect %s", dialectName)
+	}
+
+	migrations, err := loadMigrations(dialectName)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, dialect: d, migrations: migrations}, nil
+}
+
+// loadMigrations reads the migrations of a dialect sorted by version
+func loadMigrations(dialectName string) ([]Migration, error) {
+	dir := "migrations/" + dialectName
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		base, ok := strings.CutSuffix(entry.Name(), ".sql")
+		if !ok {
+			continue
+		}
+		base, direction, _ := strings.Cut(base, ".")
+		prefix, name, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("invalid migration file name %s", entry.Name())
+		}
+
+		content, err := fs.ReadFile(migrationFiles, dir+"/"+entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("error reading migration %s: %w", entry.Name(), err)
+		}
+
+		migration := byVersion[version]
+		if migration == nil {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		}
+		if direction == "up" {
+			migration.up = string(content)
+		} else {
+			migration.down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.up == "" {
+			return nil, fmt.Errorf("migration %d has no up script", migration.Version)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// withLock runs fn on a connection holding the migration lock, once schema_migrations exists
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting connection: %w", err)
+	}
+	defer conn.Close()
+
+	if m.dialect.lock != "" {
+		if _, err := conn.ExecContext(ctx, m.dialect.lock); err != nil {
+			return fmt.Errorf("error locking migrations: %w", err)
+		}
+		defer conn.ExecContext(context.Background(), m.dialect.unlock)
+	}
+
+	if _, err := conn.ExecContext(ctx, m.dialect.createTable); err != nil {
+		return fmt.Errorf("error creating schema_migrations: %w", err)
+	}
+	return fn(conn)
+}
+
+// Up applies every pending migration in order, returning how many were applied
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	applied := 0
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		for _, migration := range m.migrations {
+			ok, err := m.apply(ctx, conn, migration, true)
+			if err != nil {
+				return err
+			}
+			if ok {
+				applied++
+			}
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down rolls back the last steps applied migrations, returning how many were rolled back
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	rolledBack := 0
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		statuses, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(statuses) - 1; i >= 0 && rolledBack < steps; i-- {
+			status := statuses[i]
+			if status.AppliedAt == nil {
+				continue
+			}
+			if status.Name == "" {
+				return fmt.Errorf("migration %d is not known to this version", status.Version)
+			}
+			ok, err := m.apply(ctx, conn, status.Migration, false)
+			if err != nil {
+				return err
+			}
+			if ok {
+				rolledBack++
+			}
+		}
+		return nil
+	})
+	return rolledBack, err
+}
+
+// apply runs a migration in its own transaction unless it is already in the requested state
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, migration Migration, up bool) (bool, error) {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var count int
+	if err := tx.QueryRowContext(ctx, m.dialect.isApplied, migration.Version).Scan(&count); err != nil {
+		return false, fmt.Errorf("error checking migration %d: %w", migration.Version, err)
+	}
+	if (count > 0) == up {
+		return false, nil
+	}
+
+	script := migration.up
+	if !up {
+		script = migration.down
+		if script == "" {
+			return false, fmt.Errorf("migration %d cannot be rolled back", migration.Version)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return false, fmt.Errorf("error running migration %d_%s: %w", migration.Version, migration.Name, err)
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, m.dialect.insert, migration.Version, migration.Name, m.dialect.timeArg(time.Now()))
+	} else {
+		_, err = tx.ExecContext(ctx, m.dialect.delete, migration.Version)
+	}
+	if err != nil {
+		return false, fmt.Errorf("error recording migration %d: %w", migration.Version, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing migration %d: %w", migration.Version, err)
+	}
+	return true, nil
+}
+
+// Status lists the known and applied migrations sorted by version
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		var err error
+		statuses, err = m.status(ctx, conn)
+		return err
+	})
+	return statuses, err
+}
+
+func (m *Migrator) status(ctx context.Context, conn *sql.Conn) ([]MigrationStatus, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, m.dialect.scanTime(&appliedAt)); err != nil {
+			return nil, fmt.Errorf("error scanning migration: %w", err)
+		}
+		applied[version] = appliedAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating migrations: %w", err)
+	}
+
+	var statuses []MigrationStatus
+	for _, migration := range m.migrations {
+		status := MigrationStatus{Migration: migration}
+		if appliedAt, ok := applied[migration.Version]; ok {
+			status.AppliedAt = &appliedAt
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for version, appliedAt := range applied {
+		appliedAt := appliedAt
+		statuses = append(statuses, MigrationStatus{Migration: Migration{Version: version}, AppliedAt: &appliedAt})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// migrateUp applies the pending migrations of a dialect
+func migrateUp(db *sql.DB, dialectName string) error {
+	migrator, err := NewMigrator(db, dialectName)
+	if err != nil {
+		return err
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		return fmt.Errorf("error initializing schema: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package storage_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/fernandezvara/jobqueues/internal/storage"
+)
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	db, err := storage.NewSQLiteDB(filepath.Join(t.TempDir(), "jobqueue.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteDB: %v", err)
+	}
+	defer db.Close()
+
+	migrator, err := storage.NewMigrator(db, storage.DialectSQLite)
+	if err != nil {
+		t.Fatalf("NewMigrator: %v", err)
+	}
+
+	statuses, err := migrator.Status(ctx)
+	if err != nil || len(statuses) == 0 {
+		t.Fatalf("Status = %v, %v", statuses, err)
+	}
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			t.Errorf("migration %d applied on an empty database", status.Version)
+		}
+	}
+
+	applied, err := migrator.Up(ctx)
+	if err != nil || applied != len(statuses) {
+		t.Fatalf("Up = %d, %v, want %d applied", applied, err, len(statuses))
+	}
+	if applied, err := migrator.Up(ctx); err != nil || applied != 0 {
+		t.Errorf("second Up = %d, %v, want nothing to apply", applied, err)
+	}
+	if _, err := db.Exec("SELECT COUNT(*) FROM tasks"); err != nil {
+		t.Errorf("tasks table missing after Up: %v", err)
+	}
+
+	statuses, _ = migrator.Status(ctx)
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			t.Errorf("migration %d pending after Up", status.Version)
+		}
+	}
+
+	rolledBack, err := migrator.Down(ctx, len(statuses))
+	if err != nil || rolledBack != len(statuses) {
+		t.Fatalf("Down = %d, %v, want %d rolled back", rolledBack, err, len(statuses))
+	}
+	if _, err := db.Exec("SELECT COUNT(*) FROM tasks"); err == nil {
+		t.Errorf("tasks table still exists after rolling back every migration")
+	}
+
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Errorf("Up after Down: %v", err)
+	}
+}
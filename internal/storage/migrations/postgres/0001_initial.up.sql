@@ -0,0 +1,67 @@
+-- baseline schema, written to also bring databases created before migrations up to date
+
+CREATE TABLE IF NOT EXISTS queues (
+    name VARCHAR(255) PRIMARY KEY,
+    task_timeout BIGINT NOT NULL,
+    retry_max_attempts INT NOT NULL DEFAULT 0,
+    retry_base_delay_ms BIGINT NOT NULL DEFAULT 0,
+    retry_multiplier DOUBLE PRECISION NOT NULL DEFAULT 0,
+    retry_max_delay_ms BIGINT NOT NULL DEFAULT 0,
+    retry_jitter DOUBLE PRECISION NOT NULL DEFAULT 0,
+    dead_letter_queue VARCHAR(255),
+    idempotency_ttl BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS tasks (
+    id VARCHAR(20) PRIMARY KEY,
+    queue_name VARCHAR(255) REFERENCES queues(name),
+    status VARCHAR(20) NOT NULL,
+    priority INT NOT NULL DEFAULT 0,
+    data JSONB,
+    assigned_to VARCHAR(255),
+    attempt INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP,
+    original_queue VARCHAR(255),
+    run_at TIMESTAMP,
+    idempotency_key VARCHAR(255),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP,
+    completed_at TIMESTAMP,
+    lease_expires_at TIMESTAMP
+);
+
+-- columns added after the initial release
+ALTER TABLE queues ADD COLUMN IF NOT EXISTS retry_max_attempts INT NOT NULL DEFAULT 0;
+ALTER TABLE queues ADD COLUMN IF NOT EXISTS retry_base_delay_ms BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE queues ADD COLUMN IF NOT EXISTS retry_multiplier DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE queues ADD COLUMN IF NOT EXISTS retry_max_delay_ms BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE queues ADD COLUMN IF NOT EXISTS retry_jitter DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE queues ADD COLUMN IF NOT EXISTS dead_letter_queue VARCHAR(255);
+ALTER TABLE queues ADD COLUMN IF NOT EXISTS idempotency_ttl BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS attempt INT NOT NULL DEFAULT 0;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMP;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS original_queue VARCHAR(255);
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS run_at TIMESTAMP;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS priority INT NOT NULL DEFAULT 0;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS idempotency_key VARCHAR(255);
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_tasks_status ON tasks(status);
+CREATE INDEX IF NOT EXISTS idx_tasks_queue_name ON tasks(queue_name);
+CREATE INDEX IF NOT EXISTS idx_tasks_created_at ON tasks(created_at);
+CREATE INDEX IF NOT EXISTS idx_tasks_assigned_to ON tasks(assigned_to);
+CREATE INDEX IF NOT EXISTS idx_tasks_run_at ON tasks(run_at) WHERE run_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_tasks_original_queue ON tasks(original_queue) WHERE original_queue IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_tasks_combined ON tasks(queue_name, status, created_at, assigned_to);
+CREATE INDEX IF NOT EXISTS idx_tasks_lease ON tasks(lease_expires_at) WHERE status = 'running';
+CREATE INDEX IF NOT EXISTS idx_tasks_next ON tasks(queue_name, priority DESC, created_at ASC) WHERE status = 'pending' AND assigned_to IS NULL;
+
+-- a key can only be used by one active task per queue
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tasks_idempotency_key ON tasks(queue_name, idempotency_key)
+    WHERE idempotency_key IS NOT NULL AND status IN ('pending', 'running');
+
+CREATE INDEX IF NOT EXISTS idx_queues_name ON queues(name);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_tasks_finished;
+
+ALTER TABLE queues DROP COLUMN retention_completed;
+ALTER TABLE queues DROP COLUMN retention_failed;
+ALTER TABLE queues DROP COLUMN retention_deleted;
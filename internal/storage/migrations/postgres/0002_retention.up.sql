@@ -0,0 +1,5 @@
+ALTER TABLE queues ADD COLUMN retention_completed BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE queues ADD COLUMN retention_failed BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE queues ADD COLUMN retention_deleted BIGINT NOT NULL DEFAULT 0;
+
+CREATE INDEX idx_tasks_finished ON tasks(queue_name, status, updated_at) WHERE status IN ('completed', 'failed', 'deleted');
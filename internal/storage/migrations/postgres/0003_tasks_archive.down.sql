@@ -0,0 +1,11 @@
+-- archived tasks are moved back so rolling back loses no history
+INSERT INTO tasks (id, queue_name, status, priority, data, assigned_to, attempt, next_attempt_at, original_queue,
+    run_at, idempotency_key, created_at, updated_at, started_at, completed_at, lease_expires_at)
+SELECT id, queue_name, status, priority, data, assigned_to, attempt, next_attempt_at, original_queue,
+    run_at, idempotency_key, created_at, updated_at, started_at, completed_at, lease_expires_at
+FROM tasks_archive
+WHERE queue_name IN (SELECT name FROM queues);
+
+DROP TABLE tasks_archive;
+
+ALTER TABLE queues DROP COLUMN archive_after;
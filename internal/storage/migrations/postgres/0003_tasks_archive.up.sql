@@ -0,0 +1,25 @@
+ALTER TABLE queues ADD COLUMN archive_after BIGINT NOT NULL DEFAULT 0;
+
+-- finished tasks moved out of tasks once they are older than the archive_after of their queue
+CREATE TABLE tasks_archive (
+    id VARCHAR(20) PRIMARY KEY,
+    queue_name VARCHAR(255),
+    status VARCHAR(20) NOT NULL,
+    priority INT NOT NULL DEFAULT 0,
+    data JSONB,
+    assigned_to VARCHAR(255),
+    attempt INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP,
+    original_queue VARCHAR(255),
+    run_at TIMESTAMP,
+    idempotency_key VARCHAR(255),
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    started_at TIMESTAMP,
+    completed_at TIMESTAMP,
+    lease_expires_at TIMESTAMP,
+    archived_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_tasks_archive_queue_name ON tasks_archive(queue_name, status, updated_at);
+CREATE INDEX idx_tasks_archive_created_at ON tasks_archive(created_at);
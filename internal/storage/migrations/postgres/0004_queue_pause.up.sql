@@ -0,0 +1,2 @@
+-- paused queues keep accepting tasks but hand none out
+ALTER TABLE queues ADD COLUMN paused BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,2 @@
+-- claims stop handing out tasks while this many tasks of the queue are running, 0 means no limit
+ALTER TABLE queues ADD COLUMN max_running INT NOT NULL DEFAULT 0;
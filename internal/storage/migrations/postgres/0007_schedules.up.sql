@@ -0,0 +1,17 @@
+-- recurring tasks, a task is enqueued in queue_name every time next_run_at is due
+CREATE TABLE schedules (
+    id VARCHAR(20) PRIMARY KEY,
+    queue_name VARCHAR(255) NOT NULL REFERENCES queues(name) ON DELETE CASCADE,
+    cron VARCHAR(255) NOT NULL,
+    timezone VARCHAR(64) NOT NULL DEFAULT '',
+    priority INT NOT NULL DEFAULT 0,
+    data JSONB,
+    paused BOOLEAN NOT NULL DEFAULT FALSE,
+    next_run_at TIMESTAMP NOT NULL,
+    last_run_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_schedules_due ON schedules(next_run_at) WHERE NOT paused;
+CREATE INDEX idx_schedules_queue_name ON schedules(queue_name);
@@ -0,0 +1,5 @@
+DROP INDEX idx_tasks_idempotency_key;
+CREATE UNIQUE INDEX idx_tasks_idempotency_key ON tasks(queue_name, idempotency_key)
+    WHERE idempotency_key IS NOT NULL AND status IN ('pending', 'running');
+
+DROP TABLE task_dependencies;
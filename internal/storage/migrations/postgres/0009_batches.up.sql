@@ -0,0 +1,17 @@
+-- groups of tasks created together, callback_queue receives a task once all of them finished
+CREATE TABLE batches (
+    id VARCHAR(20) PRIMARY KEY,
+    callback_queue VARCHAR(255),
+    callback_data JSONB NOT NULL DEFAULT '{}',
+    callback_task_id VARCHAR(20),
+    total INT NOT NULL,
+    finished_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE tasks ADD COLUMN batch_id VARCHAR(20);
+ALTER TABLE tasks_archive ADD COLUMN batch_id VARCHAR(20);
+
+CREATE INDEX idx_tasks_batch_id ON tasks(batch_id) WHERE batch_id IS NOT NULL;
+CREATE INDEX idx_tasks_archive_batch_id ON tasks_archive(batch_id) WHERE batch_id IS NOT NULL;
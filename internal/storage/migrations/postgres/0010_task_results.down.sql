@@ -0,0 +1,4 @@
+ALTER TABLE tasks_archive DROP COLUMN error;
+ALTER TABLE tasks_archive DROP COLUMN result;
+ALTER TABLE tasks DROP COLUMN error;
+ALTER TABLE tasks DROP COLUMN result;
@@ -0,0 +1,5 @@
+-- what a task produced, kept apart from its input data
+ALTER TABLE tasks ADD COLUMN result JSONB;
+ALTER TABLE tasks ADD COLUMN error JSONB;
+ALTER TABLE tasks_archive ADD COLUMN result JSONB;
+ALTER TABLE tasks_archive ADD COLUMN error JSONB;
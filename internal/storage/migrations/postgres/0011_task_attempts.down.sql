@@ -0,0 +1,3 @@
+DROP INDEX idx_task_attempts_task_id;
+
+DROP TABLE task_attempts;
@@ -0,0 +1,13 @@
+-- every claim of a task by a worker, finished_at is NULL while the attempt is running
+CREATE TABLE task_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    task_id VARCHAR(20) NOT NULL,
+    attempt INT NOT NULL,
+    client_id VARCHAR(255) NOT NULL,
+    outcome VARCHAR(20) NOT NULL DEFAULT 'running',
+    error JSONB,
+    started_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP
+);
+
+CREATE INDEX idx_task_attempts_task_id ON task_attempts(task_id);
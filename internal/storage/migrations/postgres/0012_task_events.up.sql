@@ -0,0 +1,28 @@
+-- append-only audit log of the changes of tasks and who made them
+CREATE TABLE task_events (
+    id BIGSERIAL PRIMARY KEY,
+    task_id VARCHAR(20) NOT NULL,
+    queue_name VARCHAR(255) NOT NULL,
+    type VARCHAR(20) NOT NULL,
+    from_status VARCHAR(20),
+    to_status VARCHAR(20) NOT NULL,
+    details JSONB,
+    actor_type VARCHAR(20) NOT NULL,
+    actor VARCHAR(255) NOT NULL DEFAULT '',
+    request_id VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_task_events_task_id ON task_events(task_id);
+CREATE INDEX idx_task_events_queue_name ON task_events(queue_name, created_at);
+CREATE INDEX idx_task_events_actor ON task_events(actor, created_at);
+CREATE INDEX idx_task_events_created_at ON task_events(created_at);
+
+CREATE FUNCTION task_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'task events are append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER task_events_append_only BEFORE UPDATE OR DELETE ON task_events
+    FOR EACH ROW EXECUTE FUNCTION task_events_append_only();
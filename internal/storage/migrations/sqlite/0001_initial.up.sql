@@ -0,0 +1,43 @@
+CREATE TABLE IF NOT EXISTS queues (
+    name TEXT PRIMARY KEY,
+    task_timeout INTEGER NOT NULL,
+    retry_max_attempts INTEGER NOT NULL DEFAULT 0,
+    retry_base_delay_ms INTEGER NOT NULL DEFAULT 0,
+    retry_multiplier REAL NOT NULL DEFAULT 0,
+    retry_max_delay_ms INTEGER NOT NULL DEFAULT 0,
+    retry_jitter REAL NOT NULL DEFAULT 0,
+    dead_letter_queue TEXT,
+    idempotency_ttl INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS tasks (
+    id TEXT PRIMARY KEY,
+    queue_name TEXT REFERENCES queues(name),
+    status TEXT NOT NULL,
+    priority INTEGER NOT NULL DEFAULT 0,
+    data TEXT,
+    assigned_to TEXT,
+    attempt INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at INTEGER,
+    original_queue TEXT,
+    run_at INTEGER,
+    idempotency_key TEXT,
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL,
+    started_at INTEGER,
+    completed_at INTEGER,
+    lease_expires_at INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS idx_tasks_status ON tasks(status);
+CREATE INDEX IF NOT EXISTS idx_tasks_queue_name ON tasks(queue_name);
+CREATE INDEX IF NOT EXISTS idx_tasks_created_at ON tasks(created_at);
+CREATE INDEX IF NOT EXISTS idx_tasks_original_queue ON tasks(original_queue) WHERE original_queue IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_tasks_lease ON tasks(lease_expires_at) WHERE status = 'running';
+CREATE INDEX IF NOT EXISTS idx_tasks_next ON tasks(queue_name, priority DESC, created_at ASC) WHERE status = 'pending' AND assigned_to IS NULL;
+
+-- a key can only be used by one active task per queue
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tasks_idempotency_key ON tasks(queue_name, idempotency_key)
+    WHERE idempotency_key IS NOT NULL AND status IN ('pending', 'running');
@@ -0,0 +1,25 @@
+ALTER TABLE queues ADD COLUMN archive_after INTEGER NOT NULL DEFAULT 0;
+
+-- finished tasks moved out of tasks once they are older than the archive_after of their queue
+CREATE TABLE tasks_archive (
+    id TEXT PRIMARY KEY,
+    queue_name TEXT,
+    status TEXT NOT NULL,
+    priority INTEGER NOT NULL DEFAULT 0,
+    data TEXT,
+    assigned_to TEXT,
+    attempt INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at INTEGER,
+    original_queue TEXT,
+    run_at INTEGER,
+    idempotency_key TEXT,
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL,
+    started_at INTEGER,
+    completed_at INTEGER,
+    lease_expires_at INTEGER,
+    archived_at INTEGER NOT NULL
+);
+
+CREATE INDEX idx_tasks_archive_queue_name ON tasks_archive(queue_name, status, updated_at);
+CREATE INDEX idx_tasks_archive_created_at ON tasks_archive(created_at);
@@ -0,0 +1,2 @@
+-- paused queues keep accepting tasks but hand none out
+ALTER TABLE queues ADD COLUMN paused INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,4 @@
+ALTER TABLE queues DROP COLUMN rate_updated_at;
+ALTER TABLE queues DROP COLUMN rate_tokens;
+ALTER TABLE queues DROP COLUMN burst;
+ALTER TABLE queues DROP COLUMN rate;
@@ -0,0 +1,6 @@
+-- token bucket limiting how fast the tasks of a queue are handed out, 0 rate means no limit
+ALTER TABLE queues ADD COLUMN rate REAL NOT NULL DEFAULT 0;
+ALTER TABLE queues ADD COLUMN burst INTEGER NOT NULL DEFAULT 0;
+-- tokens left at rate_updated_at, a NULL rate_updated_at means a full bucket
+ALTER TABLE queues ADD COLUMN rate_tokens REAL NOT NULL DEFAULT 0;
+ALTER TABLE queues ADD COLUMN rate_updated_at INTEGER;
@@ -0,0 +1,17 @@
+-- recurring tasks, a task is enqueued in queue_name every time next_run_at is due
+CREATE TABLE schedules (
+    id TEXT PRIMARY KEY,
+    queue_name TEXT NOT NULL REFERENCES queues(name) ON DELETE CASCADE,
+    cron TEXT NOT NULL,
+    timezone TEXT NOT NULL DEFAULT '',
+    priority INTEGER NOT NULL DEFAULT 0,
+    data TEXT,
+    paused INTEGER NOT NULL DEFAULT 0,
+    next_run_at INTEGER NOT NULL,
+    last_run_at INTEGER,
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL
+);
+
+CREATE INDEX idx_schedules_due ON schedules(next_run_at) WHERE paused = 0;
+CREATE INDEX idx_schedules_queue_name ON schedules(queue_name);
@@ -0,0 +1,13 @@
+-- edges of task workflows, task_id stays blocked until every task it depends on completes
+CREATE TABLE task_dependencies (
+    task_id TEXT NOT NULL,
+    depends_on TEXT NOT NULL,
+    PRIMARY KEY (task_id, depends_on)
+);
+
+CREATE INDEX idx_task_dependencies_depends_on ON task_dependencies(depends_on);
+
+-- blocked tasks are active and hold their idempotency key as well
+DROP INDEX idx_tasks_idempotency_key;
+CREATE UNIQUE INDEX idx_tasks_idempotency_key ON tasks(queue_name, idempotency_key)
+    WHERE idempotency_key IS NOT NULL AND status IN ('pending', 'running', 'blocked');
@@ -0,0 +1,7 @@
+DROP INDEX idx_tasks_archive_batch_id;
+DROP INDEX idx_tasks_batch_id;
+
+ALTER TABLE tasks_archive DROP COLUMN batch_id;
+ALTER TABLE tasks DROP COLUMN batch_id;
+
+DROP TABLE batches;
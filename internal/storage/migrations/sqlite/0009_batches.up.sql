@@ -0,0 +1,17 @@
+-- groups of tasks created together, callback_queue receives a task once all of them finished
+CREATE TABLE batches (
+    id TEXT PRIMARY KEY,
+    callback_queue TEXT,
+    callback_data TEXT NOT NULL DEFAULT '{}',
+    callback_task_id TEXT,
+    total INTEGER NOT NULL,
+    finished_at INTEGER,
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL
+);
+
+ALTER TABLE tasks ADD COLUMN batch_id TEXT;
+ALTER TABLE tasks_archive ADD COLUMN batch_id TEXT;
+
+CREATE INDEX idx_tasks_batch_id ON tasks(batch_id) WHERE batch_id IS NOT NULL;
+CREATE INDEX idx_tasks_archive_batch_id ON tasks_archive(batch_id) WHERE batch_id IS NOT NULL;
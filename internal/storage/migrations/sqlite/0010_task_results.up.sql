@@ -0,0 +1,5 @@
+-- what a task produced, kept apart from its input data
+ALTER TABLE tasks ADD COLUMN result TEXT;
+ALTER TABLE tasks ADD COLUMN error TEXT;
+ALTER TABLE tasks_archive ADD COLUMN result TEXT;
+ALTER TABLE tasks_archive ADD COLUMN error TEXT;
@@ -0,0 +1,13 @@
+-- every claim of a task by a worker, finished_at is NULL while the attempt is running
+CREATE TABLE task_attempts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    task_id TEXT NOT NULL,
+    attempt INTEGER NOT NULL,
+    client_id TEXT NOT NULL,
+    outcome TEXT NOT NULL DEFAULT 'running',
+    error TEXT,
+    started_at INTEGER NOT NULL,
+    finished_at INTEGER
+);
+
+CREATE INDEX idx_task_attempts_task_id ON task_attempts(task_id);
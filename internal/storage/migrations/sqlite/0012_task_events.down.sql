@@ -0,0 +1,9 @@
+DROP TRIGGER task_events_no_delete;
+DROP TRIGGER task_events_no_update;
+
+DROP INDEX idx_task_events_created_at;
+DROP INDEX idx_task_events_actor;
+DROP INDEX idx_task_events_queue_name;
+DROP INDEX idx_task_events_task_id;
+
+DROP TABLE task_events;
@@ -0,0 +1,29 @@
+-- append-only audit log of the changes of tasks and who made them
+CREATE TABLE task_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    task_id TEXT NOT NULL,
+    queue_name TEXT NOT NULL,
+    type TEXT NOT NULL,
+    from_status TEXT,
+    to_status TEXT NOT NULL,
+    details TEXT,
+    actor_type TEXT NOT NULL,
+    actor TEXT NOT NULL DEFAULT '',
+    request_id TEXT NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL
+);
+
+CREATE INDEX idx_task_events_task_id ON task_events(task_id);
+CREATE INDEX idx_task_events_queue_name ON task_events(queue_name, created_at);
+CREATE INDEX idx_task_events_actor ON task_events(actor, created_at);
+CREATE INDEX idx_task_events_created_at ON task_events(created_at);
+
+CREATE TRIGGER task_events_no_update BEFORE UPDATE ON task_events
+BEGIN
+    SELECT RAISE(ABORT, 'task events are append-only');
+END;
+
+CREATE TRIGGER task_events_no_delete BEFORE DELETE ON task_events
+BEGIN
+    SELECT RAISE(ABORT, 'task events are append-only');
+END;
@@ -8,8 +8,25 @@ import (
 type Queue struct {
 	Name        string        `json:"name"`
 	TaskTimeout time.Duration `json:"task_timeout"`
-	CreatedAt   time.Time     `json:"created_at"`
-	UpdatedAt   time.Time     `json:"updated_at"`
+	RetryPolicy RetryPolicy   `json:"retry_policy"`
+	// DeadLetterQueue receives the tasks that exhaust their retries, empty keeps them failed in place
+	DeadLetterQueue string `json:"dead_letter_queue"`
+	// IdempotencyTTL keeps deduplicating finished tasks by idempotency key for this long after creation
+	IdempotencyTTL time.Duration `json:"idempotency_ttl"`
+	// Retention sets how long finished tasks are kept before they are purged
+	Retention RetentionPolicy `json:"retention"`
+	// ArchiveAfter moves finished tasks to the archive this long after their last update, 0 never archives
+	ArchiveAfter time.Duration `json:"archive_after"`
+	// MaxRunning limits how many tasks of the queue are running at once, 0 means no limit
+	MaxRunning int `json:"max_running"`
+	// Rate limits how many tasks per second are handed out, allowing bursts of Burst tasks.
+	// 0 means no limit.
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+	// Paused queues accept tasks but hand none out, it is only changed with SetQueuePaused
+	Paused    bool      `json:"paused"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 // TaskTimeoutSeconds is a helper method to convert the task timeout to seconds for database storage
@@ -17,32 +34,225 @@ func (q Queue) TaskTimeoutSeconds() int64 {
 	return int64(q.TaskTimeout.Seconds())
 }
 
+// IdempotencyTTLSeconds is a helper method to convert the idempotency TTL to seconds for database storage
+func (q Queue) IdempotencyTTLSeconds() int64 {
+	return int64(q.IdempotencyTTL.Seconds())
+}
+
+// ArchiveAfterSeconds is a helper method to convert the archive age to seconds for database storage
+func (q Queue) ArchiveAfterSeconds() int64 {
+	return int64(q.ArchiveAfter.Seconds())
+}
+
+// RetryPolicy describes how failed or timed out tasks of a queue are retried
+type RetryPolicy struct {
+	MaxAttempts int           `json:"max_attempts"` // total attempts allowed, 0 or 1 disables retries
+	BaseDelay   time.Duration `json:"base_delay"`   // delay before the first retry
+	Multiplier  float64       `json:"multiplier"`   // growth factor between retries (default 2)
+	MaxDelay    time.Duration `json:"max_delay"`    // upper bound for the delay, 0 means no bound
+	Jitter      float64       `json:"jitter"`       // random +/- fraction applied to the delay (0..1)
+}
+
+// RetentionPolicy sets how long finished tasks of a queue are kept after their last update,
+// by status. Zero keeps them forever.
+type RetentionPolicy struct {
+	Completed time.Duration `json:"completed"`
+	Failed    time.Duration `json:"failed"`
+	Deleted   time.Duration `json:"deleted"`
+}
+
 type Task struct {
-	ID          string          `json:"id"`
-	QueueName   string          `json:"queue_name"`
-	Status      string          `json:"status"`
-	Data        json.RawMessage `json:"data"`
-	AssignedTo  *string         `json:"assigned_to"`
-	CreatedAt   time.Time       `json:"created_at"`
-	UpdatedAt   time.Time       `json:"updated_at"`
-	StartedAt   *time.Time      `json:"started_at"`
-	CompletedAt *time.Time      `json:"completed_at"`
+	ID            string          `json:"id"`
+	QueueName     string          `json:"queue_name"`
+	Status        string          `json:"status"`
+	Priority      int             `json:"priority"` // higher priorities are handed out first
+	Data          json.RawMessage `json:"data"`
+	AssignedTo    *string         `json:"assigned_to"`
+	Attempt       int             `json:"attempt"`
+	NextAttemptAt *time.Time      `json:"next_attempt_at"`
+	OriginalQueue *string         `json:"original_queue"` // queue a dead-lettered task came from
+	RunAt         *time.Time      `json:"run_at"`         // task is not handed out before this time
+	// IdempotencyKey deduplicates task creation while a task with the same key is active
+	IdempotencyKey *string `json:"idempotency_key"`
+	// BatchID is the batch the task was created in, if any
+	BatchID   *string    `json:"batch_id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	StartedAt *time.Time `json:"started_at"`
+	// LeaseExpiresAt is when a running task is considered abandoned unless its worker sends a heartbeat
+	LeaseExpiresAt *time.Time `json:"lease_expires_at"`
+	CompletedAt    *time.Time `json:"completed_at"`
+	// DependsOn are the tasks that must complete before this one is handed out. It is set
+	// on creation and only loaded by GetTask and GetTaskGraph.
+	DependsOn []string `json:"depends_on,omitempty"`
+	// Result is what the worker reported when finishing the task, Data is never changed
+	Result json.RawMessage `json:"result"`
+	// Error is why the last attempt of the task failed
+	Error *TaskError `json:"error"`
+}
+
+// TaskError describes the failure of a task
+type TaskError struct {
+	Message string `json:"message"`
+	// Type classifies the failure, e.g. "timeout" or the error type of the worker
+	Type  string `json:"type,omitempty"`
+	Stack string `json:"stack,omitempty"`
+	// Details is any JSON the worker attached to the error
+	Details json.RawMessage `json:"details,omitempty"`
+}
+
+// TaskAttempt is one claim of a task by a worker, from the moment it was handed out until the
+// worker reported its result, its lease expired or the task was changed by someone else
+type TaskAttempt struct {
+	TaskID   string `json:"task_id"`
+	Attempt  int    `json:"attempt"`
+	ClientID string `json:"client_id"`
+	// Outcome is one of the Attempt* constants
+	Outcome    string     `json:"outcome"`
+	Error      *TaskError `json:"error"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	// Duration is how long the attempt ran, nil while it is running
+	Duration *time.Duration `json:"duration"`
+}
+
+// TaskEvent is an entry of the append-only audit log of tasks, recorded in the same transaction
+// as the change it describes
+type TaskEvent struct {
+	ID        int64  `json:"id"`
+	TaskID    string `json:"task_id"`
+	QueueName string `json:"queue_name"`
+	// Type is one of the Event* constants
+	Type       string  `json:"type"`
+	FromStatus *string `json:"from_status"`
+	ToStatus   string  `json:"to_status"`
+	// Details holds what changed besides the status, e.g. the error of a failed task
+	Details json.RawMessage `json:"details"`
+	Actor
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Actor is who caused a change, with the request it was made in
+type Actor struct {
+	// Type is one of the Actor* constants
+	Type      string `json:"actor_type"`
+	Name      string `json:"actor"`
+	RequestID string `json:"request_id"`
+}
+
+// TaskEventFilter selects task events, empty fields match every event
+type TaskEventFilter struct {
+	TaskID    string
+	QueueName string
+	ActorType string
+	Actor     string
+	FromDate  time.Time
+	ToDate    time.Time
+	Offset    int
+	Limit     int
+}
+
+// TaskDependency is an edge of a workflow, TaskID is blocked until DependsOn completes
+type TaskDependency struct {
+	TaskID    string `json:"task_id"`
+	DependsOn string `json:"depends_on"`
+}
+
+// TaskGraph is the workflow a task belongs to, every task connected to it by dependencies
+type TaskGraph struct {
+	Tasks []Task           `json:"tasks"`
+	Edges []TaskDependency `json:"edges"`
+}
+
+// Batch is a group of tasks created together. It finishes once none of its tasks is pending,
+// blocked or running, enqueueing a task in CallbackQueue if set.
+type Batch struct {
+	ID            string          `json:"id"`
+	CallbackQueue *string         `json:"callback_queue"`
+	CallbackData  json.RawMessage `json:"callback_data"`
+	// CallbackTaskID is the task enqueued when the batch finished, nil when the callback
+	// queue no longer existed
+	CallbackTaskID *string `json:"callback_task_id"`
+	Total          int     `json:"total"`
+	// Counts are the tasks of the batch by status, archived tasks included
+	Counts     map[string]int `json:"counts"`
+	FinishedAt *time.Time     `json:"finished_at"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+}
+
+// Schedule enqueues a task in QueueName every time its cron expression fires
+type Schedule struct {
+	ID        string `json:"id"`
+	QueueName string `json:"queue_name"`
+	// Cron is a five field cron expression or a descriptor such as @hourly or @every 1h30m
+	Cron string `json:"cron"`
+	// Timezone is the IANA time zone Cron is evaluated in, UTC when empty
+	Timezone string `json:"timezone"`
+	// Priority and Data are copied to every task the schedule creates
+	Priority int             `json:"priority"`
+	Data     json.RawMessage `json:"data"`
+	// Paused schedules create no tasks, it is only changed with SetSchedulePaused
+	Paused    bool       `json:"paused"`
+	NextRunAt time.Time  `json:"next_run_at"`
+	LastRunAt *time.Time `json:"last_run_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
 }
 
 type TaskFilter struct {
 	QueueName string
 	Status    string
+	Priority  *int
+	BatchID   string
 	FromDate  time.Time
 	ToDate    time.Time
 	SortBy    string
 	Offset    int
 	Limit     int
+	// IncludeArchived also searches the tasks moved to the archive
+	IncludeArchived bool
 }
 
+// Modes of DeleteQueue, telling what happens to the pending and running tasks of the queue
+const (
+	DeleteQueueRestrict = "restrict" // the queue is not deleted
+	DeleteQueueCascade  = "cascade"  // the tasks are soft-deleted
+	DeleteQueueMove     = "move"     // every task is moved to another queue
+)
+
 const (
 	TaskStatusPending   = "pending"
+	TaskStatusBlocked   = "blocked" // waiting for the tasks it depends on
 	TaskStatusRunning   = "running"
 	TaskStatusCompleted = "completed"
 	TaskStatusFailed    = "failed"
 	TaskStatusDeleted   = "deleted"
 )
+
+// Outcomes of a task attempt
+const (
+	AttemptRunning   = "running"
+	AttemptCompleted = "completed"
+	AttemptFailed    = "failed"    // the worker reported a failure
+	AttemptTimeout   = "timeout"   // the lease expired
+	AttemptCancelled = "cancelled" // the task was requeued or deleted while running
+)
+
+// Types of task events
+const (
+	EventCreated       = "created"
+	EventStatusChanged = "status_changed"
+	EventRequeued      = "requeued" // the task went back to pending to be handed out again
+	EventUpdated       = "updated"  // the status did not change, see the details
+	EventDeleted       = "deleted"
+)
+
+// Types of actors
+const (
+	ActorClient    = "client"    // identified by its X-Client-ID
+	ActorAPIKey    = "api_key"   // identified by a fingerprint of its bearer token
+	ActorUser      = "user"      // the basic auth user, e.g. of a proxy in front of the dashboard
+	ActorAnonymous = "anonymous" // an API request without credentials
+	ActorSystem    = "system"    // the server itself, e.g. timeouts and schedules
+)
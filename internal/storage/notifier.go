@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// NotifyChannel is the Postgres channel where the store announces queues with new pending tasks
+const NotifyChannel = "jobqueue_tasks"
+
+// Notifier wakes up consumers waiting for tasks of a queue
+type Notifier interface {
+	// Subscribe returns a channel that receives a value when tasks may have become
+	// available in the queue, and a function to cancel the subscription
+	Subscribe(queueName string) (<-chan struct{}, func())
+	Close() error
+}
+
+// broadcaster keeps the subscriptions of a Notifier
+type broadcaster struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan struct{}]struct{}
+}
+
+func (b *broadcaster) Subscribe(queueName string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	b.mu.Lock()
+	if b.subscribers == nil {
+		b.subscribers = make(map[string]map[chan struct{}]struct{})
+	}
+	if b.subscribers[queueName] == nil {
+		b.subscribers[queueName] = make(map[chan struct{}]struct{})
+	}
+	b.subscribers[queueName][ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subscribers[queueName], ch)
+		if len(b.subscribers[queueName]) == 0 {
+			delete(b.subscribers, queueName)
+		}
+		b.mu.Unlock()
+	}
+}
+
+// wake signals the subscribers of a queue
+func (b *broadcaster) wake(queueName string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers[queueName] {
+		signal(ch)
+	}
+}
+
+// wakeAll signals every subscriber
+func (b *broadcaster) wakeAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, subscribers := range b.subscribers {
+		for ch := range subscribers {
+			signal(ch)
+		}
+	}
+}
+
+// signal sends a value to ch unless a previous one is still pending
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+type pgNotifier struct {
+	broadcaster
+	listener *pq.Listener
+	done     chan struct{}
+}
+
+// NewNotifier listens on NotifyChannel using a dedicated connection to the database
+func NewNotifier(dataSourceName string) (Notifier, error) {
+	listener := pq.NewListener(dataSourceName, 10*time.Second, time.Minute,
+		func(event pq.ListenerEventType, err error) {
+			if err != nil {
+				log.Printf("Task notification listener: %v", err)
+			}
+		})
+	if err := listener.Listen(NotifyChannel); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("error listening for task notifications: %w", err)
+	}
+
+	n := &pgNotifier{
+		listener: listener,
+		done:     make(chan struct{}),
+	}
+	go n.run()
+	return n, nil
+}
+
+func (n *pgNotifier) Close() error {
+	close(n.done)
+	return n.listener.Close()
+}
+
+func (n *pgNotifier) run() {
+	for {
+		select {
+		case <-n.done:
+			return
+		case notification := <-n.listener.Notify:
+			// a nil notification means the connection was re-established and
+			// notifications may have been lost, so every subscriber is woken up
+			if notification == nil {
+				n.wakeAll()
+				continue
+			}
+			n.wake(notification.Extra)
+		case <-time.After(90 * time.Second):
+			go n.listener.Ping()
+		}
+	}
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// notifyQueue announces that the queue may have new pending tasks. Inside a transaction
+// the notification is delivered on commit.
+func notifyQueue(ctx context.Context, e execer, queueName string) error {
+	if _, err := e.ExecContext(ctx, "SELECT pg_notify($1, $2)", NotifyChannel, queueName); err != nil {
+		return fmt.Errorf("error notifying queue: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// RateLimitError is returned by ClaimTasks when the dispatch rate of the queue hands out no
+// task until RetryAfter
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("queue rate limit exceeded, retry after %v", e.RetryAfter)
+}
+
+// tokenBucket is the dispatch rate state of a queue, earning rate tokens per second up to burst
+type tokenBucket struct {
+	rate   float64
+	burst  int
+	tokens float64
+}
+
+// refill adds the tokens earned during elapsed, nil meaning the bucket was never used
+func (b *tokenBucket) refill(elapsed *time.Duration) {
+	if elapsed == nil {
+		b.tokens = float64(b.burst)
+		return
+	}
+	b.tokens = math.Min(float64(b.burst), b.tokens+elapsed.Seconds()*b.rate)
+}
+
+// take reduces n to the whole tokens available and spends them, failing with a RateLimitError
+// when there are none
+func (b *tokenBucket) take(n int) (int, error) {
+	available := int(b.tokens)
+	if available < 1 {
+		wait := (1 - b.tokens) / b.rate * float64(time.Second)
+		return 0, &RateLimitError{RetryAfter: time.Duration(math.Ceil(wait))}
+	}
+	n = min(n, available)
+	b.tokens -= float64(n)
+	return n, nil
+}
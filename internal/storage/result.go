@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// Value stores a task error as JSON
+func (e TaskError) Value() (driver.Value, error) {
+	return json.Marshal(e)
+}
+
+// Scan reads a task error stored as JSON
+func (e *TaskError) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case []byte:
+		return json.Unmarshal(src, e)
+	case string:
+		return json.Unmarshal([]byte(src), e)
+	}
+	return fmt.Errorf("cannot scan %T into a task error", src)
+}
+
+// nullJSON scans a nullable JSON column, which is nil when NULL
+type nullJSON struct{ data *json.RawMessage }
+
+func (n nullJSON) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		*n.data = nil
+	case []byte:
+		*n.data = append(json.RawMessage(nil), src...)
+	case string:
+		*n.data = json.RawMessage(src)
+	default:
+		return fmt.Errorf("cannot scan %T into JSON", src)
+	}
+	return nil
+}
+
+// nullJSONValue stores empty JSON as NULL
+func nullJSONValue(data json.RawMessage) interface{} {
+	if len(data) == 0 {
+		return nil
+	}
+	return []byte(data)
+}
+
+// defaultData gives tasks created without data an empty object, which is what they read back
+func defaultData(task *Task) {
+	if len(task.Data) == 0 {
+		task.Data = json.RawMessage(`{}`)
+	}
+}
+
+// timeoutError is the error of a task whose lease expired
+func timeoutError() *TaskError {
+	return &TaskError{Message: "Task timeout exceeded", Type: "timeout"}
+}
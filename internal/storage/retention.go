@@ -0,0 +1,37 @@
+package storage
+
+import "time"
+
+// Retention returns how long finished tasks with the given status are kept, 0 keeps them forever
+func (r RetentionPolicy) Retention(status string) time.Duration {
+	switch status {
+	case TaskStatusCompleted:
+		return r.Completed
+	case TaskStatusFailed:
+		return r.Failed
+	case TaskStatusDeleted:
+		return r.Deleted
+	}
+	return 0
+}
+
+// CompletedSeconds is a helper method to convert the completed retention to seconds for database storage
+func (r RetentionPolicy) CompletedSeconds() int64 {
+	return int64(r.Completed.Seconds())
+}
+
+// FailedSeconds is a helper method to convert the failed retention to seconds for database storage
+func (r RetentionPolicy) FailedSeconds() int64 {
+	return int64(r.Failed.Seconds())
+}
+
+// DeletedSeconds is a helper method to convert the deleted retention to seconds for database storage
+func (r RetentionPolicy) DeletedSeconds() int64 {
+	return int64(r.Deleted.Seconds())
+}
+
+// isPurgeable reports whether a finished task has outlived the retention of its queue at asOf
+func (r RetentionPolicy) isPurgeable(task *Task, asOf time.Time) bool {
+	retention := r.Retention(task.Status)
+	return retention > 0 && task.UpdatedAt.Before(asOf.Add(-retention))
+}
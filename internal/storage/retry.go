@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+const defaultRetryMultiplier = 2
+
+// Backoff returns the delay to wait before retrying a task whose attempt number
+// (starting at 1) has just failed
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = defaultRetryMultiplier
+	}
+
+	delay := float64(p.BaseDelay) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	}
+
+	if delay < 0 || math.IsInf(delay, 0) || math.IsNaN(delay) {
+		return 0
+	}
+	if delay > math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(delay)
+}
+
+// ScheduleRetry moves a failed task back to pending when the policy still allows
+// another attempt. It returns false when retries are exhausted, leaving the task untouched.
+func (p RetryPolicy) ScheduleRetry(task *Task, now time.Time) bool {
+	if task.Attempt >= p.MaxAttempts {
+		return false
+	}
+
+	next := now.Add(p.Backoff(task.Attempt))
+	task.Status = TaskStatusPending
+	task.AssignedTo = nil
+	task.StartedAt = nil
+	task.CompletedAt = nil
+	task.LeaseExpiresAt = nil
+	task.NextAttemptAt = &next
+	return true
+}
+
+// BaseDelayMillis is a helper method to convert the base delay to milliseconds for database storage
+func (p RetryPolicy) BaseDelayMillis() int64 {
+	return p.BaseDelay.Milliseconds()
+}
+
+// MaxDelayMillis is a helper method to convert the max delay to milliseconds for database storage
+func (p RetryPolicy) MaxDelayMillis() int64 {
+	return p.MaxDelay.Milliseconds()
+}
+
+// DeadLetter moves a permanently failed task into the dead-letter queue configured
+// for q, remembering the queue it originally belonged to. It returns false when
+// the queue has no dead-letter queue.
+func (q Queue) DeadLetter(task *Task) bool {
+	if q.DeadLetterQueue == "" || q.DeadLetterQueue == task.QueueName {
+		return false
+	}
+
+	if task.OriginalQueue == nil {
+		origin := task.QueueName
+		task.OriginalQueue = &origin
+	}
+	task.QueueName = q.DeadLetterQueue
+	return true
+}
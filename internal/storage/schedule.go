@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	// schedules name their time zone, which must not depend on the zoneinfo of the host
+	_ "time/tzdata"
+
+	"github.com/robfig/cron/v3"
+	"github.com/rs/xid"
+)
+
+// ErrScheduleNotFound is returned when acting on a schedule that does not exist
+var ErrScheduleNotFound = errors.New("schedule not found")
+
+var cronParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// Next returns the first time after t the schedule fires, failing when its cron expression or
+// time zone is invalid
+func (s Schedule) Next(t time.Time) (time.Time, error) {
+	location := time.UTC
+	if s.Timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(s.Timezone); err != nil {
+			return time.Time{}, fmt.Errorf("invalid timezone %s: %w", s.Timezone, err)
+		}
+	}
+
+	spec, err := cronParser.Parse(s.Cron)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid cron expression %s: %w", s.Cron, err)
+	}
+	next := spec.Next(t.In(location))
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("cron expression %s never fires", s.Cron)
+	}
+	return next.UTC(), nil
+}
+
+// newTask is the task enqueued by a run of the schedule
+func (s Schedule) newTask() Task {
+	return Task{
+		ID:        xid.New().String(),
+		QueueName: s.QueueName,
+		Status:    TaskStatusPending,
+		Priority:  s.Priority,
+		Data:      s.Data,
+	}
+}
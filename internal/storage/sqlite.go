@@ -0,0 +1,1935 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SQLiteStore is a Store backed by a SQLite database, for single node deployments. Claims
+// rely on SQLite allowing a single writer at a time instead of row locks. As every writer
+// lives in this process, it also implements Notifier, waking up waiting claims directly.
+type SQLiteStore struct {
+	broadcaster
+
+	db *sql.DB
+}
+
+var (
+	_ Store    = (*SQLiteStore)(nil)
+	_ Notifier = (*SQLiteStore)(nil)
+)
+
+// NewSQLiteStore returns a store using a database opened with NewSQLiteDB
+func NewSQLiteStore(db *sql.DB) *SQLiteStore {
+	return &SQLiteStore{db: db}
+}
+
+// Close releases nothing, the database is closed by its owner
+func (s *SQLiteStore) Close() error {
+	return nil
+}
+
+// unixTime scans the unix nanoseconds SQLite stores for a timestamp
+type unixTime struct{ t *time.Time }
+
+func (u unixTime) Scan(src interface{}) error {
+	n, ok := src.(int64)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into a timestamp", src)
+	}
+	*u.t = time.Unix(0, n).UTC()
+	return nil
+}
+
+// nullUnixTime scans a nullable timestamp, see unixTime
+type nullUnixTime struct{ t **time.Time }
+
+func (u nullUnixTime) Scan(src interface{}) error {
+	if src == nil {
+		*u.t = nil
+		return nil
+	}
+	var t time.Time
+	if err := (unixTime{&t}).Scan(src); err != nil {
+		return err
+	}
+	*u.t = &t
+	return nil
+}
+
+// nullUnixNano converts a nullable timestamp for storage
+func nullUnixNano(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UnixNano()
+}
+
+func scanSQLiteQueue(row rowScanner, queue *Queue) error {
+	var timeoutSeconds, baseDelayMs, maxDelayMs, idempotencyTTLSeconds int64
+	var completedSeconds, failedSeconds, deletedSeconds, archiveAfterSeconds int64
+	err := row.Scan(
+		&queue.Name,
+		&timeoutSeconds,
+		&queue.RetryPolicy.MaxAttempts,
+		&baseDelayMs,
+		&queue.RetryPolicy.Multiplier,
+		&maxDelayMs,
+		&queue.RetryPolicy.Jitter,
+		&queue.DeadLetterQueue,
+		&idempotencyTTLSeconds,
+		&completedSeconds,
+		&failedSeconds,
+		&deletedSeconds,
+		&archiveAfterSeconds,
+		&queue.MaxRunning,
+		&queue.Rate,
+		&queue.Burst,
+		&queue.Paused,
+		unixTime{&queue.CreatedAt},
+		unixTime{&queue.UpdatedAt},
+	)
+	if err != nil {
+		return err
+	}
+
+	queue.TaskTimeout = time.Duration(timeoutSeconds) * time.Second
+	queue.RetryPolicy.BaseDelay = time.Duration(baseDelayMs) * time.Millisecond
+	queue.RetryPolicy.MaxDelay = time.Duration(maxDelayMs) * time.Millisecond
+	queue.IdempotencyTTL = time.Duration(idempotencyTTLSeconds) * time.Second
+	queue.Retention.Completed = time.Duration(completedSeconds) * time.Second
+	queue.Retention.Failed = time.Duration(failedSeconds) * time.Second
+	queue.Retention.Deleted = time.Duration(deletedSeconds) * time.Second
+	queue.ArchiveAfter = time.Duration(archiveAfterSeconds) * time.Second
+	return nil
+}
+
+func scanSQLiteTask(row rowScanner, task *Task) error {
+	return row.Scan(
+		&task.ID, &task.QueueName, &task.Status, &task.Priority, nullJSON{&task.Data}, &task.AssignedTo,
+		&task.Attempt, nullUnixTime{&task.NextAttemptAt}, &task.OriginalQueue, nullUnixTime{&task.RunAt},
+		&task.IdempotencyKey, unixTime{&task.CreatedAt}, unixTime{&task.UpdatedAt},
+		nullUnixTime{&task.StartedAt}, nullUnixTime{&task.CompletedAt}, nullUnixTime{&task.LeaseExpiresAt},
+		&task.BatchID, nullJSON{&task.Result}, &task.Error,
+	)
+}
+
+func (s *SQLiteStore) CreateOrUpdateQueue(ctx context.Context, queue *Queue) error {
+	query := `
+		INSERT INTO queues (name, task_timeout, retry_max_attempts, retry_base_delay_ms,
+			retry_multiplier, retry_max_delay_ms, retry_jitter, dead_letter_queue, idempotency_ttl,
+			retention_completed, retention_failed, retention_deleted, archive_after, max_running,
+			rate, burst, created_at, updated_at)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, NULLIF(?8, ''), ?9, ?10, ?11, ?12, ?13, ?14, ?15, ?16, ?17, ?17)
+		ON CONFLICT (name)
+		DO UPDATE SET
+			task_timeout = excluded.task_timeout,
+			retry_max_attempts = excluded.retry_max_attempts,
+			retry_base_delay_ms = excluded.retry_base_delay_ms,
+			retry_multiplier = excluded.retry_multiplier,
+			retry_max_delay_ms = excluded.retry_max_delay_ms,
+			retry_jitter = excluded.retry_jitter,
+			dead_letter_queue = excluded.dead_letter_queue,
+			idempotency_ttl = excluded.idempotency_ttl,
+			retention_completed = excluded.retention_completed,
+			retention_failed = excluded.retention_failed,
+			retention_deleted = excluded.retention_deleted,
+			archive_after = excluded.archive_after,
+			max_running = excluded.max_running,
+			rate = excluded.rate,
+			burst = excluded.burst,
+			updated_at = excluded.updated_at
+		RETURNING paused, created_at, updated_at`
+
+	policy, retention := queue.RetryPolicy, queue.Retention
+	return s.db.QueryRowContext(ctx, query, queue.Name, queue.TaskTimeoutSeconds(),
+		policy.MaxAttempts, policy.BaseDelayMillis(), policy.Multiplier, policy.MaxDelayMillis(), policy.Jitter,
+		queue.DeadLetterQueue, queue.IdempotencyTTLSeconds(),
+		retention.CompletedSeconds(), retention.FailedSeconds(), retention.DeletedSeconds(),
+		queue.ArchiveAfterSeconds(), queue.MaxRunning, queue.Rate, queue.Burst, time.Now().UnixNano()).
+		Scan(&queue.Paused, unixTime{&queue.CreatedAt}, unixTime{&queue.UpdatedAt})
+}
+
+func (s *SQLiteStore) GetQueue(ctx context.Context, name string) (*Queue, error) {
+	var queue Queue
+	err := scanSQLiteQueue(s.db.QueryRowContext(ctx, `
+        SELECT `+queueColumns+`
+        FROM queues
+        WHERE name = ?`,
+		name,
+	), &queue)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting queue: %w", err)
+	}
+
+	return &queue, nil
+}
+
+func (s *SQLiteStore) GetQueues(ctx context.Context) ([]Queue, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT `+queueColumns+`
+        FROM queues
+        ORDER BY name ASC`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying queues: %w", err)
+	}
+	defer rows.Close()
+
+	var queues []Queue
+	for rows.Next() {
+		var queue Queue
+		if err := scanSQLiteQueue(rows, &queue); err != nil {
+			return nil, fmt.Errorf("error scanning queue: %w", err)
+		}
+		queues = append(queues, queue)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating queues: %w", err)
+	}
+
+	return queues, nil
+}
+
+// SetQueuePaused pauses or resumes handing out the tasks of a queue, see store.SetQueuePaused
+func (s *SQLiteStore) SetQueuePaused(ctx context.Context, name string, paused bool) error {
+	result, err := s.db.ExecContext(ctx, `UPDATE queues SET paused = ?, updated_at = ? WHERE name = ?`,
+		paused, time.Now().UnixNano(), name)
+	if err != nil {
+		return fmt.Errorf("error updating queue: %w", err)
+	}
+	if updated, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("error updating queue: %w", err)
+	} else if updated == 0 {
+		return ErrQueueNotFound
+	}
+	if !paused {
+		s.wake(name)
+	}
+	return nil
+}
+
+// DeleteQueue removes a queue, see store.DeleteQueue
+func (s *SQLiteStore) DeleteQueue(ctx context.Context, name, mode, target string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var count int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM queues WHERE name = ?`, name).Scan(&count); err != nil {
+		return fmt.Errorf("error checking queue: %w", err)
+	}
+	if count == 0 {
+		return ErrQueueNotFound
+	}
+
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM queues WHERE dead_letter_queue = ?`, name).Scan(&count); err != nil {
+		return fmt.Errorf("error checking dependent queues: %w", err)
+	}
+	if count > 0 {
+		return ErrQueueInUse
+	}
+
+	now := time.Now().UnixNano()
+	notify := make(map[string]bool)
+	switch mode {
+	case DeleteQueueRestrict:
+		if err := tx.QueryRowContext(ctx, `
+            SELECT COUNT(*) FROM tasks WHERE queue_name = ? AND status IN ('pending', 'running', 'blocked')`,
+			name).Scan(&count); err != nil {
+			return fmt.Errorf("error counting active tasks: %w", err)
+		}
+		if count > 0 {
+			return ErrQueueNotEmpty
+		}
+	case DeleteQueueCascade:
+		previous, err := sqliteTaskStates(ctx, tx, "queue_name = ? AND status IN ('pending', 'running', 'blocked')", name)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+            UPDATE tasks SET status = 'deleted', updated_at = ?
+            WHERE queue_name = ? AND status IN ('pending', 'running', 'blocked')`, now, name); err != nil {
+			return fmt.Errorf("error deleting tasks: %w", err)
+		}
+		deleted := make([]string, len(previous))
+		for i := range previous {
+			deleted[i] = previous[i].ID
+		}
+		if err := insertSQLiteEvents(ctx, tx, deleteEvents(ctx, previous)...); err != nil {
+			return err
+		}
+		if err := finishSQLiteAttempts(ctx, tx, AttemptCancelled, nil, deleted...); err != nil {
+			return err
+		}
+		if err := settleSQLiteFinished(ctx, tx, notify, deleted...); err != nil {
+			return err
+		}
+	case DeleteQueueMove:
+	default:
+		return fmt.Errorf("unknown delete mode %s", mode)
+	}
+
+	statements, args := movedReferences("?1", "?2"), []interface{}{name, target}
+	if mode != DeleteQueueMove {
+		statements = []string{`
+            INSERT INTO tasks_archive (` + taskColumns + `, archived_at)
+            SELECT ` + taskColumns + `, ?2 FROM tasks WHERE queue_name = ?1`,
+			`DELETE FROM tasks WHERE queue_name = ?1`,
+			`UPDATE tasks SET original_queue = NULL WHERE original_queue = ?1`,
+		}
+		args = []interface{}{name, now}
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement, args...); err != nil {
+			return fmt.Errorf("error moving tasks: %w", err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM queues WHERE name = ?`, name); err != nil {
+		return fmt.Errorf("error deleting queue: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	if mode == DeleteQueueMove {
+		notify[target] = true
+	}
+	for queueName := range notify {
+		s.wake(queueName)
+	}
+	return nil
+}
+
+// RenameQueue moves a queue, its tasks, dead letters and schedules to newName, see store.RenameQueue
+func (s *SQLiteStore) RenameQueue(ctx context.Context, name, newName string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+        INSERT INTO queues (name, `+queueSettingColumns+`, updated_at)
+        SELECT ?2, `+queueSettingColumns+`, ?3
+        FROM queues
+        WHERE name = ?1
+        ON CONFLICT (name) DO NOTHING`,
+		name, newName, time.Now().UnixNano())
+	if err != nil {
+		return fmt.Errorf("error copying queue: %w", err)
+	}
+	if copied, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("error copying queue: %w", err)
+	} else if copied == 0 {
+		var count int
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM queues WHERE name = ?`, name).Scan(&count); err != nil {
+			return fmt.Errorf("error checking queue: %w", err)
+		}
+		if count == 0 {
+			return ErrQueueNotFound
+		}
+		return ErrQueueExists
+	}
+
+	statements := append(movedReferences("?1", "?2"),
+		`UPDATE queues SET dead_letter_queue = ?2 WHERE dead_letter_queue = ?1`)
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement, name, newName); err != nil {
+			return fmt.Errorf("error renaming queue: %w", err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM queues WHERE name = ?`, name); err != nil {
+		return fmt.Errorf("error deleting queue: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	s.wake(newName)
+	return nil
+}
+
+func (s *SQLiteStore) CreateTask(ctx context.Context, task *Task) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := insertSQLiteDependentTask(ctx, tx, task); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	s.wake(task.QueueName)
+	return nil
+}
+
+// sqliteIn returns the placeholders and arguments of an IN list of ids, which must not be empty
+func sqliteIn(ids []string) (string, []interface{}) {
+	args := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	return "(?" + strings.Repeat(", ?", len(ids)-1) + ")", args
+}
+
+// insertSQLiteDependentTask inserts a task and its dependencies, taking its status from its
+// parents. Holding the write lock, no parent can finish before the task is committed.
+func insertSQLiteDependentTask(ctx context.Context, tx *sql.Tx, task *Task) error {
+	if len(task.DependsOn) == 0 {
+		return insertSQLiteTask(ctx, tx, task)
+	}
+
+	in, args := sqliteIn(task.DependsOn)
+	rows, err := tx.QueryContext(ctx, `
+        SELECT id, status FROM tasks WHERE id IN `+in+`
+        UNION ALL
+        SELECT id, status FROM tasks_archive WHERE id IN `+in, append(args, args...)...)
+	if err != nil {
+		return fmt.Errorf("error checking dependencies: %w", err)
+	}
+	parents := make(map[string]string, len(task.DependsOn))
+	for rows.Next() {
+		var id, status string
+		if err := rows.Scan(&id, &status); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning dependency: %w", err)
+		}
+		parents[id] = status
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating dependencies: %w", err)
+	}
+	for _, id := range task.DependsOn {
+		if _, ok := parents[id]; !ok {
+			return fmt.Errorf("%w: %s", ErrDependencyNotFound, id)
+		}
+	}
+
+	resolveDependencies(task, parents)
+	if err := insertSQLiteTask(ctx, tx, task); err != nil {
+		return err
+	}
+	for _, parent := range task.DependsOn {
+		if _, err := tx.ExecContext(ctx, `
+            INSERT INTO task_dependencies (task_id, depends_on) VALUES (?, ?)`, task.ID, parent); err != nil {
+			return fmt.Errorf("error inserting dependencies: %w", err)
+		}
+	}
+	return nil
+}
+
+// settleSQLiteFinished finishes batches and resolves blocked tasks as settleFinished does, adding
+// the queues of the tasks it unblocks or enqueues to notify so they are woken after commit
+func settleSQLiteFinished(ctx context.Context, tx *sql.Tx, notify map[string]bool, parentIDs ...string) error {
+	for len(parentIDs) > 0 {
+		if err := finishSQLiteBatches(ctx, tx, notify, parentIDs); err != nil {
+			return err
+		}
+
+		in, args := sqliteIn(parentIDs)
+		rows, err := tx.QueryContext(ctx, `
+            SELECT id, queue_name
+            FROM tasks
+            WHERE status = 'blocked'
+                AND id IN (SELECT task_id FROM task_dependencies WHERE depends_on IN `+in+`)
+            ORDER BY id`, args...)
+		if err != nil {
+			return fmt.Errorf("error finding dependent tasks: %w", err)
+		}
+		var blocked []Task
+		for rows.Next() {
+			var task Task
+			if err := rows.Scan(&task.ID, &task.QueueName); err != nil {
+				rows.Close()
+				return fmt.Errorf("error scanning dependent task: %w", err)
+			}
+			blocked = append(blocked, task)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("error iterating dependent tasks: %w", err)
+		}
+
+		parentIDs = nil
+		for i := range blocked {
+			task := &blocked[i]
+			parents, err := sqliteDependencyStatuses(ctx, tx, task.ID)
+			if err != nil {
+				return err
+			}
+			if !resolveDependencies(task, parents) {
+				continue
+			}
+
+			if _, err := tx.ExecContext(ctx, `
+                UPDATE tasks SET status = ?, error = ?, updated_at = ? WHERE id = ?`,
+				task.Status, task.Error, time.Now().UnixNano(), task.ID); err != nil {
+				return fmt.Errorf("error settling dependent task: %w", err)
+			}
+			previous := Task{Status: TaskStatusBlocked, QueueName: task.QueueName}
+			if err := insertSQLiteEvents(ctx, tx, newTaskEvent(ctx, task, &previous)); err != nil {
+				return err
+			}
+			if task.Status == TaskStatusPending {
+				notify[task.QueueName] = true
+			} else {
+				parentIDs = append(parentIDs, task.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// sqliteDependencyStatuses returns the statuses of the tasks, archived or not, a task depends on
+func sqliteDependencyStatuses(ctx context.Context, tx *sql.Tx, id string) (map[string]string, error) {
+	rows, err := tx.QueryContext(ctx, `
+        SELECT d.depends_on, COALESCE(t.status, a.status)
+        FROM task_dependencies d
+        LEFT JOIN tasks t ON t.id = d.depends_on
+        LEFT JOIN tasks_archive a ON a.id = d.depends_on
+        WHERE d.task_id = ? AND (t.id IS NOT NULL OR a.id IS NOT NULL)`, id)
+	if err != nil {
+		return nil, fmt.Errorf("error loading dependencies: %w", err)
+	}
+	defer rows.Close()
+
+	statuses := make(map[string]string)
+	for rows.Next() {
+		var parent, status string
+		if err := rows.Scan(&parent, &status); err != nil {
+			return nil, fmt.Errorf("error scanning dependency: %w", err)
+		}
+		statuses[parent] = status
+	}
+	return statuses, rows.Err()
+}
+
+func insertSQLiteTask(ctx context.Context, e execer, task *Task) error {
+	defaultData(task)
+	now := time.Now()
+	_, err := e.ExecContext(ctx, `
+		INSERT INTO tasks (id, queue_name, status, priority, data, run_at, idempotency_key, batch_id, created_at, updated_at)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?9)`,
+		task.ID, task.QueueName, task.Status, task.Priority, task.Data, nullUnixNano(task.RunAt),
+		task.IdempotencyKey, task.BatchID, now.UnixNano())
+	if err != nil {
+		return err
+	}
+
+	task.CreatedAt = time.Unix(0, now.UnixNano()).UTC()
+	task.UpdatedAt = task.CreatedAt
+	return insertSQLiteEvents(ctx, e, newTaskEvent(ctx, task, nil))
+}
+
+// findSQLiteByIdempotencyKey returns the task holding the idempotency key of task, if any
+func findSQLiteByIdempotencyKey(ctx context.Context, tx *sql.Tx, task *Task, ttl time.Duration) (*Task, error) {
+	var existing Task
+	err := scanSQLiteTask(tx.QueryRowContext(ctx, `
+        SELECT `+taskColumns+`
+        FROM tasks
+        WHERE queue_name = ? AND idempotency_key = ? AND status <> ?
+            AND (status IN (?, ?, ?) OR created_at >= ?)
+        ORDER BY created_at DESC
+        LIMIT 1`,
+		task.QueueName, *task.IdempotencyKey, TaskStatusDeleted,
+		TaskStatusPending, TaskStatusRunning, TaskStatusBlocked, time.Now().Add(-ttl).UnixNano(),
+	), &existing)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error checking idempotency key: %w", err)
+	}
+	return &existing, nil
+}
+
+// CreateUniqueTask follows the rules of the Postgres store. Transactions take the write lock
+// when they begin, so no other creation can run between the check and the insert.
+func (s *SQLiteStore) CreateUniqueTask(ctx context.Context, task *Task, ttl time.Duration) (bool, error) {
+	if task.IdempotencyKey == nil {
+		return true, s.CreateTask(ctx, task)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	existing, err := findSQLiteByIdempotencyKey(ctx, tx, task, ttl)
+	if err != nil {
+		return false, err
+	}
+	if existing != nil {
+		*task = *existing
+		return false, tx.Commit()
+	}
+
+	if err = insertSQLiteDependentTask(ctx, tx, task); err != nil {
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing transaction: %w", err)
+	}
+	s.wake(task.QueueName)
+	return true, nil
+}
+
+func (s *SQLiteStore) CreateTasks(ctx context.Context, tasks []*Task, idempotencyTTLs map[string]time.Duration) ([]bool, []error, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	created := make([]bool, len(tasks))
+	errs := make([]error, len(tasks))
+	notify := make(map[string]bool)
+	for i, task := range tasks {
+		if task.IdempotencyKey != nil {
+			existing, err := findSQLiteByIdempotencyKey(ctx, tx, task, idempotencyTTLs[task.QueueName])
+			if err != nil {
+				return nil, nil, err
+			}
+			if existing != nil {
+				*task = *existing
+				continue
+			}
+		}
+
+		err := insertSQLiteDependentTask(ctx, tx, task)
+		if errors.Is(err, ErrDependencyNotFound) {
+			errs[i] = err
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("error inserting task: %w", err)
+		}
+		created[i] = true
+		notify[task.QueueName] = true
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+	for queueName := range notify {
+		s.wake(queueName)
+	}
+	return created, errs, nil
+}
+
+func (s *SQLiteStore) UpdateTask(ctx context.Context, task *Task) error {
+	return s.updateTask(ctx, task, "")
+}
+
+// UpdateClaimedTask updates a task only while it is still running for clientID in the
+// given attempt, returning ErrLeaseLost when the claim was lost in the meantime
+func (s *SQLiteStore) UpdateClaimedTask(ctx context.Context, task *Task, clientID string, attempt int) error {
+	err := s.updateTask(ctx, task, " AND status = ? AND assigned_to = ? AND attempt = ?",
+		TaskStatusRunning, clientID, attempt)
+	if err == sql.ErrNoRows {
+		return ErrLeaseLost
+	}
+	return err
+}
+
+// updateTask writes the mutable fields of a task, all but its data, optionally guarded by extra conditions
+func (s *SQLiteStore) updateTask(ctx context.Context, task *Task, guard string, guardArgs ...interface{}) error {
+	query := `
+		UPDATE tasks
+		SET status = ?,
+			result = ?,
+			error = ?,
+			assigned_to = ?,
+			started_at = ?,
+			completed_at = ?,
+			attempt = ?,
+			next_attempt_at = ?,
+			queue_name = ?,
+			original_queue = ?,
+			priority = ?,
+			lease_expires_at = ?,
+			updated_at = ?
+		WHERE id = ?` + guard + `
+		RETURNING created_at, updated_at, (SELECT max_running FROM queues WHERE name = tasks.queue_name)`
+
+	args := []interface{}{
+		task.Status, nullJSONValue(task.Result), task.Error, task.AssignedTo, nullUnixNano(task.StartedAt), nullUnixNano(task.CompletedAt),
+		task.Attempt, nullUnixNano(task.NextAttemptAt), task.QueueName, task.OriginalQueue, task.Priority,
+		nullUnixNano(task.LeaseExpiresAt), time.Now().UnixNano(), task.ID,
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	previous, err := sqliteTaskStates(ctx, tx, "id = ?", task.ID)
+	if err != nil {
+		return err
+	}
+	if len(previous) == 0 {
+		return sql.ErrNoRows
+	}
+
+	var maxRunning int
+	err = tx.QueryRowContext(ctx, query, append(args, guardArgs...)...).
+		Scan(unixTime{&task.CreatedAt}, unixTime{&task.UpdatedAt}, &maxRunning)
+	if err != nil {
+		return err
+	}
+	if err := insertSQLiteEvents(ctx, tx, newTaskEvent(ctx, task, &previous[0])); err != nil {
+		return err
+	}
+
+	if outcome := attemptOutcome(task); outcome != "" {
+		if err := finishSQLiteAttempts(ctx, tx, outcome, attemptError(task, outcome), task.ID); err != nil {
+			return err
+		}
+	}
+	notify := make(map[string]bool)
+	if settlesDependents(task.Status) {
+		if err := settleSQLiteFinished(ctx, tx, notify, task.ID); err != nil {
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	// requeued tasks may be waited for, as well as the slot a finished task frees in a limited queue
+	if task.Status == TaskStatusPending || (task.Status != TaskStatusRunning && maxRunning > 0) {
+		notify[task.QueueName] = true
+	}
+	for queueName := range notify {
+		s.wake(queueName)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) GetTask(ctx context.Context, id string) (*Task, error) {
+	task := &Task{}
+	query := `
+		SELECT ` + taskColumns + `
+		FROM tasks
+		WHERE id = ?`
+
+	err := scanSQLiteTask(s.db.QueryRowContext(ctx, query, id), task)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT depends_on FROM task_dependencies WHERE task_id = ? ORDER BY depends_on`, id)
+	if err != nil {
+		return nil, fmt.Errorf("error loading dependencies: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var parent string
+		if err := rows.Scan(&parent); err != nil {
+			return nil, fmt.Errorf("error scanning dependency: %w", err)
+		}
+		task.DependsOn = append(task.DependsOn, parent)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating dependencies: %w", err)
+	}
+	return task, nil
+}
+
+// GetTaskGraph returns the workflow of a task, see store.GetTaskGraph
+func (s *SQLiteStore) GetTaskGraph(ctx context.Context, id string) (*TaskGraph, error) {
+	ids, edges, err := walkDependencies(id, func(ids []string) ([]TaskDependency, error) {
+		in, args := sqliteIn(ids)
+		rows, err := s.db.QueryContext(ctx, `
+            SELECT task_id, depends_on FROM task_dependencies
+            WHERE task_id IN `+in+` OR depends_on IN `+in, append(args, args...)...)
+		if err != nil {
+			return nil, fmt.Errorf("error loading dependencies: %w", err)
+		}
+		defer rows.Close()
+
+		var edges []TaskDependency
+		for rows.Next() {
+			var edge TaskDependency
+			if err := rows.Scan(&edge.TaskID, &edge.DependsOn); err != nil {
+				return nil, fmt.Errorf("error scanning dependency: %w", err)
+			}
+			edges = append(edges, edge)
+		}
+		return edges, rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	in, args := sqliteIn(ids)
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT `+taskColumns+` FROM tasks WHERE id IN `+in+`
+        UNION ALL
+        SELECT `+taskColumns+` FROM tasks_archive WHERE id IN `+in, append(args, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("error loading workflow: %w", err)
+	}
+	defer rows.Close()
+
+	var tasks []Task
+	found := false
+	for rows.Next() {
+		var task Task
+		if err := scanSQLiteTask(rows, &task); err != nil {
+			return nil, fmt.Errorf("error scanning task: %w", err)
+		}
+		found = found || task.ID == id
+		tasks = append(tasks, task)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating workflow: %w", err)
+	}
+	if !found {
+		return nil, nil
+	}
+	return newTaskGraph(tasks, edges), nil
+}
+
+// GetTaskAttempts returns the attempts of a task, see store.GetTaskAttempts
+func (s *SQLiteStore) GetTaskAttempts(ctx context.Context, id string) ([]TaskAttempt, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, `
+        SELECT EXISTS (SELECT 1 FROM tasks WHERE id = ?1) OR EXISTS (SELECT 1 FROM tasks_archive WHERE id = ?1)`,
+		id).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("error checking task: %w", err)
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT `+attemptColumns+` FROM task_attempts WHERE task_id = ? ORDER BY id`, id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting attempts: %w", err)
+	}
+	defer rows.Close()
+
+	attempts := []TaskAttempt{}
+	for rows.Next() {
+		var attempt TaskAttempt
+		if err := rows.Scan(&attempt.TaskID, &attempt.Attempt, &attempt.ClientID, &attempt.Outcome,
+			&attempt.Error, unixTime{&attempt.StartedAt}, nullUnixTime{&attempt.FinishedAt}); err != nil {
+			return nil, fmt.Errorf("error scanning attempt: %w", err)
+		}
+		attempt.setDuration()
+		attempts = append(attempts, attempt)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating attempts: %w", err)
+	}
+	return attempts, nil
+}
+
+// startSQLiteAttempts records an attempt for each task just claimed
+func startSQLiteAttempts(ctx context.Context, tx *sql.Tx, ids []string) error {
+	in, args := sqliteIn(ids)
+	_, err := tx.ExecContext(ctx, `
+        INSERT INTO task_attempts (task_id, attempt, client_id, started_at)
+        SELECT id, attempt, assigned_to, started_at FROM tasks WHERE id IN `+in, args...)
+	if err != nil {
+		return fmt.Errorf("error recording attempts: %w", err)
+	}
+	return nil
+}
+
+// finishSQLiteAttempts closes the running attempt of the given tasks, if any, with outcome
+func finishSQLiteAttempts(ctx context.Context, tx *sql.Tx, outcome string, taskErr *TaskError, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	in, args := sqliteIn(ids)
+	_, err := tx.ExecContext(ctx, `
+        UPDATE task_attempts
+        SET outcome = ?, error = ?, finished_at = ?
+        WHERE finished_at IS NULL AND task_id IN `+in,
+		append([]interface{}{outcome, taskErr, time.Now().UnixNano()}, args...)...)
+	if err != nil {
+		return fmt.Errorf("error finishing attempts: %w", err)
+	}
+	return nil
+}
+
+// GetTaskEvents returns the events matching the filter, see store.GetTaskEvents
+func (s *SQLiteStore) GetTaskEvents(ctx context.Context, filter TaskEventFilter) ([]TaskEvent, error) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		conditions = append(conditions, condition)
+		args = append(args, arg)
+	}
+	if filter.TaskID != "" {
+		add("task_id = ?", filter.TaskID)
+	}
+	if filter.QueueName != "" {
+		add("queue_name = ?", filter.QueueName)
+	}
+	if filter.ActorType != "" {
+		add("actor_type = ?", filter.ActorType)
+	}
+	if filter.Actor != "" {
+		add("actor = ?", filter.Actor)
+	}
+	if !filter.FromDate.IsZero() {
+		add("created_at >= ?", filter.FromDate.UnixNano())
+	}
+	if !filter.ToDate.IsZero() {
+		add("created_at <= ?", filter.ToDate.UnixNano())
+	}
+
+	query := `SELECT ` + eventColumns + ` FROM task_events`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	// SQLite reads a negative limit as no limit
+	if filter.Offset < 0 || filter.Limit < 0 {
+		return nil, fmt.Errorf("offset and limit cannot be negative")
+	}
+	query += " ORDER BY id DESC LIMIT ? OFFSET ?"
+	args = append(args, filter.Limit, filter.Offset)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error getting events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []TaskEvent{}
+	for rows.Next() {
+		var event TaskEvent
+		if err := rows.Scan(&event.ID, &event.TaskID, &event.QueueName, &event.Type, &event.FromStatus,
+			&event.ToStatus, nullJSON{&event.Details}, &event.Actor.Type, &event.Actor.Name,
+			&event.Actor.RequestID, unixTime{&event.CreatedAt}); err != nil {
+			return nil, fmt.Errorf("error scanning event: %w", err)
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating events: %w", err)
+	}
+	return events, nil
+}
+
+// insertSQLiteEvents appends the given events to the task event log, skipping nil ones
+func insertSQLiteEvents(ctx context.Context, e execer, events ...*TaskEvent) error {
+	now := time.Now().UnixNano()
+	for _, event := range events {
+		if event == nil {
+			continue
+		}
+		_, err := e.ExecContext(ctx, `
+            INSERT INTO task_events (task_id, queue_name, type, from_status, to_status, details, actor_type, actor, request_id, created_at)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			event.TaskID, event.QueueName, event.Type, event.FromStatus, event.ToStatus,
+			nullJSONValue(event.Details), event.Actor.Type, event.Actor.Name, event.Actor.RequestID, now)
+		if err != nil {
+			return fmt.Errorf("error recording events: %w", err)
+		}
+	}
+	return nil
+}
+
+// sqliteTaskStates returns the tasks matching condition as they are before a change
+func sqliteTaskStates(ctx context.Context, tx *sql.Tx, condition string, args ...interface{}) ([]Task, error) {
+	rows, err := tx.QueryContext(ctx, `
+        SELECT `+taskColumns+` FROM tasks WHERE `+condition, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error loading tasks: %w", err)
+	}
+	defer rows.Close()
+
+	var tasks []Task
+	for rows.Next() {
+		var task Task
+		if err := scanSQLiteTask(rows, &task); err != nil {
+			return nil, fmt.Errorf("error scanning task: %w", err)
+		}
+		tasks = append(tasks, task)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating tasks: %w", err)
+	}
+	return tasks, nil
+}
+
+// CreateBatch inserts a batch and its tasks, see store.CreateBatch
+func (s *SQLiteStore) CreateBatch(ctx context.Context, batch *Batch, tasks []*Task) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	batch.Total = len(tasks)
+	if batch.CallbackData == nil {
+		batch.CallbackData = json.RawMessage(`{}`)
+	}
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO batches (id, callback_queue, callback_data, total, created_at, updated_at)
+        VALUES (?1, ?2, ?3, ?4, ?5, ?5)`,
+		batch.ID, batch.CallbackQueue, batch.CallbackData, batch.Total, now.UnixNano())
+	if err != nil {
+		return fmt.Errorf("error inserting batch: %w", err)
+	}
+	batch.CreatedAt, batch.UpdatedAt = now, now
+
+	ids := make([]string, 0, len(tasks))
+	notify := make(map[string]bool)
+	for _, task := range tasks {
+		task.BatchID = &batch.ID
+		if err := insertSQLiteDependentTask(ctx, tx, task); err != nil {
+			return fmt.Errorf("error inserting task: %w", err)
+		}
+		ids = append(ids, task.ID)
+		notify[task.QueueName] = true
+	}
+
+	// tasks failed by their dependencies on creation may have finished the batch already
+	if err := finishSQLiteBatches(ctx, tx, notify, ids); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	for queueName := range notify {
+		s.wake(queueName)
+	}
+	return nil
+}
+
+func scanSQLiteBatch(row rowScanner, batch *Batch) error {
+	return row.Scan(&batch.ID, &batch.CallbackQueue, &batch.CallbackData, &batch.CallbackTaskID,
+		&batch.Total, nullUnixTime{&batch.FinishedAt}, unixTime{&batch.CreatedAt}, unixTime{&batch.UpdatedAt})
+}
+
+// GetBatch returns a batch with the counts of its tasks, see store.GetBatch
+func (s *SQLiteStore) GetBatch(ctx context.Context, id string) (*Batch, error) {
+	batch := &Batch{}
+	err := scanSQLiteBatch(s.db.QueryRowContext(ctx, `SELECT `+batchColumns+` FROM batches WHERE id = ?`, id), batch)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting batch: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT status, COUNT(*)
+        FROM (SELECT status FROM tasks WHERE batch_id = ?1
+            UNION ALL
+            SELECT status FROM tasks_archive WHERE batch_id = ?1)
+        GROUP BY status`, id)
+	if err != nil {
+		return nil, fmt.Errorf("error counting batch tasks: %w", err)
+	}
+	defer rows.Close()
+
+	batch.Counts = newBatchCounts()
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, fmt.Errorf("error scanning batch count: %w", err)
+		}
+		batch.Counts[status] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating batch counts: %w", err)
+	}
+	return batch, nil
+}
+
+// finishSQLiteBatches finishes batches as finishBatches does, adding the queues of the callback
+// tasks it enqueues to notify
+func finishSQLiteBatches(ctx context.Context, tx *sql.Tx, notify map[string]bool, taskIDs []string) error {
+	in, args := sqliteIn(taskIDs)
+	rows, err := tx.QueryContext(ctx, `
+        SELECT `+batchColumns+`
+        FROM batches
+        WHERE finished_at IS NULL
+            AND id IN (SELECT batch_id FROM tasks WHERE id IN `+in+`)
+        ORDER BY id`, args...)
+	if err != nil {
+		return fmt.Errorf("error finding batches: %w", err)
+	}
+	var batches []Batch
+	for rows.Next() {
+		var batch Batch
+		if err := scanSQLiteBatch(rows, &batch); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning batch: %w", err)
+		}
+		batches = append(batches, batch)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating batches: %w", err)
+	}
+
+	for _, batch := range batches {
+		var active bool
+		err := tx.QueryRowContext(ctx, `
+            SELECT EXISTS (SELECT 1 FROM tasks WHERE batch_id = ? AND status IN ('pending', 'blocked', 'running'))`,
+			batch.ID).Scan(&active)
+		if err != nil {
+			return fmt.Errorf("error checking batch tasks: %w", err)
+		}
+		if active {
+			continue
+		}
+
+		if batch.CallbackQueue != nil {
+			var exists bool
+			err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM queues WHERE name = ?)`,
+				*batch.CallbackQueue).Scan(&exists)
+			if err != nil {
+				return fmt.Errorf("error checking callback queue: %w", err)
+			}
+			if exists {
+				task := batch.callbackTask()
+				if err := insertSQLiteTask(ctx, tx, &task); err != nil {
+					return fmt.Errorf("error enqueuing callback of batch %s: %w", batch.ID, err)
+				}
+				notify[task.QueueName] = true
+				batch.CallbackTaskID = &task.ID
+			}
+		}
+
+		now := time.Now().UnixNano()
+		if _, err := tx.ExecContext(ctx, `
+            UPDATE batches SET finished_at = ?1, updated_at = ?1, callback_task_id = ?2 WHERE id = ?3`,
+			now, batch.CallbackTaskID, batch.ID); err != nil {
+			return fmt.Errorf("error finishing batch: %w", err)
+		}
+	}
+	return nil
+}
+
+// sqliteFilterConditions builds the WHERE conditions and their arguments for a task filter
+func sqliteFilterConditions(filter TaskFilter) ([]string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	if filter.QueueName != "" {
+		conditions = append(conditions, "queue_name = ?")
+		args = append(args, filter.QueueName)
+	}
+
+	if filter.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, filter.Status)
+	}
+
+	if filter.Priority != nil {
+		conditions = append(conditions, "priority = ?")
+		args = append(args, *filter.Priority)
+	}
+
+	if filter.BatchID != "" {
+		conditions = append(conditions, "batch_id = ?")
+		args = append(args, filter.BatchID)
+	}
+
+	if !filter.FromDate.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, filter.FromDate.UnixNano())
+	}
+
+	if !filter.ToDate.IsZero() {
+		conditions = append(conditions, "created_at <= ?")
+		args = append(args, filter.ToDate.UnixNano())
+	}
+
+	return conditions, args
+}
+
+// isTaskColumn reports whether name is one of taskColumns
+func isTaskColumn(name string) bool {
+	for _, column := range strings.Split(taskColumns, ",") {
+		if strings.TrimSpace(column) == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *SQLiteStore) GetTasks(ctx context.Context, filter TaskFilter) ([]Task, error) {
+	conditions, args := sqliteFilterConditions(filter)
+
+	query := "SELECT " + taskColumns + " FROM " + taskSource(filter)
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	// Sorting, a leading "-" sorts in descending order. NULLs are placed as Postgres does.
+	if filter.SortBy != "" {
+		column, direction := filter.SortBy, "ASC NULLS LAST"
+		if strings.HasPrefix(column, "-") {
+			column, direction = column[1:], "DESC NULLS FIRST"
+		}
+		if !isTaskColumn(column) {
+			return nil, fmt.Errorf("cannot sort by %s", column)
+		}
+		query += fmt.Sprintf(" ORDER BY %s %s, created_at DESC", column, direction)
+	} else {
+		query += " ORDER BY created_at DESC"
+	}
+
+	// Pagination, SQLite reads a negative limit as no limit
+	if filter.Offset < 0 || filter.Limit < 0 {
+		return nil, fmt.Errorf("offset and limit cannot be negative")
+	}
+	query += " LIMIT ? OFFSET ?"
+	args = append(args, filter.Limit, filter.Offset)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []Task
+	for rows.Next() {
+		var task Task
+		if err := scanSQLiteTask(rows, &task); err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+
+	return tasks, rows.Err()
+}
+
+func (s *SQLiteStore) GetTaskStats(ctx context.Context, filter TaskFilter) (map[string]int, error) {
+	conditions, args := sqliteFilterConditions(filter)
+
+	whereClause := ""
+	if len(conditions) > 0 {
+		whereClause = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	now := time.Now().UnixNano()
+	query := fmt.Sprintf(`
+        SELECT
+            COUNT(*) as total,
+            COUNT(CASE WHEN status = 'pending' THEN 1 END) as pending,
+            COUNT(CASE WHEN status = 'blocked' THEN 1 END) as blocked,
+            COUNT(CASE WHEN status = 'pending' AND (run_at > ? OR next_attempt_at > ?) THEN 1 END) as scheduled,
+            COUNT(CASE WHEN status = 'running' THEN 1 END) as running,
+            COUNT(CASE WHEN status = 'completed' THEN 1 END) as completed,
+            COUNT(CASE WHEN status = 'failed' THEN 1 END) as failed,
+            COUNT(CASE WHEN status = 'deleted' THEN 1 END) as deleted
+        FROM %s
+        %s`, taskSource(filter), whereClause)
+
+	var stats struct {
+		Total     int
+		Pending   int
+		Blocked   int
+		Scheduled int
+		Running   int
+		Completed int
+		Failed    int
+		Deleted   int
+	}
+
+	err := s.db.QueryRowContext(ctx, query, append([]interface{}{now, now}, args...)...).Scan(
+		&stats.Total,
+		&stats.Pending,
+		&stats.Blocked,
+		&stats.Scheduled,
+		&stats.Running,
+		&stats.Completed,
+		&stats.Failed,
+		&stats.Deleted,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error getting statistics: %w", err)
+	}
+
+	return map[string]int{
+		"all":       stats.Total,
+		"pending":   stats.Pending,
+		"blocked":   stats.Blocked,
+		"scheduled": stats.Scheduled,
+		"running":   stats.Running,
+		"completed": stats.Completed,
+		"failed":    stats.Failed,
+		"deleted":   stats.Deleted,
+	}, nil
+}
+
+func (s *SQLiteStore) GetNextPendingTask(ctx context.Context, queueName, clientID string) (*Task, error) {
+	tasks, err := s.ClaimTasks(ctx, queueName, clientID, 1)
+	if err != nil || len(tasks) == 0 {
+		return nil, err
+	}
+	return &tasks[0], nil
+}
+
+// ClaimTasks assigns up to n due pending tasks of the queue to clientID in a single statement.
+// SQLite runs one writer at a time, so two claims never select the same tasks.
+func (s *SQLiteStore) ClaimTasks(ctx context.Context, queueName, clientID string, n int) ([]Task, error) {
+	if n <= 0 {
+		return []Task{}, nil
+	}
+
+	// the transaction holds the write lock, so the running tasks cannot change until the claim is done
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var maxRunning, running int
+	var bucket tokenBucket
+	var updatedAt *time.Time
+	err = tx.QueryRowContext(ctx, `
+        SELECT max_running, (SELECT COUNT(*) FROM tasks WHERE queue_name = ?1 AND status = ?2),
+            rate, burst, rate_tokens, rate_updated_at
+        FROM queues
+        WHERE name = ?1`,
+		queueName, TaskStatusRunning).Scan(&maxRunning, &running,
+		&bucket.rate, &bucket.burst, &bucket.tokens, nullUnixTime{&updatedAt})
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("error counting running tasks: %w", err)
+	}
+	if maxRunning > 0 {
+		if running >= maxRunning {
+			return []Task{}, nil
+		}
+		n = min(n, maxRunning-running)
+	}
+	if bucket.rate > 0 {
+		var elapsed *time.Duration
+		if updatedAt != nil {
+			d := now.Sub(*updatedAt)
+			elapsed = &d
+		}
+		bucket.refill(elapsed)
+		if n, err = bucket.take(n); err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+        UPDATE tasks
+        SET status = ?4,
+            assigned_to = ?5,
+            started_at = ?6,
+            updated_at = ?6,
+            attempt = attempt + 1,
+            next_attempt_at = NULL,
+            lease_expires_at = ?6 + (SELECT task_timeout FROM queues WHERE name = ?1) * 1000000000
+        WHERE id IN (
+            SELECT id
+            FROM tasks
+            WHERE queue_name = ?1 AND status = ?2 AND assigned_to IS NULL
+                AND (run_at IS NULL OR run_at <= ?6)
+                AND (next_attempt_at IS NULL OR next_attempt_at <= ?6)
+                AND NOT (SELECT paused FROM queues WHERE name = ?1)
+            ORDER BY priority DESC, created_at ASC, rowid ASC
+            LIMIT ?3
+        )
+        RETURNING `+taskColumns,
+		queueName, TaskStatusPending, n, TaskStatusRunning, clientID, now.UnixNano(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error claiming tasks: %w", err)
+	}
+
+	tasks := []Task{}
+	var ids []string
+	for rows.Next() {
+		var task Task
+		if err := scanSQLiteTask(rows, &task); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning task: %w", err)
+		}
+		tasks = append(tasks, task)
+		ids = append(ids, task.ID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating tasks: %w", err)
+	}
+
+	if len(ids) > 0 {
+		if err := startSQLiteAttempts(ctx, tx, ids); err != nil {
+			return nil, err
+		}
+		if err := insertSQLiteEvents(ctx, tx, claimEvents(ctx, tasks)...); err != nil {
+			return nil, err
+		}
+	}
+	if bucket.rate > 0 {
+		// only the tasks handed out spend tokens
+		bucket.tokens += float64(n - len(tasks))
+		if _, err := tx.ExecContext(ctx, `
+            UPDATE queues SET rate_tokens = ?, rate_updated_at = ? WHERE name = ?`,
+			bucket.tokens, now.UnixNano(), queueName); err != nil {
+			return nil, fmt.Errorf("error updating rate limit: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	// RETURNING does not keep the order of the subquery
+	sort.SliceStable(tasks, func(i, j int) bool {
+		if tasks[i].Priority != tasks[j].Priority {
+			return tasks[i].Priority > tasks[j].Priority
+		}
+		return tasks[i].CreatedAt.Before(tasks[j].CreatedAt)
+	})
+
+	return tasks, nil
+}
+
+func (s *SQLiteStore) DeleteTask(ctx context.Context, id string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	previous, err := sqliteTaskStates(ctx, tx, "id = ?", id)
+	if err != nil {
+		return err
+	}
+	if len(previous) == 0 {
+		return fmt.Errorf("task not found")
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE tasks
+		SET status = ?, updated_at = ?
+		WHERE id = ?`,
+		TaskStatusDeleted, time.Now().UnixNano(), id); err != nil {
+		return err
+	}
+	if err := insertSQLiteEvents(ctx, tx, deleteEvents(ctx, previous)...); err != nil {
+		return err
+	}
+
+	if err := finishSQLiteAttempts(ctx, tx, AttemptCancelled, nil, id); err != nil {
+		return err
+	}
+	notify := make(map[string]bool)
+	if err := settleSQLiteFinished(ctx, tx, notify, id); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	for queueName := range notify {
+		s.wake(queueName)
+	}
+	return nil
+}
+
+// ExtendLease pushes the lease of a running task held by clientID forward by extension,
+// returning ErrLeaseLost when the task is no longer running for that client
+func (s *SQLiteStore) ExtendLease(ctx context.Context, id, clientID string, extension time.Duration) (time.Time, error) {
+	var leaseExpiresAt time.Time
+	err := s.db.QueryRowContext(ctx, `
+        UPDATE tasks
+        SET lease_expires_at = ?
+        WHERE id = ? AND status = ? AND assigned_to = ?
+        RETURNING lease_expires_at`,
+		time.Now().Add(extension).UnixNano(), id, TaskStatusRunning, clientID,
+	).Scan(unixTime{&leaseExpiresAt})
+	if err == sql.ErrNoRows {
+		return time.Time{}, ErrLeaseLost
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error extending lease: %w", err)
+	}
+	return leaseExpiresAt, nil
+}
+
+// MarkExpiredTasks handles expired leases as the Postgres store does. The retry decision
+// and the error of the task are computed here rather than in SQL.
+func (s *SQLiteStore) MarkExpiredTasks(ctx context.Context) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	rows, err := tx.QueryContext(ctx, `
+        SELECT t.id, t.queue_name, t.original_queue, t.attempt,
+            q.retry_max_attempts, q.retry_base_delay_ms, q.retry_multiplier,
+            q.retry_max_delay_ms, q.retry_jitter, COALESCE(q.dead_letter_queue, '')
+        FROM tasks t
+        JOIN queues q ON t.queue_name = q.name
+        WHERE
+            t.status = 'running'
+            AND COALESCE(t.lease_expires_at,
+                COALESCE(t.started_at, t.updated_at) + q.task_timeout * 1000000000) < ?`,
+		now.UnixNano())
+	if err != nil {
+		return fmt.Errorf("error finding expired tasks: %w", err)
+	}
+
+	var expired []Task
+	var queues []Queue
+	for rows.Next() {
+		task := Task{Status: TaskStatusRunning}
+		var queue Queue
+		var baseDelayMs, maxDelayMs int64
+		if err := rows.Scan(&task.ID, &task.QueueName, &task.OriginalQueue, &task.Attempt,
+			&queue.RetryPolicy.MaxAttempts, &baseDelayMs, &queue.RetryPolicy.Multiplier,
+			&maxDelayMs, &queue.RetryPolicy.Jitter, &queue.DeadLetterQueue); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning expired task: %w", err)
+		}
+		queue.Name = task.QueueName
+		queue.RetryPolicy.BaseDelay = time.Duration(baseDelayMs) * time.Millisecond
+		queue.RetryPolicy.MaxDelay = time.Duration(maxDelayMs) * time.Millisecond
+		expired = append(expired, task)
+		queues = append(queues, queue)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating expired tasks: %w", err)
+	}
+
+	notify := make(map[string]bool)
+	var failed []string
+	for i := range expired {
+		task := &expired[i]
+		previous := *task
+		task.Error = timeoutError()
+		if !queues[i].RetryPolicy.ScheduleRetry(task, now) {
+			task.Status = TaskStatusFailed
+			queues[i].DeadLetter(task)
+			failed = append(failed, task.ID)
+		}
+
+		_, err = tx.ExecContext(ctx, `
+            UPDATE tasks
+            SET
+                status = ?1,
+                assigned_to = CASE WHEN ?1 = 'pending' THEN NULL ELSE assigned_to END,
+                started_at = CASE WHEN ?1 = 'pending' THEN NULL ELSE started_at END,
+                lease_expires_at = CASE WHEN ?1 = 'pending' THEN NULL ELSE lease_expires_at END,
+                next_attempt_at = ?2,
+                queue_name = ?3,
+                original_queue = ?4,
+                updated_at = ?5,
+                error = ?6
+            WHERE id = ?7`,
+			task.Status, nullUnixNano(task.NextAttemptAt), task.QueueName, task.OriginalQueue,
+			now.UnixNano(), task.Error, task.ID)
+		if err != nil {
+			return fmt.Errorf("error marking expired tasks: %w", err)
+		}
+		if err := finishSQLiteAttempts(ctx, tx, AttemptTimeout, timeoutError(), task.ID); err != nil {
+			return err
+		}
+		if err := insertSQLiteEvents(ctx, tx, newTaskEvent(ctx, task, &previous)); err != nil {
+			return err
+		}
+
+		if task.Status == TaskStatusPending {
+			notify[task.QueueName] = true
+		}
+	}
+	if err := settleSQLiteFinished(ctx, tx, notify, failed...); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	for queueName := range notify {
+		s.wake(queueName)
+	}
+	return nil
+}
+
+// RedriveDeadLetters moves failed tasks held in a dead-letter queue back to the queue
+// they came from with a fresh retry cycle. When taskIDs is empty every dead-lettered
+// task of the queue is redriven.
+func (s *SQLiteStore) RedriveDeadLetters(ctx context.Context, queueName string, taskIDs []string) (int, error) {
+	query := `
+		UPDATE tasks
+		SET queue_name = original_queue,
+			original_queue = NULL,
+			status = ?,
+			assigned_to = NULL,
+			started_at = NULL,
+			completed_at = NULL,
+			lease_expires_at = NULL,
+			attempt = 0,
+			next_attempt_at = NULL,
+			updated_at = ?
+		WHERE queue_name = ? AND status = ? AND original_queue IS NOT NULL`
+	args := []interface{}{TaskStatusPending, time.Now().UnixNano(), queueName, TaskStatusFailed}
+
+	if len(taskIDs) > 0 {
+		query += " AND id IN (?" + strings.Repeat(", ?", len(taskIDs)-1) + ")"
+		for _, id := range taskIDs {
+			args = append(args, id)
+		}
+	}
+	query += " RETURNING id, queue_name"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("error redriving dead letters: %w", err)
+	}
+
+	counts := make(map[string]int)
+	var events []*TaskEvent
+	for rows.Next() {
+		task := Task{Status: TaskStatusPending}
+		if err := rows.Scan(&task.ID, &task.QueueName); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error scanning redriven tasks: %w", err)
+		}
+		counts[task.QueueName]++
+		previous := Task{Status: TaskStatusFailed, QueueName: queueName}
+		events = append(events, newTaskEvent(ctx, &task, &previous))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating redriven tasks: %w", err)
+	}
+	if err := insertSQLiteEvents(ctx, tx, events...); err != nil {
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	total := 0
+	for queue, count := range counts {
+		s.wake(queue)
+		total += count
+	}
+	return total, nil
+}
+
+// sqlitePurgeableCondition is purgeableCondition for SQLite
+const sqlitePurgeableCondition = `
+        (?1 = '' OR t.queue_name = ?1) AND (
+            (t.status = 'completed' AND q.retention_completed > 0
+                AND t.updated_at < ?2 - q.retention_completed * 1000000000)
+            OR (t.status = 'failed' AND q.retention_failed > 0
+                AND t.updated_at < ?2 - q.retention_failed * 1000000000)
+            OR (t.status = 'deleted' AND q.retention_deleted > 0
+                AND t.updated_at < ?2 - q.retention_deleted * 1000000000))`
+
+// PurgeTasks hard-deletes up to limit tasks, archived or not, that outlived the retention of
+// their queue at asOf
+func (s *SQLiteStore) PurgeTasks(ctx context.Context, queueName string, asOf time.Time, limit int) (int, error) {
+	purged := 0
+	for _, table := range []string{"tasks", "tasks_archive"} {
+		if purged >= limit {
+			break
+		}
+
+		result, err := s.db.ExecContext(ctx, `
+            DELETE FROM `+table+`
+            WHERE id IN (
+                SELECT t.id
+                FROM `+table+` t
+                JOIN queues q ON t.queue_name = q.name
+                WHERE `+sqlitePurgeableCondition+`
+                LIMIT ?3
+            )`,
+			queueName, asOf.UnixNano(), limit-purged)
+		if err != nil {
+			return purged, fmt.Errorf("error purging tasks: %w", err)
+		}
+
+		n, err := result.RowsAffected()
+		if err != nil {
+			return purged, fmt.Errorf("error purging tasks: %w", err)
+		}
+		purged += int(n)
+	}
+
+	if purged > 0 {
+		if _, err := s.db.ExecContext(ctx, orphanDependencies); err != nil {
+			return purged, fmt.Errorf("error purging dependencies: %w", err)
+		}
+		if _, err := s.db.ExecContext(ctx, orphanAttempts); err != nil {
+			return purged, fmt.Errorf("error purging attempts: %w", err)
+		}
+	}
+	return purged, nil
+}
+
+// CountPurgeableTasks counts the tasks PurgeTasks would delete at asOf
+func (s *SQLiteStore) CountPurgeableTasks(ctx context.Context, queueName string, asOf time.Time) (int, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, `
+        SELECT
+            (SELECT COUNT(*) FROM tasks t JOIN queues q ON t.queue_name = q.name WHERE `+sqlitePurgeableCondition+`) +
+            (SELECT COUNT(*) FROM tasks_archive t JOIN queues q ON t.queue_name = q.name WHERE `+sqlitePurgeableCondition+`)`,
+		queueName, asOf.UnixNano(),
+	).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("error counting purgeable tasks: %w", err)
+	}
+	return count, nil
+}
+
+// ArchiveTasks moves up to limit finished tasks older than the archive age of their queue at
+// asOf from tasks to tasks_archive
+func (s *SQLiteStore) ArchiveTasks(ctx context.Context, asOf time.Time, limit int) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+        SELECT t.id
+        FROM tasks t
+        JOIN queues q ON t.queue_name = q.name
+        WHERE t.status IN ('completed', 'failed', 'deleted') AND q.archive_after > 0
+            AND t.updated_at < ? - q.archive_after * 1000000000
+        LIMIT ?`,
+		asOf.UnixNano(), limit)
+	if err != nil {
+		return 0, fmt.Errorf("error finding tasks to archive: %w", err)
+	}
+
+	var ids []interface{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error scanning task: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating tasks: %w", err)
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	in := "(?" + strings.Repeat(", ?", len(ids)-1) + ")"
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO tasks_archive (`+taskColumns+`, archived_at)
+        SELECT `+taskColumns+`, ?
+        FROM tasks
+        WHERE id IN `+in, append([]interface{}{time.Now().UnixNano()}, ids...)...)
+	if err != nil {
+		return 0, fmt.Errorf("error archiving tasks: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM tasks WHERE id IN `+in, ids...); err != nil {
+		return 0, fmt.Errorf("error archiving tasks: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing transaction: %w", err)
+	}
+	return len(ids), nil
+}
+
+func scanSQLiteSchedule(row rowScanner, schedule *Schedule) error {
+	return row.Scan(
+		&schedule.ID, &schedule.QueueName, &schedule.Cron, &schedule.Timezone, &schedule.Priority,
+		&schedule.Data, &schedule.Paused, unixTime{&schedule.NextRunAt}, nullUnixTime{&schedule.LastRunAt},
+		unixTime{&schedule.CreatedAt}, unixTime{&schedule.UpdatedAt},
+	)
+}
+
+func (s *SQLiteStore) CreateSchedule(ctx context.Context, schedule *Schedule) error {
+	now := time.Now()
+	_, err := s.db.ExecContext(ctx, `
+        INSERT INTO schedules (id, queue_name, cron, timezone, priority, data, paused, next_run_at, created_at, updated_at)
+        VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?9)`,
+		schedule.ID, schedule.QueueName, schedule.Cron, schedule.Timezone, schedule.Priority, schedule.Data,
+		schedule.Paused, schedule.NextRunAt.UnixNano(), now.UnixNano())
+	if err != nil {
+		return fmt.Errorf("error creating schedule: %w", err)
+	}
+
+	schedule.CreatedAt = time.Unix(0, now.UnixNano()).UTC()
+	schedule.UpdatedAt = schedule.CreatedAt
+	return nil
+}
+
+func (s *SQLiteStore) GetSchedule(ctx context.Context, id string) (*Schedule, error) {
+	var schedule Schedule
+	err := scanSQLiteSchedule(s.db.QueryRowContext(ctx, `SELECT `+scheduleColumns+` FROM schedules WHERE id = ?`, id), &schedule)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting schedule: %w", err)
+	}
+	return &schedule, nil
+}
+
+// GetSchedules lists the schedules of a queue, or of every queue when queueName is empty
+func (s *SQLiteStore) GetSchedules(ctx context.Context, queueName string) ([]Schedule, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT `+scheduleColumns+`
+        FROM schedules
+        WHERE ?1 = '' OR queue_name = ?1
+        ORDER BY created_at, id`, queueName)
+	if err != nil {
+		return nil, fmt.Errorf("error querying schedules: %w", err)
+	}
+	defer rows.Close()
+
+	var schedules []Schedule
+	for rows.Next() {
+		var schedule Schedule
+		if err := scanSQLiteSchedule(rows, &schedule); err != nil {
+			return nil, fmt.Errorf("error scanning schedule: %w", err)
+		}
+		schedules = append(schedules, schedule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating schedules: %w", err)
+	}
+	return schedules, nil
+}
+
+// SetSchedulePaused pauses or resumes a schedule, which runs next at nextRunAt
+func (s *SQLiteStore) SetSchedulePaused(ctx context.Context, id string, paused bool, nextRunAt time.Time) error {
+	result, err := s.db.ExecContext(ctx, `
+        UPDATE schedules SET paused = ?2, next_run_at = ?3, updated_at = ?4 WHERE id = ?1`,
+		id, paused, nextRunAt.UnixNano(), time.Now().UnixNano())
+	if err != nil {
+		return fmt.Errorf("error updating schedule: %w", err)
+	}
+	if updated, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("error updating schedule: %w", err)
+	} else if updated == 0 {
+		return ErrScheduleNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) DeleteSchedule(ctx context.Context, id string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM schedules WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("error deleting schedule: %w", err)
+	}
+	if deleted, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("error deleting schedule: %w", err)
+	} else if deleted == 0 {
+		return ErrScheduleNotFound
+	}
+	return nil
+}
+
+// RunDueSchedules enqueues a task for each of up to limit schedules due at asOf, see
+// store.RunDueSchedules. The write lock of the transaction keeps the runs from being repeated.
+func (s *SQLiteStore) RunDueSchedules(ctx context.Context, asOf time.Time, limit int) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+        SELECT `+scheduleColumns+`
+        FROM schedules
+        WHERE paused = 0 AND next_run_at <= ?
+        ORDER BY next_run_at
+        LIMIT ?`,
+		asOf.UnixNano(), limit)
+	if err != nil {
+		return 0, fmt.Errorf("error querying due schedules: %w", err)
+	}
+	var schedules []Schedule
+	for rows.Next() {
+		var schedule Schedule
+		if err := scanSQLiteSchedule(rows, &schedule); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error scanning schedule: %w", err)
+		}
+		schedules = append(schedules, schedule)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating schedules: %w", err)
+	}
+
+	now := time.Now().UnixNano()
+	notify := make(map[string]bool)
+	for _, schedule := range schedules {
+		next, err := schedule.Next(asOf)
+		if err != nil {
+			if _, err := tx.ExecContext(ctx, `UPDATE schedules SET paused = 1, updated_at = ? WHERE id = ?`,
+				now, schedule.ID); err != nil {
+				return 0, fmt.Errorf("error pausing schedule: %w", err)
+			}
+			continue
+		}
+
+		task := schedule.newTask()
+		if err := insertSQLiteTask(ctx, tx, &task); err != nil {
+			return 0, fmt.Errorf("error enqueuing task of schedule %s: %w", schedule.ID, err)
+		}
+		if _, err := tx.ExecContext(ctx, `
+            UPDATE schedules SET next_run_at = ?2, last_run_at = ?3, updated_at = ?4 WHERE id = ?1`,
+			schedule.ID, next.UnixNano(), schedule.NextRunAt.UnixNano(), now); err != nil {
+			return 0, fmt.Errorf("error updating schedule: %w", err)
+		}
+		notify[schedule.QueueName] = true
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing transaction: %w", err)
+	}
+	for queueName := range notify {
+		s.wake(queueName)
+	}
+	return len(schedules), nil
+}
@@ -0,0 +1,55 @@
+package storage_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/fernandezvara/jobqueues/internal/storage"
+	"github.com/fernandezvara/jobqueues/internal/storage/storetest"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) storage.Store {
+		db, err := storage.NewSQLiteDB(filepath.Join(t.TempDir(), "jobqueue.db"))
+		if err != nil {
+			t.Fatalf("NewSQLiteDB: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		if err := storage.InitSQLiteSchema(db); err != nil {
+			t.Fatalf("InitSQLiteSchema: %v", err)
+		}
+		return storage.NewSQLiteStore(db)
+	})
+}
+
+func TestSQLiteTaskEventsAppendOnly(t *testing.T) {
+	db, err := storage.NewSQLiteDB(filepath.Join(t.TempDir(), "jobqueue.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteDB: %v", err)
+	}
+	defer db.Close()
+	if err := storage.InitSQLiteSchema(db); err != nil {
+		t.Fatalf("InitSQLiteSchema: %v", err)
+	}
+
+	ctx := context.Background()
+	s := storage.NewSQLiteStore(db)
+	if err := s.CreateOrUpdateQueue(ctx, &storage.Queue{Name: "q"}); err != nil {
+		t.Fatalf("CreateOrUpdateQueue: %v", err)
+	}
+	task := storage.Task{ID: "task", QueueName: "q", Status: storage.TaskStatusPending, Data: []byte(`{}`)}
+	if err := s.CreateTask(ctx, &task); err != nil {
+		t.Fatalf("CreateTask: %v", err)
+	}
+
+	for _, statement := range []string{
+		`UPDATE task_events SET actor = 'someone else'`,
+		`DELETE FROM task_events`,
+	} {
+		if _, err := db.Exec(statement); err == nil {
+			t.Errorf("%s succeeded on the event log", statement)
+		}
+	}
+}
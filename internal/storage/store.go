@@ -3,25 +3,62 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/lib/pq"
 )
 
+// ErrLeaseLost is returned when a client acts on a task it no longer holds
+var ErrLeaseLost = errors.New("task lease lost")
+
+// Errors returned by DeleteQueue and RenameQueue
+var (
+	ErrQueueNotFound = errors.New("queue not found")
+	ErrQueueExists   = errors.New("queue already exists")
+	ErrQueueNotEmpty = errors.New("queue has pending or running tasks")
+	ErrQueueInUse    = errors.New("queue is the dead-letter queue of another queue")
+)
+
 type Store interface {
 	GetQueues(ctx context.Context) ([]Queue, error)
 	CreateOrUpdateQueue(ctx context.Context, queue *Queue) error
 	GetQueue(ctx context.Context, name string) (*Queue, error)
+	DeleteQueue(ctx context.Context, name, mode, target string) error
+	RenameQueue(ctx context.Context, name, newName string) error
+	SetQueuePaused(ctx context.Context, name string, paused bool) error
 	CreateTask(ctx context.Context, task *Task) error
+	CreateUniqueTask(ctx context.Context, task *Task, ttl time.Duration) (bool, error)
+	CreateTasks(ctx context.Context, tasks []*Task, idempotencyTTLs map[string]time.Duration) ([]bool, []error, error)
 	UpdateTask(ctx context.Context, task *Task) error
+	UpdateClaimedTask(ctx context.Context, task *Task, clientID string, attempt int) error
 	GetTask(ctx context.Context, id string) (*Task, error)
+	GetTaskGraph(ctx context.Context, id string) (*TaskGraph, error)
+	GetTaskAttempts(ctx context.Context, id string) ([]TaskAttempt, error)
+	GetTaskEvents(ctx context.Context, filter TaskEventFilter) ([]TaskEvent, error)
+	CreateBatch(ctx context.Context, batch *Batch, tasks []*Task) error
+	GetBatch(ctx context.Context, id string) (*Batch, error)
 	GetTasks(ctx context.Context, filter TaskFilter) ([]Task, error)
 	GetTaskStats(ctx context.Context, filter TaskFilter) (map[string]int, error)
 	GetNextPendingTask(ctx context.Context, queueName, clientID string) (*Task, error)
+	ClaimTasks(ctx context.Context, queueName, clientID string, n int) ([]Task, error)
 	DeleteTask(ctx context.Context, id string) error
+	ExtendLease(ctx context.Context, id, clientID string, extension time.Duration) (time.Time, error)
 	MarkExpiredTasks(ctx context.Context) error
+	RedriveDeadLetters(ctx context.Context, queueName string, taskIDs []string) (int, error)
+	PurgeTasks(ctx context.Context, queueName string, asOf time.Time, limit int) (int, error)
+	CountPurgeableTasks(ctx context.Context, queueName string, asOf time.Time) (int, error)
+	ArchiveTasks(ctx context.Context, asOf time.Time, limit int) (int, error)
+	CreateSchedule(ctx context.Context, schedule *Schedule) error
+	GetSchedule(ctx context.Context, id string) (*Schedule, error)
+	GetSchedules(ctx context.Context, queueName string) ([]Schedule, error)
+	SetSchedulePaused(ctx context.Context, id string, paused bool, nextRunAt time.Time) error
+	DeleteSchedule(ctx context.Context, id string) error
+	RunDueSchedules(ctx context.Context, asOf time.Time, limit int) (int, error)
 }
 
 type store struct {
@@ -32,18 +69,131 @@ func NewStore(db *sql.DB) Store {
 	return &store{db: db}
 }
 
+const queueColumns = `name, task_timeout, retry_max_attempts, retry_base_delay_ms, retry_multiplier,
+            retry_max_delay_ms, retry_jitter, COALESCE(dead_letter_queue, ''), idempotency_ttl,
+            retention_completed, retention_failed, retention_deleted, archive_after, max_running, rate, burst, paused, created_at, updated_at`
+
+// queueSettingColumns are the columns RenameQueue copies to the new queue, all but name and updated_at
+const queueSettingColumns = `task_timeout, retry_max_attempts, retry_base_delay_ms, retry_multiplier,
+            retry_max_delay_ms, retry_jitter, dead_letter_queue, idempotency_ttl,
+            retention_completed, retention_failed, retention_deleted, archive_after, max_running, rate, burst, rate_tokens, rate_updated_at,
+            paused, created_at`
+
+const scheduleColumns = `id, queue_name, cron, timezone, priority, data, paused, next_run_at, last_run_at,
+            created_at, updated_at`
+
+const taskColumns = `id, queue_name, status, priority, data, assigned_to, attempt, next_attempt_at, original_queue, run_at,
+            idempotency_key, created_at, updated_at, started_at, completed_at, lease_expires_at, batch_id, result, error`
+
+const attemptColumns = `task_id, attempt, client_id, outcome, error, started_at, finished_at`
+
+const eventColumns = `id, task_id, queue_name, type, from_status, to_status, details, actor_type, actor, request_id, created_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// taskSource is the table GetTasks and GetTaskStats read, including the archive when requested
+func taskSource(filter TaskFilter) string {
+	if !filter.IncludeArchived {
+		return "tasks"
+	}
+	return "(SELECT " + taskColumns + " FROM tasks UNION ALL SELECT " + taskColumns + " FROM tasks_archive) AS tasks"
+}
+
+// scanQueue reads a row selected with queueColumns, converting stored units to durations
+func scanQueue(row rowScanner, queue *Queue) error {
+	var timeoutSeconds, baseDelayMs, maxDelayMs, idempotencyTTLSeconds int64
+	var completedSeconds, failedSeconds, deletedSeconds, archiveAfterSeconds int64
+	err := row.Scan(
+		&queue.Name,
+		&timeoutSeconds,
+		&queue.RetryPolicy.MaxAttempts,
+		&baseDelayMs,
+		&queue.RetryPolicy.Multiplier,
+		&maxDelayMs,
+		&queue.RetryPolicy.Jitter,
+		&queue.DeadLetterQueue,
+		&idempotencyTTLSeconds,
+		&completedSeconds,
+		&failedSeconds,
+		&deletedSeconds,
+		&archiveAfterSeconds,
+		&queue.MaxRunning,
+		&queue.Rate,
+		&queue.Burst,
+		&queue.Paused,
+		&queue.CreatedAt,
+		&queue.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	queue.TaskTimeout = time.Duration(timeoutSeconds) * time.Second
+	queue.RetryPolicy.BaseDelay = time.Duration(baseDelayMs) * time.Millisecond
+	queue.RetryPolicy.MaxDelay = time.Duration(maxDelayMs) * time.Millisecond
+	queue.IdempotencyTTL = time.Duration(idempotencyTTLSeconds) * time.Second
+	queue.Retention.Completed = time.Duration(completedSeconds) * time.Second
+	queue.Retention.Failed = time.Duration(failedSeconds) * time.Second
+	queue.Retention.Deleted = time.Duration(deletedSeconds) * time.Second
+	queue.ArchiveAfter = time.Duration(archiveAfterSeconds) * time.Second
+	return nil
+}
+
+// scanSchedule reads a row selected with scheduleColumns
+func scanSchedule(row rowScanner, schedule *Schedule) error {
+	return row.Scan(
+		&schedule.ID, &schedule.QueueName, &schedule.Cron, &schedule.Timezone, &schedule.Priority,
+		&schedule.Data, &schedule.Paused, &schedule.NextRunAt, &schedule.LastRunAt,
+		&schedule.CreatedAt, &schedule.UpdatedAt,
+	)
+}
+
+// scanTask reads a row selected with taskColumns
+func scanTask(row rowScanner, task *Task) error {
+	return row.Scan(
+		&task.ID, &task.QueueName, &task.Status, &task.Priority, nullJSON{&task.Data}, &task.AssignedTo,
+		&task.Attempt, &task.NextAttemptAt, &task.OriginalQueue, &task.RunAt,
+		&task.IdempotencyKey, &task.CreatedAt, &task.UpdatedAt, &task.StartedAt, &task.CompletedAt,
+		&task.LeaseExpiresAt, &task.BatchID, nullJSON{&task.Result}, &task.Error,
+	)
+}
+
 func (s *store) CreateOrUpdateQueue(ctx context.Context, queue *Queue) error {
 	query := `
-		INSERT INTO queues (name, task_timeout, created_at, updated_at)
-		VALUES ($1, $2, NOW(), NOW())
+		INSERT INTO queues (name, task_timeout, retry_max_attempts, retry_base_delay_ms,
+			retry_multiplier, retry_max_delay_ms, retry_jitter, dead_letter_queue, idempotency_ttl,
+			retention_completed, retention_failed, retention_deleted, archive_after, max_running,
+			rate, burst, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), $9, $10, $11, $12, $13, $14, $15, $16, NOW(), NOW())
 		ON CONFLICT (name) 
 		DO UPDATE SET 
 			task_timeout = $2,
+			retry_max_attempts = $3,
+			retry_base_delay_ms = $4,
+			retry_multiplier = $5,
+			retry_max_delay_ms = $6,
+			retry_jitter = $7,
+			dead_letter_queue = NULLIF($8, ''),
+			idempotency_ttl = $9,
+			retention_completed = $10,
+			retention_failed = $11,
+			retention_deleted = $12,
+			archive_after = $13,
+			max_running = $14,
+			rate = $15,
+			burst = $16,
 			updated_at = NOW()
-		RETURNING created_at, updated_at`
-
-	return s.db.QueryRowContext(ctx, query, queue.Name, queue.TaskTimeoutSeconds()).
-		Scan(&queue.CreatedAt, &queue.UpdatedAt)
+		RETURNING paused, created_at, updated_at`
+
+	policy, retention := queue.RetryPolicy, queue.Retention
+	return s.db.QueryRowContext(ctx, query, queue.Name, queue.TaskTimeoutSeconds(),
+		policy.MaxAttempts, policy.BaseDelayMillis(), policy.Multiplier, policy.MaxDelayMillis(), policy.Jitter,
+		queue.DeadLetterQueue, queue.IdempotencyTTLSeconds(),
+		retention.CompletedSeconds(), retention.FailedSeconds(), retention.DeletedSeconds(),
+		queue.ArchiveAfterSeconds(), queue.MaxRunning, queue.Rate, queue.Burst).
+		Scan(&queue.Paused, &queue.CreatedAt, &queue.UpdatedAt)
 }
 
 // func (s *store) GetQueue(ctx context.Context, name string) (*Queue, error) {
@@ -66,21 +216,12 @@ func (s *store) CreateOrUpdateQueue(ctx context.Context, queue *Queue) error {
 
 func (s *store) GetQueue(ctx context.Context, name string) (*Queue, error) {
 	var queue Queue
-	err := s.db.QueryRowContext(ctx, `
-        SELECT 
-            name, 
-            task_timeout, 
-            created_at, 
-            updated_at
+	err := scanQueue(s.db.QueryRowContext(ctx, `
+        SELECT `+queueColumns+`
         FROM queues
         WHERE name = $1`,
 		name,
-	).Scan(
-		&queue.Name,
-		&queue.TaskTimeout,
-		&queue.CreatedAt,
-		&queue.UpdatedAt,
-	)
+	), &queue)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -89,19 +230,12 @@ func (s *store) GetQueue(ctx context.Context, name string) (*Queue, error) {
 		return nil, fmt.Errorf("error getting queue: %w", err)
 	}
 
-	// Convertir segundos a Duration
-	queue.TaskTimeout = queue.TaskTimeout * time.Second
-
 	return &queue, nil
 }
 
 func (s *store) GetQueues(ctx context.Context) ([]Queue, error) {
 	rows, err := s.db.QueryContext(ctx, `
-        SELECT 
-            name, 
-            task_timeout, 
-            created_at, 
-            updated_at
+        SELECT `+queueColumns+`
         FROM queues
         ORDER BY name ASC`)
 	if err != nil {
@@ -112,17 +246,9 @@ func (s *store) GetQueues(ctx context.Context) ([]Queue, error) {
 	var queues []Queue
 	for rows.Next() {
 		var queue Queue
-		var timeoutSeconds int64
-		err := rows.Scan(
-			&queue.Name,
-			&timeoutSeconds,
-			&queue.CreatedAt,
-			&queue.UpdatedAt,
-		)
-		if err != nil {
+		if err := scanQueue(rows, &queue); err != nil {
 			return nil, fmt.Errorf("error scanning queue: %w", err)
 		}
-		queue.TaskTimeout = time.Duration(timeoutSeconds) * time.Second
 		queues = append(queues, queue)
 	}
 
@@ -133,89 +259,1172 @@ func (s *store) GetQueues(ctx context.Context) ([]Queue, error) {
 	return queues, nil
 }
 
+// SetQueuePaused pauses or resumes handing out the tasks of a queue, waking up the claims
+// waiting on it when it is resumed
+func (s *store) SetQueuePaused(ctx context.Context, name string, paused bool) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `UPDATE queues SET paused = $1, updated_at = NOW() WHERE name = $2`, paused, name)
+	if err != nil {
+		return fmt.Errorf("error updating queue: %w", err)
+	}
+	if updated, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("error updating queue: %w", err)
+	} else if updated == 0 {
+		return ErrQueueNotFound
+	}
+	if !paused {
+		if err := notifyQueue(ctx, tx, name); err != nil {
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// movedReferences are the statements moving the tasks, archived or not, the dead letters, the
+// schedules and the batch callbacks of the queue named by the placeholder from to the queue
+// named by to
+func movedReferences(from, to string) []string {
+	statements := []string{
+		"UPDATE schedules SET queue_name = " + to + " WHERE queue_name = " + from,
+		"UPDATE batches SET callback_queue = " + to + " WHERE callback_queue = " + from,
+	}
+	for _, table := range []string{"tasks", "tasks_archive"} {
+		statements = append(statements,
+			"UPDATE "+table+" SET queue_name = "+to+" WHERE queue_name = "+from,
+			"UPDATE "+table+" SET original_queue = "+to+" WHERE original_queue = "+from)
+	}
+	return statements
+}
+
+// DeleteQueue removes a queue. With DeleteQueueRestrict it is refused while the queue has pending
+// or running tasks, DeleteQueueCascade soft-deletes them and DeleteQueueMove moves every task to
+// target. Finished tasks left behind are archived and their dead letters can no longer be redriven.
+// The schedules of the queue are moved to target too, or deleted with the queue.
+func (s *store) DeleteQueue(ctx context.Context, name, mode, target string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// the lock keeps new tasks out of the queue until it is gone
+	var locked string
+	err = tx.QueryRowContext(ctx, `SELECT name FROM queues WHERE name = $1 FOR UPDATE`, name).Scan(&locked)
+	if err == sql.ErrNoRows {
+		return ErrQueueNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("error locking queue: %w", err)
+	}
+
+	var dependents int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM queues WHERE dead_letter_queue = $1`, name).Scan(&dependents); err != nil {
+		return fmt.Errorf("error checking dependent queues: %w", err)
+	}
+	if dependents > 0 {
+		return ErrQueueInUse
+	}
+
+	switch mode {
+	case DeleteQueueRestrict:
+		var active int
+		if err := tx.QueryRowContext(ctx, `
+            SELECT COUNT(*) FROM tasks WHERE queue_name = $1 AND status IN ('pending', 'running', 'blocked')`,
+			name).Scan(&active); err != nil {
+			return fmt.Errorf("error counting active tasks: %w", err)
+		}
+		if active > 0 {
+			return ErrQueueNotEmpty
+		}
+	case DeleteQueueCascade:
+		previous, err := lockTasks(ctx, tx, "queue_name = $1 AND status IN ('pending', 'running', 'blocked')", name)
+		if err != nil {
+			return err
+		}
+		deleted := make([]string, len(previous))
+		for i := range previous {
+			deleted[i] = previous[i].ID
+		}
+		if _, err := tx.ExecContext(ctx, `
+            UPDATE tasks SET status = 'deleted', updated_at = NOW() WHERE id = ANY($1)`, pq.Array(deleted)); err != nil {
+			return fmt.Errorf("error deleting tasks: %w", err)
+		}
+		if err := insertEvents(ctx, tx, deleteEvents(ctx, previous)...); err != nil {
+			return err
+		}
+		if err := finishAttempts(ctx, tx, AttemptCancelled, nil, deleted...); err != nil {
+			return err
+		}
+		if err := settleFinished(ctx, tx, deleted...); err != nil {
+			return err
+		}
+	case DeleteQueueMove:
+	default:
+		return fmt.Errorf("unknown delete mode %s", mode)
+	}
+
+	statements, args := movedReferences("$1", "$2"), []interface{}{name, target}
+	if mode != DeleteQueueMove {
+		statements = []string{`
+            WITH moved AS (
+                DELETE FROM tasks WHERE queue_name = $1
+                RETURNING ` + taskColumns + `
+            )
+            INSERT INTO tasks_archive (` + taskColumns + `, archived_at)
+            SELECT ` + taskColumns + `, NOW() FROM moved`,
+			`UPDATE tasks SET original_queue = NULL WHERE original_queue = $1`,
+		}
+		args = args[:1]
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement, args...); err != nil {
+			return fmt.Errorf("error moving tasks: %w", err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM queues WHERE name = $1`, name); err != nil {
+		return fmt.Errorf("error deleting queue: %w", err)
+	}
+	if mode == DeleteQueueMove {
+		if err := notifyQueue(ctx, tx, target); err != nil {
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// RenameQueue moves a queue, its tasks, dead letters and schedules to newName, updating the queues
+// using it as dead-letter queue
+func (s *store) RenameQueue(ctx context.Context, name, newName string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+        INSERT INTO queues (name, `+queueSettingColumns+`, updated_at)
+        SELECT $2, `+queueSettingColumns+`, NOW()
+        FROM queues
+        WHERE name = $1
+        FOR UPDATE
+        ON CONFLICT (name) DO NOTHING`,
+		name, newName)
+	if err != nil {
+		return fmt.Errorf("error copying queue: %w", err)
+	}
+	if copied, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("error copying queue: %w", err)
+	} else if copied == 0 {
+		var count int
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM queues WHERE name = $1`, name).Scan(&count); err != nil {
+			return fmt.Errorf("error checking queue: %w", err)
+		}
+		if count == 0 {
+			return ErrQueueNotFound
+		}
+		return ErrQueueExists
+	}
+
+	statements := append(movedReferences("$1", "$2"),
+		`UPDATE queues SET dead_letter_queue = $2 WHERE dead_letter_queue = $1`)
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement, name, newName); err != nil {
+			return fmt.Errorf("error renaming queue: %w", err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM queues WHERE name = $1`, name); err != nil {
+		return fmt.Errorf("error deleting queue: %w", err)
+	}
+	if err := notifyQueue(ctx, tx, newName); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// dbtx is implemented by both *sql.DB and *sql.Tx
+type dbtx interface {
+	execer
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
 func (s *store) CreateTask(ctx context.Context, task *Task) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := insertDependentTask(ctx, tx, task); err != nil {
+		return err
+	}
+	if err := notifyQueue(ctx, tx, task.QueueName); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// insertDependentTask inserts a task and its dependencies, taking its status from its parents.
+// The parents are locked until the transaction ends so none of them can finish without
+// seeing the new task.
+func insertDependentTask(ctx context.Context, tx *sql.Tx, task *Task) error {
+	if len(task.DependsOn) == 0 {
+		return insertTask(ctx, tx, task)
+	}
+
+	parents := make(map[string]string, len(task.DependsOn))
+	for _, table := range []string{"tasks", "tasks_archive"} {
+		lock := " FOR SHARE"
+		if table == "tasks_archive" {
+			lock = ""
+		}
+		rows, err := tx.QueryContext(ctx, `
+            SELECT id, status FROM `+table+` WHERE id = ANY($1) ORDER BY id`+lock,
+			pq.Array(task.DependsOn))
+		if err != nil {
+			return fmt.Errorf("error checking dependencies: %w", err)
+		}
+		for rows.Next() {
+			var id, status string
+			if err := rows.Scan(&id, &status); err != nil {
+				rows.Close()
+				return fmt.Errorf("error scanning dependency: %w", err)
+			}
+			parents[id] = status
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("error iterating dependencies: %w", err)
+		}
+	}
+	for _, id := range task.DependsOn {
+		if _, ok := parents[id]; !ok {
+			return fmt.Errorf("%w: %s", ErrDependencyNotFound, id)
+		}
+	}
+
+	resolveDependencies(task, parents)
+	if err := insertTask(ctx, tx, task); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+        INSERT INTO task_dependencies (task_id, depends_on)
+        SELECT $1, unnest($2::text[])`, task.ID, pq.Array(task.DependsOn)); err != nil {
+		return fmt.Errorf("error inserting dependencies: %w", err)
+	}
+	return nil
+}
+
+// settleFinished finishes the batches of parentIDs and resolves the blocked tasks depending on
+// them, see resolveDependencies. Tasks failed or deleted by their parents settle in turn.
+// Blocked tasks are locked first, so of two parents finishing at once the last one sees the other.
+func settleFinished(ctx context.Context, tx *sql.Tx, parentIDs ...string) error {
+	for len(parentIDs) > 0 {
+		if err := finishBatches(ctx, tx, parentIDs); err != nil {
+			return err
+		}
+
+		rows, err := tx.QueryContext(ctx, `
+            SELECT id, queue_name
+            FROM tasks
+            WHERE status = 'blocked'
+                AND id IN (SELECT task_id FROM task_dependencies WHERE depends_on = ANY($1))
+            ORDER BY id
+            FOR UPDATE`, pq.Array(parentIDs))
+		if err != nil {
+			return fmt.Errorf("error finding dependent tasks: %w", err)
+		}
+		var blocked []Task
+		for rows.Next() {
+			var task Task
+			if err := rows.Scan(&task.ID, &task.QueueName); err != nil {
+				rows.Close()
+				return fmt.Errorf("error scanning dependent task: %w", err)
+			}
+			blocked = append(blocked, task)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("error iterating dependent tasks: %w", err)
+		}
+
+		parentIDs = nil
+		for i := range blocked {
+			task := &blocked[i]
+			parents, err := dependencyStatuses(ctx, tx, task.ID)
+			if err != nil {
+				return err
+			}
+			if !resolveDependencies(task, parents) {
+				continue
+			}
+
+			if _, err := tx.ExecContext(ctx, `
+                UPDATE tasks SET status = $1, error = $2, updated_at = NOW() WHERE id = $3`,
+				task.Status, task.Error, task.ID); err != nil {
+				return fmt.Errorf("error settling dependent task: %w", err)
+			}
+			previous := Task{Status: TaskStatusBlocked, QueueName: task.QueueName}
+			if err := insertEvents(ctx, tx, newTaskEvent(ctx, task, &previous)); err != nil {
+				return err
+			}
+			if task.Status == TaskStatusPending {
+				if err := notifyQueue(ctx, tx, task.QueueName); err != nil {
+					return err
+				}
+			} else {
+				parentIDs = append(parentIDs, task.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// dependencyStatuses returns the statuses of the tasks, archived or not, a task depends on
+func dependencyStatuses(ctx context.Context, tx *sql.Tx, id string) (map[string]string, error) {
+	rows, err := tx.QueryContext(ctx, `
+        SELECT d.depends_on, COALESCE(t.status, a.status)
+        FROM task_dependencies d
+        LEFT JOIN tasks t ON t.id = d.depends_on
+        LEFT JOIN tasks_archive a ON a.id = d.depends_on
+        WHERE d.task_id = $1 AND (t.id IS NOT NULL OR a.id IS NOT NULL)`, id)
+	if err != nil {
+		return nil, fmt.Errorf("error loading dependencies: %w", err)
+	}
+	defer rows.Close()
+
+	statuses := make(map[string]string)
+	for rows.Next() {
+		var parent, status string
+		if err := rows.Scan(&parent, &status); err != nil {
+			return nil, fmt.Errorf("error scanning dependency: %w", err)
+		}
+		statuses[parent] = status
+	}
+	return statuses, rows.Err()
+}
+
+func insertTask(ctx context.Context, q dbtx, task *Task) error {
 	query := `
-		INSERT INTO tasks (id, queue_name, status, data, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, NOW(), NOW())
+		INSERT INTO tasks (id, queue_name, status, priority, data, run_at, idempotency_key, batch_id, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW(), NOW())
 		RETURNING created_at, updated_at`
 
-	return s.db.QueryRowContext(ctx, query, task.ID, task.QueueName, task.Status, task.Data).
+	defaultData(task)
+	err := q.QueryRowContext(ctx, query, task.ID, task.QueueName, task.Status, task.Priority, task.Data,
+		task.RunAt, task.IdempotencyKey, task.BatchID).
 		Scan(&task.CreatedAt, &task.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	return insertEvents(ctx, q, newTaskEvent(ctx, task, nil))
+}
+
+// insertTasks inserts tasks with a single multi-row statement
+func insertTasks(ctx context.Context, tx *sql.Tx, tasks []*Task) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	values := make([]string, 0, len(tasks))
+	args := make([]interface{}, 0, len(tasks)*8)
+	byID := make(map[string]*Task, len(tasks))
+	events := make([]*TaskEvent, 0, len(tasks))
+	for _, task := range tasks {
+		defaultData(task)
+		n := len(args)
+		values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, NOW(), NOW())",
+			n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8))
+		args = append(args, task.ID, task.QueueName, task.Status, task.Priority, task.Data,
+			task.RunAt, task.IdempotencyKey, task.BatchID)
+		byID[task.ID] = task
+		events = append(events, newTaskEvent(ctx, task, nil))
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		INSERT INTO tasks (id, queue_name, status, priority, data, run_at, idempotency_key, batch_id, created_at, updated_at)
+		VALUES `+strings.Join(values, ", ")+`
+		RETURNING id, created_at, updated_at`, args...)
+	if err != nil {
+		return fmt.Errorf("error inserting tasks: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var createdAt, updatedAt time.Time
+		if err := rows.Scan(&id, &createdAt, &updatedAt); err != nil {
+			return fmt.Errorf("error scanning task: %w", err)
+		}
+		byID[id].CreatedAt = createdAt
+		byID[id].UpdatedAt = updatedAt
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return insertEvents(ctx, tx, events...)
+}
+
+// lockIdempotencyKey serializes the creations sharing the key of the task until the transaction ends
+func lockIdempotencyKey(ctx context.Context, tx *sql.Tx, queueName, key string) error {
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, queueName+"/"+key)
+	if err != nil {
+		return fmt.Errorf("error locking idempotency key: %w", err)
+	}
+	return nil
+}
+
+// findByIdempotencyKey returns the task holding the idempotency key of task, if any
+func findByIdempotencyKey(ctx context.Context, tx *sql.Tx, task *Task, ttl time.Duration) (*Task, error) {
+	var existing Task
+	err := scanTask(tx.QueryRowContext(ctx, `
+        SELECT `+taskColumns+`
+        FROM tasks
+        WHERE queue_name = $1 AND idempotency_key = $2 AND status <> $3
+            AND (status IN ($4, $5, $6) OR created_at >= NOW() - $7 * INTERVAL '1 second')
+        ORDER BY created_at DESC
+        LIMIT 1`,
+		task.QueueName, *task.IdempotencyKey, TaskStatusDeleted,
+		TaskStatusPending, TaskStatusRunning, TaskStatusBlocked, int64(ttl.Seconds()),
+	), &existing)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error checking idempotency key: %w", err)
+	}
+	return &existing, nil
+}
+
+// CreateUniqueTask inserts the task unless another task of the same queue holds its
+// idempotency key, either because it is still active or because it was
+// created within the ttl. In that case the existing task is loaded into task and false is returned.
+func (s *store) CreateUniqueTask(ctx context.Context, task *Task, ttl time.Duration) (bool, error) {
+	if task.IdempotencyKey == nil {
+		return true, s.CreateTask(ctx, task)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err = lockIdempotencyKey(ctx, tx, task.QueueName, *task.IdempotencyKey); err != nil {
+		return false, err
+	}
+
+	existing, err := findByIdempotencyKey(ctx, tx, task, ttl)
+	if err != nil {
+		return false, err
+	}
+	if existing != nil {
+		*task = *existing
+		return false, tx.Commit()
+	}
+
+	if err = insertDependentTask(ctx, tx, task); err != nil {
+		return false, err
+	}
+	if err = notifyQueue(ctx, tx, task.QueueName); err != nil {
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing transaction: %w", err)
+	}
+	return true, nil
+}
+
+// CreateTasks inserts several tasks in a single transaction. Tasks with an idempotency key
+// follow the rules of CreateUniqueTask, using the TTL of their queue in idempotencyTTLs.
+// The returned slices tell which tasks were created, the others hold the existing task, and
+// which ones were skipped because they depend on a missing task, see ErrDependencyNotFound.
+func (s *store) CreateTasks(ctx context.Context, tasks []*Task, idempotencyTTLs map[string]time.Duration) ([]bool, []error, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// lock idempotency keys in a stable order so concurrent batches cannot deadlock
+	var keyed []*Task
+	for _, task := range tasks {
+		if task.IdempotencyKey != nil {
+			keyed = append(keyed, task)
+		}
+	}
+	sort.Slice(keyed, func(i, j int) bool {
+		if keyed[i].QueueName != keyed[j].QueueName {
+			return keyed[i].QueueName < keyed[j].QueueName
+		}
+		return *keyed[i].IdempotencyKey < *keyed[j].IdempotencyKey
+	})
+	for _, task := range keyed {
+		if err := lockIdempotencyKey(ctx, tx, task.QueueName, *task.IdempotencyKey); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	created := make([]bool, len(tasks))
+	errs := make([]error, len(tasks))
+	var plain []*Task
+	notify := make(map[string]bool)
+	for i, task := range tasks {
+		if task.IdempotencyKey == nil && len(task.DependsOn) == 0 {
+			plain = append(plain, task)
+			created[i] = true
+			notify[task.QueueName] = true
+			continue
+		}
+
+		if task.IdempotencyKey != nil {
+			existing, err := findByIdempotencyKey(ctx, tx, task, idempotencyTTLs[task.QueueName])
+			if err != nil {
+				return nil, nil, err
+			}
+			if existing != nil {
+				*task = *existing
+				continue
+			}
+		}
+		// dependencies are checked before anything is written, the transaction goes on
+		err := insertDependentTask(ctx, tx, task)
+		if errors.Is(err, ErrDependencyNotFound) {
+			errs[i] = err
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("error inserting task: %w", err)
+		}
+		created[i] = true
+		notify[task.QueueName] = true
+	}
+
+	if err := insertTaskChunks(ctx, tx, plain); err != nil {
+		return nil, nil, err
+	}
+
+	for queueName := range notify {
+		if err := notifyQueue(ctx, tx, queueName); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+	return created, errs, nil
+}
+
+// insertTaskChunks inserts tasks with insertTasks, keeping statements below the protocol
+// limit of 65535 parameters
+func insertTaskChunks(ctx context.Context, tx *sql.Tx, tasks []*Task) error {
+	const insertChunk = 1000
+	for start := 0; start < len(tasks); start += insertChunk {
+		end := start + insertChunk
+		if end > len(tasks) {
+			end = len(tasks)
+		}
+		if err := insertTasks(ctx, tx, tasks[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (s *store) UpdateTask(ctx context.Context, task *Task) error {
+	return s.updateTask(ctx, task, "")
+}
+
+// UpdateClaimedTask updates a task only while it is still running for clientID in the
+// given attempt, returning ErrLeaseLost when the claim was lost in the meantime
+func (s *store) UpdateClaimedTask(ctx context.Context, task *Task, clientID string, attempt int) error {
+	err := s.updateTask(ctx, task, " AND status = $14 AND assigned_to = $15 AND attempt = $16",
+		TaskStatusRunning, clientID, attempt)
+	if err == sql.ErrNoRows {
+		return ErrLeaseLost
+	}
+	return err
+}
+
+// updateTask writes the mutable fields of a task, all but its data, optionally guarded by extra conditions
+func (s *store) updateTask(ctx context.Context, task *Task, guard string, guardArgs ...interface{}) error {
 	query := `
 		UPDATE tasks 
 		SET status = $1,
-			data = $2,
+			result = $2,
 			assigned_to = $3,
 			started_at = $4,
 			completed_at = $5,
+			attempt = $6,
+			next_attempt_at = $7,
+			queue_name = $8,
+			original_queue = $9,
+			priority = $10,
+			lease_expires_at = $11,
+			error = $13,
 			updated_at = NOW()
-		WHERE id = $6
-		RETURNING created_at, updated_at`
+		WHERE id = $12` + guard + `
+		RETURNING created_at, updated_at, (SELECT max_running FROM queues WHERE name = tasks.queue_name)`
 
-	return s.db.QueryRowContext(ctx, query,
-		task.Status, task.Data, task.AssignedTo, task.StartedAt, task.CompletedAt, task.ID).
-		Scan(&task.CreatedAt, &task.UpdatedAt)
+	args := []interface{}{
+		task.Status, nullJSONValue(task.Result), task.AssignedTo, task.StartedAt, task.CompletedAt,
+		task.Attempt, task.NextAttemptAt, task.QueueName, task.OriginalQueue, task.Priority,
+		task.LeaseExpiresAt, task.ID, task.Error,
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	previous, err := lockTasks(ctx, tx, "id = $1", task.ID)
+	if err != nil {
+		return err
+	}
+	if len(previous) == 0 {
+		return sql.ErrNoRows
+	}
+
+	var maxRunning int
+	err = tx.QueryRowContext(ctx, query, append(args, guardArgs...)...).
+		Scan(&task.CreatedAt, &task.UpdatedAt, &maxRunning)
+	if err != nil {
+		return err
+	}
+	if err := insertEvents(ctx, tx, newTaskEvent(ctx, task, &previous[0])); err != nil {
+		return err
+	}
+
+	if outcome := attemptOutcome(task); outcome != "" {
+		if err := finishAttempts(ctx, tx, outcome, attemptError(task, outcome), task.ID); err != nil {
+			return err
+		}
+	}
+	if settlesDependents(task.Status) {
+		if err := settleFinished(ctx, tx, task.ID); err != nil {
+			return err
+		}
+	}
+
+	// requeued tasks may be waited for, as well as the slot a finished task frees in a limited queue
+	if task.Status == TaskStatusPending || (task.Status != TaskStatusRunning && maxRunning > 0) {
+		if err := notifyQueue(ctx, tx, task.QueueName); err != nil {
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
 }
 
-func (s *store) GetTask(ctx context.Context, id string) (*Task, error) {
-	task := &Task{}
-	query := `
-		SELECT id, queue_name, status, data, assigned_to, created_at, updated_at, started_at, completed_at
-		FROM tasks
-		WHERE id = $1`
+func (s *store) GetTask(ctx context.Context, id string) (*Task, error) {
+	task := &Task{}
+	query := `
+		SELECT ` + taskColumns + `
+		FROM tasks
+		WHERE id = $1`
+
+	err := scanTask(s.db.QueryRowContext(ctx, query, id), task)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.QueryRowContext(ctx, `
+        SELECT array_agg(depends_on ORDER BY depends_on) FROM task_dependencies WHERE task_id = $1`,
+		id).Scan(pq.Array(&task.DependsOn))
+	if err != nil {
+		return nil, fmt.Errorf("error loading dependencies: %w", err)
+	}
+	return task, nil
+}
+
+// GetTaskGraph returns the workflow of a task, archived tasks included, or nil when the task
+// does not exist
+func (s *store) GetTaskGraph(ctx context.Context, id string) (*TaskGraph, error) {
+	ids, edges, err := walkDependencies(id, func(ids []string) ([]TaskDependency, error) {
+		rows, err := s.db.QueryContext(ctx, `
+            SELECT task_id, depends_on FROM task_dependencies
+            WHERE task_id = ANY($1) OR depends_on = ANY($1)`, pq.Array(ids))
+		if err != nil {
+			return nil, fmt.Errorf("error loading dependencies: %w", err)
+		}
+		defer rows.Close()
+
+		var edges []TaskDependency
+		for rows.Next() {
+			var edge TaskDependency
+			if err := rows.Scan(&edge.TaskID, &edge.DependsOn); err != nil {
+				return nil, fmt.Errorf("error scanning dependency: %w", err)
+			}
+			edges = append(edges, edge)
+		}
+		return edges, rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT `+taskColumns+` FROM tasks WHERE id = ANY($1)
+        UNION ALL
+        SELECT `+taskColumns+` FROM tasks_archive WHERE id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("error loading workflow: %w", err)
+	}
+	defer rows.Close()
+
+	var tasks []Task
+	found := false
+	for rows.Next() {
+		var task Task
+		if err := scanTask(rows, &task); err != nil {
+			return nil, fmt.Errorf("error scanning task: %w", err)
+		}
+		found = found || task.ID == id
+		tasks = append(tasks, task)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating workflow: %w", err)
+	}
+	if !found {
+		return nil, nil
+	}
+	return newTaskGraph(tasks, edges), nil
+}
+
+// GetTaskAttempts returns the attempts of a task, archived or not, oldest first, or nil when the
+// task does not exist
+func (s *store) GetTaskAttempts(ctx context.Context, id string) ([]TaskAttempt, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, `
+        SELECT EXISTS (SELECT 1 FROM tasks WHERE id = $1) OR EXISTS (SELECT 1 FROM tasks_archive WHERE id = $1)`,
+		id).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("error checking task: %w", err)
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT `+attemptColumns+` FROM task_attempts WHERE task_id = $1 ORDER BY id`, id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting attempts: %w", err)
+	}
+	defer rows.Close()
+
+	attempts := []TaskAttempt{}
+	for rows.Next() {
+		var attempt TaskAttempt
+		if err := rows.Scan(&attempt.TaskID, &attempt.Attempt, &attempt.ClientID, &attempt.Outcome,
+			&attempt.Error, &attempt.StartedAt, &attempt.FinishedAt); err != nil {
+			return nil, fmt.Errorf("error scanning attempt: %w", err)
+		}
+		attempt.setDuration()
+		attempts = append(attempts, attempt)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating attempts: %w", err)
+	}
+	return attempts, nil
+}
+
+// startAttempts records an attempt for each task just claimed
+func startAttempts(ctx context.Context, tx *sql.Tx, ids []string) error {
+	_, err := tx.ExecContext(ctx, `
+        INSERT INTO task_attempts (task_id, attempt, client_id, started_at)
+        SELECT id, attempt, assigned_to, started_at FROM tasks WHERE id = ANY($1)`,
+		pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("error recording attempts: %w", err)
+	}
+	return nil
+}
+
+// finishAttempts closes the running attempt of the given tasks, if any, with outcome
+func finishAttempts(ctx context.Context, tx *sql.Tx, outcome string, taskErr *TaskError, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, `
+        UPDATE task_attempts
+        SET outcome = $1, error = $2, finished_at = $3
+        WHERE task_id = ANY($4) AND finished_at IS NULL`,
+		outcome, taskErr, time.Now(), pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("error finishing attempts: %w", err)
+	}
+	return nil
+}
+
+// GetTaskEvents returns the events matching the filter, newest first
+func (s *store) GetTaskEvents(ctx context.Context, filter TaskEventFilter) ([]TaskEvent, error) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if filter.TaskID != "" {
+		add("task_id = $%d", filter.TaskID)
+	}
+	if filter.QueueName != "" {
+		add("queue_name = $%d", filter.QueueName)
+	}
+	if filter.ActorType != "" {
+		add("actor_type = $%d", filter.ActorType)
+	}
+	if filter.Actor != "" {
+		add("actor = $%d", filter.Actor)
+	}
+	if !filter.FromDate.IsZero() {
+		add("created_at >= $%d", filter.FromDate)
+	}
+	if !filter.ToDate.IsZero() {
+		add("created_at <= $%d", filter.ToDate)
+	}
+
+	query := `SELECT ` + eventColumns + ` FROM task_events`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT $%d OFFSET $%d", len(args)+1, len(args)+2)
+	args = append(args, filter.Limit, filter.Offset)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error getting events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []TaskEvent{}
+	for rows.Next() {
+		var event TaskEvent
+		if err := rows.Scan(&event.ID, &event.TaskID, &event.QueueName, &event.Type, &event.FromStatus,
+			&event.ToStatus, nullJSON{&event.Details}, &event.Actor.Type, &event.Actor.Name,
+			&event.Actor.RequestID, &event.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning event: %w", err)
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating events: %w", err)
+	}
+	return events, nil
+}
+
+// insertEvents appends the given events to the task event log, skipping nil ones
+func insertEvents(ctx context.Context, e execer, events ...*TaskEvent) error {
+	const chunk = 1000
+	var values []string
+	var args []interface{}
+	flush := func() error {
+		if len(values) == 0 {
+			return nil
+		}
+		_, err := e.ExecContext(ctx, `
+            INSERT INTO task_events (task_id, queue_name, type, from_status, to_status, details, actor_type, actor, request_id)
+            VALUES `+strings.Join(values, ", "), args...)
+		values, args = values[:0], args[:0]
+		if err != nil {
+			return fmt.Errorf("error recording events: %w", err)
+		}
+		return nil
+	}
+
+	for _, event := range events {
+		if event == nil {
+			continue
+		}
+		n := len(args)
+		values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)",
+			n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8, n+9))
+		args = append(args, event.TaskID, event.QueueName, event.Type, event.FromStatus, event.ToStatus,
+			nullJSONValue(event.Details), event.Actor.Type, event.Actor.Name, event.Actor.RequestID)
+		if len(values) == chunk {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// lockTasks locks the tasks matching condition and returns their state before a change
+func lockTasks(ctx context.Context, tx *sql.Tx, condition string, args ...interface{}) ([]Task, error) {
+	rows, err := tx.QueryContext(ctx, `
+        SELECT `+taskColumns+` FROM tasks WHERE `+condition+` FOR UPDATE`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error locking tasks: %w", err)
+	}
+	defer rows.Close()
+
+	var tasks []Task
+	for rows.Next() {
+		var task Task
+		if err := scanTask(rows, &task); err != nil {
+			return nil, fmt.Errorf("error scanning task: %w", err)
+		}
+		tasks = append(tasks, task)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating tasks: %w", err)
+	}
+	return tasks, nil
+}
+
+// CreateBatch inserts a batch and its tasks in a single transaction, setting the BatchID of
+// the tasks. Tasks with dependencies take their status from their parents as in CreateTask.
+func (s *store) CreateBatch(ctx context.Context, batch *Batch, tasks []*Task) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	batch.Total = len(tasks)
+	if batch.CallbackData == nil {
+		batch.CallbackData = json.RawMessage(`{}`)
+	}
+	err = tx.QueryRowContext(ctx, `
+        INSERT INTO batches (id, callback_queue, callback_data, total, created_at, updated_at)
+        VALUES ($1, $2, $3, $4, NOW(), NOW())
+        RETURNING created_at, updated_at`,
+		batch.ID, batch.CallbackQueue, batch.CallbackData, batch.Total).
+		Scan(&batch.CreatedAt, &batch.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("error inserting batch: %w", err)
+	}
+
+	var plain []*Task
+	ids := make([]string, 0, len(tasks))
+	notify := make(map[string]bool)
+	for _, task := range tasks {
+		task.BatchID = &batch.ID
+		ids = append(ids, task.ID)
+		notify[task.QueueName] = true
+		if len(task.DependsOn) == 0 {
+			plain = append(plain, task)
+			continue
+		}
+		if err := insertDependentTask(ctx, tx, task); err != nil {
+			return fmt.Errorf("error inserting task: %w", err)
+		}
+	}
+	if err := insertTaskChunks(ctx, tx, plain); err != nil {
+		return err
+	}
+
+	// tasks failed by their dependencies on creation may have finished the batch already
+	if err := finishBatches(ctx, tx, ids); err != nil {
+		return err
+	}
+	for queueName := range notify {
+		if err := notifyQueue(ctx, tx, queueName); err != nil {
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+const batchColumns = `id, callback_queue, callback_data, callback_task_id, total, finished_at, created_at, updated_at`
+
+func scanBatch(row rowScanner, batch *Batch) error {
+	return row.Scan(&batch.ID, &batch.CallbackQueue, &batch.CallbackData, &batch.CallbackTaskID,
+		&batch.Total, &batch.FinishedAt, &batch.CreatedAt, &batch.UpdatedAt)
+}
+
+// GetBatch returns a batch with the counts of its tasks, archived tasks included, or nil when
+// the batch does not exist
+func (s *store) GetBatch(ctx context.Context, id string) (*Batch, error) {
+	batch := &Batch{}
+	err := scanBatch(s.db.QueryRowContext(ctx, `SELECT `+batchColumns+` FROM batches WHERE id = $1`, id), batch)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting batch: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT status, COUNT(*)
+        FROM (SELECT status FROM tasks WHERE batch_id = $1
+            UNION ALL
+            SELECT status FROM tasks_archive WHERE batch_id = $1) AS tasks
+        GROUP BY status`, id)
+	if err != nil {
+		return nil, fmt.Errorf("error counting batch tasks: %w", err)
+	}
+	defer rows.Close()
+
+	batch.Counts = newBatchCounts()
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, fmt.Errorf("error scanning batch count: %w", err)
+		}
+		batch.Counts[status] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating batch counts: %w", err)
+	}
+	return batch, nil
+}
+
+// finishBatches finishes the unfinished batches of taskIDs that have no pending, blocked or
+// running task left, enqueueing their callback task. Batches are locked first, so of two tasks
+// finishing at once the last one sees the other.
+func finishBatches(ctx context.Context, tx *sql.Tx, taskIDs []string) error {
+	rows, err := tx.QueryContext(ctx, `
+        SELECT `+batchColumns+`
+        FROM batches
+        WHERE finished_at IS NULL
+            AND id IN (SELECT batch_id FROM tasks WHERE id = ANY($1))
+        ORDER BY id
+        FOR UPDATE`, pq.Array(taskIDs))
+	if err != nil {
+		return fmt.Errorf("error finding batches: %w", err)
+	}
+	var batches []Batch
+	for rows.Next() {
+		var batch Batch
+		if err := scanBatch(rows, &batch); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning batch: %w", err)
+		}
+		batches = append(batches, batch)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating batches: %w", err)
+	}
+
+	for _, batch := range batches {
+		var active bool
+		err := tx.QueryRowContext(ctx, `
+            SELECT EXISTS (SELECT 1 FROM tasks WHERE batch_id = $1 AND status IN ('pending', 'blocked', 'running'))`,
+			batch.ID).Scan(&active)
+		if err != nil {
+			return fmt.Errorf("error checking batch tasks: %w", err)
+		}
+		if active {
+			continue
+		}
+
+		if batch.CallbackQueue != nil {
+			var exists bool
+			err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM queues WHERE name = $1)`,
+				*batch.CallbackQueue).Scan(&exists)
+			if err != nil {
+				return fmt.Errorf("error checking callback queue: %w", err)
+			}
+			if exists {
+				task := batch.callbackTask()
+				if err := insertTask(ctx, tx, &task); err != nil {
+					return fmt.Errorf("error enqueuing callback of batch %s: %w", batch.ID, err)
+				}
+				if err := notifyQueue(ctx, tx, task.QueueName); err != nil {
+					return err
+				}
+				batch.CallbackTaskID = &task.ID
+			}
+		}
 
-	err := s.db.QueryRowContext(ctx, query, id).
-		Scan(&task.ID, &task.QueueName, &task.Status, &task.Data, &task.AssignedTo,
-			&task.CreatedAt, &task.UpdatedAt, &task.StartedAt, &task.CompletedAt)
-	if err == sql.ErrNoRows {
-		return nil, nil
-	}
-	if err != nil {
-		return nil, err
+		if _, err := tx.ExecContext(ctx, `
+            UPDATE batches SET finished_at = NOW(), updated_at = NOW(), callback_task_id = $2 WHERE id = $1`,
+			batch.ID, batch.CallbackTaskID); err != nil {
+			return fmt.Errorf("error finishing batch: %w", err)
+		}
 	}
-	return task, nil
+	return nil
 }
 
-func (s *store) GetTasks(ctx context.Context, filter TaskFilter) ([]Task, error) {
+// filterConditions builds the WHERE conditions and their arguments for a task filter
+func filterConditions(filter TaskFilter) ([]string, []interface{}) {
 	var conditions []string
 	var args []interface{}
-	argCount := 1
 
 	if filter.QueueName != "" {
-		conditions = append(conditions, fmt.Sprintf("queue_name = $%d", argCount))
 		args = append(args, filter.QueueName)
-		argCount++
+		conditions = append(conditions, fmt.Sprintf("queue_name = $%d", len(args)))
 	}
 
 	if filter.Status != "" {
-		conditions = append(conditions, fmt.Sprintf("status = $%d", argCount))
 		args = append(args, filter.Status)
-		argCount++
+		conditions = append(conditions, fmt.Sprintf("status = $%d", len(args)))
+	}
+
+	if filter.Priority != nil {
+		args = append(args, *filter.Priority)
+		conditions = append(conditions, fmt.Sprintf("priority = $%d", len(args)))
+	}
+
+	if filter.BatchID != "" {
+		args = append(args, filter.BatchID)
+		conditions = append(conditions, fmt.Sprintf("batch_id = $%d", len(args)))
 	}
 
 	if !filter.FromDate.IsZero() {
-		conditions = append(conditions, fmt.Sprintf("created_at >= $%d", argCount))
 		args = append(args, filter.FromDate)
-		argCount++
+		conditions = append(conditions, fmt.Sprintf("created_at >= $%d", len(args)))
 	}
 
 	if !filter.ToDate.IsZero() {
-		conditions = append(conditions, fmt.Sprintf("created_at <= $%d", argCount))
 		args = append(args, filter.ToDate)
-		argCount++
+		conditions = append(conditions, fmt.Sprintf("created_at <= $%d", len(args)))
 	}
 
-	query := "SELECT id, queue_name, status, data, assigned_to, created_at, updated_at, started_at, completed_at FROM tasks"
+	return conditions, args
+}
+
+func (s *store) GetTasks(ctx context.Context, filter TaskFilter) ([]Task, error) {
+	conditions, args := filterConditions(filter)
+	argCount := len(args) + 1
+
+	query := "SELECT " + taskColumns + " FROM " + taskSource(filter)
 	if len(conditions) > 0 {
 		query += " WHERE " + strings.Join(conditions, " AND ")
 	}
 
-	// Sorting
+	// Sorting, a leading "-" sorts in descending order
 	if filter.SortBy != "" {
-		query += fmt.Sprintf(" ORDER BY %s", pq.QuoteIdentifier(filter.SortBy))
+		column, direction := filter.SortBy, "ASC"
+		if strings.HasPrefix(column, "-") {
+			column, direction = column[1:], "DESC"
+		}
+		query += fmt.Sprintf(" ORDER BY %s %s, created_at DESC", pq.QuoteIdentifier(column), direction)
 	} else {
 		query += " ORDER BY created_at DESC"
 	}
@@ -233,11 +1442,7 @@ func (s *store) GetTasks(ctx context.Context, filter TaskFilter) ([]Task, error)
 	var tasks []Task
 	for rows.Next() {
 		var task Task
-		err := rows.Scan(
-			&task.ID, &task.QueueName, &task.Status, &task.Data, &task.AssignedTo,
-			&task.CreatedAt, &task.UpdatedAt, &task.StartedAt, &task.CompletedAt,
-		)
-		if err != nil {
+		if err := scanTask(rows, &task); err != nil {
 			return nil, err
 		}
 		tasks = append(tasks, task)
@@ -247,33 +1452,7 @@ func (s *store) GetTasks(ctx context.Context, filter TaskFilter) ([]Task, error)
 }
 
 func (s *store) GetTaskStats(ctx context.Context, filter TaskFilter) (map[string]int, error) {
-	var conditions []string
-	var args []interface{}
-	argCount := 1
-
-	if filter.QueueName != "" {
-		conditions = append(conditions, fmt.Sprintf("queue_name = $%d", argCount))
-		args = append(args, filter.QueueName)
-		argCount++
-	}
-
-	if filter.Status != "" {
-		conditions = append(conditions, fmt.Sprintf("status = $%d", argCount))
-		args = append(args, filter.Status)
-		argCount++
-	}
-
-	if !filter.FromDate.IsZero() {
-		conditions = append(conditions, fmt.Sprintf("created_at >= $%d", argCount))
-		args = append(args, filter.FromDate)
-		argCount++
-	}
-
-	if !filter.ToDate.IsZero() {
-		conditions = append(conditions, fmt.Sprintf("created_at <= $%d", argCount))
-		args = append(args, filter.ToDate)
-		argCount++
-	}
+	conditions, args := filterConditions(filter)
 
 	whereClause := ""
 	if len(conditions) > 0 {
@@ -284,16 +1463,20 @@ func (s *store) GetTaskStats(ctx context.Context, filter TaskFilter) (map[string
         SELECT 
             COUNT(*) as total,
             COUNT(CASE WHEN status = 'pending' THEN 1 END) as pending,
+            COUNT(CASE WHEN status = 'blocked' THEN 1 END) as blocked,
+            COUNT(CASE WHEN status = 'pending' AND (run_at > NOW() OR next_attempt_at > NOW()) THEN 1 END) as scheduled,
             COUNT(CASE WHEN status = 'running' THEN 1 END) as running,
             COUNT(CASE WHEN status = 'completed' THEN 1 END) as completed,
             COUNT(CASE WHEN status = 'failed' THEN 1 END) as failed,
             COUNT(CASE WHEN status = 'deleted' THEN 1 END) as deleted
-        FROM tasks
-        %s`, whereClause)
+        FROM %s
+        %s`, taskSource(filter), whereClause)
 
 	var stats struct {
 		Total     int
 		Pending   int
+		Blocked   int
+		Scheduled int
 		Running   int
 		Completed int
 		Failed    int
@@ -303,6 +1486,8 @@ func (s *store) GetTaskStats(ctx context.Context, filter TaskFilter) (map[string
 	err := s.db.QueryRowContext(ctx, query, args...).Scan(
 		&stats.Total,
 		&stats.Pending,
+		&stats.Blocked,
+		&stats.Scheduled,
 		&stats.Running,
 		&stats.Completed,
 		&stats.Failed,
@@ -315,6 +1500,8 @@ func (s *store) GetTaskStats(ctx context.Context, filter TaskFilter) (map[string
 	return map[string]int{
 		"all":       stats.Total,
 		"pending":   stats.Pending,
+		"blocked":   stats.Blocked,
+		"scheduled": stats.Scheduled,
 		"running":   stats.Running,
 		"completed": stats.Completed,
 		"failed":    stats.Failed,
@@ -373,116 +1560,633 @@ func (s *store) GetTaskStats(ctx context.Context, filter TaskFilter) (map[string
 // }
 
 func (s *store) GetNextPendingTask(ctx context.Context, queueName, clientID string) (*Task, error) {
+	tasks, err := s.ClaimTasks(ctx, queueName, clientID, 1)
+	if err != nil || len(tasks) == 0 {
+		return nil, err
+	}
+	return &tasks[0], nil
+}
+
+// ClaimTasks assigns up to n due pending tasks of the queue to clientID in a single statement,
+// starting their lease with the queue task timeout. Tasks are returned in the order they are handed out.
+// Queues with max_running never get more running tasks than that, and queues with a rate hand
+// tasks out no faster, failing with a RateLimitError while it allows none.
+func (s *store) ClaimTasks(ctx context.Context, queueName, clientID string, n int) ([]Task, error) {
+	if n <= 0 {
+		return []Task{}, nil
+	}
+
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, fmt.Errorf("error starting transaction: %w", err)
 	}
 	defer tx.Rollback()
 
-	// get queue timeout
-	var queueTimeout int
+	// claims of a limited queue lock its row in turn so each one sees the tasks and tokens taken
+	// by the others. NO KEY UPDATE does not block enqueues, whose foreign key check takes a key share lock.
+	var maxRunning int
+	var bucket tokenBucket
+	var elapsedSeconds sql.NullFloat64
 	err = tx.QueryRowContext(ctx, `
-        SELECT task_timeout 
-        FROM queues 
-        WHERE name = $1`,
-		queueName,
-	).Scan(&queueTimeout)
-	if err != nil {
-		return nil, fmt.Errorf("error getting queue timeout: %w", err)
+        SELECT max_running, rate, burst, rate_tokens, EXTRACT(EPOCH FROM NOW() - rate_updated_at)
+        FROM queues
+        WHERE name = $1 AND (max_running > 0 OR rate > 0)
+        FOR NO KEY UPDATE`,
+		queueName).Scan(&maxRunning, &bucket.rate, &bucket.burst, &bucket.tokens, &elapsedSeconds)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("error locking queue: %w", err)
 	}
-
-	// get next pending task
-	task := &Task{}
-	err = tx.QueryRowContext(ctx, `
-        SELECT id, queue_name, status, data, created_at, updated_at
-        FROM tasks
-        WHERE queue_name = $1 AND status = $2 AND assigned_to IS NULL
-        ORDER BY created_at ASC
-        LIMIT 1
-        FOR UPDATE SKIP LOCKED`,
-		queueName, TaskStatusPending,
-	).Scan(&task.ID, &task.QueueName, &task.Status, &task.Data,
-		&task.CreatedAt, &task.UpdatedAt)
-	if err == sql.ErrNoRows {
-		return nil, nil
+	if maxRunning > 0 {
+		var running int
+		if err := tx.QueryRowContext(ctx, `
+            SELECT COUNT(*) FROM tasks WHERE queue_name = $1 AND status = $2`,
+			queueName, TaskStatusRunning).Scan(&running); err != nil {
+			return nil, fmt.Errorf("error counting running tasks: %w", err)
+		}
+		if running >= maxRunning {
+			return []Task{}, nil
+		}
+		n = min(n, maxRunning-running)
 	}
-	if err != nil {
-		return nil, fmt.Errorf("error getting next task: %w", err)
+	if bucket.rate > 0 {
+		var elapsed *time.Duration
+		if elapsedSeconds.Valid {
+			d := time.Duration(elapsedSeconds.Float64 * float64(time.Second))
+			elapsed = &d
+		}
+		bucket.refill(elapsed)
+		if n, err = bucket.take(n); err != nil {
+			return nil, err
+		}
 	}
 
 	now := time.Now()
-	task.Status = TaskStatusRunning
-	task.AssignedTo = &clientID
-	task.StartedAt = &now
-	task.UpdatedAt = now
-
-	// update task status with assigned client
-	_, err = tx.ExecContext(ctx, `
-        UPDATE tasks 
-        SET status = $1, 
-            assigned_to = $2, 
-            started_at = $3, 
-            updated_at = $3
-        WHERE id = $4`,
-		task.Status, task.AssignedTo, task.StartedAt, task.ID,
+	rows, err := tx.QueryContext(ctx, `
+        WITH next AS (
+            SELECT id
+            FROM tasks
+            WHERE queue_name = $1 AND status = $2 AND assigned_to IS NULL
+                AND (run_at IS NULL OR run_at <= NOW())
+                AND (next_attempt_at IS NULL OR next_attempt_at <= NOW())
+                AND NOT (SELECT paused FROM queues WHERE name = $1)
+            ORDER BY priority DESC, created_at ASC
+            LIMIT $3
+            FOR UPDATE SKIP LOCKED
+        )
+        UPDATE tasks
+        SET status = $4,
+            assigned_to = $5,
+            started_at = $6,
+            updated_at = $6,
+            attempt = attempt + 1,
+            next_attempt_at = NULL,
+            lease_expires_at = $6::timestamp + (SELECT task_timeout FROM queues WHERE name = $1) * INTERVAL '1 second'
+        WHERE id IN (SELECT id FROM next)
+        RETURNING `+taskColumns,
+		queueName, TaskStatusPending, n, TaskStatusRunning, clientID, now,
 	)
 	if err != nil {
-		return nil, fmt.Errorf("error updating task status: %w", err)
+		return nil, fmt.Errorf("error claiming tasks: %w", err)
+	}
+
+	tasks := []Task{}
+	var ids []string
+	for rows.Next() {
+		var task Task
+		if err := scanTask(rows, &task); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning task: %w", err)
+		}
+		tasks = append(tasks, task)
+		ids = append(ids, task.ID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating tasks: %w", err)
+	}
+
+	if len(ids) > 0 {
+		if err := startAttempts(ctx, tx, ids); err != nil {
+			return nil, err
+		}
+		if err := insertEvents(ctx, tx, claimEvents(ctx, tasks)...); err != nil {
+			return nil, err
+		}
+	}
+	if bucket.rate > 0 {
+		// only the tasks handed out spend tokens
+		bucket.tokens += float64(n - len(tasks))
+		if _, err := tx.ExecContext(ctx, `
+            UPDATE queues SET rate_tokens = $1, rate_updated_at = NOW() WHERE name = $2`,
+			bucket.tokens, queueName); err != nil {
+			return nil, fmt.Errorf("error updating rate limit: %w", err)
+		}
 	}
 
 	if err = tx.Commit(); err != nil {
 		return nil, fmt.Errorf("error committing transaction: %w", err)
 	}
 
-	return task, nil
+	// RETURNING does not keep the order of the subquery
+	sort.SliceStable(tasks, func(i, j int) bool {
+		if tasks[i].Priority != tasks[j].Priority {
+			return tasks[i].Priority > tasks[j].Priority
+		}
+		return tasks[i].CreatedAt.Before(tasks[j].CreatedAt)
+	})
+
+	return tasks, nil
 }
 
 func (s *store) DeleteTask(ctx context.Context, id string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	previous, err := lockTasks(ctx, tx, "id = $1", id)
+	if err != nil {
+		return err
+	}
+	if len(previous) == 0 {
+		return fmt.Errorf("task not found")
+	}
+
 	query := `
 		UPDATE tasks 
 		SET status = $1, updated_at = NOW()
 		WHERE id = $2`
 
-	result, err := s.db.ExecContext(ctx, query, TaskStatusDeleted, id)
-	if err != nil {
+	if _, err := tx.ExecContext(ctx, query, TaskStatusDeleted, id); err != nil {
 		return err
 	}
 
-	rows, err := result.RowsAffected()
-	if err != nil {
+	if err := insertEvents(ctx, tx, deleteEvents(ctx, previous)...); err != nil {
+		return err
+	}
+	if err := finishAttempts(ctx, tx, AttemptCancelled, nil, id); err != nil {
+		return err
+	}
+	if err := settleFinished(ctx, tx, id); err != nil {
 		return err
 	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
 
-	if rows == 0 {
-		return fmt.Errorf("task not found")
+// ExtendLease pushes the lease of a running task held by clientID forward by extension,
+// returning ErrLeaseLost when the task is no longer running for that client
+func (s *store) ExtendLease(ctx context.Context, id, clientID string, extension time.Duration) (time.Time, error) {
+	var leaseExpiresAt time.Time
+	err := s.db.QueryRowContext(ctx, `
+        UPDATE tasks
+        SET lease_expires_at = $1
+        WHERE id = $2 AND status = $3 AND assigned_to = $4
+        RETURNING lease_expires_at`,
+		time.Now().Add(extension), id, TaskStatusRunning, clientID,
+	).Scan(&leaseExpiresAt)
+	if err == sql.ErrNoRows {
+		return time.Time{}, ErrLeaseLost
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error extending lease: %w", err)
+	}
+	return leaseExpiresAt, nil
+}
+
+// mark expired tasks as failed with error message when the task lease is exceeded,
+// rescheduling them instead when the queue retry policy allows another attempt and
+// moving them to the dead-letter queue once retries are exhausted. Running tasks without
+// a lease or a start time expire a task timeout after their last update.
+func (s *store) MarkExpiredTasks(ctx context.Context) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+        SELECT t.id, t.queue_name, t.original_queue, t.attempt,
+            q.retry_max_attempts, q.retry_base_delay_ms, q.retry_multiplier,
+            q.retry_max_delay_ms, q.retry_jitter, COALESCE(q.dead_letter_queue, '')
+        FROM tasks t
+        JOIN queues q ON t.queue_name = q.name
+        WHERE 
+            t.status = 'running'
+            AND COALESCE(t.lease_expires_at,
+                COALESCE(t.started_at, t.updated_at) + (q.task_timeout || ' seconds')::interval) < NOW()
+        FOR UPDATE OF t SKIP LOCKED`)
+	if err != nil {
+		return fmt.Errorf("error finding expired tasks: %w", err)
+	}
+
+	var expired []Task
+	var queues []Queue
+	for rows.Next() {
+		task := Task{Status: TaskStatusRunning}
+		var queue Queue
+		var baseDelayMs, maxDelayMs int64
+		if err := rows.Scan(&task.ID, &task.QueueName, &task.OriginalQueue, &task.Attempt,
+			&queue.RetryPolicy.MaxAttempts, &baseDelayMs, &queue.RetryPolicy.Multiplier,
+			&maxDelayMs, &queue.RetryPolicy.Jitter, &queue.DeadLetterQueue); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning expired task: %w", err)
+		}
+		queue.Name = task.QueueName
+		queue.RetryPolicy.BaseDelay = time.Duration(baseDelayMs) * time.Millisecond
+		queue.RetryPolicy.MaxDelay = time.Duration(maxDelayMs) * time.Millisecond
+		expired = append(expired, task)
+		queues = append(queues, queue)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating expired tasks: %w", err)
+	}
+
+	now := time.Now()
+	var failed []string
+	for i := range expired {
+		task := &expired[i]
+		previous := *task
+		task.Error = timeoutError()
+		if !queues[i].RetryPolicy.ScheduleRetry(task, now) {
+			task.Status = TaskStatusFailed
+			queues[i].DeadLetter(task)
+			failed = append(failed, task.ID)
+		}
+
+		_, err = tx.ExecContext(ctx, `
+            UPDATE tasks
+            SET 
+                status = $1,
+                assigned_to = CASE WHEN $1 = 'pending' THEN NULL ELSE assigned_to END,
+                started_at = CASE WHEN $1 = 'pending' THEN NULL ELSE started_at END,
+                lease_expires_at = CASE WHEN $1 = 'pending' THEN NULL ELSE lease_expires_at END,
+                next_attempt_at = $2,
+                queue_name = $3,
+                original_queue = $4,
+                updated_at = NOW(),
+                error = $6
+            WHERE id = $5`,
+			task.Status, task.NextAttemptAt, task.QueueName, task.OriginalQueue, task.ID, task.Error)
+		if err != nil {
+			return fmt.Errorf("error marking expired tasks: %w", err)
+		}
+		if err := finishAttempts(ctx, tx, AttemptTimeout, timeoutError(), task.ID); err != nil {
+			return err
+		}
+		if err := insertEvents(ctx, tx, newTaskEvent(ctx, task, &previous)); err != nil {
+			return err
+		}
+
+		if task.Status == TaskStatusPending {
+			if err := notifyQueue(ctx, tx, task.QueueName); err != nil {
+				return err
+			}
+		}
+	}
+	if err := settleFinished(ctx, tx, failed...); err != nil {
+		return err
 	}
 
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
 	return nil
 }
 
-// mark expired tasks as failed with error message when the task timeout is exceeded
-func (s *store) MarkExpiredTasks(ctx context.Context) error {
-	_, err := s.db.ExecContext(ctx, `
-        UPDATE tasks t
-        SET 
-            status = 'failed',
-            updated_at = NOW(),
-            data = jsonb_set(
-                CASE 
-                    WHEN jsonb_typeof(data) = 'object' THEN data 
-                    ELSE '{}'::jsonb 
-                END, 
-                '{error}', 
-                '"Task timeout exceeded"'
+// RedriveDeadLetters moves failed tasks held in a dead-letter queue back to the queue
+// they came from with a fresh retry cycle. When taskIDs is empty every dead-lettered
+// task of the queue is redriven.
+func (s *store) RedriveDeadLetters(ctx context.Context, queueName string, taskIDs []string) (int, error) {
+	query := `
+		UPDATE tasks
+		SET queue_name = original_queue,
+			original_queue = NULL,
+			status = $1,
+			assigned_to = NULL,
+			started_at = NULL,
+			completed_at = NULL,
+			lease_expires_at = NULL,
+			attempt = 0,
+			next_attempt_at = NULL,
+			updated_at = NOW()
+		WHERE queue_name = $2 AND status = $3 AND original_queue IS NOT NULL`
+	args := []interface{}{TaskStatusPending, queueName, TaskStatusFailed}
+
+	if len(taskIDs) > 0 {
+		query += " AND id = ANY($4)"
+		args = append(args, pq.Array(taskIDs))
+	}
+	query += `
+		RETURNING id, queue_name`
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("error redriving dead letters: %w", err)
+	}
+
+	counts := make(map[string]int)
+	var events []*TaskEvent
+	for rows.Next() {
+		task := Task{Status: TaskStatusPending}
+		if err := rows.Scan(&task.ID, &task.QueueName); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error scanning redriven tasks: %w", err)
+		}
+		counts[task.QueueName]++
+		previous := Task{Status: TaskStatusFailed, QueueName: queueName}
+		events = append(events, newTaskEvent(ctx, &task, &previous))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating redriven tasks: %w", err)
+	}
+	if err := insertEvents(ctx, tx, events...); err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for queue, count := range counts {
+		if err := notifyQueue(ctx, tx, queue); err != nil {
+			return 0, err
+		}
+		total += count
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing transaction: %w", err)
+	}
+	return total, nil
+}
+
+// purgeableCondition selects the tasks t of queue $1 (any queue when empty) finished for
+// longer than the retention of their queue q at $2
+const purgeableCondition = `
+        ($1 = '' OR t.queue_name = $1) AND (
+            (t.status = 'completed' AND q.retention_completed > 0
+                AND t.updated_at < $2::timestamp - q.retention_completed * INTERVAL '1 second')
+            OR (t.status = 'failed' AND q.retention_failed > 0
+                AND t.updated_at < $2::timestamp - q.retention_failed * INTERVAL '1 second')
+            OR (t.status = 'deleted' AND q.retention_deleted > 0
+                AND t.updated_at < $2::timestamp - q.retention_deleted * INTERVAL '1 second'))`
+
+// orphanDependencies deletes the dependencies of purged tasks
+const orphanDependencies = `
+        DELETE FROM task_dependencies
+        WHERE NOT EXISTS (SELECT 1 FROM tasks WHERE id = task_dependencies.task_id)
+            AND NOT EXISTS (SELECT 1 FROM tasks_archive WHERE id = task_dependencies.task_id)`
+
+// orphanAttempts deletes the attempts of purged tasks
+const orphanAttempts = `
+        DELETE FROM task_attempts
+        WHERE NOT EXISTS (SELECT 1 FROM tasks WHERE id = task_attempts.task_id)
+            AND NOT EXISTS (SELECT 1 FROM tasks_archive WHERE id = task_attempts.task_id)`
+
+// PurgeTasks hard-deletes up to limit tasks, archived or not, that outlived the retention of
+// their queue at asOf
+func (s *store) PurgeTasks(ctx context.Context, queueName string, asOf time.Time, limit int) (int, error) {
+	purged := 0
+	for _, table := range []string{"tasks", "tasks_archive"} {
+		if purged >= limit {
+			break
+		}
+
+		result, err := s.db.ExecContext(ctx, `
+            DELETE FROM `+table+`
+            WHERE id IN (
+                SELECT t.id
+                FROM `+table+` t
+                JOIN queues q ON t.queue_name = q.name
+                WHERE `+purgeableCondition+`
+                LIMIT $3
+                FOR UPDATE OF t SKIP LOCKED
+            )`,
+			queueName, asOf.UTC(), limit-purged)
+		if err != nil {
+			return purged, fmt.Errorf("error purging tasks: %w", err)
+		}
+
+		n, err := result.RowsAffected()
+		if err != nil {
+			return purged, fmt.Errorf("error purging tasks: %w", err)
+		}
+		purged += int(n)
+	}
+
+	if purged > 0 {
+		if _, err := s.db.ExecContext(ctx, orphanDependencies); err != nil {
+			return purged, fmt.Errorf("error purging dependencies: %w", err)
+		}
+		if _, err := s.db.ExecContext(ctx, orphanAttempts); err != nil {
+			return purged, fmt.Errorf("error purging attempts: %w", err)
+		}
+	}
+	return purged, nil
+}
+
+// CountPurgeableTasks counts the tasks PurgeTasks would delete at asOf
+func (s *store) CountPurgeableTasks(ctx context.Context, queueName string, asOf time.Time) (int, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, `
+        SELECT
+            (SELECT COUNT(*) FROM tasks t JOIN queues q ON t.queue_name = q.name WHERE `+purgeableCondition+`) +
+            (SELECT COUNT(*) FROM tasks_archive t JOIN queues q ON t.queue_name = q.name WHERE `+purgeableCondition+`)`,
+		queueName, asOf.UTC(),
+	).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("error counting purgeable tasks: %w", err)
+	}
+	return count, nil
+}
+
+// ArchiveTasks moves up to limit finished tasks older than the archive age of their queue at
+// asOf from tasks to tasks_archive
+func (s *store) ArchiveTasks(ctx context.Context, asOf time.Time, limit int) (int, error) {
+	result, err := s.db.ExecContext(ctx, `
+        WITH moved AS (
+            DELETE FROM tasks
+            WHERE id IN (
+                SELECT t.id
+                FROM tasks t
+                JOIN queues q ON t.queue_name = q.name
+                WHERE t.status IN ('completed', 'failed', 'deleted') AND q.archive_after > 0
+                    AND t.updated_at < $1::timestamp - q.archive_after * INTERVAL '1 second'
+                LIMIT $2
+                FOR UPDATE OF t SKIP LOCKED
             )
-        FROM queues q
-        WHERE 
-            t.queue_name = q.name
-            AND t.status = 'running'
-            AND t.started_at + (q.task_timeout || ' seconds')::interval < NOW()`)
+            RETURNING `+taskColumns+`
+        )
+        INSERT INTO tasks_archive (`+taskColumns+`, archived_at)
+        SELECT `+taskColumns+`, NOW() FROM moved`,
+		asOf.UTC(), limit)
+	if err != nil {
+		return 0, fmt.Errorf("error archiving tasks: %w", err)
+	}
+
+	archived, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error archiving tasks: %w", err)
+	}
+	return int(archived), nil
+}
+
+func (s *store) CreateSchedule(ctx context.Context, schedule *Schedule) error {
+	err := s.db.QueryRowContext(ctx, `
+        INSERT INTO schedules (id, queue_name, cron, timezone, priority, data, paused, next_run_at, created_at, updated_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW(), NOW())
+        RETURNING created_at, updated_at`,
+		schedule.ID, schedule.QueueName, schedule.Cron, schedule.Timezone, schedule.Priority, schedule.Data,
+		schedule.Paused, schedule.NextRunAt.UTC(),
+	).Scan(&schedule.CreatedAt, &schedule.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("error creating schedule: %w", err)
+	}
+	return nil
+}
+
+func (s *store) GetSchedule(ctx context.Context, id string) (*Schedule, error) {
+	var schedule Schedule
+	err := scanSchedule(s.db.QueryRowContext(ctx, `SELECT `+scheduleColumns+` FROM schedules WHERE id = $1`, id), &schedule)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting schedule: %w", err)
+	}
+	return &schedule, nil
+}
+
+// GetSchedules lists the schedules of a queue, or of every queue when queueName is empty
+func (s *store) GetSchedules(ctx context.Context, queueName string) ([]Schedule, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT `+scheduleColumns+`
+        FROM schedules
+        WHERE $1 = '' OR queue_name = $1
+        ORDER BY created_at, id`, queueName)
+	if err != nil {
+		return nil, fmt.Errorf("error querying schedules: %w", err)
+	}
+	defer rows.Close()
+
+	var schedules []Schedule
+	for rows.Next() {
+		var schedule Schedule
+		if err := scanSchedule(rows, &schedule); err != nil {
+			return nil, fmt.Errorf("error scanning schedule: %w", err)
+		}
+		schedules = append(schedules, schedule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating schedules: %w", err)
+	}
+	return schedules, nil
+}
+
+// SetSchedulePaused pauses or resumes a schedule, which runs next at nextRunAt
+func (s *store) SetSchedulePaused(ctx context.Context, id string, paused bool, nextRunAt time.Time) error {
+	result, err := s.db.ExecContext(ctx, `
+        UPDATE schedules SET paused = $2, next_run_at = $3, updated_at = NOW() WHERE id = $1`,
+		id, paused, nextRunAt.UTC())
+	if err != nil {
+		return fmt.Errorf("error updating schedule: %w", err)
+	}
+	if updated, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("error updating schedule: %w", err)
+	} else if updated == 0 {
+		return ErrScheduleNotFound
+	}
+	return nil
+}
 
+func (s *store) DeleteSchedule(ctx context.Context, id string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM schedules WHERE id = $1`, id)
 	if err != nil {
-		return fmt.Errorf("error marking expired tasks: %w", err)
+		return fmt.Errorf("error deleting schedule: %w", err)
+	}
+	if deleted, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("error deleting schedule: %w", err)
+	} else if deleted == 0 {
+		return ErrScheduleNotFound
 	}
 	return nil
 }
+
+// RunDueSchedules enqueues a task for each of up to limit schedules due at asOf and moves them to
+// their first run after asOf, so missed runs are not made up. Due schedules are locked until their
+// task is enqueued, so every run happens once however many schedulers are running. Schedules whose
+// next run cannot be computed are paused. It returns the number of schedules handled.
+func (s *store) RunDueSchedules(ctx context.Context, asOf time.Time, limit int) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+        SELECT `+scheduleColumns+`
+        FROM schedules
+        WHERE NOT paused AND next_run_at <= $1
+        ORDER BY next_run_at
+        LIMIT $2
+        FOR UPDATE SKIP LOCKED`,
+		asOf.UTC(), limit)
+	if err != nil {
+		return 0, fmt.Errorf("error querying due schedules: %w", err)
+	}
+	var schedules []Schedule
+	for rows.Next() {
+		var schedule Schedule
+		if err := scanSchedule(rows, &schedule); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error scanning schedule: %w", err)
+		}
+		schedules = append(schedules, schedule)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating schedules: %w", err)
+	}
+
+	notify := make(map[string]bool)
+	for _, schedule := range schedules {
+		next, err := schedule.Next(asOf)
+		if err != nil {
+			if _, err := tx.ExecContext(ctx, `UPDATE schedules SET paused = TRUE, updated_at = NOW() WHERE id = $1`,
+				schedule.ID); err != nil {
+				return 0, fmt.Errorf("error pausing schedule: %w", err)
+			}
+			continue
+		}
+
+		task := schedule.newTask()
+		if err := insertTask(ctx, tx, &task); err != nil {
+			return 0, fmt.Errorf("error enqueuing task of schedule %s: %w", schedule.ID, err)
+		}
+		if _, err := tx.ExecContext(ctx, `
+            UPDATE schedules SET next_run_at = $2, last_run_at = $3, updated_at = NOW() WHERE id = $1`,
+			schedule.ID, next, schedule.NextRunAt.UTC()); err != nil {
+			return 0, fmt.Errorf("error updating schedule: %w", err)
+		}
+		notify[schedule.QueueName] = true
+	}
+	for queueName := range notify {
+		if err := notifyQueue(ctx, tx, queueName); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing transaction: %w", err)
+	}
+	return len(schedules), nil
+}
@@ -0,0 +1,37 @@
+package storage_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/fernandezvara/jobqueues/internal/storage"
+	"github.com/fernandezvara/jobqueues/internal/storage/storetest"
+)
+
+// TestPostgresStore runs the conformance suite against the database in JOBQUEUE_TEST_DATABASE_URL.
+// Its tables are emptied before every test, schema_migrations aside.
+func TestPostgresStore(t *testing.T) {
+	dbURL := os.Getenv("JOBQUEUE_TEST_DATABASE_URL")
+	if dbURL == "" {
+		t.Skip("JOBQUEUE_TEST_DATABASE_URL is not set")
+	}
+
+	db, err := storage.NewDB(dbURL)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := storage.InitSchema(db); err != nil {
+		t.Fatalf("InitSchema: %v", err)
+	}
+
+	storetest.Run(t, func(t *testing.T) storage.Store {
+		// every table of the migrations, CASCADE also empties any other table referencing them
+		if _, err := db.Exec(`TRUNCATE queues, tasks, tasks_archive, schedules, task_dependencies,
+            batches, task_attempts, task_events CASCADE`); err != nil {
+			t.Fatalf("error emptying tables: %v", err)
+		}
+		return storage.NewStore(db)
+	})
+}
@@ -0,0 +1,1890 @@
+// Package storetest provides the conformance tests that every storage.Store implementation must pass.
+package storetest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fernandezvara/jobqueues/internal/storage"
+	"github.com/rs/xid"
+)
+
+// tolerance absorbs the clock differences between the test and the database and the
+// precision lost when timestamps are stored
+const tolerance = 2 * time.Second
+
+// Factory returns an empty store, cleaning up after the test is done
+type Factory func(t *testing.T) storage.Store
+
+// Run runs the conformance suite against the stores returned by newStore, one per test
+func Run(t *testing.T, newStore Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s storage.Store)
+	}{
+		{"Queues", testQueues},
+		{"CreateAndGetTask", testCreateAndGetTask},
+		{"CreateTaskWithoutData", testCreateTaskWithoutData},
+		{"CreateTaskUnknownQueue", testCreateTaskUnknownQueue},
+		{"ClaimOrder", testClaimOrder},
+		{"ClaimSkipsTasksNotDue", testClaimSkipsTasksNotDue},
+		{"ClaimSetsLease", testClaimSetsLease},
+		{"ConcurrentClaims", testConcurrentClaims},
+		{"MaxRunning", testMaxRunning},
+		{"RateLimit", testRateLimit},
+		{"UpdateTask", testUpdateTask},
+		{"UpdateClaimedTask", testUpdateClaimedTask},
+		{"ExtendLease", testExtendLease},
+		{"MarkExpiredTasks", testMarkExpiredTasks},
+		{"MarkExpiredTasksWithoutLease", testMarkExpiredTasksWithoutLease},
+		{"MarkExpiredTasksRetries", testMarkExpiredTasksRetries},
+		{"MarkExpiredTasksDeadLetters", testMarkExpiredTasksDeadLetters},
+		{"TaskAttempts", testTaskAttempts},
+		{"TaskEvents", testTaskEvents},
+		{"RedriveDeadLetters", testRedriveDeadLetters},
+		{"CreateUniqueTask", testCreateUniqueTask},
+		{"CreateTasks", testCreateTasks},
+		{"CreateTasksIsAtomic", testCreateTasksIsAtomic},
+		{"GetTasksFilters", testGetTasksFilters},
+		{"GetTasksSortAndPagination", testGetTasksSortAndPagination},
+		{"GetTaskStats", testGetTaskStats},
+		{"DeleteTask", testDeleteTask},
+		{"PurgeTasks", testPurgeTasks},
+		{"ArchiveTasks", testArchiveTasks},
+		{"DeleteQueue", testDeleteQueue},
+		{"DeleteQueueMove", testDeleteQueueMove},
+		{"RenameQueue", testRenameQueue},
+		{"PauseQueue", testPauseQueue},
+		{"Schedules", testSchedules},
+		{"RunDueSchedules", testRunDueSchedules},
+		{"ConcurrentScheduleRuns", testConcurrentScheduleRuns},
+		{"Dependencies", testDependencies},
+		{"DependencyFailureCascades", testDependencyFailureCascades},
+		{"TaskGraph", testTaskGraph},
+		{"Batches", testBatches},
+		{"ConcurrentBatchFinish", testConcurrentBatchFinish},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStore(t))
+		})
+	}
+}
+
+func testQueues(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+
+	queue, err := s.GetQueue(ctx, "missing")
+	if err != nil || queue != nil {
+		t.Fatalf("GetQueue(missing) = %v, %v, want nil, nil", queue, err)
+	}
+
+	b := mustCreateQueue(t, s, storage.Queue{
+		Name:        "b",
+		TaskTimeout: time.Minute,
+		RetryPolicy: storage.RetryPolicy{
+			MaxAttempts: 3,
+			BaseDelay:   time.Second,
+			Multiplier:  2,
+			MaxDelay:    time.Minute,
+			Jitter:      0.5,
+		},
+		DeadLetterQueue: "a",
+		IdempotencyTTL:  time.Hour,
+		Retention: storage.RetentionPolicy{
+			Completed: 7 * 24 * time.Hour,
+			Failed:    30 * 24 * time.Hour,
+			Deleted:   time.Hour,
+		},
+		ArchiveAfter: 24 * time.Hour,
+		MaxRunning:   5,
+		Rate:         0.5,
+		Burst:        4,
+	})
+	mustCreateQueue(t, s, storage.Queue{Name: "a", TaskTimeout: time.Second})
+
+	got, err := s.GetQueue(ctx, "b")
+	if err != nil {
+		t.Fatalf("GetQueue: %v", err)
+	}
+	if got.TaskTimeout != b.TaskTimeout || got.RetryPolicy != b.RetryPolicy ||
+		got.DeadLetterQueue != b.DeadLetterQueue || got.IdempotencyTTL != b.IdempotencyTTL ||
+		got.Retention != b.Retention || got.ArchiveAfter != b.ArchiveAfter || got.MaxRunning != b.MaxRunning ||
+		got.Rate != b.Rate || got.Burst != b.Burst {
+		t.Errorf("GetQueue = %+v, want %+v", got, b)
+	}
+
+	// updating keeps the creation time
+	update := storage.Queue{Name: "b", TaskTimeout: 2 * time.Minute}
+	if err := s.CreateOrUpdateQueue(ctx, &update); err != nil {
+		t.Fatalf("CreateOrUpdateQueue: %v", err)
+	}
+	got, _ = s.GetQueue(ctx, "b")
+	if got.TaskTimeout != 2*time.Minute || got.DeadLetterQueue != "" {
+		t.Errorf("updated queue = %+v", got)
+	}
+	if !got.CreatedAt.Equal(b.CreatedAt) {
+		t.Errorf("CreatedAt changed on update: %v, want %v", got.CreatedAt, b.CreatedAt)
+	}
+
+	queues, err := s.GetQueues(ctx)
+	if err != nil {
+		t.Fatalf("GetQueues: %v", err)
+	}
+	if len(queues) != 2 || queues[0].Name != "a" || queues[1].Name != "b" {
+		t.Errorf("GetQueues = %+v, want queues a and b", queues)
+	}
+}
+
+func testCreateAndGetTask(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{Name: "q", TaskTimeout: time.Minute})
+
+	task := mustCreateTask(t, s, storage.Task{QueueName: "q", Priority: 3, Data: json.RawMessage(`{"key": "value"}`)})
+	if task.CreatedAt.IsZero() || task.UpdatedAt.IsZero() {
+		t.Errorf("timestamps not set: %+v", task)
+	}
+
+	got, err := s.GetTask(ctx, task.ID)
+	if err != nil {
+		t.Fatalf("GetTask: %v", err)
+	}
+	if got.QueueName != "q" || got.Status != storage.TaskStatusPending || got.Priority != 3 ||
+		got.Attempt != 0 || got.AssignedTo != nil {
+		t.Errorf("GetTask = %+v", got)
+	}
+	assertJSON(t, got.Data, `{"key": "value"}`)
+
+	missing, err := s.GetTask(ctx, xid.New().String())
+	if err != nil || missing != nil {
+		t.Errorf("GetTask(missing) = %v, %v, want nil, nil", missing, err)
+	}
+}
+
+func testCreateTaskWithoutData(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{Name: "q", TaskTimeout: time.Minute})
+
+	// newTask fills in the data, these tasks are created as they are sent
+	single := storage.Task{ID: xid.New().String(), QueueName: "q", Status: storage.TaskStatusPending}
+	if err := s.CreateTask(ctx, &single); err != nil {
+		t.Fatalf("CreateTask: %v", err)
+	}
+	batched := storage.Task{ID: xid.New().String(), QueueName: "q", Status: storage.TaskStatusPending}
+	if _, _, err := s.CreateTasks(ctx, []*storage.Task{&batched}, nil); err != nil {
+		t.Fatalf("CreateTasks: %v", err)
+	}
+
+	for _, task := range []*storage.Task{&single, &batched} {
+		assertJSON(t, task.Data, `{}`)
+		got, err := s.GetTask(ctx, task.ID)
+		if err != nil || got == nil {
+			t.Fatalf("GetTask = %v, %v", got, err)
+		}
+		assertJSON(t, got.Data, `{}`)
+	}
+	if claimed := mustClaim(t, s, "q", "worker"); claimed.ID != single.ID {
+		t.Errorf("claimed %s, want %s", claimed.ID, single.ID)
+	}
+}
+
+func testCreateTaskUnknownQueue(t *testing.T, s storage.Store) {
+	task := newTask(storage.Task{QueueName: "missing"})
+	if err := s.CreateTask(context.Background(), &task); err == nil {
+		t.Error("CreateTask in a missing queue succeeded")
+	}
+}
+
+func testClaimOrder(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{Name: "q", TaskTimeout: time.Minute})
+	mustCreateQueue(t, s, storage.Queue{Name: "other", TaskTimeout: time.Minute})
+
+	low := mustCreateTask(t, s, storage.Task{QueueName: "q", Priority: 0})
+	time.Sleep(10 * time.Millisecond)
+	high := mustCreateTask(t, s, storage.Task{QueueName: "q", Priority: 5})
+	time.Sleep(10 * time.Millisecond)
+	lowLater := mustCreateTask(t, s, storage.Task{QueueName: "q", Priority: 0})
+	mustCreateTask(t, s, storage.Task{QueueName: "other", Priority: 10})
+
+	tasks, err := s.ClaimTasks(ctx, "q", "worker", 2)
+	if err != nil {
+		t.Fatalf("ClaimTasks: %v", err)
+	}
+	if ids := taskIDs(tasks); !reflect.DeepEqual(ids, []string{high.ID, low.ID}) {
+		t.Errorf("claimed %v, want %v", ids, []string{high.ID, low.ID})
+	}
+
+	next, err := s.GetNextPendingTask(ctx, "q", "worker")
+	if err != nil {
+		t.Fatalf("GetNextPendingTask: %v", err)
+	}
+	if next == nil || next.ID != lowLater.ID {
+		t.Errorf("GetNextPendingTask = %+v, want %s", next, lowLater.ID)
+	}
+
+	next, err = s.GetNextPendingTask(ctx, "q", "worker")
+	if err != nil || next != nil {
+		t.Errorf("GetNextPendingTask on an empty queue = %v, %v, want nil, nil", next, err)
+	}
+
+	tasks, err = s.ClaimTasks(ctx, "q", "worker", 10)
+	if err != nil || tasks == nil || len(tasks) != 0 {
+		t.Errorf("ClaimTasks on an empty queue = %v, %v, want an empty list", tasks, err)
+	}
+}
+
+func testClaimSkipsTasksNotDue(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{Name: "q", TaskTimeout: time.Minute})
+
+	future := time.Now().UTC().Add(time.Hour)
+	past := time.Now().UTC().Add(-time.Hour)
+	mustCreateTask(t, s, storage.Task{QueueName: "q", RunAt: &future})
+	due := mustCreateTask(t, s, storage.Task{QueueName: "q", RunAt: &past})
+
+	retrying := mustCreateTask(t, s, storage.Task{QueueName: "q", Priority: 1})
+	retrying.NextAttemptAt = &future
+	if err := s.UpdateTask(ctx, retrying); err != nil {
+		t.Fatalf("UpdateTask: %v", err)
+	}
+
+	tasks, err := s.ClaimTasks(ctx, "q", "worker", 10)
+	if err != nil {
+		t.Fatalf("ClaimTasks: %v", err)
+	}
+	if ids := taskIDs(tasks); !reflect.DeepEqual(ids, []string{due.ID}) {
+		t.Errorf("claimed %v, want only the due task %s", ids, due.ID)
+	}
+}
+
+func testClaimSetsLease(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{Name: "q", TaskTimeout: time.Minute})
+	created := mustCreateTask(t, s, storage.Task{QueueName: "q"})
+
+	task := mustClaim(t, s, "q", "worker")
+	if task.ID != created.ID || task.Status != storage.TaskStatusRunning || task.Attempt != 1 {
+		t.Fatalf("claimed %+v", task)
+	}
+	if task.AssignedTo == nil || *task.AssignedTo != "worker" {
+		t.Errorf("AssignedTo = %v, want worker", task.AssignedTo)
+	}
+	if task.StartedAt == nil || task.LeaseExpiresAt == nil {
+		t.Fatalf("StartedAt or LeaseExpiresAt not set: %+v", task)
+	}
+	assertNear(t, "LeaseExpiresAt", *task.LeaseExpiresAt, task.StartedAt.Add(time.Minute))
+
+	stored, _ := s.GetTask(ctx, task.ID)
+	if stored.Status != storage.TaskStatusRunning || stored.Attempt != 1 || stored.LeaseExpiresAt == nil {
+		t.Errorf("stored task = %+v", stored)
+	}
+}
+
+func testConcurrentClaims(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{Name: "q", TaskTimeout: time.Minute})
+	const tasks, workers = 40, 8
+	for i := 0; i < tasks; i++ {
+		mustCreateTask(t, s, storage.Task{QueueName: "q"})
+	}
+
+	var mu sync.Mutex
+	claims := make(map[string]int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				claimed, err := s.ClaimTasks(ctx, "q", "worker", 3)
+				if err != nil {
+					t.Errorf("ClaimTasks: %v", err)
+					return
+				}
+				if len(claimed) == 0 {
+					return
+				}
+				mu.Lock()
+				for _, task := range claimed {
+					claims[task.ID]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(claims) != tasks {
+		t.Errorf("claimed %d tasks, want %d", len(claims), tasks)
+	}
+	for id, n := range claims {
+		if n != 1 {
+			t.Errorf("task %s claimed %d times", id, n)
+		}
+	}
+}
+
+func testMaxRunning(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{Name: "q", TaskTimeout: time.Minute, MaxRunning: 3})
+	for i := 0; i < 10; i++ {
+		mustCreateTask(t, s, storage.Task{QueueName: "q"})
+	}
+
+	var mu sync.Mutex
+	var claimed []storage.Task
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tasks, err := s.ClaimTasks(ctx, "q", "worker", 2)
+			if err != nil {
+				t.Errorf("ClaimTasks: %v", err)
+				return
+			}
+			mu.Lock()
+			claimed = append(claimed, tasks...)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if len(claimed) != 3 {
+		t.Fatalf("concurrent claims got %d tasks, want 3", len(claimed))
+	}
+	if tasks, _ := s.ClaimTasks(ctx, "q", "worker", 1); len(tasks) != 0 {
+		t.Errorf("claimed %d tasks of a full queue", len(tasks))
+	}
+
+	// finishing a task frees its slot
+	done := claimed[0]
+	done.Status = storage.TaskStatusCompleted
+	if err := s.UpdateTask(ctx, &done); err != nil {
+		t.Fatalf("UpdateTask: %v", err)
+	}
+	if tasks, _ := s.ClaimTasks(ctx, "q", "worker", 5); len(tasks) != 1 {
+		t.Errorf("claimed %d tasks after one finished, want 1", len(tasks))
+	}
+}
+
+func testRateLimit(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{Name: "q", TaskTimeout: time.Minute, Rate: 1, Burst: 2})
+
+	// claiming from an empty queue spends no tokens
+	if tasks, err := s.ClaimTasks(ctx, "q", "worker", 2); err != nil || len(tasks) != 0 {
+		t.Fatalf("ClaimTasks on empty queue = %d tasks, %v", len(tasks), err)
+	}
+	for i := 0; i < 5; i++ {
+		mustCreateTask(t, s, storage.Task{QueueName: "q"})
+	}
+
+	tasks, err := s.ClaimTasks(ctx, "q", "worker", 5)
+	if err != nil {
+		t.Fatalf("ClaimTasks: %v", err)
+	}
+	if len(tasks) != 2 {
+		t.Fatalf("claimed %d tasks, want the burst of 2", len(tasks))
+	}
+
+	_, err = s.ClaimTasks(ctx, "q", "worker", 1)
+	var limited *storage.RateLimitError
+	if !errors.As(err, &limited) {
+		t.Fatalf("ClaimTasks after the burst = %v, want RateLimitError", err)
+	}
+	if limited.RetryAfter <= 0 || limited.RetryAfter > time.Second {
+		t.Errorf("RetryAfter = %v, want up to 1s", limited.RetryAfter)
+	}
+}
+
+func testUpdateTask(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{Name: "q", TaskTimeout: time.Minute})
+	task := mustClaim(t, s, "q", "worker", storage.Task{QueueName: "q"})
+
+	completedAt := time.Now().UTC()
+	task.Status = storage.TaskStatusCompleted
+	task.Data = json.RawMessage(`{"changed": true}`)
+	task.Result = json.RawMessage(`{"result": 42}`)
+	task.Error = &storage.TaskError{Message: "partial", Details: json.RawMessage(`{"skipped": 1}`)}
+	task.CompletedAt = &completedAt
+	task.Priority = 7
+	if err := s.UpdateTask(ctx, task); err != nil {
+		t.Fatalf("UpdateTask: %v", err)
+	}
+
+	got, _ := s.GetTask(ctx, task.ID)
+	if got.Status != storage.TaskStatusCompleted || got.Priority != 7 || got.CompletedAt == nil {
+		t.Errorf("updated task = %+v", got)
+	}
+	assertJSON(t, got.Data, `{}`)
+	assertJSON(t, got.Result, `{"result": 42}`)
+	if got.Error == nil || got.Error.Message != "partial" {
+		t.Fatalf("error = %+v, want partial", got.Error)
+	}
+	assertJSON(t, got.Error.Details, `{"skipped": 1}`)
+
+	// clearing the result and error stores NULL
+	got.Result, got.Error = nil, nil
+	if err := s.UpdateTask(ctx, got); err != nil {
+		t.Fatalf("UpdateTask: %v", err)
+	}
+	if got, _ := s.GetTask(ctx, task.ID); got.Result != nil || got.Error != nil {
+		t.Errorf("cleared result = %s, error = %+v", got.Result, got.Error)
+	}
+
+	missing := newTask(storage.Task{QueueName: "q"})
+	if err := s.UpdateTask(ctx, &missing); err == nil {
+		t.Error("UpdateTask of a missing task succeeded")
+	}
+}
+
+func testUpdateClaimedTask(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{Name: "q", TaskTimeout: time.Minute})
+	task := mustClaim(t, s, "q", "worker", storage.Task{QueueName: "q"})
+
+	update := *task
+	update.Status = storage.TaskStatusCompleted
+	if err := s.UpdateClaimedTask(ctx, &update, "intruder", task.Attempt); !errors.Is(err, storage.ErrLeaseLost) {
+		t.Errorf("UpdateClaimedTask by another client = %v, want ErrLeaseLost", err)
+	}
+	if err := s.UpdateClaimedTask(ctx, &update, "worker", task.Attempt+1); !errors.Is(err, storage.ErrLeaseLost) {
+		t.Errorf("UpdateClaimedTask of another attempt = %v, want ErrLeaseLost", err)
+	}
+	if got, _ := s.GetTask(ctx, task.ID); got.Status != storage.TaskStatusRunning {
+		t.Fatalf("rejected update changed the task: %+v", got)
+	}
+
+	if err := s.UpdateClaimedTask(ctx, &update, "worker", task.Attempt); err != nil {
+		t.Fatalf("UpdateClaimedTask: %v", err)
+	}
+	if got, _ := s.GetTask(ctx, task.ID); got.Status != storage.TaskStatusCompleted {
+		t.Errorf("status = %s, want completed", got.Status)
+	}
+
+	// the task is no longer running
+	if err := s.UpdateClaimedTask(ctx, &update, "worker", task.Attempt); !errors.Is(err, storage.ErrLeaseLost) {
+		t.Errorf("UpdateClaimedTask of a finished task = %v, want ErrLeaseLost", err)
+	}
+}
+
+func testExtendLease(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{Name: "q", TaskTimeout: time.Minute})
+	task := mustClaim(t, s, "q", "worker", storage.Task{QueueName: "q"})
+
+	expiresAt, err := s.ExtendLease(ctx, task.ID, "worker", time.Hour)
+	if err != nil {
+		t.Fatalf("ExtendLease: %v", err)
+	}
+	assertNear(t, "lease", expiresAt, time.Now().Add(time.Hour))
+
+	if _, err := s.ExtendLease(ctx, task.ID, "intruder", time.Hour); !errors.Is(err, storage.ErrLeaseLost) {
+		t.Errorf("ExtendLease by another client = %v, want ErrLeaseLost", err)
+	}
+	if _, err := s.ExtendLease(ctx, xid.New().String(), "worker", time.Hour); !errors.Is(err, storage.ErrLeaseLost) {
+		t.Errorf("ExtendLease of a missing task = %v, want ErrLeaseLost", err)
+	}
+
+	pending := mustCreateTask(t, s, storage.Task{QueueName: "q"})
+	if _, err := s.ExtendLease(ctx, pending.ID, "worker", time.Hour); !errors.Is(err, storage.ErrLeaseLost) {
+		t.Errorf("ExtendLease of a pending task = %v, want ErrLeaseLost", err)
+	}
+}
+
+func testMarkExpiredTasks(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{Name: "q", TaskTimeout: time.Minute})
+
+	expired := mustClaim(t, s, "q", "worker", storage.Task{QueueName: "q", Data: json.RawMessage(`{"key": "value"}`)})
+	expire(t, s, expired)
+	alive := mustClaim(t, s, "q", "worker", storage.Task{QueueName: "q"})
+
+	if err := s.MarkExpiredTasks(ctx); err != nil {
+		t.Fatalf("MarkExpiredTasks: %v", err)
+	}
+
+	got, _ := s.GetTask(ctx, expired.ID)
+	if got.Status != storage.TaskStatusFailed {
+		t.Errorf("expired task status = %s, want failed", got.Status)
+	}
+	assertJSON(t, got.Data, `{"key": "value"}`)
+	if got.Error == nil || got.Error.Message != "Task timeout exceeded" || got.Error.Type != "timeout" {
+		t.Errorf("expired task error = %+v, want a timeout", got.Error)
+	}
+
+	if got, _ := s.GetTask(ctx, alive.ID); got.Status != storage.TaskStatusRunning {
+		t.Errorf("task within its lease status = %s, want running", got.Status)
+	}
+}
+
+func testMarkExpiredTasksWithoutLease(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	// without a timeout the task expires as soon as it was updated
+	mustCreateQueue(t, s, storage.Queue{Name: "q"})
+
+	// a running task nobody claimed has neither a lease nor a start time
+	task := mustCreateTask(t, s, storage.Task{QueueName: "q"})
+	task.Status = storage.TaskStatusRunning
+	if err := s.UpdateTask(ctx, task); err != nil {
+		t.Fatalf("UpdateTask: %v", err)
+	}
+
+	if err := s.MarkExpiredTasks(ctx); err != nil {
+		t.Fatalf("MarkExpiredTasks: %v", err)
+	}
+	got, _ := s.GetTask(ctx, task.ID)
+	if got.Status != storage.TaskStatusFailed || got.Error == nil || got.Error.Type != "timeout" {
+		t.Errorf("running task without a lease = %+v, want it failed by a timeout", got)
+	}
+}
+
+func testMarkExpiredTasksRetries(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{
+		Name:        "q",
+		TaskTimeout: time.Minute,
+		RetryPolicy: storage.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Hour},
+	})
+
+	task := mustClaim(t, s, "q", "worker", storage.Task{QueueName: "q"})
+	expire(t, s, task)
+	if err := s.MarkExpiredTasks(ctx); err != nil {
+		t.Fatalf("MarkExpiredTasks: %v", err)
+	}
+
+	got, _ := s.GetTask(ctx, task.ID)
+	if got.Status != storage.TaskStatusPending || got.AssignedTo != nil || got.LeaseExpiresAt != nil || got.Attempt != 1 {
+		t.Fatalf("retried task = %+v", got)
+	}
+	if got.NextAttemptAt == nil {
+		t.Fatal("NextAttemptAt not set")
+	}
+	assertNear(t, "NextAttemptAt", *got.NextAttemptAt, time.Now().Add(time.Hour))
+
+	// the retry is not handed out before it is due
+	if tasks, _ := s.ClaimTasks(ctx, "q", "worker", 1); len(tasks) != 0 {
+		t.Errorf("retry claimed before it was due: %+v", tasks)
+	}
+}
+
+func testMarkExpiredTasksDeadLetters(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{Name: "dlq", TaskTimeout: time.Minute})
+	mustCreateQueue(t, s, storage.Queue{Name: "q", TaskTimeout: time.Minute, DeadLetterQueue: "dlq"})
+
+	task := mustClaim(t, s, "q", "worker", storage.Task{QueueName: "q"})
+	expire(t, s, task)
+	if err := s.MarkExpiredTasks(ctx); err != nil {
+		t.Fatalf("MarkExpiredTasks: %v", err)
+	}
+
+	got, _ := s.GetTask(ctx, task.ID)
+	if got.Status != storage.TaskStatusFailed || got.QueueName != "dlq" {
+		t.Errorf("dead-lettered task = %+v", got)
+	}
+	if got.OriginalQueue == nil || *got.OriginalQueue != "q" {
+		t.Errorf("OriginalQueue = %v, want q", got.OriginalQueue)
+	}
+}
+
+func testTaskAttempts(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{Name: "q", TaskTimeout: time.Minute})
+
+	if attempts, err := s.GetTaskAttempts(ctx, xid.New().String()); err != nil || attempts != nil {
+		t.Errorf("GetTaskAttempts(missing) = %v, %v, want nil, nil", attempts, err)
+	}
+	task := mustCreateTask(t, s, storage.Task{QueueName: "q"})
+	if attempts, err := s.GetTaskAttempts(ctx, task.ID); err != nil || attempts == nil || len(attempts) != 0 {
+		t.Errorf("GetTaskAttempts(never claimed) = %v, %v, want no attempts", attempts, err)
+	}
+
+	// the first attempt times out and the task is requeued
+	task = mustClaim(t, s, "q", "w1")
+	attempts, err := s.GetTaskAttempts(ctx, task.ID)
+	if err != nil || len(attempts) != 1 {
+		t.Fatalf("GetTaskAttempts = %+v, %v, want one attempt", attempts, err)
+	}
+	if got := attempts[0]; got.ClientID != "w1" || got.Attempt != 1 || got.Outcome != storage.AttemptRunning ||
+		got.FinishedAt != nil || got.Duration != nil {
+		t.Errorf("running attempt = %+v", got)
+	}
+	assertNear(t, "started_at", attempts[0].StartedAt, time.Now())
+
+	expire(t, s, task)
+	if err := s.MarkExpiredTasks(ctx); err != nil {
+		t.Fatalf("MarkExpiredTasks: %v", err)
+	}
+	task, _ = s.GetTask(ctx, task.ID)
+	task.Status = storage.TaskStatusPending
+	task.Attempt = 0
+	task.AssignedTo, task.StartedAt, task.LeaseExpiresAt, task.Error = nil, nil, nil, nil
+	if err := s.UpdateTask(ctx, task); err != nil {
+		t.Fatalf("UpdateTask: %v", err)
+	}
+
+	// the second one fails and is rescheduled, the third one completes
+	task = mustClaim(t, s, "q", "w2")
+	retryAt := time.Now().UTC().Add(-time.Minute)
+	task.Status = storage.TaskStatusPending
+	task.AssignedTo, task.StartedAt, task.LeaseExpiresAt = nil, nil, nil
+	task.NextAttemptAt = &retryAt
+	task.Error = &storage.TaskError{Message: "boom"}
+	if err := s.UpdateTask(ctx, task); err != nil {
+		t.Fatalf("UpdateTask: %v", err)
+	}
+	task = mustClaim(t, s, "q", "w3")
+	task.Status = storage.TaskStatusCompleted
+	task.Error = nil
+	if err := s.UpdateTask(ctx, task); err != nil {
+		t.Fatalf("UpdateTask: %v", err)
+	}
+
+	attempts, err = s.GetTaskAttempts(ctx, task.ID)
+	if err != nil || len(attempts) != 3 {
+		t.Fatalf("GetTaskAttempts = %+v, %v, want three attempts", attempts, err)
+	}
+	want := []struct {
+		clientID, outcome, error string
+		attempt                  int
+	}{
+		{"w1", storage.AttemptTimeout, "Task timeout exceeded", 1},
+		{"w2", storage.AttemptFailed, "boom", 1},
+		{"w3", storage.AttemptCompleted, "", 2},
+	}
+	for i, w := range want {
+		got := attempts[i]
+		message := ""
+		if got.Error != nil {
+			message = got.Error.Message
+		}
+		if got.TaskID != task.ID || got.ClientID != w.clientID || got.Outcome != w.outcome || message != w.error ||
+			got.Attempt != w.attempt {
+			t.Errorf("attempt %d = %+v, want %+v", i, got, w)
+		}
+		if got.FinishedAt == nil || got.Duration == nil || *got.Duration != got.FinishedAt.Sub(got.StartedAt) {
+			t.Errorf("attempt %d finished at %v after %v", i, got.FinishedAt, got.Duration)
+		}
+	}
+
+	// deleting a running task cancels its attempt
+	deleted := mustClaim(t, s, "q", "w4", storage.Task{QueueName: "q"})
+	if err := s.DeleteTask(ctx, deleted.ID); err != nil {
+		t.Fatalf("DeleteTask: %v", err)
+	}
+	if attempts, _ := s.GetTaskAttempts(ctx, deleted.ID); len(attempts) != 1 || attempts[0].Outcome != storage.AttemptCancelled {
+		t.Errorf("attempts of the deleted task = %+v, want one cancelled", attempts)
+	}
+}
+
+func testTaskEvents(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	client := storage.WithActor(ctx, storage.Actor{Type: storage.ActorClient, Name: "c1", RequestID: "r1"})
+	admin := storage.WithActor(ctx, storage.Actor{Type: storage.ActorUser, Name: "admin", RequestID: "r2"})
+	mustCreateQueue(t, s, storage.Queue{Name: "q", TaskTimeout: time.Minute})
+	mustCreateQueue(t, s, storage.Queue{Name: "other", TaskTimeout: time.Minute})
+	started := time.Now().Add(-time.Second)
+
+	task := newTask(storage.Task{QueueName: "q"})
+	if err := s.CreateTask(client, &task); err != nil {
+		t.Fatalf("CreateTask: %v", err)
+	}
+	mustCreateTask(t, s, storage.Task{QueueName: "other"})
+
+	// claimed without an actor, completed by the client, then its result overwritten, requeued,
+	// reprioritized and deleted by a user
+	claimed := mustClaim(t, s, "q", "w1")
+	claimed.Status = storage.TaskStatusCompleted
+	claimed.Result = json.RawMessage(`{"x":1}`)
+	if err := s.UpdateTask(client, claimed); err != nil {
+		t.Fatalf("UpdateTask: %v", err)
+	}
+	claimed.Result = json.RawMessage(`{"x": 2}`)
+	if err := s.UpdateTask(admin, claimed); err != nil {
+		t.Fatalf("UpdateTask: %v", err)
+	}
+	claimed.Status = storage.TaskStatusPending
+	claimed.AssignedTo, claimed.StartedAt, claimed.LeaseExpiresAt = nil, nil, nil
+	if err := s.UpdateTask(admin, claimed); err != nil {
+		t.Fatalf("UpdateTask: %v", err)
+	}
+	claimed.Priority = 5
+	if err := s.UpdateTask(admin, claimed); err != nil {
+		t.Fatalf("UpdateTask: %v", err)
+	}
+	// writing the task unchanged records nothing
+	if err := s.UpdateTask(admin, claimed); err != nil {
+		t.Fatalf("UpdateTask: %v", err)
+	}
+	if err := s.DeleteTask(admin, task.ID); err != nil {
+		t.Fatalf("DeleteTask: %v", err)
+	}
+
+	events, err := s.GetTaskEvents(ctx, storage.TaskEventFilter{TaskID: task.ID, Limit: 100})
+	if err != nil {
+		t.Fatalf("GetTaskEvents: %v", err)
+	}
+	want := []struct {
+		event, from, to, actorType, actor, requestID string
+	}{
+		{storage.EventDeleted, storage.TaskStatusPending, storage.TaskStatusDeleted, storage.ActorUser, "admin", "r2"},
+		{storage.EventUpdated, storage.TaskStatusPending, storage.TaskStatusPending, storage.ActorUser, "admin", "r2"},
+		{storage.EventRequeued, storage.TaskStatusCompleted, storage.TaskStatusPending, storage.ActorUser, "admin", "r2"},
+		{storage.EventUpdated, storage.TaskStatusCompleted, storage.TaskStatusCompleted, storage.ActorUser, "admin", "r2"},
+		{storage.EventStatusChanged, storage.TaskStatusRunning, storage.TaskStatusCompleted, storage.ActorClient, "c1", "r1"},
+		{storage.EventStatusChanged, storage.TaskStatusPending, storage.TaskStatusRunning, storage.ActorSystem, "", ""},
+		{storage.EventCreated, "", storage.TaskStatusPending, storage.ActorClient, "c1", "r1"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("GetTaskEvents = %+v, want %d events", events, len(want))
+	}
+	for i, w := range want {
+		got := events[i]
+		from := ""
+		if got.FromStatus != nil {
+			from = *got.FromStatus
+		}
+		if got.TaskID != task.ID || got.QueueName != "q" || got.Type != w.event || from != w.from || got.ToStatus != w.to ||
+			got.Actor.Type != w.actorType || got.Actor.Name != w.actor || got.Actor.RequestID != w.requestID {
+			t.Errorf("event %d = %+v, want %+v", i, got, w)
+		}
+		assertNear(t, "created_at", got.CreatedAt, time.Now())
+	}
+	var details map[string]interface{}
+	if err := json.Unmarshal(events[1].Details, &details); err != nil || details["priority"] != float64(5) ||
+		details["previous_priority"] != float64(0) {
+		t.Errorf("details of the update = %s, want the priorities", events[1].Details)
+	}
+	details = nil
+	if err := json.Unmarshal(events[3].Details, &details); err != nil || len(details) != 2 ||
+		!reflect.DeepEqual(details["result"], map[string]interface{}{"x": float64(2)}) ||
+		!reflect.DeepEqual(details["previous_result"], map[string]interface{}{"x": float64(1)}) {
+		t.Errorf("details of the result overwrite = %s, want the results", events[3].Details)
+	}
+	if events[0].ID <= events[1].ID {
+		t.Errorf("events are not sorted newest first: %d, %d", events[0].ID, events[1].ID)
+	}
+
+	filters := []struct {
+		name   string
+		filter storage.TaskEventFilter
+		count  int
+	}{
+		{"queue", storage.TaskEventFilter{QueueName: "other"}, 1},
+		{"actor", storage.TaskEventFilter{Actor: "admin"}, 4},
+		{"actor type", storage.TaskEventFilter{ActorType: storage.ActorSystem}, 2},
+		{"actor and queue", storage.TaskEventFilter{ActorType: storage.ActorClient, QueueName: "q"}, 2},
+		{"from", storage.TaskEventFilter{FromDate: started}, 8},
+		{"to", storage.TaskEventFilter{ToDate: started}, 0},
+		{"future", storage.TaskEventFilter{FromDate: time.Now().Add(time.Hour)}, 0},
+	}
+	for _, f := range filters {
+		f.filter.Limit = 100
+		if events, err := s.GetTaskEvents(ctx, f.filter); err != nil || len(events) != f.count {
+			t.Errorf("GetTaskEvents(%s) = %d events, %v, want %d", f.name, len(events), err, f.count)
+		}
+	}
+
+	page, err := s.GetTaskEvents(ctx, storage.TaskEventFilter{TaskID: task.ID, Limit: 2, Offset: 1})
+	if err != nil || len(page) != 2 || page[0].Type != storage.EventUpdated || page[1].Type != storage.EventRequeued {
+		t.Errorf("GetTaskEvents(page) = %+v, %v, want the update and the requeue", page, err)
+	}
+}
+
+func testRedriveDeadLetters(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{Name: "dlq", TaskTimeout: time.Minute})
+	mustCreateQueue(t, s, storage.Queue{Name: "q", TaskTimeout: time.Minute, DeadLetterQueue: "dlq"})
+
+	var dead []*storage.Task
+	for i := 0; i < 3; i++ {
+		task := mustClaim(t, s, "q", "worker", storage.Task{QueueName: "q"})
+		expire(t, s, task)
+		dead = append(dead, task)
+	}
+	if err := s.MarkExpiredTasks(ctx); err != nil {
+		t.Fatalf("MarkExpiredTasks: %v", err)
+	}
+
+	n, err := s.RedriveDeadLetters(ctx, "dlq", []string{dead[0].ID})
+	if err != nil || n != 1 {
+		t.Fatalf("RedriveDeadLetters(one) = %d, %v, want 1", n, err)
+	}
+	got, _ := s.GetTask(ctx, dead[0].ID)
+	if got.QueueName != "q" || got.Status != storage.TaskStatusPending || got.OriginalQueue != nil ||
+		got.Attempt != 0 || got.AssignedTo != nil || got.LeaseExpiresAt != nil {
+		t.Errorf("redriven task = %+v", got)
+	}
+
+	n, err = s.RedriveDeadLetters(ctx, "dlq", nil)
+	if err != nil || n != 2 {
+		t.Errorf("RedriveDeadLetters(all) = %d, %v, want 2", n, err)
+	}
+	if tasks, _ := s.ClaimTasks(ctx, "q", "worker", 10); len(tasks) != 3 {
+		t.Errorf("claimed %d redriven tasks, want 3", len(tasks))
+	}
+}
+
+func testCreateUniqueTask(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{Name: "q", TaskTimeout: time.Minute})
+
+	create := func(key string, ttl time.Duration) (*storage.Task, bool) {
+		t.Helper()
+		task := newTask(storage.Task{QueueName: "q", IdempotencyKey: &key})
+		created, err := s.CreateUniqueTask(ctx, &task, ttl)
+		if err != nil {
+			t.Fatalf("CreateUniqueTask: %v", err)
+		}
+		return &task, created
+	}
+
+	first, created := create("k", 0)
+	if !created {
+		t.Fatal("first task with a key was not created")
+	}
+	second, created := create("k", 0)
+	if created || second.ID != first.ID {
+		t.Errorf("active key created %s (created=%v), want existing %s", second.ID, created, first.ID)
+	}
+	if _, created := create("other", 0); !created {
+		t.Error("task with another key was not created")
+	}
+
+	// finished tasks keep their key for the TTL only
+	claimed := mustClaim(t, s, "q", "worker")
+	claimed.Status = storage.TaskStatusCompleted
+	if err := s.UpdateTask(ctx, claimed); err != nil {
+		t.Fatalf("UpdateTask: %v", err)
+	}
+	finishedKey := *claimed.IdempotencyKey
+	if again, created := create(finishedKey, time.Hour); created || again.ID != claimed.ID {
+		t.Errorf("key within TTL created a task (created=%v)", created)
+	}
+	if _, created := create(finishedKey, 0); !created {
+		t.Error("key of a finished task without TTL did not create a task")
+	}
+}
+
+func testCreateTasks(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{Name: "q", TaskTimeout: time.Minute})
+
+	key := "k"
+	existing := newTask(storage.Task{QueueName: "q", IdempotencyKey: &key})
+	if _, err := s.CreateUniqueTask(ctx, &existing, 0); err != nil {
+		t.Fatalf("CreateUniqueTask: %v", err)
+	}
+
+	newKey := "new"
+	batch := []storage.Task{
+		newTask(storage.Task{QueueName: "q", Data: json.RawMessage(`{"n": 1}`)}),
+		newTask(storage.Task{QueueName: "q", IdempotencyKey: &key}),
+		newTask(storage.Task{QueueName: "q", IdempotencyKey: &newKey}),
+		newTask(storage.Task{QueueName: "q", IdempotencyKey: &newKey}),
+		newTask(storage.Task{QueueName: "q", Priority: 2}),
+		// a missing dependency only skips its own task
+		newTask(storage.Task{QueueName: "q", DependsOn: []string{xid.New().String()}}),
+		newTask(storage.Task{QueueName: "q", DependsOn: []string{existing.ID}}),
+	}
+	tasks := make([]*storage.Task, len(batch))
+	for i := range batch {
+		tasks[i] = &batch[i]
+	}
+
+	created, errs, err := s.CreateTasks(ctx, tasks, map[string]time.Duration{"q": 0})
+	if err != nil {
+		t.Fatalf("CreateTasks: %v", err)
+	}
+	if want := []bool{true, false, true, false, true, false, true}; !reflect.DeepEqual(created, want) {
+		t.Errorf("created = %v, want %v", created, want)
+	}
+	for i, err := range errs {
+		if dependencyMissing := i == 5; errors.Is(err, storage.ErrDependencyNotFound) != dependencyMissing {
+			t.Errorf("error of task %d = %v", i, err)
+		}
+	}
+	if got, _ := s.GetTask(ctx, batch[5].ID); got != nil {
+		t.Errorf("task with an unknown dependency was created: %+v", got)
+	}
+	if batch[1].ID != existing.ID {
+		t.Errorf("duplicated key returned %s, want %s", batch[1].ID, existing.ID)
+	}
+	if batch[3].ID != batch[2].ID {
+		t.Errorf("key repeated in the batch returned %s, want %s", batch[3].ID, batch[2].ID)
+	}
+	if batch[0].CreatedAt.IsZero() || batch[4].CreatedAt.IsZero() {
+		t.Error("timestamps of created tasks not set")
+	}
+
+	got, _ := s.GetTask(ctx, batch[0].ID)
+	if got == nil || got.Status != storage.TaskStatusPending {
+		t.Fatalf("batch task = %+v", got)
+	}
+	assertJSON(t, got.Data, `{"n": 1}`)
+
+	stats, _ := s.GetTaskStats(ctx, storage.TaskFilter{QueueName: "q"})
+	if stats["all"] != 5 {
+		t.Errorf("tasks in queue = %d, want 5", stats["all"])
+	}
+}
+
+func testCreateTasksIsAtomic(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{Name: "q", TaskTimeout: time.Minute})
+
+	valid := newTask(storage.Task{QueueName: "q"})
+	invalid := newTask(storage.Task{QueueName: "missing"})
+	if _, _, err := s.CreateTasks(ctx, []*storage.Task{&valid, &invalid}, nil); err == nil {
+		t.Fatal("CreateTasks with a missing queue succeeded")
+	}
+	if got, _ := s.GetTask(ctx, valid.ID); got != nil {
+		t.Errorf("task of a failed batch was created: %+v", got)
+	}
+}
+
+func testGetTasksFilters(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{Name: "a", TaskTimeout: time.Minute})
+	mustCreateQueue(t, s, storage.Queue{Name: "b", TaskTimeout: time.Minute})
+
+	a1 := mustCreateTask(t, s, storage.Task{QueueName: "a", Priority: 1})
+	a2 := mustCreateTask(t, s, storage.Task{QueueName: "a", Priority: 2})
+	b1 := mustCreateTask(t, s, storage.Task{QueueName: "b", Priority: 1})
+	running := mustClaim(t, s, "b", "worker")
+
+	priority := 1
+	tests := []struct {
+		name   string
+		filter storage.TaskFilter
+		want   []string
+	}{
+		{"queue", storage.TaskFilter{QueueName: "a"}, []string{a1.ID, a2.ID}},
+		{"status", storage.TaskFilter{Status: storage.TaskStatusRunning}, []string{running.ID}},
+		{"priority", storage.TaskFilter{Priority: &priority}, []string{a1.ID, b1.ID}},
+		{"queue and priority", storage.TaskFilter{QueueName: "a", Priority: &priority}, []string{a1.ID}},
+		{"from", storage.TaskFilter{FromDate: time.Now().Add(time.Hour)}, nil},
+		{"to", storage.TaskFilter{ToDate: time.Now().Add(-time.Hour)}, nil},
+	}
+	for _, tt := range tests {
+		tt.filter.Limit = 100
+		tasks, err := s.GetTasks(ctx, tt.filter)
+		if err != nil {
+			t.Fatalf("%s: GetTasks: %v", tt.name, err)
+		}
+		if got := sortedIDs(tasks); !reflect.DeepEqual(got, sortedStrings(tt.want)) {
+			t.Errorf("%s: GetTasks = %v, want %v", tt.name, got, sortedStrings(tt.want))
+		}
+	}
+}
+
+func testGetTasksSortAndPagination(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{Name: "q", TaskTimeout: time.Minute})
+
+	var created []*storage.Task
+	for _, priority := range []int{2, 0, 1} {
+		created = append(created, mustCreateTask(t, s, storage.Task{QueueName: "q", Priority: priority}))
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	tests := []struct {
+		sortBy string
+		offset int
+		limit  int
+		want   []*storage.Task
+	}{
+		{"", 0, 10, []*storage.Task{created[2], created[1], created[0]}},
+		{"priority", 0, 10, []*storage.Task{created[1], created[2], created[0]}},
+		{"-priority", 0, 10, []*storage.Task{created[0], created[2], created[1]}},
+		{"created_at", 0, 10, []*storage.Task{created[0], created[1], created[2]}},
+		{"priority", 1, 1, []*storage.Task{created[2]}},
+		{"priority", 3, 10, nil},
+	}
+	for _, tt := range tests {
+		tasks, err := s.GetTasks(ctx, storage.TaskFilter{SortBy: tt.sortBy, Offset: tt.offset, Limit: tt.limit})
+		if err != nil {
+			t.Fatalf("GetTasks(%q): %v", tt.sortBy, err)
+		}
+		want := make([]string, 0, len(tt.want))
+		for _, task := range tt.want {
+			want = append(want, task.ID)
+		}
+		if got := taskIDs(tasks); !reflect.DeepEqual(got, want) {
+			t.Errorf("GetTasks(sort %q, offset %d, limit %d) = %v, want %v", tt.sortBy, tt.offset, tt.limit, got, want)
+		}
+	}
+}
+
+func testGetTaskStats(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{Name: "q", TaskTimeout: time.Minute})
+	mustCreateQueue(t, s, storage.Queue{Name: "other", TaskTimeout: time.Minute})
+
+	future := time.Now().UTC().Add(time.Hour)
+	mustCreateTask(t, s, storage.Task{QueueName: "q"})
+	mustCreateTask(t, s, storage.Task{QueueName: "q", RunAt: &future})
+	running := mustClaim(t, s, "q", "worker")
+	completed := mustClaim(t, s, "q", "worker", storage.Task{QueueName: "q"})
+	completed.Status = storage.TaskStatusCompleted
+	if err := s.UpdateTask(ctx, completed); err != nil {
+		t.Fatalf("UpdateTask: %v", err)
+	}
+	deleted := mustCreateTask(t, s, storage.Task{QueueName: "q"})
+	if err := s.DeleteTask(ctx, deleted.ID); err != nil {
+		t.Fatalf("DeleteTask: %v", err)
+	}
+	mustCreateTask(t, s, storage.Task{QueueName: "q", DependsOn: []string{running.ID}})
+	mustCreateTask(t, s, storage.Task{QueueName: "other"})
+
+	stats, err := s.GetTaskStats(ctx, storage.TaskFilter{QueueName: "q"})
+	if err != nil {
+		t.Fatalf("GetTaskStats: %v", err)
+	}
+	// the scheduled task is counted as pending too
+	want := map[string]int{
+		"all":       5,
+		"pending":   1,
+		"blocked":   1,
+		"scheduled": 1,
+		"running":   1,
+		"completed": 1,
+		"failed":    0,
+		"deleted":   1,
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("GetTaskStats = %v, want %v", stats, want)
+	}
+}
+
+func testDeleteTask(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{Name: "q", TaskTimeout: time.Minute})
+	task := mustCreateTask(t, s, storage.Task{QueueName: "q"})
+
+	if err := s.DeleteTask(ctx, task.ID); err != nil {
+		t.Fatalf("DeleteTask: %v", err)
+	}
+	if got, _ := s.GetTask(ctx, task.ID); got == nil || got.Status != storage.TaskStatusDeleted {
+		t.Errorf("deleted task = %+v", got)
+	}
+	if tasks, _ := s.ClaimTasks(ctx, "q", "worker", 1); len(tasks) != 0 {
+		t.Errorf("deleted task was claimed: %+v", tasks)
+	}
+	if err := s.DeleteTask(ctx, xid.New().String()); err == nil {
+		t.Error("DeleteTask of a missing task succeeded")
+	}
+}
+
+func testPurgeTasks(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{
+		Name:        "q",
+		TaskTimeout: time.Minute,
+		Retention:   storage.RetentionPolicy{Completed: time.Hour, Deleted: time.Hour},
+	})
+	mustCreateQueue(t, s, storage.Queue{Name: "forever", TaskTimeout: time.Minute})
+
+	finish := func(queueName, status string) *storage.Task {
+		task := mustCreateTask(t, s, storage.Task{QueueName: queueName})
+		task.Status = status
+		if err := s.UpdateTask(ctx, task); err != nil {
+			t.Fatalf("UpdateTask: %v", err)
+		}
+		return task
+	}
+	completed := finish("q", storage.TaskStatusCompleted)
+	failed := finish("q", storage.TaskStatusFailed)
+	pending := mustCreateTask(t, s, storage.Task{QueueName: "q"})
+	deleted := mustCreateTask(t, s, storage.Task{QueueName: "q"})
+	if err := s.DeleteTask(ctx, deleted.ID); err != nil {
+		t.Fatalf("DeleteTask: %v", err)
+	}
+	kept := finish("forever", storage.TaskStatusCompleted)
+
+	if n, err := s.CountPurgeableTasks(ctx, "", time.Now()); err != nil || n != 0 {
+		t.Errorf("CountPurgeableTasks within the retention = %d, %v, want 0", n, err)
+	}
+
+	later := time.Now().Add(2 * time.Hour)
+	if n, err := s.CountPurgeableTasks(ctx, "", later); err != nil || n != 2 {
+		t.Fatalf("CountPurgeableTasks = %d, %v, want 2", n, err)
+	}
+	if n, err := s.CountPurgeableTasks(ctx, "forever", later); err != nil || n != 0 {
+		t.Errorf("CountPurgeableTasks(forever) = %d, %v, want 0", n, err)
+	}
+
+	// batches are bounded by the limit
+	if n, err := s.PurgeTasks(ctx, "q", later, 1); err != nil || n != 1 {
+		t.Fatalf("PurgeTasks(limit 1) = %d, %v, want 1", n, err)
+	}
+	if n, err := s.PurgeTasks(ctx, "q", later, 10); err != nil || n != 1 {
+		t.Fatalf("second PurgeTasks = %d, %v, want 1", n, err)
+	}
+	if n, err := s.PurgeTasks(ctx, "", later, 10); err != nil || n != 0 {
+		t.Errorf("PurgeTasks with nothing left = %d, %v, want 0", n, err)
+	}
+
+	for _, task := range []*storage.Task{completed, deleted} {
+		if got, _ := s.GetTask(ctx, task.ID); got != nil {
+			t.Errorf("%s task %s was not purged", task.Status, task.ID)
+		}
+	}
+	for _, task := range []*storage.Task{failed, pending, kept} {
+		if got, _ := s.GetTask(ctx, task.ID); got == nil {
+			t.Errorf("%s task %s of %s was purged", task.Status, task.ID, task.QueueName)
+		}
+	}
+}
+
+func testArchiveTasks(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{
+		Name:         "q",
+		TaskTimeout:  time.Minute,
+		ArchiveAfter: time.Hour,
+		Retention:    storage.RetentionPolicy{Completed: 3 * time.Hour},
+	})
+
+	completed := mustCreateTask(t, s, storage.Task{QueueName: "q", Data: json.RawMessage(`{"n":1}`)})
+	completed.Status = storage.TaskStatusCompleted
+	if err := s.UpdateTask(ctx, completed); err != nil {
+		t.Fatalf("UpdateTask: %v", err)
+	}
+	pending := mustCreateTask(t, s, storage.Task{QueueName: "q"})
+
+	if n, err := s.ArchiveTasks(ctx, time.Now(), 10); err != nil || n != 0 {
+		t.Errorf("ArchiveTasks before the archive age = %d, %v, want 0", n, err)
+	}
+	if n, err := s.ArchiveTasks(ctx, time.Now().Add(2*time.Hour), 10); err != nil || n != 1 {
+		t.Fatalf("ArchiveTasks = %d, %v, want 1", n, err)
+	}
+
+	if got, _ := s.GetTask(ctx, completed.ID); got != nil {
+		t.Errorf("archived task is still in tasks: %+v", got)
+	}
+	hot, err := s.GetTasks(ctx, storage.TaskFilter{QueueName: "q", Limit: 10})
+	if err != nil {
+		t.Fatalf("GetTasks: %v", err)
+	}
+	if ids := taskIDs(hot); !reflect.DeepEqual(ids, []string{pending.ID}) {
+		t.Errorf("GetTasks = %v, want only the pending task", ids)
+	}
+
+	all, err := s.GetTasks(ctx, storage.TaskFilter{QueueName: "q", Limit: 10, IncludeArchived: true})
+	if err != nil {
+		t.Fatalf("GetTasks(IncludeArchived): %v", err)
+	}
+	if ids := sortedIDs(all); !reflect.DeepEqual(ids, sortedStrings([]string{completed.ID, pending.ID})) {
+		t.Errorf("GetTasks(IncludeArchived) = %v, want both tasks", ids)
+	}
+	for _, task := range all {
+		if task.ID == completed.ID {
+			assertJSON(t, task.Data, `{"n":1}`)
+		}
+	}
+
+	stats, err := s.GetTaskStats(ctx, storage.TaskFilter{QueueName: "q", IncludeArchived: true})
+	if err != nil || stats["completed"] != 1 || stats["all"] != 2 {
+		t.Errorf("GetTaskStats(IncludeArchived) = %v, %v", stats, err)
+	}
+
+	// retention also applies to the archive
+	if n, err := s.PurgeTasks(ctx, "q", time.Now().Add(4*time.Hour), 10); err != nil || n != 1 {
+		t.Errorf("PurgeTasks of the archive = %d, %v, want 1", n, err)
+	}
+	if all, _ := s.GetTasks(ctx, storage.TaskFilter{QueueName: "q", Limit: 10, IncludeArchived: true}); len(all) != 1 {
+		t.Errorf("%d tasks left after purging the archive, want 1", len(all))
+	}
+}
+
+func testDeleteQueue(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{Name: "dlq", TaskTimeout: time.Minute})
+	mustCreateQueue(t, s, storage.Queue{Name: "q", TaskTimeout: time.Minute, DeadLetterQueue: "dlq"})
+
+	if err := s.DeleteQueue(ctx, "missing", storage.DeleteQueueRestrict, ""); !errors.Is(err, storage.ErrQueueNotFound) {
+		t.Errorf("DeleteQueue(missing) = %v, want ErrQueueNotFound", err)
+	}
+	if err := s.DeleteQueue(ctx, "dlq", storage.DeleteQueueCascade, ""); !errors.Is(err, storage.ErrQueueInUse) {
+		t.Errorf("DeleteQueue(dead-letter queue) = %v, want ErrQueueInUse", err)
+	}
+
+	completed := mustCreateTask(t, s, storage.Task{QueueName: "q"})
+	completed.Status = storage.TaskStatusCompleted
+	if err := s.UpdateTask(ctx, completed); err != nil {
+		t.Fatalf("UpdateTask: %v", err)
+	}
+	running := mustClaim(t, s, "q", "worker", storage.Task{QueueName: "q"})
+
+	if err := s.DeleteQueue(ctx, "q", storage.DeleteQueueRestrict, ""); !errors.Is(err, storage.ErrQueueNotEmpty) {
+		t.Errorf("DeleteQueue(restrict) = %v, want ErrQueueNotEmpty", err)
+	}
+	if queue, _ := s.GetQueue(ctx, "q"); queue == nil {
+		t.Fatal("queue deleted despite its running task")
+	}
+
+	if err := s.DeleteQueue(ctx, "q", storage.DeleteQueueCascade, ""); err != nil {
+		t.Fatalf("DeleteQueue(cascade): %v", err)
+	}
+	if queue, _ := s.GetQueue(ctx, "q"); queue != nil {
+		t.Errorf("queue still exists after DeleteQueue")
+	}
+	if task, _ := s.GetTask(ctx, running.ID); task != nil {
+		t.Errorf("task of the deleted queue is still in tasks: %+v", task)
+	}
+
+	// the tasks are kept in the archive
+	tasks, err := s.GetTasks(ctx, storage.TaskFilter{QueueName: "q", Limit: 10, IncludeArchived: true})
+	if err != nil {
+		t.Fatalf("GetTasks(IncludeArchived): %v", err)
+	}
+	statuses := make(map[string]string)
+	for _, task := range tasks {
+		statuses[task.ID] = task.Status
+	}
+	want := map[string]string{completed.ID: storage.TaskStatusCompleted, running.ID: storage.TaskStatusDeleted}
+	if !reflect.DeepEqual(statuses, want) {
+		t.Errorf("archived tasks = %v, want %v", statuses, want)
+	}
+
+	if err := s.DeleteQueue(ctx, "dlq", storage.DeleteQueueRestrict, ""); err != nil {
+		t.Errorf("DeleteQueue(dead-letter queue no longer in use): %v", err)
+	}
+}
+
+func testDeleteQueueMove(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{Name: "a", TaskTimeout: time.Minute})
+	mustCreateQueue(t, s, storage.Queue{Name: "b", TaskTimeout: time.Minute})
+	mustCreateQueue(t, s, storage.Queue{Name: "c", TaskTimeout: time.Minute})
+
+	key := "k"
+	mustCreateTask(t, s, storage.Task{QueueName: "a"})
+	mustCreateTask(t, s, storage.Task{QueueName: "a", IdempotencyKey: &key})
+	if err := s.DeleteQueue(ctx, "a", storage.DeleteQueueMove, "b"); err != nil {
+		t.Fatalf("DeleteQueue(move): %v", err)
+	}
+	if queue, _ := s.GetQueue(ctx, "a"); queue != nil {
+		t.Errorf("queue still exists after DeleteQueue")
+	}
+	if tasks, _ := s.ClaimTasks(ctx, "b", "worker", 10); len(tasks) != 2 {
+		t.Errorf("claimed %d moved tasks, want 2", len(tasks))
+	}
+
+	// moving fails as a whole when an active idempotency key is taken in the target
+	other := "other"
+	mustCreateTask(t, s, storage.Task{QueueName: "b", IdempotencyKey: &other})
+	mustCreateTask(t, s, storage.Task{QueueName: "c", IdempotencyKey: &other})
+	if err := s.DeleteQueue(ctx, "c", storage.DeleteQueueMove, "b"); err == nil {
+		t.Error("DeleteQueue(move) succeeded despite an idempotency key conflict")
+	}
+	if queue, _ := s.GetQueue(ctx, "c"); queue == nil {
+		t.Error("queue deleted despite the failed move")
+	}
+}
+
+func testRenameQueue(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{Name: "dlq", TaskTimeout: time.Minute})
+	mustCreateQueue(t, s, storage.Queue{Name: "q", TaskTimeout: 2 * time.Minute, DeadLetterQueue: "dlq"})
+	mustCreateQueue(t, s, storage.Queue{Name: "taken", TaskTimeout: time.Minute})
+
+	dead := mustClaim(t, s, "q", "worker", storage.Task{QueueName: "q"})
+	expire(t, s, dead)
+	if err := s.MarkExpiredTasks(ctx); err != nil {
+		t.Fatalf("MarkExpiredTasks: %v", err)
+	}
+	pending := mustCreateTask(t, s, storage.Task{QueueName: "q"})
+
+	if err := s.RenameQueue(ctx, "missing", "x"); !errors.Is(err, storage.ErrQueueNotFound) {
+		t.Errorf("RenameQueue(missing) = %v, want ErrQueueNotFound", err)
+	}
+	if err := s.RenameQueue(ctx, "q", "taken"); !errors.Is(err, storage.ErrQueueExists) {
+		t.Errorf("RenameQueue(taken) = %v, want ErrQueueExists", err)
+	}
+
+	if err := s.RenameQueue(ctx, "q", "renamed"); err != nil {
+		t.Fatalf("RenameQueue: %v", err)
+	}
+	if queue, _ := s.GetQueue(ctx, "q"); queue != nil {
+		t.Errorf("old queue still exists after RenameQueue")
+	}
+	renamed, err := s.GetQueue(ctx, "renamed")
+	if err != nil || renamed == nil {
+		t.Fatalf("GetQueue(renamed) = %v, %v", renamed, err)
+	}
+	if renamed.TaskTimeout != 2*time.Minute || renamed.DeadLetterQueue != "dlq" {
+		t.Errorf("renamed queue = %+v", renamed)
+	}
+	if got, _ := s.GetTask(ctx, pending.ID); got == nil || got.QueueName != "renamed" {
+		t.Errorf("pending task = %+v, want it in the renamed queue", got)
+	}
+	if got, _ := s.GetTask(ctx, dead.ID); got == nil || got.OriginalQueue == nil || *got.OriginalQueue != "renamed" {
+		t.Errorf("dead letter = %+v, want its original queue renamed", got)
+	}
+
+	if err := s.RenameQueue(ctx, "dlq", "dlq2"); err != nil {
+		t.Fatalf("RenameQueue(dead-letter queue): %v", err)
+	}
+	if queue, _ := s.GetQueue(ctx, "renamed"); queue == nil || queue.DeadLetterQueue != "dlq2" {
+		t.Errorf("queue = %+v, want its dead-letter queue renamed", queue)
+	}
+	if n, err := s.RedriveDeadLetters(ctx, "dlq2", nil); err != nil || n != 1 {
+		t.Errorf("RedriveDeadLetters = %d, %v, want 1", n, err)
+	}
+}
+
+func testPauseQueue(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{Name: "q", TaskTimeout: time.Minute})
+	mustCreateTask(t, s, storage.Task{QueueName: "q"})
+
+	if err := s.SetQueuePaused(ctx, "missing", true); !errors.Is(err, storage.ErrQueueNotFound) {
+		t.Errorf("SetQueuePaused(missing) = %v, want ErrQueueNotFound", err)
+	}
+	if err := s.SetQueuePaused(ctx, "q", true); err != nil {
+		t.Fatalf("SetQueuePaused: %v", err)
+	}
+
+	// paused queues accept tasks and keep the pause across updates
+	mustCreateTask(t, s, storage.Task{QueueName: "q"})
+	updated := mustCreateQueue(t, s, storage.Queue{Name: "q", TaskTimeout: 2 * time.Minute})
+	if !updated.Paused {
+		t.Error("CreateOrUpdateQueue resumed the queue")
+	}
+	if queue, _ := s.GetQueue(ctx, "q"); queue == nil || !queue.Paused {
+		t.Errorf("GetQueue = %+v, want it paused", queue)
+	}
+	if tasks, err := s.ClaimTasks(ctx, "q", "worker", 10); err != nil || len(tasks) != 0 {
+		t.Errorf("ClaimTasks of a paused queue = %d tasks, %v, want none", len(tasks), err)
+	}
+
+	if err := s.SetQueuePaused(ctx, "q", false); err != nil {
+		t.Fatalf("SetQueuePaused(resume): %v", err)
+	}
+	if tasks, err := s.ClaimTasks(ctx, "q", "worker", 10); err != nil || len(tasks) != 2 {
+		t.Errorf("ClaimTasks after resuming = %d tasks, %v, want 2", len(tasks), err)
+	}
+}
+
+func testSchedules(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{Name: "a", TaskTimeout: time.Minute})
+	mustCreateQueue(t, s, storage.Queue{Name: "b", TaskTimeout: time.Minute})
+
+	if schedule, err := s.GetSchedule(ctx, "missing"); err != nil || schedule != nil {
+		t.Fatalf("GetSchedule(missing) = %v, %v, want nil, nil", schedule, err)
+	}
+
+	hourly := mustCreateSchedule(t, s, storage.Schedule{QueueName: "a", Cron: "0 * * * *", Timezone: "Europe/Madrid",
+		Priority: 3, Data: json.RawMessage(`{"report":"hourly"}`)})
+	mustCreateSchedule(t, s, storage.Schedule{QueueName: "b", Cron: "@daily"})
+
+	got, err := s.GetSchedule(ctx, hourly.ID)
+	if err != nil || got == nil {
+		t.Fatalf("GetSchedule = %v, %v", got, err)
+	}
+	if got.QueueName != "a" || got.Cron != hourly.Cron || got.Timezone != hourly.Timezone || got.Priority != 3 ||
+		!got.NextRunAt.Equal(hourly.NextRunAt) || got.LastRunAt != nil {
+		t.Errorf("GetSchedule = %+v, want %+v", got, hourly)
+	}
+	assertJSON(t, got.Data, `{"report":"hourly"}`)
+
+	all, err := s.GetSchedules(ctx, "")
+	if err != nil || len(all) != 2 {
+		t.Fatalf("GetSchedules = %d schedules, %v, want 2", len(all), err)
+	}
+	if ofA, err := s.GetSchedules(ctx, "a"); err != nil || len(ofA) != 1 || ofA[0].ID != hourly.ID {
+		t.Errorf("GetSchedules(a) = %+v, %v, want the hourly schedule", ofA, err)
+	}
+
+	resumeAt := hourly.NextRunAt.Add(time.Hour)
+	if err := s.SetSchedulePaused(ctx, hourly.ID, true, resumeAt); err != nil {
+		t.Fatalf("SetSchedulePaused: %v", err)
+	}
+	if got, _ := s.GetSchedule(ctx, hourly.ID); got == nil || !got.Paused || !got.NextRunAt.Equal(resumeAt) {
+		t.Errorf("GetSchedule after pausing = %+v, want it paused until %v", got, resumeAt)
+	}
+	if err := s.SetSchedulePaused(ctx, "missing", true, resumeAt); !errors.Is(err, storage.ErrScheduleNotFound) {
+		t.Errorf("SetSchedulePaused(missing) = %v, want ErrScheduleNotFound", err)
+	}
+
+	// schedules follow their queue when it is renamed and go away with it
+	if err := s.RenameQueue(ctx, "a", "c"); err != nil {
+		t.Fatalf("RenameQueue: %v", err)
+	}
+	if got, _ := s.GetSchedule(ctx, hourly.ID); got == nil || got.QueueName != "c" {
+		t.Errorf("schedule after renaming its queue = %+v, want it in c", got)
+	}
+	if err := s.DeleteQueue(ctx, "c", storage.DeleteQueueRestrict, ""); err != nil {
+		t.Fatalf("DeleteQueue: %v", err)
+	}
+	if got, _ := s.GetSchedule(ctx, hourly.ID); got != nil {
+		t.Errorf("schedule of a deleted queue = %+v, want it deleted", got)
+	}
+
+	if err := s.DeleteSchedule(ctx, all[1].ID); err != nil {
+		t.Fatalf("DeleteSchedule: %v", err)
+	}
+	if err := s.DeleteSchedule(ctx, all[1].ID); !errors.Is(err, storage.ErrScheduleNotFound) {
+		t.Errorf("second DeleteSchedule = %v, want ErrScheduleNotFound", err)
+	}
+}
+
+func testRunDueSchedules(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{Name: "q", TaskTimeout: time.Minute})
+
+	asOf := time.Now().UTC().Truncate(time.Second)
+	due := mustCreateSchedule(t, s, storage.Schedule{QueueName: "q", Cron: "*/5 * * * *", Priority: 2,
+		Data: json.RawMessage(`{"n":1}`), NextRunAt: asOf.Add(-time.Minute)})
+	mustCreateSchedule(t, s, storage.Schedule{QueueName: "q", Cron: "* * * * *", NextRunAt: asOf.Add(time.Minute)})
+	paused := mustCreateSchedule(t, s, storage.Schedule{QueueName: "q", Cron: "* * * * *", NextRunAt: asOf.Add(-time.Minute)})
+	if err := s.SetSchedulePaused(ctx, paused.ID, true, paused.NextRunAt); err != nil {
+		t.Fatalf("SetSchedulePaused: %v", err)
+	}
+
+	if ran, err := s.RunDueSchedules(ctx, asOf, 10); err != nil || ran != 1 {
+		t.Fatalf("RunDueSchedules = %d, %v, want 1", ran, err)
+	}
+	if ran, err := s.RunDueSchedules(ctx, asOf, 10); err != nil || ran != 0 {
+		t.Errorf("second RunDueSchedules = %d, %v, want 0", ran, err)
+	}
+
+	tasks, err := s.GetTasks(ctx, storage.TaskFilter{QueueName: "q", Limit: 10})
+	if err != nil || len(tasks) != 1 {
+		t.Fatalf("GetTasks = %d tasks, %v, want the one enqueued", len(tasks), err)
+	}
+	if task := tasks[0]; task.Status != storage.TaskStatusPending || task.Priority != 2 {
+		t.Errorf("enqueued task = %+v, want the priority of the schedule", task)
+	}
+	assertJSON(t, tasks[0].Data, `{"n":1}`)
+
+	got, _ := s.GetSchedule(ctx, due.ID)
+	want, _ := due.Next(asOf)
+	if got == nil || !got.NextRunAt.Equal(want) || got.LastRunAt == nil || !got.LastRunAt.Equal(due.NextRunAt) {
+		t.Errorf("schedule after running = %+v, want next run %v and last run %v", got, want, due.NextRunAt)
+	}
+}
+
+func testConcurrentScheduleRuns(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{Name: "q", TaskTimeout: time.Minute})
+	asOf := time.Now().UTC()
+	for i := 0; i < 5; i++ {
+		mustCreateSchedule(t, s, storage.Schedule{QueueName: "q", Cron: "@hourly", NextRunAt: asOf.Add(-time.Minute)})
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.RunDueSchedules(ctx, asOf, 10); err != nil {
+				t.Errorf("RunDueSchedules: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if tasks, _ := s.GetTasks(ctx, storage.TaskFilter{QueueName: "q", Limit: 100}); len(tasks) != 5 {
+		t.Errorf("concurrent runs enqueued %d tasks, want one per schedule", len(tasks))
+	}
+}
+
+func testDependencies(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{Name: "q", TaskTimeout: time.Minute})
+	mustCreateQueue(t, s, storage.Queue{Name: "next", TaskTimeout: time.Minute})
+
+	first := mustCreateTask(t, s, storage.Task{QueueName: "q"})
+	second := mustCreateTask(t, s, storage.Task{QueueName: "q"})
+	child := mustCreateTask(t, s, storage.Task{QueueName: "next", DependsOn: []string{first.ID, second.ID}})
+	if child.Status != storage.TaskStatusBlocked {
+		t.Fatalf("child status = %s, want blocked", child.Status)
+	}
+	got, _ := s.GetTask(ctx, child.ID)
+	if got.Status != storage.TaskStatusBlocked || !reflect.DeepEqual(got.DependsOn, sortedStrings([]string{first.ID, second.ID})) {
+		t.Errorf("stored child = %+v", got)
+	}
+	if tasks, _ := s.ClaimTasks(ctx, "next", "worker", 1); len(tasks) != 0 {
+		t.Fatalf("blocked task was claimed: %+v", tasks)
+	}
+
+	for i, parent := range []*storage.Task{first, second} {
+		claimed := mustClaim(t, s, "q", "worker")
+		if claimed.ID != parent.ID {
+			t.Fatalf("claimed %s, want %s", claimed.ID, parent.ID)
+		}
+		claimed.Status = storage.TaskStatusCompleted
+		if err := s.UpdateTask(ctx, claimed); err != nil {
+			t.Fatalf("UpdateTask: %v", err)
+		}
+		got, _ := s.GetTask(ctx, child.ID)
+		if want := []string{storage.TaskStatusBlocked, storage.TaskStatusPending}[i]; got.Status != want {
+			t.Errorf("child status after %d parents completed = %s, want %s", i+1, got.Status, want)
+		}
+	}
+	if claimed := mustClaim(t, s, "next", "worker"); claimed.ID != child.ID {
+		t.Errorf("claimed %s, want the unblocked child %s", claimed.ID, child.ID)
+	}
+
+	// tasks depending on finished tasks take their status right away
+	ready := mustCreateTask(t, s, storage.Task{QueueName: "next", DependsOn: []string{first.ID}})
+	if ready.Status != storage.TaskStatusPending {
+		t.Errorf("task depending on a completed task status = %s, want pending", ready.Status)
+	}
+
+	missing := newTask(storage.Task{QueueName: "next", DependsOn: []string{xid.New().String()}})
+	if err := s.CreateTask(ctx, &missing); !errors.Is(err, storage.ErrDependencyNotFound) {
+		t.Errorf("CreateTask with an unknown dependency = %v, want ErrDependencyNotFound", err)
+	}
+	if got, _ := s.GetTask(ctx, missing.ID); got != nil {
+		t.Errorf("task with an unknown dependency was created: %+v", got)
+	}
+}
+
+func testDependencyFailureCascades(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{Name: "q", TaskTimeout: time.Minute})
+
+	// failures cascade down the whole chain
+	parent := mustCreateTask(t, s, storage.Task{QueueName: "q"})
+	child := mustCreateTask(t, s, storage.Task{QueueName: "q", DependsOn: []string{parent.ID}})
+	grandchild := mustCreateTask(t, s, storage.Task{QueueName: "q", DependsOn: []string{child.ID}})
+	claimed := mustClaim(t, s, "q", "worker")
+	claimed.Status = storage.TaskStatusFailed
+	if err := s.UpdateTask(ctx, claimed); err != nil {
+		t.Fatalf("UpdateTask: %v", err)
+	}
+	got, _ := s.GetTask(ctx, child.ID)
+	if got.Status != storage.TaskStatusFailed {
+		t.Errorf("child of a failed task status = %s, want failed", got.Status)
+	}
+	if got.Error == nil || got.Error.Message != "dependency "+parent.ID+" failed" {
+		t.Errorf("child of a failed task error = %+v", got.Error)
+	}
+	got, _ = s.GetTask(ctx, grandchild.ID)
+	if got.Status != storage.TaskStatusFailed {
+		t.Errorf("grandchild of a failed task status = %s, want failed", got.Status)
+	}
+	if got.Error == nil || got.Error.Message != "dependency "+child.ID+" failed" {
+		t.Errorf("grandchild of a failed task error = %+v", got.Error)
+	}
+
+	// so do timeouts without retries left
+	expired := mustClaim(t, s, "q", "worker", storage.Task{QueueName: "q"})
+	timedOutChild := mustCreateTask(t, s, storage.Task{QueueName: "q", DependsOn: []string{expired.ID}})
+	expire(t, s, expired)
+	if err := s.MarkExpiredTasks(ctx); err != nil {
+		t.Fatalf("MarkExpiredTasks: %v", err)
+	}
+	if got, _ := s.GetTask(ctx, timedOutChild.ID); got.Status != storage.TaskStatusFailed {
+		t.Errorf("child of a timed out task status = %s, want failed", got.Status)
+	}
+
+	// and deletions, which delete the dependents
+	deleted := mustCreateTask(t, s, storage.Task{QueueName: "q"})
+	deletedChild := mustCreateTask(t, s, storage.Task{QueueName: "q", DependsOn: []string{deleted.ID}})
+	if err := s.DeleteTask(ctx, deleted.ID); err != nil {
+		t.Fatalf("DeleteTask: %v", err)
+	}
+	if got, _ := s.GetTask(ctx, deletedChild.ID); got.Status != storage.TaskStatusDeleted {
+		t.Errorf("child of a deleted task status = %s, want deleted", got.Status)
+	}
+
+	late := mustCreateTask(t, s, storage.Task{QueueName: "q", DependsOn: []string{parent.ID}})
+	if late.Status != storage.TaskStatusFailed {
+		t.Errorf("task depending on a failed task status = %s, want failed", late.Status)
+	}
+}
+
+func testTaskGraph(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{Name: "q", TaskTimeout: time.Minute})
+
+	// a diamond: root -> left, right -> join
+	root := mustCreateTask(t, s, storage.Task{QueueName: "q"})
+	left := mustCreateTask(t, s, storage.Task{QueueName: "q", DependsOn: []string{root.ID}})
+	right := mustCreateTask(t, s, storage.Task{QueueName: "q", DependsOn: []string{root.ID}})
+	join := mustCreateTask(t, s, storage.Task{QueueName: "q", DependsOn: []string{left.ID, right.ID}})
+	unrelated := mustCreateTask(t, s, storage.Task{QueueName: "q"})
+
+	want := []storage.TaskDependency{
+		{TaskID: join.ID, DependsOn: left.ID},
+		{TaskID: join.ID, DependsOn: right.ID},
+		{TaskID: left.ID, DependsOn: root.ID},
+		{TaskID: right.ID, DependsOn: root.ID},
+	}
+	sort.Slice(want, func(i, j int) bool {
+		if want[i].TaskID != want[j].TaskID {
+			return want[i].TaskID < want[j].TaskID
+		}
+		return want[i].DependsOn < want[j].DependsOn
+	})
+	for _, task := range []*storage.Task{root, left, join} {
+		graph, err := s.GetTaskGraph(ctx, task.ID)
+		if err != nil {
+			t.Fatalf("GetTaskGraph: %v", err)
+		}
+		if got := taskIDs(graph.Tasks); !reflect.DeepEqual(got, []string{root.ID, left.ID, right.ID, join.ID}) {
+			t.Errorf("graph of %s tasks = %v", task.ID, got)
+		}
+		if !reflect.DeepEqual(graph.Edges, want) {
+			t.Errorf("graph of %s edges = %v, want %v", task.ID, graph.Edges, want)
+		}
+		if got := graph.Tasks[3].DependsOn; !reflect.DeepEqual(got, sortedStrings([]string{left.ID, right.ID})) {
+			t.Errorf("join depends on %v", got)
+		}
+	}
+
+	graph, err := s.GetTaskGraph(ctx, unrelated.ID)
+	if err != nil || len(graph.Tasks) != 1 || len(graph.Edges) != 0 {
+		t.Errorf("graph of a task without dependencies = %+v, %v", graph, err)
+	}
+	if graph, err := s.GetTaskGraph(ctx, xid.New().String()); graph != nil || err != nil {
+		t.Errorf("graph of a missing task = %+v, %v", graph, err)
+	}
+}
+
+func testBatches(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{Name: "a", TaskTimeout: time.Minute})
+	mustCreateQueue(t, s, storage.Queue{Name: "b", TaskTimeout: time.Minute})
+	mustCreateQueue(t, s, storage.Queue{Name: "done", TaskTimeout: time.Minute})
+
+	callbackQueue := "done"
+	batch, tasks := mustCreateBatch(t, s, storage.Batch{CallbackQueue: &callbackQueue, CallbackData: json.RawMessage(`{"report": 1}`)},
+		storage.Task{QueueName: "a"}, storage.Task{QueueName: "a"}, storage.Task{QueueName: "b"})
+	if batch.Total != 3 || *tasks[2].BatchID != batch.ID {
+		t.Fatalf("created batch = %+v, task batch = %v", batch, tasks[2].BatchID)
+	}
+	if got, _ := s.GetTasks(ctx, storage.TaskFilter{BatchID: batch.ID, Limit: 10}); len(got) != 3 {
+		t.Errorf("tasks of the batch = %d, want 3", len(got))
+	}
+
+	claimed := mustClaim(t, s, "a", "worker")
+	claimed.Status = storage.TaskStatusCompleted
+	if err := s.UpdateTask(ctx, claimed); err != nil {
+		t.Fatalf("UpdateTask: %v", err)
+	}
+	if err := s.DeleteTask(ctx, tasks[2].ID); err != nil {
+		t.Fatalf("DeleteTask: %v", err)
+	}
+	got, err := s.GetBatch(ctx, batch.ID)
+	if err != nil {
+		t.Fatalf("GetBatch: %v", err)
+	}
+	want := map[string]int{"pending": 1, "blocked": 0, "running": 0, "completed": 1, "failed": 0, "deleted": 1}
+	if !reflect.DeepEqual(got.Counts, want) {
+		t.Errorf("counts = %v, want %v", got.Counts, want)
+	}
+	if got.FinishedAt != nil || got.CallbackTaskID != nil {
+		t.Errorf("batch with a pending task finished at %v", got.FinishedAt)
+	}
+
+	// the last task finishing enqueues the callback
+	claimed = mustClaim(t, s, "a", "worker")
+	claimed.Status = storage.TaskStatusFailed
+	if err := s.UpdateTask(ctx, claimed); err != nil {
+		t.Fatalf("UpdateTask: %v", err)
+	}
+	got, _ = s.GetBatch(ctx, batch.ID)
+	if got.FinishedAt == nil || got.CallbackTaskID == nil {
+		t.Fatalf("finished batch = %+v", got)
+	}
+	callback, _ := s.GetTask(ctx, *got.CallbackTaskID)
+	if callback == nil || callback.QueueName != "done" || callback.Status != storage.TaskStatusPending {
+		t.Fatalf("callback task = %+v", callback)
+	}
+	assertJSON(t, callback.Data, `{"report": 1, "batch_id": "`+batch.ID+`"}`)
+
+	// a batch whose tasks all fail on creation finishes right away, without a callback queue
+	failed := mustClaim(t, s, "b", "worker", storage.Task{QueueName: "b"})
+	failed.Status = storage.TaskStatusFailed
+	if err := s.UpdateTask(ctx, failed); err != nil {
+		t.Fatalf("UpdateTask: %v", err)
+	}
+	batch, _ = mustCreateBatch(t, s, storage.Batch{}, storage.Task{QueueName: "b", DependsOn: []string{failed.ID}})
+	got, _ = s.GetBatch(ctx, batch.ID)
+	if got.FinishedAt == nil || got.CallbackTaskID != nil || got.Counts["failed"] != 1 {
+		t.Errorf("batch of failed tasks = %+v", got)
+	}
+	assertJSON(t, got.CallbackData, `{}`)
+
+	if got, err := s.GetBatch(ctx, xid.New().String()); got != nil || err != nil {
+		t.Errorf("GetBatch of a missing batch = %+v, %v", got, err)
+	}
+}
+
+func testConcurrentBatchFinish(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	mustCreateQueue(t, s, storage.Queue{Name: "q", TaskTimeout: time.Minute})
+	mustCreateQueue(t, s, storage.Queue{Name: "done", TaskTimeout: time.Minute})
+
+	callbackQueue := "done"
+	batch, _ := mustCreateBatch(t, s, storage.Batch{CallbackQueue: &callbackQueue},
+		storage.Task{QueueName: "q"}, storage.Task{QueueName: "q"}, storage.Task{QueueName: "q"}, storage.Task{QueueName: "q"})
+	claimed, err := s.ClaimTasks(ctx, "q", "worker", 4)
+	if err != nil || len(claimed) != 4 {
+		t.Fatalf("ClaimTasks = %d tasks, %v", len(claimed), err)
+	}
+
+	var wg sync.WaitGroup
+	for i := range claimed {
+		wg.Add(1)
+		go func(task storage.Task) {
+			defer wg.Done()
+			task.Status = storage.TaskStatusCompleted
+			if err := s.UpdateTask(ctx, &task); err != nil {
+				t.Errorf("UpdateTask: %v", err)
+			}
+		}(claimed[i])
+	}
+	wg.Wait()
+
+	if got, _ := s.GetBatch(ctx, batch.ID); got.FinishedAt == nil {
+		t.Errorf("batch not finished after its tasks completed concurrently")
+	}
+	if tasks, _ := s.GetTasks(ctx, storage.TaskFilter{QueueName: "done", Limit: 10}); len(tasks) != 1 {
+		t.Errorf("concurrent finishes enqueued %d callbacks, want 1", len(tasks))
+	}
+}
+
+func mustCreateQueue(t *testing.T, s storage.Store, queue storage.Queue) *storage.Queue {
+	t.Helper()
+	if err := s.CreateOrUpdateQueue(context.Background(), &queue); err != nil {
+		t.Fatalf("CreateOrUpdateQueue(%s): %v", queue.Name, err)
+	}
+	return &queue
+}
+
+// mustCreateSchedule creates a schedule, due at its next run unless NextRunAt is set
+func mustCreateSchedule(t *testing.T, s storage.Store, schedule storage.Schedule) *storage.Schedule {
+	t.Helper()
+	schedule.ID = xid.New().String()
+	if schedule.Data == nil {
+		schedule.Data = json.RawMessage(`{}`)
+	}
+	if schedule.NextRunAt.IsZero() {
+		next, err := schedule.Next(time.Now())
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		schedule.NextRunAt = next
+	}
+	if err := s.CreateSchedule(context.Background(), &schedule); err != nil {
+		t.Fatalf("CreateSchedule: %v", err)
+	}
+	return &schedule
+}
+
+// newTask fills the fields the service sets on new tasks
+func newTask(task storage.Task) storage.Task {
+	task.ID = xid.New().String()
+	task.Status = storage.TaskStatusPending
+	if task.Data == nil {
+		task.Data = json.RawMessage(`{}`)
+	}
+	return task
+}
+
+func mustCreateTask(t *testing.T, s storage.Store, task storage.Task) *storage.Task {
+	t.Helper()
+	task = newTask(task)
+	if err := s.CreateTask(context.Background(), &task); err != nil {
+		t.Fatalf("CreateTask: %v", err)
+	}
+	return &task
+}
+
+func mustCreateBatch(t *testing.T, s storage.Store, batch storage.Batch, create ...storage.Task) (*storage.Batch, []*storage.Task) {
+	t.Helper()
+	batch.ID = xid.New().String()
+	tasks := make([]*storage.Task, len(create))
+	for i, task := range create {
+		task = newTask(task)
+		tasks[i] = &task
+	}
+	if err := s.CreateBatch(context.Background(), &batch, tasks); err != nil {
+		t.Fatalf("CreateBatch: %v", err)
+	}
+	return &batch, tasks
+}
+
+// mustClaim claims the next task of the queue, creating the given task first if any
+func mustClaim(t *testing.T, s storage.Store, queueName, clientID string, create ...storage.Task) *storage.Task {
+	t.Helper()
+	for _, task := range create {
+		mustCreateTask(t, s, task)
+	}
+	task, err := s.GetNextPendingTask(context.Background(), queueName, clientID)
+	if err != nil {
+		t.Fatalf("GetNextPendingTask: %v", err)
+	}
+	if task == nil {
+		t.Fatalf("no task to claim in %s", queueName)
+	}
+	return task
+}
+
+// expire moves the lease of a running task to the past
+func expire(t *testing.T, s storage.Store, task *storage.Task) {
+	t.Helper()
+	expiredAt := time.Now().UTC().Add(-time.Minute)
+	task.LeaseExpiresAt = &expiredAt
+	if err := s.UpdateTask(context.Background(), task); err != nil {
+		t.Fatalf("UpdateTask: %v", err)
+	}
+}
+
+func assertJSON(t *testing.T, got json.RawMessage, want string) {
+	t.Helper()
+	var gotValue, wantValue interface{}
+	if err := json.Unmarshal(got, &gotValue); err != nil {
+		t.Fatalf("invalid JSON %s: %v", got, err)
+	}
+	if err := json.Unmarshal([]byte(want), &wantValue); err != nil {
+		t.Fatalf("invalid JSON %s: %v", want, err)
+	}
+	if !reflect.DeepEqual(gotValue, wantValue) {
+		t.Errorf("data = %s, want %s", got, want)
+	}
+}
+
+func assertNear(t *testing.T, name string, got, want time.Time) {
+	t.Helper()
+	if diff := got.Sub(want); diff > tolerance || diff < -tolerance {
+		t.Errorf("%s = %v, want about %v", name, got, want)
+	}
+}
+
+func taskIDs(tasks []storage.Task) []string {
+	ids := make([]string, 0, len(tasks))
+	for _, task := range tasks {
+		ids = append(ids, task.ID)
+	}
+	return ids
+}
+
+func sortedIDs(tasks []storage.Task) []string {
+	return sortedStrings(taskIDs(tasks))
+}
+
+func sortedStrings(values []string) []string {
+	sorted := append([]string{}, values...)
+	sort.Strings(sorted)
+	return sorted
+}
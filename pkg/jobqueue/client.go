@@ -1,3 +1,8 @@
+// Package jobqueue is the client of the job queue service.
+//
+// The data of a task is its input and never changes once created. UpdateTask only changes the
+// status of a task, and results are reported with CompleteTask by the worker holding the task,
+// or returned by the processor given to ProcessTasks.
 package jobqueue
 
 import (
@@ -9,6 +14,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"time"
 )
 
@@ -49,12 +55,16 @@ func (c *Client) Health(ctx context.Context) (*HealthStatus, error) {
 }
 
 // CreateOrUpdateQueue creates or updates a queue
-func (c *Client) CreateOrUpdateQueue(ctx context.Context, name string, timeout time.Duration) (*Queue, error) {
+func (c *Client) CreateOrUpdateQueue(ctx context.Context, name string, timeout time.Duration, opts ...QueueOption) (*Queue, error) {
 	queue := Queue{
 		Name:        name,
 		TaskTimeout: timeout,
 	}
 
+	for _, opt := range opts {
+		opt(&queue)
+	}
+
 	var result Queue
 	err := c.doRequest(ctx, http.MethodPut, fmt.Sprintf("/api/v1/queues/%s", name), queue, &result)
 	if err != nil {
@@ -87,26 +97,202 @@ func (c *Client) GetQueues(ctx context.Context) ([]Queue, error) {
 	return queues, nil
 }
 
+// DeleteQueue deletes a queue. Without options it fails while the queue has pending or running
+// tasks, finished tasks are kept in the archive.
+func (c *Client) DeleteQueue(ctx context.Context, name string, opts ...DeleteQueueOption) error {
+	if name == "" {
+		return fmt.Errorf("queue name is required")
+	}
+
+	params := url.Values{}
+	for _, opt := range opts {
+		opt(params)
+	}
+
+	path := fmt.Sprintf("/api/v1/queues/%s", name)
+	if len(params) > 0 {
+		path += "?" + params.Encode()
+	}
+	return c.doRequest(ctx, http.MethodDelete, path, nil, nil)
+}
+
+// RenameQueue renames a queue, moving its tasks and dead letters along
+func (c *Client) RenameQueue(ctx context.Context, name, newName string) (*Queue, error) {
+	request := struct {
+		Name string `json:"name"`
+	}{
+		Name: newName,
+	}
+
+	var queue Queue
+	err := c.doRequest(ctx, http.MethodPost, fmt.Sprintf("/api/v1/queues/%s/rename", name), request, &queue)
+	if err != nil {
+		return nil, err
+	}
+	return &queue, nil
+}
+
+// PauseQueue stops the queue from handing out tasks, it keeps accepting new ones.
+// Workers in ProcessTasks wait until it is resumed.
+func (c *Client) PauseQueue(ctx context.Context, name string) (*Queue, error) {
+	return c.setQueuePaused(ctx, name, "pause")
+}
+
+// ResumeQueue hands out the tasks of a paused queue again
+func (c *Client) ResumeQueue(ctx context.Context, name string) (*Queue, error) {
+	return c.setQueuePaused(ctx, name, "resume")
+}
+
+func (c *Client) setQueuePaused(ctx context.Context, name, action string) (*Queue, error) {
+	if name == "" {
+		return nil, fmt.Errorf("queue name is required")
+	}
+
+	var queue Queue
+	err := c.doRequest(ctx, http.MethodPost, fmt.Sprintf("/api/v1/queues/%s/%s", name, action), nil, &queue)
+	if err != nil {
+		return nil, err
+	}
+	return &queue, nil
+}
+
+// RedriveDeadLetters moves dead-lettered tasks of a queue back to the queue they came from.
+// When no task IDs are given every dead-lettered task is redriven. It returns the number of tasks moved.
+func (c *Client) RedriveDeadLetters(ctx context.Context, deadLetterQueue string, taskIDs ...string) (int, error) {
+	request := struct {
+		TaskIDs []string `json:"task_ids,omitempty"`
+	}{
+		TaskIDs: taskIDs,
+	}
+
+	var result struct {
+		Redriven int `json:"redriven"`
+	}
+	err := c.doRequest(ctx, http.MethodPost, fmt.Sprintf("/api/v1/queues/%s/dead-letter/redrive", deadLetterQueue), request, &result)
+	if err != nil {
+		return 0, err
+	}
+	return result.Redriven, nil
+}
+
+// PurgeTasks deletes the finished tasks that outlived the retention of their queue, of every
+// queue when queueName is empty. With dryRun they are only counted. It returns the number of tasks.
+func (c *Client) PurgeTasks(ctx context.Context, queueName string, dryRun bool) (int, error) {
+	params := url.Values{}
+	if queueName != "" {
+		params.Set("queue", queueName)
+	}
+	if dryRun {
+		params.Set("dry_run", "true")
+	}
+
+	var result struct {
+		Purged    int `json:"purged"`
+		Purgeable int `json:"purgeable"`
+	}
+	err := c.doRequest(ctx, http.MethodPost, "/api/v1/admin/purge?"+params.Encode(), nil, &result)
+	if err != nil {
+		return 0, err
+	}
+	if dryRun {
+		return result.Purgeable, nil
+	}
+	return result.Purged, nil
+}
+
+// CreateSchedule enqueues a task with data in the queue every time the cron expression fires
+func (c *Client) CreateSchedule(ctx context.Context, queueName, cron string, data interface{}, opts ...ScheduleOption) (*Schedule, error) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling data: %w", err)
+	}
+
+	schedule := Schedule{
+		QueueName: queueName,
+		Cron:      cron,
+		Data:      jsonData,
+	}
+	for _, opt := range opts {
+		opt(&schedule)
+	}
+
+	var result Schedule
+	if err := c.doRequest(ctx, http.MethodPost, "/api/v1/schedules", schedule, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetSchedules lists the schedules of a queue, or of every queue when queueName is empty
+func (c *Client) GetSchedules(ctx context.Context, queueName string) ([]Schedule, error) {
+	path := "/api/v1/schedules"
+	if queueName != "" {
+		path += "?" + url.Values{"queue": {queueName}}.Encode()
+	}
+
+	var schedules []Schedule
+	if err := c.doRequest(ctx, http.MethodGet, path, nil, &schedules); err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+func (c *Client) GetSchedule(ctx context.Context, id string) (*Schedule, error) {
+	var schedule Schedule
+	if err := c.doRequest(ctx, http.MethodGet, fmt.Sprintf("/api/v1/schedules/%s", id), nil, &schedule); err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+// PauseSchedule stops the schedule from enqueueing tasks
+func (c *Client) PauseSchedule(ctx context.Context, id string) (*Schedule, error) {
+	return c.setSchedulePaused(ctx, id, "pause")
+}
+
+// ResumeSchedule enqueues the tasks of a paused schedule again, runs missed while paused are skipped
+func (c *Client) ResumeSchedule(ctx context.Context, id string) (*Schedule, error) {
+	return c.setSchedulePaused(ctx, id, "resume")
+}
+
+func (c *Client) setSchedulePaused(ctx context.Context, id, action string) (*Schedule, error) {
+	var schedule Schedule
+	err := c.doRequest(ctx, http.MethodPost, fmt.Sprintf("/api/v1/schedules/%s/%s", id, action), nil, &schedule)
+	if err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+// DeleteSchedule deletes a schedule, the tasks it already created are kept
+func (c *Client) DeleteSchedule(ctx context.Context, id string) error {
+	return c.doRequest(ctx, http.MethodDelete, fmt.Sprintf("/api/v1/schedules/%s", id), nil, nil)
+}
+
 // CreateTask creates a new task
-func (c *Client) CreateTask(ctx context.Context, queueName string, data interface{}) (*Task, error) {
+func (c *Client) CreateTask(ctx context.Context, queueName string, data interface{}, opts ...EnqueueOption) (*Task, error) {
 	jsonData, err := json.Marshal(data)
 	if err != nil {
 		return nil, fmt.Errorf("error marshaling data: %w", err)
 	}
 
-	task := struct {
-		QueueName string          `json:"queue_name"`
-		Data      json.RawMessage `json:"data"`
-	}{
+	task := enqueueRequest{
 		QueueName: queueName,
 		Data:      jsonData,
 	}
 
+	for _, opt := range opts {
+		opt(&task)
+	}
+
 	var result Task
-	err = c.doRequest(ctx, http.MethodPost, "/api/v1/tasks", task, &result)
+	status, err := c.do(ctx, http.MethodPost, "/api/v1/tasks", task, &result)
 	if err != nil {
 		return nil, err
 	}
+
+	// The server answers 200 instead of 201 when an idempotency key matched an existing task
+	result.Created = status == http.StatusCreated
 	return &result, nil
 }
 
@@ -136,29 +322,232 @@ func (c *Client) GetTaskStats(ctx context.Context, filter TaskFilter) (map[strin
 	return stats, nil
 }
 
-// UpdateTask updates an existing task
-func (c *Client) UpdateTask(ctx context.Context, id string, status string, data interface{}) (*Task, error) {
-	jsonData, err := json.Marshal(data)
+// UpdateTask updates the status of an existing task, e.g. to requeue it. The data of a task
+// never changes, see CompleteTask to report a result.
+func (c *Client) UpdateTask(ctx context.Context, id string, status string) (*Task, error) {
+	update := struct {
+		Status string `json:"status"`
+	}{
+		Status: status,
+	}
+
+	var task Task
+	err := c.doRequest(ctx, http.MethodPut, fmt.Sprintf("/api/v1/tasks/%s", id), update, &task)
 	if err != nil {
-		return nil, fmt.Errorf("error marshaling data: %w", err)
+		return nil, err
 	}
+	return &task, nil
+}
 
+// CompleteTask completes a task claimed by this client, storing result as its result when
+// not nil. It fails with a conflict when the claim was lost.
+func (c *Client) CompleteTask(ctx context.Context, id string, result interface{}) (*Task, error) {
 	update := struct {
 		Status string          `json:"status"`
-		Data   json.RawMessage `json:"data"`
+		Result json.RawMessage `json:"result,omitempty"`
 	}{
-		Status: status,
-		Data:   jsonData,
+		Status: "completed",
+	}
+	if result != nil {
+		jsonResult, err := json.Marshal(result)
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling result: %w", err)
+		}
+		update.Result = jsonResult
+	}
+
+	var task Task
+	err := c.doRequest(ctx, http.MethodPut, fmt.Sprintf("/api/v1/tasks/%s", id), update, &task)
+	if err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// reportTaskResult sends the outcome of a claimed task, including the attempt it was
+// processed in so the server can reject results from a claim that was lost
+func (c *Client) reportTaskResult(ctx context.Context, task *Task, status string, result json.RawMessage, taskErr *TaskError) error {
+	update := struct {
+		Status  string          `json:"status"`
+		Result  json.RawMessage `json:"result,omitempty"`
+		Error   *TaskError      `json:"error,omitempty"`
+		Attempt int             `json:"attempt"`
+	}{
+		Status:  status,
+		Result:  result,
+		Error:   taskErr,
+		Attempt: task.Attempt,
+	}
+
+	return c.doRequest(ctx, http.MethodPut, fmt.Sprintf("/api/v1/tasks/%s", task.ID), update, nil)
+}
+
+// CreateTasks creates a task in the queue for each item, sending them in batches of
+// createTasksChunk tasks. The options are applied to every task. The results keep the
+// order of items, and a task that could not be created has its Error set. When a request
+// fails the results of the batches already sent are returned along with the error.
+func (c *Client) CreateTasks(ctx context.Context, queueName string, items []interface{}, opts ...EnqueueOption) ([]BatchResult, error) {
+	tasks := make([]enqueueRequest, len(items))
+	for i, item := range items {
+		jsonData, err := json.Marshal(item)
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling data of item %d: %w", i, err)
+		}
+		tasks[i] = enqueueRequest{
+			QueueName: queueName,
+			Data:      jsonData,
+		}
+		for _, opt := range opts {
+			opt(&tasks[i])
+		}
+	}
+
+	results := make([]BatchResult, 0, len(items))
+	for start := 0; start < len(tasks); start += createTasksChunk {
+		end := start + createTasksChunk
+		if end > len(tasks) {
+			end = len(tasks)
+		}
+
+		var response struct {
+			Results []BatchResult `json:"results"`
+		}
+		err := c.doRequest(ctx, http.MethodPost, "/api/v1/tasks/batch", tasks[start:end], &response)
+		if err != nil {
+			return results, err
+		}
+		for _, result := range response.Results {
+			result.Index += start
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+// SetTaskPriority changes the priority of an existing task
+func (c *Client) SetTaskPriority(ctx context.Context, id string, priority int) (*Task, error) {
+	update := struct {
+		Priority int `json:"priority"`
+	}{
+		Priority: priority,
 	}
 
 	var result Task
-	err = c.doRequest(ctx, http.MethodPut, fmt.Sprintf("/api/v1/tasks/%s", id), update, &result)
+	err := c.doRequest(ctx, http.MethodPut, fmt.Sprintf("/api/v1/tasks/%s", id), update, &result)
 	if err != nil {
 		return nil, err
 	}
 	return &result, nil
 }
 
+// Heartbeat extends the lease of a running task held by this client. A zero extension
+// extends it by the queue task timeout. Use IsLeaseLost to detect that the task is no longer held.
+func (c *Client) Heartbeat(ctx context.Context, id string, extension time.Duration) (time.Time, error) {
+	request := struct {
+		ExtendBy time.Duration `json:"extend_by,omitempty"`
+	}{
+		ExtendBy: extension,
+	}
+
+	var result struct {
+		LeaseExpiresAt time.Time `json:"lease_expires_at"`
+	}
+	err := c.doRequest(ctx, http.MethodPost, fmt.Sprintf("/api/v1/tasks/%s/heartbeat", id), request, &result)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return result.LeaseExpiresAt, nil
+}
+
+// GetTaskGraph retrieves the tasks and dependencies of the workflow a task belongs to
+func (c *Client) GetTaskGraph(ctx context.Context, id string) (*TaskGraph, error) {
+	var graph TaskGraph
+	if err := c.doRequest(ctx, http.MethodGet, fmt.Sprintf("/api/v1/tasks/%s/graph", id), nil, &graph); err != nil {
+		return nil, err
+	}
+	return &graph, nil
+}
+
+// GetTaskAttempts retrieves every claim of a task by a worker, oldest first
+func (c *Client) GetTaskAttempts(ctx context.Context, id string) ([]TaskAttempt, error) {
+	var attempts []TaskAttempt
+	if err := c.doRequest(ctx, http.MethodGet, fmt.Sprintf("/api/v1/tasks/%s/attempts", id), nil, &attempts); err != nil {
+		return nil, err
+	}
+	return attempts, nil
+}
+
+// GetTaskEvents retrieves the changes of tasks matching the filter, newest first
+func (c *Client) GetTaskEvents(ctx context.Context, filter EventFilter) ([]TaskEvent, error) {
+	var events []TaskEvent
+	if err := c.doRequest(ctx, http.MethodGet, "/api/v1/events?"+filter.toQueryParams().Encode(), nil, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// CreateBatch creates a group of tasks, possibly in several queues, whose progress is tracked
+// together. Either every task is created or none is.
+func (c *Client) CreateBatch(ctx context.Context, tasks []BatchTask, opts ...BatchOption) (*Batch, error) {
+	request := createBatchRequest{Tasks: make([]enqueueRequest, len(tasks))}
+	for i, task := range tasks {
+		jsonData, err := json.Marshal(task.Data)
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling data of task %d: %w", i, err)
+		}
+		request.Tasks[i] = enqueueRequest{
+			QueueName: task.QueueName,
+			Data:      jsonData,
+		}
+		for _, opt := range task.Options {
+			opt(&request.Tasks[i])
+		}
+	}
+	for _, opt := range opts {
+		opt(&request)
+	}
+
+	var batch Batch
+	if err := c.doRequest(ctx, http.MethodPost, "/api/v1/batches", request, &batch); err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// GetBatch retrieves a batch with the counts of its tasks by status
+func (c *Client) GetBatch(ctx context.Context, id string) (*Batch, error) {
+	var batch Batch
+	if err := c.doRequest(ctx, http.MethodGet, fmt.Sprintf("/api/v1/batches/%s", id), nil, &batch); err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// WaitBatch polls a batch every interval (a second when 0) until it finished or ctx is done
+func (c *Client) WaitBatch(ctx context.Context, id string, interval time.Duration) (*Batch, error) {
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		batch, err := c.GetBatch(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if batch.FinishedAt != nil {
+			return batch, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
 // DeleteTask deletes a task
 func (c *Client) DeleteTask(ctx context.Context, id string) error {
 	return c.doRequest(ctx, http.MethodDelete, fmt.Sprintf("/api/v1/tasks/%s", id), nil, nil)
@@ -185,6 +574,31 @@ func (c *Client) GetNextTask(ctx context.Context, queueName string) (*Task, erro
 	return &task, nil
 }
 
+// GetNextTasks claims up to count tasks of the queue in a single request. The server caps
+// count at 100, an empty list means that there are no tasks available.
+func (c *Client) GetNextTasks(ctx context.Context, queueName string, count int) ([]Task, error) {
+	return c.WaitForTasks(ctx, queueName, count, 0)
+}
+
+// WaitForTasks works like GetNextTasks but, when there are no tasks, the server holds the
+// request for up to wait (at most a minute) until tasks become available. Keep wait below
+// the client timeout.
+func (c *Client) WaitForTasks(ctx context.Context, queueName string, count int, wait time.Duration) ([]Task, error) {
+	query := url.Values{}
+	query.Set("queue", queueName)
+	query.Set("count", strconv.Itoa(count))
+	if wait > 0 {
+		query.Set("wait", wait.String())
+	}
+
+	var tasks []Task
+	err := c.doRequest(ctx, http.MethodGet, "/api/v1/tasks/next?"+query.Encode(), nil, &tasks)
+	if err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
 // DashboardURL returns the URL to access the dashboard
 func (c *Client) DashboardURL() string {
 	return fmt.Sprintf("%s/dashboard/", c.baseURL)
@@ -192,18 +606,24 @@ func (c *Client) DashboardURL() string {
 
 // doRequest performs the HTTP request and processes the response
 func (c *Client) doRequest(ctx context.Context, method, path string, body interface{}, result interface{}) error {
+	_, err := c.do(ctx, method, path, body, result)
+	return err
+}
+
+// do performs the HTTP request and processes the response, returning the response status code
+func (c *Client) do(ctx context.Context, method, path string, body interface{}, result interface{}) (int, error) {
 	var bodyReader io.Reader
 	if body != nil {
 		jsonBody, err := json.Marshal(body)
 		if err != nil {
-			return fmt.Errorf("error marshaling request body: %w", err)
+			return 0, fmt.Errorf("error marshaling request body: %w", err)
 		}
 		bodyReader = bytes.NewReader(jsonBody)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bodyReader)
 	if err != nil {
-		return fmt.Errorf("error creating request: %w", err)
+		return 0, fmt.Errorf("error creating request: %w", err)
 	}
 
 	if body != nil {
@@ -216,39 +636,41 @@ func (c *Client) doRequest(ctx context.Context, method, path string, body interf
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("error making request: %w", err)
+		return 0, fmt.Errorf("error making request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	// Read the response body
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("error reading response body: %w", err)
+		return resp.StatusCode, fmt.Errorf("error reading response body: %w", err)
 	}
 
 	// Check if the response is successful
 	if resp.StatusCode >= 400 {
+		apiErr := &APIError{
+			StatusCode: resp.StatusCode,
+			Message:    http.StatusText(resp.StatusCode),
+		}
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			apiErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+
 		var apiError struct {
 			Error string `json:"error"`
 		}
 		if err := json.Unmarshal(respBody, &apiError); err == nil && apiError.Error != "" {
-			return &APIError{
-				StatusCode: resp.StatusCode,
-				Message:    apiError.Error,
-			}
-		}
-		return &APIError{
-			StatusCode: resp.StatusCode,
-			Message:    http.StatusText(resp.StatusCode),
+			apiErr.Message = apiError.Error
 		}
+		return resp.StatusCode, apiErr
 	}
 
 	// If a result is expected, deserialize the response
 	if result != nil && len(respBody) > 0 {
 		if err := json.Unmarshal(respBody, result); err != nil {
-			return fmt.Errorf("error unmarshaling response: %w", err)
+			return resp.StatusCode, fmt.Errorf("error unmarshaling response: %w", err)
 		}
 	}
 
-	return nil
+	return resp.StatusCode, nil
 }
@@ -10,11 +10,15 @@ import (
 type TaskFilter struct {
 	QueueName string
 	Status    string
+	Priority  *int
+	BatchID   string
 	FromDate  time.Time
 	ToDate    time.Time
 	SortBy    string
 	Offset    int
 	Limit     int
+	// IncludeArchived también busca en las tareas archivadas
+	IncludeArchived bool
 }
 
 // toQueryParams convierte el filtro en parámetros de consulta URL
@@ -30,6 +34,14 @@ func (f TaskFilter) toQueryParams() url.Values {
 		params.Set("status", f.Status)
 	}
 
+	if f.Priority != nil {
+		params.Set("priority", strconv.Itoa(*f.Priority))
+	}
+
+	if f.BatchID != "" {
+		params.Set("batch_id", f.BatchID)
+	}
+
 	if !f.FromDate.IsZero() {
 		params.Set("from", strconv.FormatInt(f.FromDate.Unix(), 10))
 	}
@@ -50,6 +62,62 @@ func (f TaskFilter) toQueryParams() url.Values {
 		params.Set("limit", strconv.Itoa(f.Limit))
 	}
 
+	if f.IncludeArchived {
+		params.Set("include_archived", "true")
+	}
+
+	return params
+}
+
+// EventFilter contains filters for searching the task event log
+type EventFilter struct {
+	TaskID    string
+	QueueName string
+	// ActorType is "client", "api_key", "user", "anonymous" or "system"
+	ActorType string
+	Actor     string
+	FromDate  time.Time
+	ToDate    time.Time
+	Offset    int
+	Limit     int
+}
+
+// toQueryParams convierte el filtro de eventos en parámetros de consulta URL
+func (f EventFilter) toQueryParams() url.Values {
+	params := url.Values{}
+
+	if f.TaskID != "" {
+		params.Set("task_id", f.TaskID)
+	}
+
+	if f.QueueName != "" {
+		params.Set("queue", f.QueueName)
+	}
+
+	if f.ActorType != "" {
+		params.Set("actor_type", f.ActorType)
+	}
+
+	if f.Actor != "" {
+		params.Set("actor", f.Actor)
+	}
+
+	if !f.FromDate.IsZero() {
+		params.Set("from", strconv.FormatInt(f.FromDate.Unix(), 10))
+	}
+
+	if !f.ToDate.IsZero() {
+		params.Set("to", strconv.FormatInt(f.ToDate.Unix(), 10))
+	}
+
+	if f.Offset > 0 {
+		params.Set("offset", strconv.Itoa(f.Offset))
+	}
+
+	if f.Limit > 0 {
+		params.Set("limit", strconv.Itoa(f.Limit))
+	}
+
 	return params
 }
 
@@ -72,6 +140,12 @@ func (f TaskFilter) WithStatus(status string) TaskFilter {
 	return f
 }
 
+// WithPriority agrega un filtro por prioridad
+func (f TaskFilter) WithPriority(priority int) TaskFilter {
+	f.Priority = &priority
+	return f
+}
+
 // WithDateRange agrega un filtro por rango de fechas
 func (f TaskFilter) WithDateRange(from, to time.Time) TaskFilter {
 	f.FromDate = from
@@ -86,8 +160,14 @@ func (f TaskFilter) WithPagination(offset, limit int) TaskFilter {
 	return f
 }
 
-// WithSort agrega ordenamiento al filtro
+// WithSort agrega ordenamiento al filtro, un prefijo "-" ordena de forma descendente (ej. "-priority")
 func (f TaskFilter) WithSort(sortBy string) TaskFilter {
 	f.SortBy = sortBy
 	return f
 }
+
+// WithArchived incluye las tareas archivadas en la búsqueda
+func (f TaskFilter) WithArchived() TaskFilter {
+	f.IncludeArchived = true
+	return f
+}
@@ -2,8 +2,10 @@ package jobqueue
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"time"
 )
 
@@ -43,9 +45,18 @@ type ProcessTasksConfig struct {
 	QueueName     string
 	RetryInterval time.Duration // Interval between attempts when there are no tasks
 	StopOnError   bool          // Whether to stop when an error is encountered
-	PreserveError bool          // Whether to preserve the original error in the task data
-	WorkerCount   int           // Number of workers to process tasks
-	WorkerBuffer  int           // Buffer size for the worker channel
+	// Deprecated: the data of a task is never modified, errors are stored in Task.Error
+	PreserveError bool
+	WorkerCount   int // Number of workers to process tasks
+	WorkerBuffer  int // Buffer size for the worker channel
+	// HeartbeatInterval is how often the lease of a running task is extended while it is processed.
+	// 0 uses a third of the queue timeout, a negative value disables heartbeats and cancels
+	// the processor context once the queue timeout is reached.
+	HeartbeatInterval time.Duration
+	// PollWait is how long the server holds a request for tasks while there are none.
+	// 0 uses 30 seconds (less when the client timeout is shorter), a negative value
+	// disables long polling and RetryInterval is waited between requests instead.
+	PollWait time.Duration
 }
 
 // DefaultProcessTasksConfig returns a default configuration
@@ -64,8 +75,51 @@ func DefaultProcessTasksConfig(queueName string) ProcessTasksConfig {
 type Queue struct {
 	Name        string        `json:"name"`
 	TaskTimeout time.Duration `json:"task_timeout"`
-	CreatedAt   time.Time     `json:"created_at"`
-	UpdatedAt   time.Time     `json:"updated_at"`
+	RetryPolicy RetryPolicy   `json:"retry_policy"`
+	// DeadLetterQueue receives the tasks that exhaust their retries
+	DeadLetterQueue string `json:"dead_letter_queue,omitempty"`
+	// IdempotencyTTL keeps deduplicating finished tasks by idempotency key for this long after creation
+	IdempotencyTTL time.Duration `json:"idempotency_ttl,omitempty"`
+	// Retention sets how long finished tasks are kept before the server purges them
+	Retention RetentionPolicy `json:"retention"`
+	// ArchiveAfter moves finished tasks to the archive this long after their last update
+	ArchiveAfter time.Duration `json:"archive_after,omitempty"`
+	// MaxRunning limits how many tasks of the queue the server hands out at once, 0 means no limit
+	MaxRunning int `json:"max_running,omitempty"`
+	// Rate limits how many tasks per second the server hands out, 0 means no limit
+	Rate float64 `json:"rate,omitempty"`
+	// Burst is how many tasks can be handed out at once before Rate applies (server default 1)
+	Burst int `json:"burst,omitempty"`
+	// Paused queues accept tasks but hand none out, see PauseQueue
+	Paused    bool      `json:"paused,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RetentionPolicy sets how long finished tasks are kept after their last update, 0 keeps them forever
+type RetentionPolicy struct {
+	Completed time.Duration `json:"completed"`
+	Failed    time.Duration `json:"failed"`
+	Deleted   time.Duration `json:"deleted"`
+}
+
+// RetryPolicy configures how the server retries failed or timed out tasks of a queue
+type RetryPolicy struct {
+	MaxAttempts int           `json:"max_attempts"` // Total attempts allowed, 0 or 1 disables retries
+	BaseDelay   time.Duration `json:"base_delay"`   // Delay before the first retry
+	Multiplier  float64       `json:"multiplier"`   // Growth factor between retries (server default 2)
+	MaxDelay    time.Duration `json:"max_delay"`    // Upper bound for the delay, 0 means no bound
+	Jitter      float64       `json:"jitter"`       // Random +/- fraction applied to the delay (0..1)
+}
+
+// QueueOption is a function that configures a queue on creation or update
+type QueueOption func(*Queue)
+
+// WithRetryPolicy configures the retry policy of the queue
+func WithRetryPolicy(policy RetryPolicy) QueueOption {
+	return func(q *Queue) {
+		q.RetryPolicy = policy
+	}
 }
 
 // Helper methods for Queue
@@ -77,17 +131,313 @@ func (q Queue) String() string {
 	return fmt.Sprintf("Queue{Name: %s, Timeout: %v}", q.Name, q.TaskTimeout)
 }
 
+// WithRetention configures how long finished tasks of the queue are kept
+func WithRetention(retention RetentionPolicy) QueueOption {
+	return func(q *Queue) {
+		q.Retention = retention
+	}
+}
+
+// WithArchiveAfter moves finished tasks of the queue to the archive this long after their last update
+func WithArchiveAfter(age time.Duration) QueueOption {
+	return func(q *Queue) {
+		q.ArchiveAfter = age
+	}
+}
+
+// WithMaxRunning limits how many tasks of the queue run at once, across all workers
+func WithMaxRunning(n int) QueueOption {
+	return func(q *Queue) {
+		q.MaxRunning = n
+	}
+}
+
+// WithRateLimit hands out at most rate tasks per second of the queue across all workers,
+// allowing bursts of up to burst tasks
+func WithRateLimit(rate float64, burst int) QueueOption {
+	return func(q *Queue) {
+		q.Rate = rate
+		q.Burst = burst
+	}
+}
+
+// WithDeadLetterQueue configures the queue that receives tasks which exhaust their retries
+func WithDeadLetterQueue(name string) QueueOption {
+	return func(q *Queue) {
+		q.DeadLetterQueue = name
+	}
+}
+
+// DeleteQueueOption configures what DeleteQueue does with the pending and running tasks of the queue
+type DeleteQueueOption func(url.Values)
+
+// WithCascade soft-deletes the pending and running tasks of the deleted queue
+func WithCascade() DeleteQueueOption {
+	return func(params url.Values) {
+		params.Set("mode", "cascade")
+	}
+}
+
+// WithMoveTo moves every task of the deleted queue to another queue
+func WithMoveTo(queueName string) DeleteQueueOption {
+	return func(params url.Values) {
+		params.Set("mode", "move")
+		params.Set("target", queueName)
+	}
+}
+
 // Task represents a task in the queue
 type Task struct {
-	ID          string          `json:"id"`
-	QueueName   string          `json:"queue_name"`
-	Status      string          `json:"status"`
-	Data        json.RawMessage `json:"data"`
-	AssignedTo  *string         `json:"assigned_to,omitempty"`
-	CreatedAt   time.Time       `json:"created_at"`
-	UpdatedAt   time.Time       `json:"updated_at"`
-	StartedAt   *time.Time      `json:"started_at,omitempty"`
-	CompletedAt *time.Time      `json:"completed_at,omitempty"`
+	ID            string          `json:"id"`
+	QueueName     string          `json:"queue_name"`
+	Status        string          `json:"status"`
+	Priority      int             `json:"priority"` // Higher priorities are processed first
+	Data          json.RawMessage `json:"data"`
+	AssignedTo    *string         `json:"assigned_to,omitempty"`
+	Attempt       int             `json:"attempt"`                   // Number of times the task has been handed to a worker
+	NextAttemptAt *time.Time      `json:"next_attempt_at,omitempty"` // When a rescheduled task becomes available again
+	OriginalQueue *string         `json:"original_queue,omitempty"`  // Queue a dead-lettered task came from
+	RunAt         *time.Time      `json:"run_at,omitempty"`          // Task is not handed out before this time
+	// IdempotencyKey deduplicates task creation while a task with the same key is active
+	IdempotencyKey *string `json:"idempotency_key,omitempty"`
+	// BatchID is the batch the task was created in, if any
+	BatchID *string `json:"batch_id,omitempty"`
+	// Result is the output stored when the task finished, kept apart from its input Data
+	Result json.RawMessage `json:"result,omitempty"`
+	// Error describes why the task failed
+	Error     *TaskError `json:"error,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	// LeaseExpiresAt is when a running task is considered abandoned unless its worker sends a heartbeat
+	LeaseExpiresAt *time.Time `json:"lease_expires_at,omitempty"`
+	CompletedAt    *time.Time `json:"completed_at,omitempty"`
+	// DependsOn are the tasks that must complete before this one is processed, the task is
+	// "blocked" until then. It is only returned for single tasks and task graphs.
+	DependsOn []string `json:"depends_on,omitempty"`
+
+	// Created reports, for tasks returned by CreateTask, whether the task was newly
+	// created (false when an existing task matched the idempotency key)
+	Created bool `json:"-"`
+}
+
+// TaskError is the structured error of a failed task. A processor can return one to set
+// more than the message.
+type TaskError struct {
+	Message string          `json:"message"`
+	Type    string          `json:"type,omitempty"`
+	Stack   string          `json:"stack,omitempty"`
+	Details json.RawMessage `json:"details,omitempty"`
+}
+
+// Error returns the message of the error
+func (e *TaskError) Error() string {
+	return e.Message
+}
+
+// newTaskError converts the error returned by a processor, nil when it succeeded
+func newTaskError(err error) *TaskError {
+	if err == nil {
+		return nil
+	}
+	var taskErr *TaskError
+	if errors.As(err, &taskErr) {
+		return taskErr
+	}
+	return &TaskError{Message: err.Error()}
+}
+
+// TaskDependency is an edge of a workflow, TaskID is blocked until DependsOn completes
+type TaskDependency struct {
+	TaskID    string `json:"task_id"`
+	DependsOn string `json:"depends_on"`
+}
+
+// TaskGraph is the workflow a task belongs to, every task connected to it by dependencies
+type TaskGraph struct {
+	Tasks []Task           `json:"tasks"`
+	Edges []TaskDependency `json:"edges"`
+}
+
+// TaskAttempt is one claim of a task by a worker. Outcome is "running", "completed", "failed",
+// "timeout" or "cancelled" when the task was requeued or deleted while running.
+type TaskAttempt struct {
+	TaskID     string         `json:"task_id"`
+	Attempt    int            `json:"attempt"`
+	ClientID   string         `json:"client_id"`
+	Outcome    string         `json:"outcome"`
+	Error      *TaskError     `json:"error,omitempty"`
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+	Duration   *time.Duration `json:"duration,omitempty"` // nil while the attempt is running
+}
+
+// TaskEvent is an entry of the append-only log of task changes. Type is "created",
+// "status_changed", "requeued", "updated" or "deleted". ActorType tells who made the change:
+// a worker ("client"), an API key, a "user" of the dashboard, "anonymous" or the "system".
+type TaskEvent struct {
+	ID         int64           `json:"id"`
+	TaskID     string          `json:"task_id"`
+	QueueName  string          `json:"queue_name"`
+	Type       string          `json:"type"`
+	FromStatus *string         `json:"from_status,omitempty"`
+	ToStatus   string          `json:"to_status"`
+	Details    json.RawMessage `json:"details,omitempty"`
+	ActorType  string          `json:"actor_type"`
+	Actor      string          `json:"actor"`
+	RequestID  string          `json:"request_id"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// Batch is a group of tasks created together with CreateBatch. It finishes once none of its
+// tasks is pending, blocked or running, enqueueing a task in CallbackQueue if set.
+type Batch struct {
+	ID            string          `json:"id"`
+	CallbackQueue *string         `json:"callback_queue,omitempty"`
+	CallbackData  json.RawMessage `json:"callback_data,omitempty"`
+	// CallbackTaskID is the task enqueued when the batch finished
+	CallbackTaskID *string `json:"callback_task_id,omitempty"`
+	Total          int     `json:"total"`
+	// Counts are the tasks of the batch by status
+	Counts     map[string]int `json:"counts"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+}
+
+// BatchTask is one of the tasks sent to CreateBatch
+type BatchTask struct {
+	QueueName string
+	Data      interface{}
+	Options   []EnqueueOption
+}
+
+// BatchOption is a function that configures a batch on creation
+type BatchOption func(*createBatchRequest)
+
+// createBatchRequest is the payload sent to create a batch
+type createBatchRequest struct {
+	Tasks    []enqueueRequest `json:"tasks"`
+	Callback *batchCallback   `json:"callback,omitempty"`
+}
+
+type batchCallback struct {
+	QueueName string      `json:"queue_name"`
+	Data      interface{} `json:"data"`
+}
+
+// WithCallback enqueues a task with data in the given queue once every task of the batch
+// finished. The id of the batch is added to data under "batch_id".
+func WithCallback(queueName string, data interface{}) BatchOption {
+	return func(r *createBatchRequest) {
+		r.Callback = &batchCallback{QueueName: queueName, Data: data}
+	}
+}
+
+// Schedule enqueues a task in a queue every time its cron expression fires
+type Schedule struct {
+	ID        string `json:"id"`
+	QueueName string `json:"queue_name"`
+	// Cron is a five field cron expression or a descriptor such as @hourly or @every 1h30m
+	Cron string `json:"cron"`
+	// Timezone is the IANA time zone Cron is evaluated in, UTC when empty
+	Timezone string `json:"timezone,omitempty"`
+	// Priority and Data are copied to every task the schedule creates
+	Priority  int             `json:"priority"`
+	Data      json.RawMessage `json:"data"`
+	Paused    bool            `json:"paused,omitempty"`
+	NextRunAt time.Time       `json:"next_run_at"`
+	LastRunAt *time.Time      `json:"last_run_at,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// ScheduleOption is a function that configures a schedule on creation
+type ScheduleOption func(*Schedule)
+
+// WithTimezone evaluates the cron expression of the schedule in an IANA time zone, e.g. "Europe/Madrid"
+func WithTimezone(name string) ScheduleOption {
+	return func(s *Schedule) {
+		s.Timezone = name
+	}
+}
+
+// WithSchedulePriority sets the priority of the tasks created by the schedule
+func WithSchedulePriority(priority int) ScheduleOption {
+	return func(s *Schedule) {
+		s.Priority = priority
+	}
+}
+
+// EnqueueOption is a function that configures a task on creation
+type EnqueueOption func(*enqueueRequest)
+
+// enqueueRequest is the payload sent to create a task
+// createTasksChunk is the number of tasks sent per request by CreateTasks
+const createTasksChunk = 500
+
+// BatchResult is the outcome of one of the tasks sent to CreateTasks
+type BatchResult struct {
+	Index   int    `json:"index"`   // position of the item in the batch
+	ID      string `json:"id"`      // ID of the task, empty on error
+	Created bool   `json:"created"` // false when an existing task was returned for its idempotency key
+	Error   string `json:"error"`
+}
+
+type enqueueRequest struct {
+	QueueName      string          `json:"queue_name"`
+	Data           json.RawMessage `json:"data"`
+	Priority       int             `json:"priority,omitempty"`
+	RunAt          *time.Time      `json:"run_at,omitempty"`
+	Delay          time.Duration   `json:"delay,omitempty"`
+	IdempotencyKey string          `json:"idempotency_key,omitempty"`
+	DependsOn      []string        `json:"depends_on,omitempty"`
+}
+
+// WithRunAt schedules the task so it is not processed before the given time
+func WithRunAt(t time.Time) EnqueueOption {
+	return func(r *enqueueRequest) {
+		r.RunAt = &t
+		r.Delay = 0
+	}
+}
+
+// WithDelay schedules the task so it is not processed until the delay has elapsed
+func WithDelay(d time.Duration) EnqueueOption {
+	return func(r *enqueueRequest) {
+		r.Delay = d
+		r.RunAt = nil
+	}
+}
+
+// WithPriority sets the task priority, higher priorities are processed first (default 0)
+func WithPriority(priority int) EnqueueOption {
+	return func(r *enqueueRequest) {
+		r.Priority = priority
+	}
+}
+
+// WithIdempotencyKey deduplicates the task: while a task with the same key is pending or
+// running in the queue (or within the queue idempotency TTL) the existing task is returned
+func WithIdempotencyKey(key string) EnqueueOption {
+	return func(r *enqueueRequest) {
+		r.IdempotencyKey = key
+	}
+}
+
+// WithDependsOn holds the task until the given tasks complete. The task fails when one of them
+// fails and is deleted when one of them is deleted.
+func WithDependsOn(taskIDs ...string) EnqueueOption {
+	return func(r *enqueueRequest) {
+		r.DependsOn = append(r.DependsOn, taskIDs...)
+	}
+}
+
+// WithIdempotencyTTL keeps deduplicating tasks by idempotency key for this long after their creation
+func WithIdempotencyTTL(ttl time.Duration) QueueOption {
+	return func(q *Queue) {
+		q.IdempotencyTTL = ttl
+	}
 }
 
 // HealthStatus represents the health status of the service
@@ -101,15 +451,38 @@ type HealthStatus struct {
 type APIError struct {
 	StatusCode int    `json:"status_code"`
 	Message    string `json:"message"`
+	// RetryAfter is how long the server asked to wait before trying again, 0 when it did not say
+	RetryAfter time.Duration `json:"retry_after,omitempty"`
 }
 
 func (e *APIError) Error() string {
 	return fmt.Sprintf("API error: %d - %s", e.StatusCode, e.Message)
 }
 
+// IsLeaseLost reports whether the error means the client no longer holds the task,
+// because it expired or was handed to another worker
+func IsLeaseLost(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict
+}
+
+// IsQueuePaused reports whether the error means tasks were not handed out because the queue is paused
+func IsQueuePaused(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusServiceUnavailable
+}
+
+// IsRateLimited reports whether the error means tasks were not handed out because the queue
+// reached its rate limit, RetryAfter tells when the next one is available
+func IsRateLimited(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusTooManyRequests
+}
+
 // taskResult represents the result of a task processing
 type taskResult struct {
-	task *Task
-	err  error
-	data json.RawMessage
+	task      *Task
+	value     interface{}
+	err       error
+	leaseLost bool
 }
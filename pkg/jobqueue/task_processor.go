@@ -3,14 +3,26 @@ package jobqueue
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"sync"
 	"time"
 )
 
-// ProcessTasks processes tasks from the queue concurrently
-func (c *Client) ProcessTasks(ctx context.Context, config ProcessTasksConfig, processor func(context.Context, *Task) error) error {
+const (
+	// minHeartbeatInterval bounds the heartbeat frequency for queues with very short timeouts
+	minHeartbeatInterval = 100 * time.Millisecond
+	// defaultPollWait is how long idle workers wait on the server for new tasks
+	defaultPollWait = 30 * time.Second
+	// pollWaitMargin keeps long polling requests away from the client timeout
+	pollWaitMargin = 5 * time.Second
+)
+
+// ProcessTasks processes tasks from the queue concurrently. The value returned by the processor
+// is stored as the result of the task, and its error, a *TaskError to set more than the message,
+// fails the task.
+func (c *Client) ProcessTasks(ctx context.Context, config ProcessTasksConfig, processor func(context.Context, *Task) (interface{}, error)) error {
 
 	if config.WorkerCount < 1 {
 		config.WorkerCount = 1
@@ -25,12 +37,35 @@ func (c *Client) ProcessTasks(ctx context.Context, config ProcessTasksConfig, pr
 		return fmt.Errorf("queue %s does not exist", config.QueueName)
 	}
 
+	// Heartbeats keep the lease of long running tasks alive
+	heartbeatInterval := config.HeartbeatInterval
+	if heartbeatInterval == 0 {
+		heartbeatInterval = queue.TaskTimeout / 3
+		if heartbeatInterval < minHeartbeatInterval {
+			heartbeatInterval = minHeartbeatInterval
+		}
+	}
+
+	// Idle workers wait for new tasks on the server instead of sleeping between requests
+	pollWait := config.PollWait
+	if pollWait == 0 {
+		pollWait = defaultPollWait
+		if timeout := c.httpClient.Timeout; timeout > 0 && timeout-pollWaitMargin < pollWait {
+			pollWait = timeout - pollWaitMargin
+		}
+	}
+	if pollWait < 0 {
+		pollWait = 0
+	}
+
 	// Channel to distribute tasks to workers
 	tasksChan := make(chan *Task, config.WorkerBuffer)
 	// Channel to receive results from workers
 	resultsChan := make(chan taskResult, config.WorkerBuffer)
 	// Channel to signal critical errors
 	errorsChan := make(chan error, 1)
+	// One slot per busy worker, tasks are only claimed for free workers
+	slots := make(chan struct{}, config.WorkerCount)
 	// WaitGroup to wait for all workers to finish
 	var wg sync.WaitGroup
 
@@ -41,7 +76,7 @@ func (c *Client) ProcessTasks(ctx context.Context, config ProcessTasksConfig, pr
 	// Start workers
 	for i := 0; i < config.WorkerCount; i++ {
 		wg.Add(1)
-		go c.runWorker(workerCtx, &wg, queue.TaskTimeout, tasksChan, resultsChan, processor)
+		go c.runWorker(workerCtx, &wg, queue.TaskTimeout, heartbeatInterval, tasksChan, resultsChan, slots, processor)
 	}
 
 	// Goroutine to process results
@@ -74,8 +109,43 @@ func (c *Client) ProcessTasks(ctx context.Context, config ProcessTasksConfig, pr
 			return err
 
 		default:
-			task, err := c.GetNextTask(workerCtx, config.QueueName)
+			// Wait for a free worker, then claim as many tasks as there are free workers
+			free := acquireSlots(workerCtx, slots)
+			if free == 0 {
+				continue
+			}
+
+			requestedAt := time.Now()
+			tasks, err := c.WaitForTasks(workerCtx, config.QueueName, free, pollWait)
 			if err != nil {
+				releaseSlots(slots, free)
+				// A paused queue is not an error, workers wait for it to be resumed
+				if IsQueuePaused(err) {
+					backoff := config.RetryInterval
+					var apiErr *APIError
+					if errors.As(err, &apiErr) && apiErr.RetryAfter > backoff {
+						backoff = apiErr.RetryAfter
+					}
+					log.Printf("Queue %s is paused, retrying in %v", config.QueueName, backoff)
+					select {
+					case <-time.After(backoff):
+					case <-workerCtx.Done():
+					}
+					continue
+				}
+				// A rate limited queue tells when its next task is available
+				if IsRateLimited(err) {
+					backoff := config.RetryInterval
+					var apiErr *APIError
+					if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+						backoff = apiErr.RetryAfter
+					}
+					select {
+					case <-time.After(backoff):
+					case <-workerCtx.Done():
+					}
+					continue
+				}
 				if config.StopOnError {
 					errorsChan <- fmt.Errorf("error getting next task: %w", err)
 					continue
@@ -84,103 +154,181 @@ func (c *Client) ProcessTasks(ctx context.Context, config ProcessTasksConfig, pr
 				time.Sleep(config.RetryInterval)
 				continue
 			}
+			releaseSlots(slots, free-len(tasks))
 
-			if task == nil {
-				time.Sleep(config.RetryInterval)
+			// Servers without long polling answer right away when there are no tasks
+			if len(tasks) == 0 {
+				if pollWait == 0 || time.Since(requestedAt) < pollWait/2 {
+					time.Sleep(config.RetryInterval)
+				}
 				continue
 			}
 
-			select {
-			case tasksChan <- task:
-				// Task sent to worker
-			case <-workerCtx.Done():
-				return workerCtx.Err()
+			for i := range tasks {
+				select {
+				case tasksChan <- &tasks[i]:
+					// Task sent to worker
+				case <-workerCtx.Done():
+					return workerCtx.Err()
+				}
 			}
 		}
 	}
 }
 
-func (c *Client) runWorker(ctx context.Context, wg *sync.WaitGroup, timeout time.Duration,
-	tasks <-chan *Task, results chan<- taskResult, processor func(context.Context, *Task) error) {
+// acquireSlots blocks until at least one worker is free and reserves all the free workers,
+// returning how many were reserved or 0 if ctx is done
+func acquireSlots(ctx context.Context, slots chan<- struct{}) int {
+	select {
+	case slots <- struct{}{}:
+	case <-ctx.Done():
+		return 0
+	}
+
+	n := 1
+	for n < cap(slots) {
+		select {
+		case slots <- struct{}{}:
+			n++
+		default:
+			return n
+		}
+	}
+	return n
+}
+
+// releaseSlots frees n reserved workers
+func releaseSlots(slots <-chan struct{}, n int) {
+	for i := 0; i < n; i++ {
+		<-slots
+	}
+}
+
+func (c *Client) runWorker(ctx context.Context, wg *sync.WaitGroup, timeout, heartbeatInterval time.Duration,
+	tasks <-chan *Task, results chan<- taskResult, slots <-chan struct{}, processor func(context.Context, *Task) (interface{}, error)) {
 	defer wg.Done()
 
 	for task := range tasks {
+		// Send result
+		results <- c.processTask(ctx, task, timeout, heartbeatInterval, processor)
+		releaseSlots(slots, 1)
+
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// processTask runs the processor for a task. With heartbeats enabled the task lease is extended
+// in the background and the processor context is only cancelled if the lease is lost, otherwise
+// the processor context expires with the queue timeout.
+func (c *Client) processTask(ctx context.Context, task *Task, timeout, heartbeatInterval time.Duration,
+	processor func(context.Context, *Task) (interface{}, error)) taskResult {
+	var taskCtx context.Context
+	var cancel context.CancelFunc
+	leaseLost := make(chan struct{})
+
+	if heartbeatInterval > 0 {
+		taskCtx, cancel = context.WithCancel(ctx)
+		go c.keepAlive(taskCtx, task.ID, heartbeatInterval, leaseLost)
+	} else {
 		// Create context with timeout for the task
-		taskCtx, cancel := context.WithTimeout(ctx, timeout)
+		taskCtx, cancel = context.WithTimeout(ctx, timeout)
+	}
 
-		// Channel for the processing result
-		done := make(chan error, 1)
+	// Clean up the context
+	defer cancel()
 
-		// Process the task
-		go func() {
-			done <- processor(taskCtx, task)
-		}()
+	// Channel for the processing result
+	done := make(chan taskResult, 1)
 
-		// Wait for result or timeout
-		var processingErr error
-		select {
-		case err := <-done:
-			processingErr = err
-		case <-taskCtx.Done():
-			if taskCtx.Err() == context.DeadlineExceeded {
-				processingErr = fmt.Errorf("task processing exceeded timeout of %v", timeout)
-			} else {
-				processingErr = taskCtx.Err()
-			}
+	// Process the task
+	go func() {
+		value, err := processor(taskCtx, task)
+		done <- taskResult{value: value, err: err}
+	}()
+
+	// Wait for result, timeout or lease loss
+	var value interface{}
+	var processingErr error
+	select {
+	case result := <-done:
+		value, processingErr = result.value, result.err
+	case <-leaseLost:
+		return taskResult{
+			task:      task,
+			err:       fmt.Errorf("task lease lost"),
+			leaseLost: true,
+		}
+	case <-taskCtx.Done():
+		if taskCtx.Err() == context.DeadlineExceeded {
+			processingErr = &TaskError{Message: fmt.Sprintf("task processing exceeded timeout of %v", timeout), Type: "timeout"}
+		} else {
+			processingErr = taskCtx.Err()
 		}
+	}
 
-		// Clean up the context
-		cancel()
+	return taskResult{
+		task:  task,
+		value: value,
+		err:   processingErr,
+	}
+}
 
-		// Send result
-		results <- taskResult{
-			task: task,
-			err:  processingErr,
-			data: task.Data,
-		}
+// keepAlive extends the lease of a task every interval until ctx is done, closing lost
+// when the server reports that the task is no longer held by this client
+func (c *Client) keepAlive(ctx context.Context, taskID string, interval time.Duration, lost chan<- struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
-		if ctx.Err() != nil {
+	for {
+		select {
+		case <-ctx.Done():
 			return
+		case <-ticker.C:
+			_, err := c.Heartbeat(ctx, taskID, 0)
+			if err == nil {
+				continue
+			}
+			if IsLeaseLost(err) {
+				close(lost)
+				return
+			}
+			if ctx.Err() == nil {
+				log.Printf("Error sending heartbeat for task %s: %v", taskID, err)
+			}
 		}
 	}
 }
 
 // handleTaskResult handles the result of a processed task
 func (c *Client) handleTaskResult(ctx context.Context, result taskResult, config ProcessTasksConfig) error {
-	var updatedData json.RawMessage
-	if result.err != nil {
-		errorData := make(map[string]interface{})
-
-		if result.data != nil && config.PreserveError {
-			if err := json.Unmarshal(result.data, &errorData); err == nil {
-				errorData["error"] = result.err.Error()
-			} else {
-				errorData = map[string]interface{}{
-					"original_data": string(result.data),
-					"error":         result.err.Error(),
-				}
-			}
-		} else {
-			errorData = map[string]interface{}{
-				"error": result.err.Error(),
-			}
-		}
+	// The task belongs to someone else now, reporting a result would overwrite theirs
+	if result.leaseLost {
+		log.Printf("Lease lost for task %s, result discarded", result.task.ID)
+		return nil
+	}
 
-		updatedDataBytes, err := json.Marshal(errorData)
-		if err != nil {
-			updatedDataBytes = []byte(fmt.Sprintf(`{"error":"Error encoding task data: %v"}`, err))
+	var value json.RawMessage
+	taskErr := newTaskError(result.err)
+	if result.value != nil {
+		encoded, err := json.Marshal(result.value)
+		if err != nil && taskErr == nil {
+			taskErr = &TaskError{Message: fmt.Sprintf("error encoding task result: %v", err), Type: "encoding"}
 		}
-		updatedData = updatedDataBytes
-	} else {
-		updatedData = result.data
+		value = encoded
 	}
 
 	status := "completed"
-	if result.err != nil {
+	if taskErr != nil {
 		status = "failed"
 	}
 
-	_, err := c.UpdateTask(ctx, result.task.ID, status, updatedData)
+	err := c.reportTaskResult(ctx, result.task, status, value, taskErr)
+	if IsLeaseLost(err) {
+		log.Printf("Task %s is no longer held by this client, result discarded", result.task.ID)
+		return nil
+	}
 	return err
 }
 